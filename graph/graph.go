@@ -0,0 +1,312 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/erdoai/erdo-common/template"
+	"github.com/erdoai/erdo-common/types"
+)
+
+// stepsPrefix is the state key step outputs are written under (steps.<key>.output...)
+const stepsPrefix = "steps."
+
+// Node is a single step in the dependency graph
+type Node struct {
+	// ID is the step key, or its position in the bot definition when the step has no key
+	ID   string
+	Step *types.Step
+	// Parent is the ID of the step whose result handler contains this step, if any
+	Parent string
+	// DependsOn lists the IDs of the steps this step depends on, in discovery order
+	DependsOn []string
+	// Explicit is true when the dependencies came from Step.DependsOn rather than templates
+	Explicit bool
+}
+
+// Graph is a step dependency graph derived from a bot's steps
+type Graph struct {
+	Nodes map[string]*Node
+	// order holds node IDs in the order they appear in the bot definition
+	order []string
+}
+
+// CycleError is returned when steps depend on each other in a loop
+type CycleError struct {
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle between steps: %s", strings.Join(e.Path, " -> "))
+}
+
+// UnknownStepError is returned when a step references a step key that doesn't exist
+type UnknownStepError struct {
+	Step      string
+	Reference string
+}
+
+func (e *UnknownStepError) Error() string {
+	return fmt.Sprintf("step %q references unknown step %q", e.Step, e.Reference)
+}
+
+// DuplicateStepError is returned when two steps share the same key
+type DuplicateStepError struct {
+	Key string
+}
+
+func (e *DuplicateStepError) Error() string {
+	return fmt.Sprintf("duplicate step key %q", e.Key)
+}
+
+// Build walks the steps (including steps nested in result handlers) and builds
+// the dependency graph. Steps with a nil DependsOn have their dependencies
+// resolved from the steps.<key> references in their parameters and execution
+// mode data; steps with an explicit DependsOn use it as-is. Nested steps always
+// depend on the step that owns their result handler.
+//
+// The graph is returned even when there are errors, so callers can report every
+// problem at once. Errors are joined and can be inspected with errors.As.
+func Build(steps []types.StepWithHandlers) (*Graph, error) {
+	g := &Graph{Nodes: map[string]*Node{}}
+
+	var errs []error
+	g.addSteps(steps, "", "steps", &errs)
+
+	for _, id := range g.order {
+		node := g.Nodes[id]
+		if !node.Explicit {
+			node.DependsOn = append(node.DependsOn, findStepReferences(node.Step)...)
+		}
+		node.DependsOn = dedupe(node.DependsOn)
+
+		for _, dep := range node.DependsOn {
+			if _, ok := g.Nodes[dep]; !ok {
+				errs = append(errs, &UnknownStepError{Step: id, Reference: dep})
+			}
+		}
+	}
+
+	if _, err := g.TopologicalOrder(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return g, errors.Join(errs...)
+}
+
+func (g *Graph) addSteps(steps []types.StepWithHandlers, parent string, path string, errs *[]error) {
+	for i := range steps {
+		step := &steps[i].Step
+		stepPath := fmt.Sprintf("%s[%d]", path, i)
+
+		id := stepPath
+		if step.Key != nil && *step.Key != "" {
+			id = *step.Key
+		}
+
+		if _, exists := g.Nodes[id]; exists {
+			*errs = append(*errs, &DuplicateStepError{Key: id})
+		} else {
+			node := &Node{ID: id, Step: step, Parent: parent}
+			if parent != "" {
+				node.DependsOn = append(node.DependsOn, parent)
+			}
+			if step.DependsOn != nil {
+				node.Explicit = true
+				node.DependsOn = append(node.DependsOn, *step.DependsOn...)
+			}
+			g.Nodes[id] = node
+			g.order = append(g.order, id)
+		}
+
+		for j, handler := range steps[i].ResultHandlers {
+			g.addSteps(handler.Steps, id, fmt.Sprintf("%s.result_handlers[%d].steps", stepPath, j), errs)
+		}
+	}
+}
+
+// findStepReferences returns the keys of the steps referenced by templates in a
+// step's parameters and execution mode data, whether as keys ({{steps.a.output}}),
+// as arguments ({{get "steps.a.output"}}) or as .Data fields. Parameters the
+// step's hydration behaviour or policy leaves raw or drops aren't hydrated, so
+// they don't count.
+func findStepReferences(step *types.Step) []string {
+	var refs []string
+	collect := func(paths []string) {
+		for _, path := range paths {
+			if ref := stepKeyFromTemplateKey(path); ref != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	if step.Parameters != nil {
+		policy := step.EffectiveHydrationPolicy()
+		paths, err := template.FindPolicyDependencies(step.Parameters, &policy)
+		if err != nil {
+			// An invalid policy fails hydration; every template could still run
+			paths, _ = template.FindPolicyDependencies(step.Parameters, nil)
+		}
+		collect(paths)
+	}
+	if step.ExecutionMode.Data != nil {
+		paths, _ := template.FindPolicyDependencies(step.ExecutionMode.Data, nil)
+		collect(paths)
+	}
+
+	return refs
+}
+
+// stepKeyFromTemplateKey extracts "search" from "steps.search.output.items"
+func stepKeyFromTemplateKey(key string) string {
+	if !strings.HasPrefix(key, stepsPrefix) {
+		return ""
+	}
+	rest := strings.TrimPrefix(key, stepsPrefix)
+	if idx := strings.IndexAny(rest, ".["); idx >= 0 {
+		rest = rest[:idx]
+	}
+	return rest
+}
+
+// IDs returns the node IDs in the order they appear in the bot definition
+func (g *Graph) IDs() []string {
+	return append([]string{}, g.order...)
+}
+
+// Dependencies returns the IDs of the steps the given step depends on
+func (g *Graph) Dependencies(id string) []string {
+	node, ok := g.Nodes[id]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, node.DependsOn...)
+}
+
+// Dependents returns the IDs of the steps that depend on the given step
+func (g *Graph) Dependents(id string) []string {
+	var dependents []string
+	for _, other := range g.order {
+		for _, dep := range g.Nodes[other].DependsOn {
+			if dep == id {
+				dependents = append(dependents, other)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// TopologicalOrder returns the step IDs ordered so every step comes after the
+// steps it depends on. Ties are broken by definition order so the result is
+// stable. References to unknown steps are ignored here (Build reports them).
+// Returns a *CycleError with the offending path if the graph has a cycle.
+func (g *Graph) TopologicalOrder() ([]string, error) {
+	position := make(map[string]int, len(g.order))
+	for i, id := range g.order {
+		position[id] = i
+	}
+
+	inDegree := make(map[string]int, len(g.order))
+	dependents := make(map[string][]string, len(g.order))
+	for _, id := range g.order {
+		for _, dep := range g.Nodes[id].DependsOn {
+			if _, ok := g.Nodes[dep]; !ok {
+				continue
+			}
+			inDegree[id]++
+			dependents[dep] = append(dependents[dep], id)
+		}
+	}
+
+	var ready []string
+	for _, id := range g.order {
+		if inDegree[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	result := make([]string, 0, len(g.order))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return position[ready[i]] < position[ready[j]] })
+		id := ready[0]
+		ready = ready[1:]
+		result = append(result, id)
+
+		for _, dependent := range dependents[id] {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(result) < len(g.order) {
+		return nil, &CycleError{Path: g.findCycle()}
+	}
+
+	return result, nil
+}
+
+// findCycle returns the path of the first cycle found, starting and ending at the same step
+func (g *Graph) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g.order))
+	var stack []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = visiting
+		stack = append(stack, id)
+
+		for _, dep := range g.Nodes[id].DependsOn {
+			if _, ok := g.Nodes[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, s := range stack {
+					if s == dep {
+						cycle := append([]string{}, stack[i:]...)
+						return append(cycle, dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+		return nil
+	}
+
+	for _, id := range g.order {
+		if state[id] == unvisited {
+			if cycle := visit(id); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
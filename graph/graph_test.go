@@ -0,0 +1,164 @@
+package graph
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func step(key string, params map[string]any) types.StepWithHandlers {
+	return types.StepWithHandlers{Step: types.Step{Key: &key, Parameters: params}}
+}
+
+func TestBuild(t *testing.T) {
+	t.Run("dependencies from parameters and execution mode", func(t *testing.T) {
+		iterate := step("summarise", map[string]any{
+			"text": "{{item.content}}",
+		})
+		iterate.Step.ExecutionMode = types.ExecutionMode{
+			Mode: types.ExecutionModeTypeIterateOver,
+			Data: "{{steps.search.output.results}}",
+		}
+
+		steps := []types.StepWithHandlers{
+			step("answer", map[string]any{
+				"context": "{{steps.summarise.output}} {{steps.search.output.query?}}",
+			}),
+			step("search", map[string]any{"query": "{{query}}"}),
+			iterate,
+		}
+
+		g, err := Build(steps)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"summarise", "search"}, g.Dependencies("answer"))
+		assert.Equal(t, []string{"search"}, g.Dependencies("summarise"))
+		assert.Equal(t, []string{"answer", "summarise"}, g.Dependents("search"))
+
+		order, err := g.TopologicalOrder()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"search", "summarise", "answer"}, order)
+	})
+
+	t.Run("nested result handler steps", func(t *testing.T) {
+		parent := step("parent", nil)
+		parent.ResultHandlers = []types.ResultHandler{{
+			Type: string(types.HandlerTypeFinal),
+			Steps: []types.StepWithHandlers{
+				step("child", map[string]any{"value": "{{steps.sibling.output}}"}),
+			},
+		}}
+
+		g, err := Build([]types.StepWithHandlers{parent, step("sibling", nil)})
+		require.NoError(t, err)
+
+		assert.Equal(t, "parent", g.Nodes["child"].Parent)
+		assert.Equal(t, []string{"parent", "sibling"}, g.Dependencies("child"))
+
+		order, err := g.TopologicalOrder()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"parent", "sibling", "child"}, order)
+	})
+
+	t.Run("explicit depends on is used as-is", func(t *testing.T) {
+		deps := []string{}
+		explicit := step("b", map[string]any{"value": "{{steps.a.output}}"})
+		explicit.Step.DependsOn = &deps
+
+		g, err := Build([]types.StepWithHandlers{step("a", nil), explicit})
+		require.NoError(t, err)
+		assert.Empty(t, g.Dependencies("b"))
+		assert.True(t, g.Nodes["b"].Explicit)
+	})
+
+	t.Run("raw parameters aren't dependencies", func(t *testing.T) {
+		// The template in "example" is passed to the action as-is, so it doesn't
+		// make "first" depend on "second" and there's no cycle
+		first := step("first", map[string]any{
+			"example": "{{steps.second.output}}",
+			"code":    map[string]any{"body": "{{steps.second.output}}"},
+		})
+		first.Step.HydrationPolicy = &types.HydrationPolicy{Rules: []types.HydrationRule{
+			{Path: "example", Behaviour: types.ParameterHydrationBehaviourRaw},
+			{Path: "**.body", Behaviour: types.ParameterHydrationBehaviourNone},
+		}}
+		raw := types.ParameterHydrationBehaviourRaw
+		third := step("third", map[string]any{"prompt": "{{steps.second.output}}"})
+		third.Step.ParameterHydrationBehaviour = &raw
+
+		g, err := Build([]types.StepWithHandlers{
+			first,
+			step("second", map[string]any{"input": "{{steps.first.output}}"}),
+			third,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, g.Dependencies("first"))
+		assert.Empty(t, g.Dependencies("third"))
+		assert.Equal(t, []string{"first"}, g.Dependencies("second"))
+	})
+
+	t.Run("steps named by arguments and data fields", func(t *testing.T) {
+		g, err := Build([]types.StepWithHandlers{
+			step("a", nil),
+			step("c", map[string]any{
+				"input":   `{{get "steps.b.output"}}`,
+				"recent":  `{{sliceEnd "steps.x.output.items" 3}}`,
+				"summary": "{{.Data.steps.y.output}}",
+			}),
+			step("b", map[string]any{"v": "{{steps.a.output}}"}),
+			step("x", nil),
+			step("y", nil),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b", "x", "y"}, g.Dependencies("c"))
+
+		order, err := g.TopologicalOrder()
+		require.NoError(t, err)
+		assert.Less(t, slices.Index(order, "b"), slices.Index(order, "c"))
+		assert.Less(t, slices.Index(order, "x"), slices.Index(order, "c"))
+	})
+
+	t.Run("unkeyed steps use their position", func(t *testing.T) {
+		g, err := Build([]types.StepWithHandlers{
+			step("a", nil),
+			{Step: types.Step{Parameters: map[string]any{"v": "{{steps.a.output}}"}}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, g.Dependencies("steps[1]"))
+	})
+
+	t.Run("unknown step references", func(t *testing.T) {
+		_, err := Build([]types.StepWithHandlers{
+			step("a", map[string]any{"v": "{{steps.missing.output}}"}),
+		})
+		var unknownErr *UnknownStepError
+		require.True(t, errors.As(err, &unknownErr))
+		assert.Equal(t, "a", unknownErr.Step)
+		assert.Equal(t, "missing", unknownErr.Reference)
+	})
+
+	t.Run("duplicate step keys", func(t *testing.T) {
+		_, err := Build([]types.StepWithHandlers{step("a", nil), step("a", nil)})
+		var dupErr *DuplicateStepError
+		require.True(t, errors.As(err, &dupErr))
+		assert.Equal(t, "a", dupErr.Key)
+	})
+
+	t.Run("cycles report the offending path", func(t *testing.T) {
+		g, err := Build([]types.StepWithHandlers{
+			step("a", map[string]any{"v": "{{steps.c.output}}"}),
+			step("b", map[string]any{"v": "{{steps.a.output}}"}),
+			step("c", map[string]any{"v": "{{steps.b.output}}"}),
+		})
+		var cycleErr *CycleError
+		require.True(t, errors.As(err, &cycleErr))
+		assert.Equal(t, []string{"a", "c", "b", "a"}, cycleErr.Path)
+
+		_, err = g.TopologicalOrder()
+		assert.Error(t, err)
+	})
+}
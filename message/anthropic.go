@@ -0,0 +1,217 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// Anthropic Messages
+// ==================
+
+// AnthropicMessage is a message in an Anthropic Messages API request
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content AnthropicContent `json:"content"`
+}
+
+// AnthropicContent is a message's content blocks. It's always encoded as an
+// array, but also decodes from a plain string.
+type AnthropicContent []AnthropicContentBlock
+
+func (c *AnthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = AnthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// AnthropicContentBlock is a content block of any type
+type AnthropicContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Source    *AnthropicSource `json:"source,omitempty"`
+	Title     string           `json:"title,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     any              `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   AnthropicContent `json:"content,omitempty"`
+	IsError   bool             `json:"is_error,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Signature string           `json:"signature,omitempty"`
+	// Data is the encrypted thinking of redacted_thinking blocks
+	Data string `json:"data,omitempty"`
+}
+
+// AnthropicSource is the source of an image or document block
+type AnthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
+}
+
+// ToAnthropic converts messages to Anthropic's format. System messages are
+// returned separately as the request's system prompt, and tool messages are
+// sent as user messages of tool_result blocks.
+func ToAnthropic(messages []common.Message) (system string, out []AnthropicMessage, err error) {
+	for i, m := range messages {
+		role := m.Role
+		switch role {
+		case common.MessageRoleSystem:
+			continue
+		case common.MessageRoleTool:
+			role = common.MessageRoleUser
+		}
+
+		content := AnthropicContent{}
+		for j, part := range m.ContentParts() {
+			block, err := toAnthropicBlock(partPath(i, j), part)
+			if err != nil {
+				return "", nil, err
+			}
+			content = append(content, block)
+		}
+		out = append(out, AnthropicMessage{Role: role, Content: content})
+	}
+	return systemText(messages), out, nil
+}
+
+func toAnthropicBlock(path string, part common.ContentPart) (AnthropicContentBlock, error) {
+	switch part.Type {
+	case common.ContentPartTypeText:
+		return AnthropicContentBlock{Type: "text", Text: part.Text}, nil
+	case common.ContentPartTypeImage:
+		source, err := toAnthropicSource(path, part)
+		return AnthropicContentBlock{Type: "image", Source: source}, err
+	case common.ContentPartTypeFile:
+		source, err := toAnthropicSource(path, part)
+		return AnthropicContentBlock{Type: "document", Source: source, Title: part.Filename}, err
+	case common.ContentPartTypeToolUse:
+		input := part.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+		return AnthropicContentBlock{Type: "tool_use", ID: part.ToolCallID, Name: part.ToolName, Input: input}, nil
+	case common.ContentPartTypeToolResult:
+		content := AnthropicContent{}
+		for i, result := range part.Content {
+			block, err := toAnthropicBlock(fmt.Sprintf("%s.content[%d]", path, i), result)
+			if err != nil {
+				return AnthropicContentBlock{}, err
+			}
+			content = append(content, block)
+		}
+		return AnthropicContentBlock{Type: "tool_result", ToolUseID: part.ToolCallID, Content: content, IsError: part.IsError}, nil
+	case common.ContentPartTypeThinking:
+		if part.Text == "" && part.Data != "" {
+			return AnthropicContentBlock{Type: "redacted_thinking", Data: part.Data}, nil
+		}
+		return AnthropicContentBlock{Type: "thinking", Thinking: part.Text, Signature: part.Signature}, nil
+	default:
+		return AnthropicContentBlock{}, unsupported(path, "unknown part type %q", part.Type)
+	}
+}
+
+func toAnthropicSource(path string, part common.ContentPart) (*AnthropicSource, error) {
+	switch {
+	case part.Data != "":
+		return &AnthropicSource{Type: "base64", MediaType: part.MediaType, Data: part.Data}, nil
+	case part.URL != "":
+		return &AnthropicSource{Type: "url", URL: part.URL}, nil
+	case part.FileID != "":
+		return &AnthropicSource{Type: "file", FileID: part.FileID}, nil
+	default:
+		return nil, unsupported(path, "%s has no data, URL or file ID", part.Type)
+	}
+}
+
+// FromAnthropic converts an Anthropic system prompt and messages. User messages
+// of only tool_result blocks become tool messages.
+func FromAnthropic(system string, messages []AnthropicMessage) ([]common.Message, error) {
+	var out []common.Message
+	if system != "" {
+		out = append(out, common.NewMessage(common.MessageRoleSystem, common.TextPart(system)))
+	}
+	for i, m := range messages {
+		parts := make([]common.ContentPart, 0, len(m.Content))
+		for j, block := range m.Content {
+			part, err := fromAnthropicBlock(partPath(i, j), block)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+
+		role := m.Role
+		if role == common.MessageRoleUser && allToolResults(parts) {
+			role = common.MessageRoleTool
+		}
+		out = append(out, common.NewMessage(role, parts...))
+	}
+	return out, nil
+}
+
+func fromAnthropicBlock(path string, block AnthropicContentBlock) (common.ContentPart, error) {
+	switch block.Type {
+	case "text":
+		return common.TextPart(block.Text), nil
+	case "image":
+		part := common.ContentPart{Type: common.ContentPartTypeImage}
+		err := fromAnthropicSource(path, block.Source, &part)
+		return part, err
+	case "document":
+		part := common.ContentPart{Type: common.ContentPartTypeFile, Filename: block.Title}
+		err := fromAnthropicSource(path, block.Source, &part)
+		return part, err
+	case "tool_use":
+		input, ok := block.Input.(map[string]any)
+		if !ok && block.Input != nil {
+			return common.ContentPart{}, unsupported(path, "tool_use input must be an object, got %T", block.Input)
+		}
+		return common.ToolUsePart(block.ID, block.Name, input), nil
+	case "tool_result":
+		content := make([]common.ContentPart, 0, len(block.Content))
+		for i, result := range block.Content {
+			part, err := fromAnthropicBlock(fmt.Sprintf("%s.content[%d]", path, i), result)
+			if err != nil {
+				return common.ContentPart{}, err
+			}
+			content = append(content, part)
+		}
+		return common.ToolResultPart(block.ToolUseID, block.IsError, content...), nil
+	case "thinking":
+		return common.ThinkingPart(block.Thinking, block.Signature), nil
+	case "redacted_thinking":
+		return common.ContentPart{Type: common.ContentPartTypeThinking, Data: block.Data}, nil
+	default:
+		return common.ContentPart{}, unsupported(path, "unknown Anthropic block type %q", block.Type)
+	}
+}
+
+func fromAnthropicSource(path string, source *AnthropicSource, part *common.ContentPart) error {
+	if source == nil {
+		return unsupported(path, "%s block has no source", part.Type)
+	}
+	switch source.Type {
+	case "base64":
+		part.MediaType, part.Data = source.MediaType, source.Data
+	case "url":
+		part.URL = source.URL
+	case "file":
+		part.FileID = source.FileID
+	default:
+		return unsupported(path, "unknown Anthropic source type %q", source.Type)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// Gemini Contents
+// ===============
+
+const geminiRoleModel = "model"
+
+// GeminiContent is an entry of a Gemini request's contents, or its system instruction
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart is a part of any kind: text (a thought if Thought is set), inline
+// data, file data, a function call or a function response
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiBlob is base64 data sent inline
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// GeminiFileData refers to an uploaded file or a URL
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+// GeminiFunctionCall is a function call made by the model
+type GeminiFunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// GeminiFunctionResponse is the result of a function call
+type GeminiFunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// ToGemini converts messages to Gemini contents. System messages are returned
+// separately as the system instruction. Function responses are keyed by the
+// function's name, so tool results without a ToolName take the name of the tool
+// call they answer. Results are sent as {"output": text}, or {"error": text} for
+// errors. Images and files with a URL or file ID are sent as file data.
+func ToGemini(messages []common.Message) (systemInstruction *GeminiContent, contents []GeminiContent, err error) {
+	if system := systemText(messages); system != "" {
+		systemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: system}}}
+	}
+
+	toolNames := map[string]string{}
+	for i, m := range messages {
+		var role string
+		switch m.Role {
+		case common.MessageRoleSystem:
+			continue
+		case common.MessageRoleAssistant:
+			role = geminiRoleModel
+		default:
+			role = common.MessageRoleUser
+		}
+
+		content := GeminiContent{Role: role, Parts: []GeminiPart{}}
+		for j, part := range m.ContentParts() {
+			path := partPath(i, j)
+			switch part.Type {
+			case common.ContentPartTypeText:
+				content.Parts = append(content.Parts, GeminiPart{Text: part.Text})
+			case common.ContentPartTypeImage, common.ContentPartTypeFile:
+				geminiPart, err := toGeminiMedia(path, part)
+				if err != nil {
+					return nil, nil, err
+				}
+				content.Parts = append(content.Parts, geminiPart)
+			case common.ContentPartTypeToolUse:
+				toolNames[part.ToolCallID] = part.ToolName
+				args := part.Input
+				if args == nil {
+					args = map[string]any{}
+				}
+				content.Parts = append(content.Parts, GeminiPart{FunctionCall: &GeminiFunctionCall{ID: part.ToolCallID, Name: part.ToolName, Args: args}})
+			case common.ContentPartTypeToolResult:
+				text, err := resultText(path, part)
+				if err != nil {
+					return nil, nil, err
+				}
+				name := part.ToolName
+				if name == "" {
+					name = toolNames[part.ToolCallID]
+				}
+				if name == "" {
+					return nil, nil, unsupported(path, "no tool name for tool call %q", part.ToolCallID)
+				}
+				key := "output"
+				if part.IsError {
+					key = "error"
+				}
+				content.Parts = append(content.Parts, GeminiPart{FunctionResponse: &GeminiFunctionResponse{ID: part.ToolCallID, Name: name, Response: map[string]any{key: text}}})
+			case common.ContentPartTypeThinking:
+				if part.Text == "" && part.Signature == "" {
+					continue
+				}
+				content.Parts = append(content.Parts, GeminiPart{Text: part.Text, Thought: true, ThoughtSignature: part.Signature})
+			default:
+				return nil, nil, unsupported(path, "unknown part type %q", part.Type)
+			}
+		}
+		contents = append(contents, content)
+	}
+	return systemInstruction, contents, nil
+}
+
+func toGeminiMedia(path string, part common.ContentPart) (GeminiPart, error) {
+	switch {
+	case part.Data != "":
+		return GeminiPart{InlineData: &GeminiBlob{MimeType: part.MediaType, Data: part.Data}}, nil
+	case part.URL != "":
+		return GeminiPart{FileData: &GeminiFileData{MimeType: part.MediaType, FileURI: part.URL}}, nil
+	case part.FileID != "":
+		return GeminiPart{FileData: &GeminiFileData{MimeType: part.MediaType, FileURI: part.FileID}}, nil
+	default:
+		return GeminiPart{}, unsupported(path, "%s has no data, URL or file ID", part.Type)
+	}
+}
+
+// FromGemini converts a Gemini system instruction and contents. User contents of
+// only function responses become tool messages. Inline data and file data
+// become images when their MIME type is an image type, and files otherwise.
+func FromGemini(systemInstruction *GeminiContent, contents []GeminiContent) ([]common.Message, error) {
+	var out []common.Message
+	if systemInstruction != nil {
+		texts := make([]string, len(systemInstruction.Parts))
+		for i, part := range systemInstruction.Parts {
+			texts[i] = part.Text
+		}
+		out = append(out, common.NewMessage(common.MessageRoleSystem, common.TextPart(strings.Join(texts, "\n\n"))))
+	}
+
+	for i, content := range contents {
+		parts := make([]common.ContentPart, 0, len(content.Parts))
+		for j, geminiPart := range content.Parts {
+			part, err := fromGeminiPart(partPath(i, j), geminiPart)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+
+		role := common.MessageRoleUser
+		switch {
+		case content.Role == geminiRoleModel:
+			role = common.MessageRoleAssistant
+		case allToolResults(parts):
+			role = common.MessageRoleTool
+		}
+		out = append(out, common.NewMessage(role, parts...))
+	}
+	return out, nil
+}
+
+func fromGeminiPart(path string, part GeminiPart) (common.ContentPart, error) {
+	switch {
+	case part.Thought:
+		return common.ThinkingPart(part.Text, part.ThoughtSignature), nil
+	case part.InlineData != nil:
+		return common.ContentPart{Type: mediaPartType(part.InlineData.MimeType), MediaType: part.InlineData.MimeType, Data: part.InlineData.Data}, nil
+	case part.FileData != nil:
+		return common.ContentPart{Type: mediaPartType(part.FileData.MimeType), MediaType: part.FileData.MimeType, URL: part.FileData.FileURI}, nil
+	case part.FunctionCall != nil:
+		return common.ToolUsePart(part.FunctionCall.ID, part.FunctionCall.Name, part.FunctionCall.Args), nil
+	case part.FunctionResponse != nil:
+		response := part.FunctionResponse
+		result := common.ToolResultPart(response.ID, false)
+		result.ToolName = response.Name
+		if output, ok := response.Response["error"].(string); ok && len(response.Response) == 1 {
+			result.IsError = true
+			result.Content = []common.ContentPart{common.TextPart(output)}
+		} else if output, ok := response.Response["output"].(string); ok && len(response.Response) == 1 {
+			result.Content = []common.ContentPart{common.TextPart(output)}
+		} else {
+			encoded, err := json.Marshal(response.Response)
+			if err != nil {
+				return common.ContentPart{}, fmt.Errorf("%s: %w", path, err)
+			}
+			result.Content = []common.ContentPart{common.TextPart(string(encoded))}
+		}
+		return result, nil
+	default:
+		return common.TextPart(part.Text), nil
+	}
+}
+
+// mediaPartType returns image for image MIME types and file for the rest
+func mediaPartType(mimeType string) common.ContentPartType {
+	if strings.HasPrefix(mimeType, "image/") {
+		return common.ContentPartTypeImage
+	}
+	return common.ContentPartTypeFile
+}
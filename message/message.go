@@ -0,0 +1,116 @@
+// Package message converts conversations of common.Message to and from the
+// wire formats of the LLM providers: Anthropic Messages, OpenAI Chat
+// Completions and Responses, and Gemini contents.
+//
+// Converting to a provider's format and back keeps everything the provider can
+// represent; message IDs and timestamps, and parts a format has no place for
+// (e.g. thinking in OpenAI Chat), are dropped. Parts a provider can't accept in
+// a position, like a file URL in an OpenAI Chat message, are errors.
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// ErrUnsupportedContent is returned for content a provider's format can't represent
+var ErrUnsupportedContent = errors.New("unsupported content")
+
+func unsupported(path string, format string, args ...any) error {
+	return fmt.Errorf("%s: %w: %s", path, ErrUnsupportedContent, fmt.Sprintf(format, args...))
+}
+
+func partPath(messageIndex, partIndex int) string {
+	return fmt.Sprintf("messages[%d].parts[%d]", messageIndex, partIndex)
+}
+
+// dataURL encodes base64 data as a data URL
+func dataURL(mediaType string, data string) string {
+	return "data:" + mediaType + ";base64," + data
+}
+
+// parseDataURL splits a base64 data URL into its media type and data
+func parseDataURL(url string) (mediaType string, data string, ok bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", "", false
+	}
+	mediaType, ok = strings.CutSuffix(header, ";base64")
+	return mediaType, data, ok
+}
+
+// encodeArguments encodes a tool call's input as a JSON string
+func encodeArguments(input map[string]any) (string, error) {
+	if input == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeArguments decodes a tool call's JSON string arguments
+func decodeArguments(arguments string) (map[string]any, error) {
+	input := map[string]any{}
+	if strings.TrimSpace(arguments) == "" {
+		return input, nil
+	}
+	if err := json.Unmarshal([]byte(arguments), &input); err != nil {
+		return nil, fmt.Errorf("invalid tool call arguments: %w", err)
+	}
+	return input, nil
+}
+
+// resultText joins the text of a tool result for formats that only take text
+func resultText(path string, part common.ContentPart) (string, error) {
+	texts := make([]string, 0, len(part.Content))
+	for i, content := range part.Content {
+		if content.Type != common.ContentPartTypeText {
+			return "", unsupported(fmt.Sprintf("%s.content[%d]", path, i), "tool results can only contain text, got %s", content.Type)
+		}
+		texts = append(texts, content.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// systemText joins the text of system messages, which some providers take separately
+func systemText(messages []common.Message) string {
+	var texts []string
+	for _, m := range messages {
+		if m.Role == common.MessageRoleSystem {
+			texts = append(texts, m.Text())
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// allToolResults reports whether every part is a tool result, meaning the
+// message holds the results of the assistant's tool calls
+func allToolResults(parts []common.ContentPart) bool {
+	for _, part := range parts {
+		if part.Type != common.ContentPartTypeToolResult {
+			return false
+		}
+	}
+	return len(parts) > 0
+}
+
+// appendToLast adds parts to the last message if it has the role, or starts a new message
+func appendToLast(messages []common.Message, role string, parts ...common.ContentPart) []common.Message {
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1] = common.NewMessage(role, append(slices.Clone(messages[n-1].ContentParts()), parts...)...)
+		return messages
+	}
+	return append(messages, common.NewMessage(role, parts...))
+}
@@ -0,0 +1,217 @@
+package message
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// conversation uses every kind of content part
+func conversation() []common.Message {
+	return []common.Message{
+		common.NewMessage(common.MessageRoleSystem, common.TextPart("You are a research assistant.")),
+		common.NewMessage(common.MessageRoleUser,
+			common.TextPart("What's in this chart and the attached report?"),
+			common.ImagePart("image/png", "iVBORw0KGgo="),
+			common.FileRefPart("file-abc123", "application/pdf", "report.pdf"),
+		),
+		common.NewMessage(common.MessageRoleAssistant,
+			common.ThinkingPart("The user wants the Q3 figures.", "sig-1"),
+			common.TextPart("Let me look up the figures."),
+			common.ToolUsePart("call_1", "search", map[string]any{"query": "Q3 revenue", "limit": 5.0}),
+		),
+		common.NewMessage(common.MessageRoleTool, common.ToolResultPart("call_1", false, common.TextPart("Q3 revenue was $4.2M"))),
+		common.NewMessage(common.MessageRoleAssistant, common.TextPart("Q3 revenue was $4.2M, up 12% on Q2.")),
+		common.NewMessage(common.MessageRoleUser,
+			common.ContentPart{Type: common.ContentPartTypeImage, URL: "https://example.com/logo.png", MediaType: "image/png"},
+			common.TextPart("And this logo?"),
+		),
+	}
+}
+
+// withParts returns the conversation with the parts of message i changed by fn
+func withParts(messages []common.Message, i int, fn func(parts []common.ContentPart) []common.ContentPart) []common.Message {
+	messages[i] = common.NewMessage(messages[i].Role, fn(slices.Clone(messages[i].Parts))...)
+	return messages
+}
+
+// assertGolden compares got, encoded as indented JSON, with testdata/name, and
+// returns the golden file's contents
+func assertGolden(t *testing.T, name string, got any) []byte {
+	t.Helper()
+	encoded, err := json.MarshalIndent(got, "", "  ")
+	require.NoError(t, err)
+	encoded = append(encoded, '\n')
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.WriteFile(path, encoded, 0o644))
+	}
+	golden, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(golden), string(encoded), "run go test ./message -update to update %s", path)
+	return golden
+}
+
+type anthropicRequest struct {
+	System   string             `json:"system,omitempty"`
+	Messages []AnthropicMessage `json:"messages"`
+}
+
+func TestAnthropic(t *testing.T) {
+	system, messages, err := ToAnthropic(conversation())
+	require.NoError(t, err)
+	golden := assertGolden(t, "anthropic.json", anthropicRequest{system, messages})
+
+	var request anthropicRequest
+	require.NoError(t, json.Unmarshal(golden, &request))
+	got, err := FromAnthropic(request.System, request.Messages)
+	require.NoError(t, err)
+
+	// URL sources don't have a media type
+	want := withParts(conversation(), 5, func(parts []common.ContentPart) []common.ContentPart {
+		parts[0].MediaType = ""
+		return parts
+	})
+	want = withParts(want, 1, func(parts []common.ContentPart) []common.ContentPart {
+		parts[2].MediaType = ""
+		return parts
+	})
+	assert.Equal(t, want, got)
+}
+
+type openAIChatRequest struct {
+	Messages []OpenAIChatMessage `json:"messages"`
+}
+
+func TestOpenAIChat(t *testing.T) {
+	messages, err := ToOpenAIChat(conversation())
+	require.NoError(t, err)
+	golden := assertGolden(t, "openai_chat.json", openAIChatRequest{messages})
+
+	var request openAIChatRequest
+	require.NoError(t, json.Unmarshal(golden, &request))
+	got, err := FromOpenAIChat(request.Messages)
+	require.NoError(t, err)
+
+	// Thinking is dropped, and only data URLs have a media type
+	want := withParts(conversation(), 1, func(parts []common.ContentPart) []common.ContentPart {
+		parts[2].MediaType = ""
+		return parts
+	})
+	want = withParts(want, 2, func(parts []common.ContentPart) []common.ContentPart {
+		return parts[1:]
+	})
+	want = withParts(want, 5, func(parts []common.ContentPart) []common.ContentPart {
+		parts[0].MediaType = ""
+		return parts
+	})
+	assert.Equal(t, want, got)
+}
+
+type openAIResponsesRequest struct {
+	Input []OpenAIResponsesItem `json:"input"`
+}
+
+func TestOpenAIResponses(t *testing.T) {
+	items, err := ToOpenAIResponses(conversation())
+	require.NoError(t, err)
+	golden := assertGolden(t, "openai_responses.json", openAIResponsesRequest{items})
+
+	var request openAIResponsesRequest
+	require.NoError(t, json.Unmarshal(golden, &request))
+	got, err := FromOpenAIResponses(request.Input)
+	require.NoError(t, err)
+
+	// Only data URLs have a media type
+	want := withParts(conversation(), 1, func(parts []common.ContentPart) []common.ContentPart {
+		parts[2].MediaType = ""
+		return parts
+	})
+	want = withParts(want, 5, func(parts []common.ContentPart) []common.ContentPart {
+		parts[0].MediaType = ""
+		return parts
+	})
+	assert.Equal(t, want, got)
+}
+
+type geminiRequest struct {
+	SystemInstruction *GeminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []GeminiContent `json:"contents"`
+}
+
+func TestGemini(t *testing.T) {
+	systemInstruction, contents, err := ToGemini(conversation())
+	require.NoError(t, err)
+	golden := assertGolden(t, "gemini.json", geminiRequest{systemInstruction, contents})
+
+	var request geminiRequest
+	require.NoError(t, json.Unmarshal(golden, &request))
+	got, err := FromGemini(request.SystemInstruction, request.Contents)
+	require.NoError(t, err)
+
+	// File IDs come back as URIs without the filename, and tool results have the tool's name
+	want := withParts(conversation(), 1, func(parts []common.ContentPart) []common.ContentPart {
+		parts[2] = common.ContentPart{Type: common.ContentPartTypeFile, MediaType: "application/pdf", URL: "file-abc123"}
+		return parts
+	})
+	want[3] = common.NewMessage(common.MessageRoleTool, common.ContentPart{
+		Type:       common.ContentPartTypeToolResult,
+		ToolCallID: "call_1",
+		ToolName:   "search",
+		Content:    []common.ContentPart{common.TextPart("Q3 revenue was $4.2M")},
+	})
+	assert.Equal(t, want, got)
+}
+
+func TestFromProviderShorthand(t *testing.T) {
+	var anthropic []AnthropicMessage
+	require.NoError(t, json.Unmarshal([]byte(`[{"role": "user", "content": "Hi"}]`), &anthropic))
+	messages, err := FromAnthropic("", anthropic)
+	require.NoError(t, err)
+	assert.Equal(t, []common.Message{{Role: "user", Content: "Hi"}}, messages)
+
+	var items []OpenAIResponsesItem
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"role": "developer", "content": "Be brief"},
+		{"type": "function_call", "call_id": "c1", "name": "now", "arguments": ""},
+		{"type": "function_call_output", "call_id": "c1", "output": "noon"},
+		{"type": "function_call_output", "call_id": "c2", "output": "later"}
+	]`), &items))
+	messages, err = FromOpenAIResponses(items)
+	require.NoError(t, err)
+	assert.Equal(t, []common.Message{
+		{Role: "system", Content: "Be brief"},
+		common.NewMessage("assistant", common.ToolUsePart("c1", "now", map[string]any{})),
+		common.NewMessage("tool",
+			common.ToolResultPart("c1", false, common.TextPart("noon")),
+			common.ToolResultPart("c2", false, common.TextPart("later")),
+		),
+	}, messages)
+}
+
+func TestUnsupportedContent(t *testing.T) {
+	urlFile := []common.Message{common.NewMessage(common.MessageRoleUser, common.ContentPart{Type: common.ContentPartTypeFile, URL: "https://example.com/a.pdf"})}
+	_, err := ToOpenAIChat(urlFile)
+	assert.ErrorIs(t, err, ErrUnsupportedContent)
+	assert.ErrorContains(t, err, "messages[0].parts[0]")
+
+	imageResult := []common.Message{common.NewMessage(common.MessageRoleTool, common.ToolResultPart("c1", false, common.ImagePart("image/png", "AAAA")))}
+	_, err = ToOpenAIResponses(imageResult)
+	assert.ErrorIs(t, err, ErrUnsupportedContent)
+	_, _, err = ToAnthropic(imageResult)
+	assert.NoError(t, err, "Anthropic tool results can contain images")
+
+	unnamedResult := []common.Message{common.NewMessage(common.MessageRoleTool, common.ToolResultPart("c1", false, common.TextPart("ok")))}
+	_, _, err = ToGemini(unnamedResult)
+	assert.ErrorContains(t, err, `no tool name for tool call "c1"`)
+}
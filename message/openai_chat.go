@@ -0,0 +1,262 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// OpenAI Chat Completions
+// =======================
+
+// OpenAIChatMessage is a message in an OpenAI Chat Completions request
+type OpenAIChatMessage struct {
+	Role       string            `json:"role"`
+	Content    OpenAIChatContent `json:"content"`
+	ToolCalls  []OpenAIToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+}
+
+// OpenAIChatContent is a message's content: a string, an array of parts, or
+// null for assistant messages with only tool calls
+type OpenAIChatContent struct {
+	Text  string
+	Parts []OpenAIChatContentPart
+}
+
+func (c OpenAIChatContent) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.Parts != nil:
+		return json.Marshal(c.Parts)
+	case c.Text != "":
+		return json.Marshal(c.Text)
+	default:
+		return []byte("null"), nil
+	}
+}
+
+func (c *OpenAIChatContent) UnmarshalJSON(data []byte) error {
+	*c = OpenAIChatContent{}
+	if string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.Text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &c.Parts)
+}
+
+// text returns the content's text, joining text parts
+func (c OpenAIChatContent) text(path string) (string, error) {
+	if c.Parts == nil {
+		return c.Text, nil
+	}
+	texts := make([]string, 0, len(c.Parts))
+	for i, part := range c.Parts {
+		if part.Type != "text" {
+			return "", unsupported(fmt.Sprintf("%s.content[%d]", path, i), "expected text, got %s", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// OpenAIChatContentPart is a text, image_url or file content part
+type OpenAIChatContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *OpenAIImageURL `json:"image_url,omitempty"`
+	File     *OpenAIFile     `json:"file,omitempty"`
+}
+
+// OpenAIImageURL is an image's URL, which may be a base64 data URL
+type OpenAIImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// OpenAIFile is an uploaded file or a file's contents as a base64 data URL
+type OpenAIFile struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// OpenAIToolCall is a function call made by the assistant
+type OpenAIToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+// OpenAIFunctionCall is the function and JSON-encoded arguments of a tool call
+type OpenAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToOpenAIChat converts messages to OpenAI Chat Completions messages. Each tool
+// result becomes its own tool message, and thinking is dropped since Chat
+// Completions can't take it back.
+func ToOpenAIChat(messages []common.Message) ([]OpenAIChatMessage, error) {
+	var out []OpenAIChatMessage
+	for i, m := range messages {
+		msg := OpenAIChatMessage{Role: m.Role}
+		if m.Role == common.MessageRoleTool {
+			msg.Role = common.MessageRoleUser
+		}
+
+		var parts []OpenAIChatContentPart
+		var texts []string
+		for j, part := range m.ContentParts() {
+			path := partPath(i, j)
+			switch part.Type {
+			case common.ContentPartTypeText:
+				texts = append(texts, part.Text)
+				parts = append(parts, OpenAIChatContentPart{Type: "text", Text: part.Text})
+			case common.ContentPartTypeImage, common.ContentPartTypeFile:
+				if m.Role != common.MessageRoleUser {
+					return nil, unsupported(path, "%s parts are only supported in user messages", part.Type)
+				}
+				contentPart, err := toOpenAIChatMedia(path, part)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, contentPart)
+			case common.ContentPartTypeToolUse:
+				if m.Role != common.MessageRoleAssistant {
+					return nil, unsupported(path, "tool calls are only supported in assistant messages")
+				}
+				arguments, err := encodeArguments(part.Input)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				msg.ToolCalls = append(msg.ToolCalls, OpenAIToolCall{
+					ID:       part.ToolCallID,
+					Type:     "function",
+					Function: OpenAIFunctionCall{Name: part.ToolName, Arguments: arguments},
+				})
+			case common.ContentPartTypeToolResult:
+				text, err := resultText(path, part)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, OpenAIChatMessage{Role: common.MessageRoleTool, Content: OpenAIChatContent{Text: text}, ToolCallID: part.ToolCallID})
+			case common.ContentPartTypeThinking:
+			default:
+				return nil, unsupported(path, "unknown part type %q", part.Type)
+			}
+		}
+
+		// Only user messages need parts; the rest take their text
+		if m.Role == common.MessageRoleUser && len(parts) > len(texts) {
+			msg.Content.Parts = parts
+		} else {
+			msg.Content.Text = strings.Join(texts, "\n")
+		}
+		if msg.Content.Parts != nil || msg.Content.Text != "" || len(msg.ToolCalls) > 0 {
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+func toOpenAIChatMedia(path string, part common.ContentPart) (OpenAIChatContentPart, error) {
+	if part.Type == common.ContentPartTypeImage {
+		switch {
+		case part.Data != "":
+			return OpenAIChatContentPart{Type: "image_url", ImageURL: &OpenAIImageURL{URL: dataURL(part.MediaType, part.Data)}}, nil
+		case part.URL != "":
+			return OpenAIChatContentPart{Type: "image_url", ImageURL: &OpenAIImageURL{URL: part.URL}}, nil
+		default:
+			return OpenAIChatContentPart{}, unsupported(path, "images need data or a URL")
+		}
+	}
+	switch {
+	case part.Data != "":
+		return OpenAIChatContentPart{Type: "file", File: &OpenAIFile{FileData: dataURL(part.MediaType, part.Data), Filename: part.Filename}}, nil
+	case part.FileID != "":
+		return OpenAIChatContentPart{Type: "file", File: &OpenAIFile{FileID: part.FileID, Filename: part.Filename}}, nil
+	default:
+		return OpenAIChatContentPart{}, unsupported(path, "files need data or a file ID")
+	}
+}
+
+// FromOpenAIChat converts OpenAI Chat Completions messages. Developer messages
+// become system messages, and consecutive tool messages are combined into one.
+func FromOpenAIChat(messages []OpenAIChatMessage) ([]common.Message, error) {
+	var out []common.Message
+	for i, m := range messages {
+		path := fmt.Sprintf("messages[%d]", i)
+		switch m.Role {
+		case common.MessageRoleSystem, "developer":
+			text, err := m.Content.text(path)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, common.NewMessage(common.MessageRoleSystem, common.TextPart(text)))
+
+		case common.MessageRoleTool:
+			text, err := m.Content.text(path)
+			if err != nil {
+				return nil, err
+			}
+			out = appendToLast(out, common.MessageRoleTool, common.ToolResultPart(m.ToolCallID, false, common.TextPart(text)))
+
+		case common.MessageRoleAssistant:
+			text, err := m.Content.text(path)
+			if err != nil {
+				return nil, err
+			}
+			var parts []common.ContentPart
+			if text != "" {
+				parts = append(parts, common.TextPart(text))
+			}
+			for j, call := range m.ToolCalls {
+				input, err := decodeArguments(call.Function.Arguments)
+				if err != nil {
+					return nil, fmt.Errorf("%s.tool_calls[%d]: %w", path, j, err)
+				}
+				parts = append(parts, common.ToolUsePart(call.ID, call.Function.Name, input))
+			}
+			out = append(out, common.NewMessage(common.MessageRoleAssistant, parts...))
+
+		default:
+			if m.Content.Parts == nil {
+				out = append(out, common.NewMessage(m.Role, common.TextPart(m.Content.Text)))
+				continue
+			}
+			parts := make([]common.ContentPart, 0, len(m.Content.Parts))
+			for j, contentPart := range m.Content.Parts {
+				part, err := fromOpenAIChatPart(partPath(i, j), contentPart)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, part)
+			}
+			out = append(out, common.NewMessage(m.Role, parts...))
+		}
+	}
+	return out, nil
+}
+
+func fromOpenAIChatPart(path string, part OpenAIChatContentPart) (common.ContentPart, error) {
+	switch {
+	case part.Type == "text":
+		return common.TextPart(part.Text), nil
+	case part.Type == "image_url" && part.ImageURL != nil:
+		if mediaType, data, ok := parseDataURL(part.ImageURL.URL); ok {
+			return common.ImagePart(mediaType, data), nil
+		}
+		return common.ImageURLPart(part.ImageURL.URL), nil
+	case part.Type == "file" && part.File != nil:
+		if mediaType, data, ok := parseDataURL(part.File.FileData); ok {
+			return common.ContentPart{Type: common.ContentPartTypeFile, MediaType: mediaType, Data: data, Filename: part.File.Filename}, nil
+		}
+		return common.FileRefPart(part.File.FileID, "", part.File.Filename), nil
+	default:
+		return common.ContentPart{}, unsupported(path, "unknown OpenAI content part type %q", part.Type)
+	}
+}
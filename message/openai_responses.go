@@ -0,0 +1,228 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// OpenAI Responses
+// ================
+
+// OpenAIResponsesItem is an input item of an OpenAI Responses request: a
+// message, function_call, function_call_output or reasoning item
+type OpenAIResponsesItem struct {
+	Type    string                  `json:"type"`
+	Role    string                  `json:"role,omitempty"`
+	Content OpenAIResponsesContents `json:"content,omitempty"`
+	// CallID links function_call and function_call_output items
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// Output is the result of a function_call_output item
+	Output           *string                  `json:"output,omitempty"`
+	Summary          []OpenAIReasoningSummary `json:"summary,omitempty"`
+	EncryptedContent string                   `json:"encrypted_content,omitempty"`
+}
+
+// MarshalJSON always includes the summary of reasoning items, which the API requires
+func (item OpenAIResponsesItem) MarshalJSON() ([]byte, error) {
+	type responsesItem OpenAIResponsesItem
+	if item.Type != "reasoning" {
+		return json.Marshal(responsesItem(item))
+	}
+	summary := item.Summary
+	if summary == nil {
+		summary = []OpenAIReasoningSummary{}
+	}
+	return json.Marshal(struct {
+		responsesItem
+		Summary []OpenAIReasoningSummary `json:"summary"`
+	}{responsesItem(item), summary})
+}
+
+// OpenAIResponsesContents is a message item's content. It's always encoded as
+// an array, but also decodes from a plain string.
+type OpenAIResponsesContents []OpenAIResponsesContent
+
+func (c *OpenAIResponsesContents) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = OpenAIResponsesContents{{Type: "input_text", Text: text}}
+		return nil
+	}
+	var contents []OpenAIResponsesContent
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return err
+	}
+	*c = contents
+	return nil
+}
+
+// OpenAIResponsesContent is an input_text, output_text, input_image or input_file content item
+type OpenAIResponsesContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	FileURL  string `json:"file_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// OpenAIReasoningSummary is a summary of a reasoning item
+type OpenAIReasoningSummary struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// ToOpenAIResponses converts messages to OpenAI Responses input items. Text,
+// images and files become message items, tool calls function_call items, tool
+// results function_call_output items and thinking reasoning items, with the
+// thinking's Signature as their encrypted content.
+func ToOpenAIResponses(messages []common.Message) ([]OpenAIResponsesItem, error) {
+	var items []OpenAIResponsesItem
+	for i, m := range messages {
+		var content OpenAIResponsesContents
+		flush := func() {
+			if len(content) > 0 {
+				items = append(items, OpenAIResponsesItem{Type: "message", Role: m.Role, Content: content})
+				content = nil
+			}
+		}
+
+		for j, part := range m.ContentParts() {
+			path := partPath(i, j)
+			switch part.Type {
+			case common.ContentPartTypeText:
+				textType := "input_text"
+				if m.Role == common.MessageRoleAssistant {
+					textType = "output_text"
+				}
+				content = append(content, OpenAIResponsesContent{Type: textType, Text: part.Text})
+			case common.ContentPartTypeImage, common.ContentPartTypeFile:
+				if m.Role == common.MessageRoleAssistant {
+					return nil, unsupported(path, "%s parts aren't supported in assistant messages", part.Type)
+				}
+				content = append(content, toOpenAIResponsesMedia(part))
+			case common.ContentPartTypeToolUse:
+				flush()
+				arguments, err := encodeArguments(part.Input)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				items = append(items, OpenAIResponsesItem{Type: "function_call", CallID: part.ToolCallID, Name: part.ToolName, Arguments: arguments})
+			case common.ContentPartTypeToolResult:
+				flush()
+				output, err := resultText(path, part)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, OpenAIResponsesItem{Type: "function_call_output", CallID: part.ToolCallID, Output: &output})
+			case common.ContentPartTypeThinking:
+				flush()
+				var summary []OpenAIReasoningSummary
+				if part.Text != "" {
+					summary = append(summary, OpenAIReasoningSummary{Type: "summary_text", Text: part.Text})
+				}
+				items = append(items, OpenAIResponsesItem{Type: "reasoning", Summary: summary, EncryptedContent: part.Signature})
+			default:
+				return nil, unsupported(path, "unknown part type %q", part.Type)
+			}
+		}
+		flush()
+	}
+	return items, nil
+}
+
+func toOpenAIResponsesMedia(part common.ContentPart) OpenAIResponsesContent {
+	if part.Type == common.ContentPartTypeImage {
+		content := OpenAIResponsesContent{Type: "input_image", ImageURL: part.URL, FileID: part.FileID}
+		if part.Data != "" {
+			content.ImageURL = dataURL(part.MediaType, part.Data)
+		}
+		return content
+	}
+	content := OpenAIResponsesContent{Type: "input_file", FileID: part.FileID, FileURL: part.URL, Filename: part.Filename}
+	if part.Data != "" {
+		content.FileData = dataURL(part.MediaType, part.Data)
+	}
+	return content
+}
+
+// FromOpenAIResponses converts OpenAI Responses items. Consecutive reasoning,
+// function_call and assistant message items are combined into one assistant
+// message, and consecutive function_call_output items into one tool message.
+func FromOpenAIResponses(items []OpenAIResponsesItem) ([]common.Message, error) {
+	var out []common.Message
+	for i, item := range items {
+		path := fmt.Sprintf("items[%d]", i)
+		switch item.Type {
+		case "message", "":
+			role := item.Role
+			if role == "developer" {
+				role = common.MessageRoleSystem
+			}
+			parts := make([]common.ContentPart, 0, len(item.Content))
+			for j, content := range item.Content {
+				part, err := fromOpenAIResponsesContent(fmt.Sprintf("%s.content[%d]", path, j), content)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, part)
+			}
+			if role == common.MessageRoleAssistant {
+				out = appendToLast(out, role, parts...)
+			} else {
+				out = append(out, common.NewMessage(role, parts...))
+			}
+
+		case "function_call":
+			input, err := decodeArguments(item.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			out = appendToLast(out, common.MessageRoleAssistant, common.ToolUsePart(item.CallID, item.Name, input))
+
+		case "function_call_output":
+			var output string
+			if item.Output != nil {
+				output = *item.Output
+			}
+			out = appendToLast(out, common.MessageRoleTool, common.ToolResultPart(item.CallID, false, common.TextPart(output)))
+
+		case "reasoning":
+			summaries := make([]string, len(item.Summary))
+			for j, summary := range item.Summary {
+				summaries[j] = summary.Text
+			}
+			out = appendToLast(out, common.MessageRoleAssistant, common.ThinkingPart(strings.Join(summaries, "\n\n"), item.EncryptedContent))
+
+		default:
+			return nil, unsupported(path, "unknown OpenAI Responses item type %q", item.Type)
+		}
+	}
+	return out, nil
+}
+
+func fromOpenAIResponsesContent(path string, content OpenAIResponsesContent) (common.ContentPart, error) {
+	switch content.Type {
+	case "input_text", "output_text":
+		return common.TextPart(content.Text), nil
+	case "input_image":
+		if mediaType, data, ok := parseDataURL(content.ImageURL); ok {
+			return common.ImagePart(mediaType, data), nil
+		}
+		return common.ContentPart{Type: common.ContentPartTypeImage, URL: content.ImageURL, FileID: content.FileID}, nil
+	case "input_file":
+		part := common.ContentPart{Type: common.ContentPartTypeFile, URL: content.FileURL, FileID: content.FileID, Filename: content.Filename}
+		if mediaType, data, ok := parseDataURL(content.FileData); ok {
+			part.MediaType, part.Data = mediaType, data
+		}
+		return part, nil
+	default:
+		return common.ContentPart{}, unsupported(path, "unknown OpenAI Responses content type %q", content.Type)
+	}
+}
@@ -0,0 +1,53 @@
+package schema
+
+import (
+	"maps"
+	"slices"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// JSON schema types, including "null" for nullable unions
+const (
+	TypeString  = string(common.JSONSchemaTypeString)
+	TypeInteger = string(common.JSONSchemaTypeInteger)
+	TypeNumber  = string(common.JSONSchemaTypeNumber)
+	TypeBoolean = string(common.JSONSchemaTypeBoolean)
+	TypeObject  = string(common.JSONSchemaTypeObject)
+	TypeArray   = string(common.JSONSchemaTypeArray)
+	TypeNull    = string(common.JSONSchemaTypeNull)
+)
+
+// Schema is a typed JSON schema. It's defined in the types package as
+// common.Schema, so common.Tool can hold one as its RawInputSchema.
+type Schema = common.Schema
+
+// Bool returns a boolean schema
+func Bool(b bool) *Schema {
+	return common.BoolSchema(b)
+}
+
+// FromMap parses a schema from its decoded JSON
+func FromMap(m map[string]any) (*Schema, error) {
+	return common.SchemaFromMap(m)
+}
+
+// FromJSONSchema converts the simple tool schema used by common.Tool's InputSchema
+func FromJSONSchema(js common.JSONSchema) *Schema {
+	return js.ToSchema()
+}
+
+// FromJSONSchemaProperty converts a property of the simple tool schema
+func FromJSONSchemaProperty(property common.JSONSchemaProperty) *Schema {
+	return property.ToSchema()
+}
+
+// FromTool returns the tool's input schema, from RawInputSchema if it's set and
+// InputSchema otherwise
+func FromTool(tool common.Tool) *Schema {
+	return tool.Schema()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
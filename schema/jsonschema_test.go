@@ -0,0 +1,129 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaJSON(t *testing.T) {
+	input := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "x-label": "Name"},
+			"age": {"type": ["integer", "null"], "minimum": 0, "exclusiveMaximum": 150},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+			"pet": {"$ref": "#/$defs/Pet"},
+			"anything": true
+		},
+		"required": ["name"],
+		"additionalProperties": false,
+		"$defs": {
+			"Pet": {"anyOf": [{"type": "string", "enum": ["cat", "dog"]}, {"const": 7}], "nullable": true}
+		}
+	}`
+
+	var s Schema
+	require.NoError(t, json.Unmarshal([]byte(input), &s))
+
+	assert.Equal(t, []string{TypeObject}, s.Type)
+	assert.True(t, s.AdditionalProperties.IsBool(false))
+	assert.True(t, s.Properties["anything"].IsBool(true))
+	assert.Equal(t, []string{TypeInteger, TypeNull}, s.Properties["age"].Type)
+	assert.True(t, s.Properties["age"].IsNullable())
+	assert.Equal(t, 150.0, *s.Properties["age"].ExclusiveMaximum)
+	assert.Equal(t, 1, *s.Properties["name"].MinLength)
+	assert.Equal(t, "Name", s.Properties["name"].Extra["x-label"])
+	assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", s.Extra["$schema"])
+	assert.Equal(t, "#/$defs/Pet", s.Properties["pet"].Ref)
+	assert.True(t, s.Defs["Pet"].IsNullable())
+
+	encoded, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, input, string(encoded))
+
+	t.Run("clones are independent", func(t *testing.T) {
+		clone := s.Clone()
+		clone.Properties["name"].Type[0] = TypeInteger
+		clone.Required = append(clone.Required, "age")
+		assert.Equal(t, []string{TypeString}, s.Properties["name"].Type)
+		assert.Equal(t, []string{"name"}, s.Required)
+	})
+
+	t.Run("walk visits every schema", func(t *testing.T) {
+		count := 0
+		s.Walk(func(*Schema) { count++ })
+		// root, $defs.Pet and its two branches, five properties, tags items, additionalProperties
+		assert.Equal(t, 11, count)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		assert.Error(t, json.Unmarshal([]byte(`{"type": 1}`), &Schema{}))
+	})
+}
+
+func TestFromTool(t *testing.T) {
+	tool := common.Tool{
+		InputSchema: common.JSONSchema{
+			Type: common.JSONSchemaTypeObject,
+			Properties: map[string]common.JSONSchemaProperty{
+				"unit": {Type: common.JSONSchemaTypeString, Enum: []string{"c", "f"}, Description: "Unit"},
+				"days": {Type: common.JSONSchemaTypeArray, Items: &common.JSONSchemaProperty{Type: common.JSONSchemaTypeInteger}},
+			},
+			Required: []string{"unit"},
+		},
+	}
+
+	s := FromTool(tool)
+	encoded, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "object",
+		"properties": {
+			"unit": {"type": "string", "enum": ["c", "f"], "description": "Unit"},
+			"days": {"type": "array", "items": {"type": "integer"}}
+		},
+		"required": ["unit"]
+	}`, string(encoded))
+
+	raw := map[string]any{"type": "object", "properties": map[string]any{"q": map[string]any{"$ref": "#/$defs/Q"}}}
+	tool.RawInputSchema, err = FromMap(raw)
+	require.NoError(t, err)
+	s = FromTool(tool)
+	assert.Equal(t, "#/$defs/Q", s.Properties["q"].Ref)
+
+	m, err := s.ToMap()
+	require.NoError(t, err)
+	assert.Equal(t, raw, m)
+
+	t.Run("set schema keeps the simple form in sync", func(t *testing.T) {
+		var tool common.Tool
+		tool.SetSchema(&Schema{
+			Type: []string{TypeObject},
+			Properties: map[string]*Schema{
+				"unit": {Type: []string{TypeString, TypeNull}, Enum: []any{"c", "f"}},
+				"tags": {Type: []string{TypeArray}, Items: &Schema{Type: []string{TypeString}}, MinItems: new(int)},
+			},
+			Required: []string{"tags"},
+		})
+		assert.Equal(t, common.JSONSchema{
+			Type: common.JSONSchemaTypeObject,
+			Properties: map[string]common.JSONSchemaProperty{
+				"unit": {Type: common.JSONSchemaTypeString, Enum: []string{"c", "f"}},
+				"tags": {Type: common.JSONSchemaTypeArray, Items: &common.JSONSchemaProperty{Type: common.JSONSchemaTypeString}},
+			},
+			Required: []string{"tags"},
+		}, tool.InputSchema)
+		assert.Same(t, tool.RawInputSchema, FromTool(tool))
+
+		encoded, err := json.Marshal(tool)
+		require.NoError(t, err)
+		var decoded common.Tool
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.Equal(t, tool.RawInputSchema, decoded.RawInputSchema)
+	})
+}
@@ -0,0 +1,391 @@
+package schema
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Provider sanitisers
+// ===================
+//
+// Each provider accepts a different part of JSON Schema for tool inputs. The
+// sanitisers rewrite a schema into what the provider accepts, keeping its meaning
+// where they can and dropping keywords the provider would reject otherwise. They
+// return a new schema and leave their argument unchanged.
+
+// openAIFormats are the string formats OpenAI strict mode accepts
+var openAIFormats = []string{"date-time", "time", "date", "duration", "email", "hostname", "ipv4", "ipv6", "uuid"}
+
+// geminiFormats are the formats Gemini accepts for each type
+var geminiFormats = map[string][]string{
+	TypeString:  {"enum", "date-time"},
+	TypeNumber:  {"float", "double"},
+	TypeInteger: {"int32", "int64"},
+}
+
+// geminiExtras are the keywords Gemini accepts that Schema keeps in Extra
+var geminiExtras = []string{"example", "propertyOrdering"}
+
+// maxInlineDepth limits how many times a recursive $ref is inlined for providers
+// that don't support references
+const maxInlineDepth = 3
+
+// SanitizeOpenAIStrict rewrites the schema for OpenAI's strict mode: every object
+// lists all its properties as required and sets additionalProperties to false,
+// properties that were optional become nullable instead, oneOf becomes anyOf,
+// allOf is merged, and keywords strict mode rejects (such as default, minLength
+// and unknown keywords) are removed.
+func SanitizeOpenAIStrict(s *Schema) *Schema {
+	root := s.Clone()
+	ensureRootObject(root)
+	root.Walk(func(node *Schema) {
+		normalizeComposition(root, node)
+		nullableToType(node)
+	})
+	root.Walk(func(node *Schema) {
+		if isObject(node) {
+			if node.Properties == nil {
+				node.Properties = map[string]*Schema{}
+			}
+			for _, key := range sortedKeys(node.Properties) {
+				if !slices.Contains(node.Required, key) {
+					node.Properties[key] = makeNullable(node.Properties[key])
+				}
+			}
+			node.Required = append([]string{}, sortedKeys(node.Properties)...)
+			node.AdditionalProperties = Bool(false)
+		}
+
+		if node.Format != "" && !slices.Contains(openAIFormats, node.Format) {
+			node.Format = ""
+		}
+		node.Default = nil
+		node.Examples = nil
+		node.MinLength, node.MaxLength = nil, nil
+		node.MinProperties, node.MaxProperties = nil, nil
+		node.UniqueItems = false
+		node.Not = nil
+		node.Extra = nil
+	})
+	return root
+}
+
+// SanitizeAnthropic rewrites the schema for Anthropic tools: the root must be an
+// object without a top-level anyOf, oneOf or allOf, so those are merged into a
+// single object, and the OpenAPI nullable keyword becomes a null type.
+func SanitizeAnthropic(s *Schema) *Schema {
+	root := s.Clone()
+	flattenRootUnion(root)
+	ensureRootObject(root)
+	root.Walk(nullableToType)
+	return root
+}
+
+// SanitizeGemini rewrites the schema into the OpenAPI 3.0 subset Gemini accepts:
+// references are inlined, null types become nullable, type unions and oneOf
+// become anyOf, allOf is merged, const becomes a single-value enum, exclusive
+// bounds become inclusive ones, and unsupported keywords (additionalProperties,
+// multipleOf, non-string enums and so on) are removed.
+func SanitizeGemini(s *Schema) *Schema {
+	root := inlineRefs(s.Clone(), s, 0)
+	root.Defs = nil
+	root.Walk(func(node *Schema) {
+		normalizeComposition(root, node)
+		typeToNullable(node)
+	})
+	root.Walk(func(node *Schema) {
+		if node.Bool != nil {
+			// Gemini has no boolean schemas; true is the closest it can get
+			*node = Schema{}
+			return
+		}
+		if node.Const != nil {
+			if _, ok := node.Const.(string); ok && len(node.Enum) == 0 {
+				node.Enum = []any{node.Const}
+			}
+			node.Const = nil
+		}
+		if len(node.Enum) > 0 && !allStrings(node.Enum) {
+			if node.HasType(TypeString) {
+				node.Enum = stringValues(node.Enum)
+			} else {
+				node.Enum = nil
+			}
+		}
+		if node.ExclusiveMinimum != nil && node.Minimum == nil {
+			node.Minimum = node.ExclusiveMinimum
+		}
+		if node.ExclusiveMaximum != nil && node.Maximum == nil {
+			node.Maximum = node.ExclusiveMaximum
+		}
+		node.ExclusiveMinimum, node.ExclusiveMaximum = nil, nil
+		if node.Format != "" && (len(node.Type) != 1 || !slices.Contains(geminiFormats[node.Type[0]], node.Format)) {
+			node.Format = ""
+		}
+		if len(node.Examples) > 0 {
+			if node.Extra == nil {
+				node.Extra = map[string]any{}
+			}
+			if _, ok := node.Extra["example"]; !ok {
+				node.Extra["example"] = node.Examples[0]
+			}
+		}
+		for key := range node.Extra {
+			if !slices.Contains(geminiExtras, key) {
+				delete(node.Extra, key)
+			}
+		}
+		node.Examples = nil
+		node.AdditionalProperties = nil
+		node.MultipleOf = nil
+		node.UniqueItems = false
+		node.Not = nil
+	})
+	return root
+}
+
+func isObject(s *Schema) bool {
+	return s.Bool == nil && (s.HasType(TypeObject) || (len(s.Type) == 0 && s.Properties != nil))
+}
+
+// ensureRootObject makes a root schema without a type an object, as every
+// provider requires tool inputs to be objects
+func ensureRootObject(root *Schema) {
+	if root.Bool != nil {
+		*root = Schema{}
+	}
+	if len(root.Type) == 0 && root.Ref == "" && len(root.AnyOf) == 0 && len(root.OneOf) == 0 {
+		root.Type = []string{TypeObject}
+	}
+	if isObject(root) && root.Properties == nil {
+		root.Properties = map[string]*Schema{}
+	}
+}
+
+// normalizeComposition turns oneOf into anyOf and merges allOf into the schema
+func normalizeComposition(root, s *Schema) {
+	if len(s.OneOf) > 0 {
+		s.AnyOf = append(s.AnyOf, s.OneOf...)
+		s.OneOf = nil
+	}
+	if len(s.AllOf) > 0 {
+		branches := s.AllOf
+		s.AllOf = nil
+		for _, branch := range branches {
+			if branch.Ref != "" {
+				if resolved := root.ResolveRef(branch.Ref); resolved != nil {
+					branch = resolved.Clone()
+				}
+			}
+			normalizeComposition(root, branch)
+			mergeInto(s, branch)
+		}
+	}
+}
+
+// mergeInto adds the constraints of src to dst, as for allOf
+func mergeInto(dst, src *Schema) {
+	if src == nil || src.Bool != nil {
+		return
+	}
+	if len(dst.Type) == 0 {
+		dst.Type = slices.Clone(src.Type)
+	}
+	if dst.Description == "" {
+		dst.Description = src.Description
+	}
+	if dst.Format == "" {
+		dst.Format = src.Format
+	}
+	if len(dst.Enum) == 0 {
+		dst.Enum = src.Enum
+	}
+	if dst.Items == nil {
+		dst.Items = src.Items
+	}
+	if dst.Nullable && !src.IsNullable() {
+		dst.Nullable = false
+	}
+	if src.Properties != nil && dst.Properties == nil {
+		dst.Properties = map[string]*Schema{}
+	}
+	for key, property := range src.Properties {
+		if _, exists := dst.Properties[key]; !exists {
+			dst.Properties[key] = property
+		}
+	}
+	for _, key := range src.Required {
+		if !slices.Contains(dst.Required, key) {
+			dst.Required = append(dst.Required, key)
+		}
+	}
+	dst.AnyOf = append(dst.AnyOf, src.AnyOf...)
+}
+
+// flattenRootUnion merges a root anyOf, oneOf or allOf of objects into a single
+// object. Properties from every branch are kept, and only properties required by
+// every anyOf/oneOf branch stay required.
+func flattenRootUnion(root *Schema) {
+	normalizeComposition(root, root)
+	if len(root.AnyOf) == 0 {
+		return
+	}
+	branches := root.AnyOf
+	root.AnyOf = nil
+
+	var required []string
+	for i, branch := range branches {
+		if branch.Ref != "" {
+			if resolved := root.ResolveRef(branch.Ref); resolved != nil {
+				branch = resolved.Clone()
+			}
+		}
+		if branch.Bool != nil {
+			continue
+		}
+		branchRequired := branch.Required
+		branch = &Schema{Type: branch.Type, Properties: branch.Properties}
+		mergeInto(root, branch)
+		if i == 0 {
+			required = slices.Clone(branchRequired)
+		} else {
+			required = slices.DeleteFunc(required, func(key string) bool { return !slices.Contains(branchRequired, key) })
+		}
+	}
+	for _, key := range required {
+		if !slices.Contains(root.Required, key) {
+			root.Required = append(root.Required, key)
+		}
+	}
+	root.Type = []string{TypeObject}
+}
+
+// nullableToType turns the OpenAPI nullable keyword into a null type
+func nullableToType(s *Schema) {
+	if !s.Nullable {
+		return
+	}
+	s.Nullable = false
+	if len(s.Type) > 0 {
+		if !s.HasType(TypeNull) {
+			s.Type = append(s.Type, TypeNull)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, nil) {
+			s.Enum = append(s.Enum, nil)
+		}
+		return
+	}
+	if !s.IsNullable() {
+		s.AnyOf = append(s.AnyOf, &Schema{Type: []string{TypeNull}})
+	}
+}
+
+// makeNullable returns a schema that also allows null
+func makeNullable(s *Schema) *Schema {
+	if s.IsNullable() || s.Bool != nil {
+		return s
+	}
+	if len(s.Type) > 0 {
+		s.Type = append(s.Type, TypeNull)
+		if len(s.Enum) > 0 {
+			s.Enum = append(s.Enum, nil)
+		}
+		return s
+	}
+	if len(s.AnyOf) > 0 && s.Ref == "" {
+		s.AnyOf = append(s.AnyOf, &Schema{Type: []string{TypeNull}})
+		return s
+	}
+	// References can't have sibling keywords, so wrap the schema
+	return &Schema{AnyOf: []*Schema{s, {Type: []string{TypeNull}}}}
+}
+
+// typeToNullable turns null types and null anyOf branches into the nullable
+// keyword, and unions of other types into anyOf
+func typeToNullable(s *Schema) {
+	if s.HasType(TypeNull) {
+		s.Type = slices.DeleteFunc(s.Type, func(t string) bool { return t == TypeNull })
+		s.Nullable = true
+	}
+	if len(s.AnyOf) > 0 {
+		branches := slices.DeleteFunc(s.AnyOf, func(branch *Schema) bool {
+			return len(branch.Type) == 1 && branch.Type[0] == TypeNull
+		})
+		if len(branches) < len(s.AnyOf) {
+			s.Nullable = true
+		}
+		s.AnyOf = branches
+		if len(s.AnyOf) == 1 {
+			only := s.AnyOf[0]
+			s.AnyOf = nil
+			nullable := s.Nullable || only.Nullable
+			description := s.Description
+			*s = *only
+			s.Nullable = nullable
+			if description != "" {
+				s.Description = description
+			}
+		}
+	}
+	if len(s.Type) > 1 {
+		for _, t := range s.Type {
+			s.AnyOf = append(s.AnyOf, &Schema{Type: []string{t}})
+		}
+		s.Type = nil
+	}
+}
+
+// inlineRefs replaces references with copies of their definitions. Recursive
+// definitions are inlined up to maxInlineDepth times and then become objects.
+func inlineRefs(s *Schema, root *Schema, depth int) *Schema {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		resolved := root.ResolveRef(s.Ref)
+		if resolved == nil || depth >= maxInlineDepth {
+			return &Schema{
+				Type:        []string{TypeObject},
+				Description: strings.TrimSpace(s.Description + fmt.Sprintf(" (see %s)", s.Ref)),
+			}
+		}
+		inlined := inlineRefs(resolved.Clone(), root, depth+1)
+		if s.Description != "" {
+			inlined.Description = s.Description
+		}
+		return inlined
+	}
+
+	for key, property := range s.Properties {
+		s.Properties[key] = inlineRefs(property, root, depth)
+	}
+	s.Items = inlineRefs(s.Items, root, depth)
+	s.AdditionalProperties = inlineRefs(s.AdditionalProperties, root, depth)
+	s.Not = inlineRefs(s.Not, root, depth)
+	for _, list := range [][]*Schema{s.AnyOf, s.OneOf, s.AllOf} {
+		for i, branch := range list {
+			list[i] = inlineRefs(branch, root, depth)
+		}
+	}
+	return s
+}
+
+func allStrings(values []any) bool {
+	for _, value := range values {
+		if _, ok := value.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func stringValues(values []any) []any {
+	var strs []any
+	for _, value := range values {
+		if value != nil {
+			strs = append(strs, fmt.Sprint(value))
+		}
+	}
+	return strs
+}
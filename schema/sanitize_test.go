@@ -0,0 +1,176 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseSchema(t *testing.T, input string) *Schema {
+	t.Helper()
+	var s Schema
+	require.NoError(t, json.Unmarshal([]byte(input), &s))
+	return &s
+}
+
+func assertSchemaJSON(t *testing.T, expected string, s *Schema) {
+	t.Helper()
+	encoded, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, expected, string(encoded))
+}
+
+const sanitizeInput = `{
+	"type": "object",
+	"properties": {
+		"query": {"type": "string", "minLength": 1, "default": "", "format": "regex"},
+		"limit": {"type": "integer", "exclusiveMinimum": 0, "multipleOf": 5},
+		"unit": {"type": "string", "enum": ["c", "f"], "nullable": true},
+		"filter": {"$ref": "#/$defs/Filter"},
+		"mode": {"oneOf": [{"const": "fast"}, {"const": "slow"}]},
+		"meta": {"type": "object", "additionalProperties": {"type": "string"}, "x-internal": true}
+	},
+	"required": ["query"],
+	"$defs": {
+		"Filter": {
+			"allOf": [
+				{"type": "object", "properties": {"field": {"type": "string"}}, "required": ["field"]},
+				{"properties": {"value": {"type": ["string", "number", "null"]}}}
+			]
+		}
+	}
+}`
+
+func TestSanitizeOpenAIStrict(t *testing.T) {
+	input := parseSchema(t, sanitizeInput)
+	before, err := json.Marshal(input)
+	require.NoError(t, err)
+
+	assertSchemaJSON(t, `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string"},
+			"limit": {"type": ["integer", "null"], "exclusiveMinimum": 0, "multipleOf": 5},
+			"unit": {"type": ["string", "null"], "enum": ["c", "f", null]},
+			"filter": {"anyOf": [{"$ref": "#/$defs/Filter"}, {"type": "null"}]},
+			"mode": {"anyOf": [{"const": "fast"}, {"const": "slow"}, {"type": "null"}]},
+			"meta": {"type": ["object", "null"], "properties": {}, "required": [], "additionalProperties": false}
+		},
+		"required": ["filter", "limit", "meta", "mode", "query", "unit"],
+		"additionalProperties": false,
+		"$defs": {
+			"Filter": {
+				"type": "object",
+				"properties": {
+					"field": {"type": "string"},
+					"value": {"type": ["string", "number", "null"]}
+				},
+				"required": ["field", "value"],
+				"additionalProperties": false
+			}
+		}
+	}`, SanitizeOpenAIStrict(input))
+
+	after, err := json.Marshal(input)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(before), string(after), "the input is unchanged")
+}
+
+func TestSanitizeAnthropic(t *testing.T) {
+	assertSchemaJSON(t, `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string", "minLength": 1, "default": "", "format": "regex"},
+			"limit": {"type": "integer", "exclusiveMinimum": 0, "multipleOf": 5},
+			"unit": {"type": ["string", "null"], "enum": ["c", "f", null]},
+			"filter": {"$ref": "#/$defs/Filter"},
+			"mode": {"oneOf": [{"const": "fast"}, {"const": "slow"}]},
+			"meta": {"type": "object", "additionalProperties": {"type": "string"}, "x-internal": true}
+		},
+		"required": ["query"],
+		"$defs": {
+			"Filter": {
+				"allOf": [
+					{"type": "object", "properties": {"field": {"type": "string"}}, "required": ["field"]},
+					{"properties": {"value": {"type": ["string", "number", "null"]}}}
+				]
+			}
+		}
+	}`, SanitizeAnthropic(parseSchema(t, sanitizeInput)))
+
+	t.Run("root unions become one object", func(t *testing.T) {
+		s := parseSchema(t, `{
+			"anyOf": [
+				{"type": "object", "properties": {"id": {"type": "string"}, "name": {"type": "string"}}, "required": ["id", "name"]},
+				{"type": "object", "properties": {"id": {"type": "string"}, "email": {"type": "string"}}, "required": ["id"]}
+			]
+		}`)
+		assertSchemaJSON(t, `{
+			"type": "object",
+			"properties": {"id": {"type": "string"}, "name": {"type": "string"}, "email": {"type": "string"}},
+			"required": ["id"]
+		}`, SanitizeAnthropic(s))
+	})
+
+	t.Run("missing root type", func(t *testing.T) {
+		assertSchemaJSON(t, `{"type": "object", "properties": {}}`, SanitizeAnthropic(&Schema{}))
+	})
+}
+
+func TestSanitizeGemini(t *testing.T) {
+	assertSchemaJSON(t, `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string", "minLength": 1, "default": ""},
+			"limit": {"type": "integer", "minimum": 0},
+			"unit": {"type": "string", "enum": ["c", "f"], "nullable": true},
+			"filter": {
+				"type": "object",
+				"properties": {
+					"field": {"type": "string"},
+					"value": {"anyOf": [{"type": "string"}, {"type": "number"}], "nullable": true}
+				},
+				"required": ["field"]
+			},
+			"mode": {"anyOf": [{"enum": ["fast"]}, {"enum": ["slow"]}]},
+			"meta": {"type": "object"}
+		},
+		"required": ["query"]
+	}`, SanitizeGemini(parseSchema(t, sanitizeInput)))
+
+	t.Run("recursive references are cut off", func(t *testing.T) {
+		s := parseSchema(t, `{
+			"type": "object",
+			"properties": {"root": {"$ref": "#/$defs/Node"}},
+			"$defs": {"Node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/Node"}}}}
+		}`)
+		sanitized := SanitizeGemini(s)
+		node := sanitized.Properties["root"]
+		for i := 0; i < maxInlineDepth-1; i++ {
+			node = node.Properties["child"]
+			require.NotNil(t, node)
+		}
+		assertSchemaJSON(t, `{"type": "object", "description": "(see #/$defs/Node)"}`, node.Properties["child"])
+	})
+
+	t.Run("examples, numeric enums and nullable unions", func(t *testing.T) {
+		s := parseSchema(t, `{
+			"type": "object",
+			"properties": {
+				"level": {"type": "integer", "enum": [1, 2, 3], "examples": [2]},
+				"code": {"type": "string", "enum": ["a", 1]},
+				"note": {"anyOf": [{"type": "string", "format": "date-time"}, {"type": "null"}], "description": "When"}
+			}
+		}`)
+		assertSchemaJSON(t, `{
+			"type": "object",
+			"properties": {
+				"level": {"type": "integer", "example": 2},
+				"code": {"type": "string", "enum": ["a", "1"]},
+				"note": {"type": "string", "format": "date-time", "nullable": true, "description": "When"}
+			}
+		}`, SanitizeGemini(s))
+	})
+}
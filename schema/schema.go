@@ -0,0 +1,22 @@
+// Package schema converts between erdo parameter definitions and the JSON
+// schemas of LLM tool inputs
+package schema
+
+import (
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// FromParameters builds a tool input schema from parameter definitions. Each
+// parameter becomes a property named by its key, typed by its parameter type
+// (string, integer, float as number, bool as boolean, json as object) and
+// described by its description, and required parameters are listed as required.
+func FromParameters(params []common.ParameterDefinition) common.JSONSchema {
+	return common.SchemaFromParameters(params)
+}
+
+// ToParameters returns the parameter definitions for a tool input schema,
+// sorted by key. It is the reverse of FromParameters, except that array
+// properties also become json parameters.
+func ToParameters(schema common.JSONSchema) []common.ParameterDefinition {
+	return schema.ToParameters()
+}
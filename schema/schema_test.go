@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromParameters(t *testing.T) {
+	description := "The search query"
+	params := []common.ParameterDefinition{
+		{Name: "Query", Key: "query", Type: common.ParameterTypeString, Description: &description, IsRequired: true},
+		{Name: "Limit", Key: "limit", Type: common.ParameterTypeInteger},
+		{Name: "Threshold", Key: "threshold", Type: common.ParameterTypeFloat, IsRequired: true},
+		{Name: "Exact", Key: "exact", Type: common.ParameterTypeBoolean},
+		{Name: "Filters", Key: "filters", Type: common.ParameterTypeJson},
+	}
+
+	schema := FromParameters(params)
+	assert.Equal(t, common.JSONSchema{
+		Type: common.JSONSchemaTypeObject,
+		Properties: map[string]common.JSONSchemaProperty{
+			"query":     {Type: common.JSONSchemaTypeString, Description: "The search query"},
+			"limit":     {Type: common.JSONSchemaTypeInteger},
+			"threshold": {Type: common.JSONSchemaTypeNumber},
+			"exact":     {Type: common.JSONSchemaTypeBoolean},
+			"filters":   {Type: common.JSONSchemaTypeObject},
+		},
+		Required: []string{"query", "threshold"},
+	}, schema)
+
+	t.Run("round trip", func(t *testing.T) {
+		back := ToParameters(schema)
+		require.Len(t, back, len(params))
+		byKey := map[string]common.ParameterDefinition{}
+		for _, param := range back {
+			byKey[param.Key] = param
+		}
+		for _, param := range params {
+			got := byKey[param.Key]
+			assert.Equal(t, param.Type, got.Type, param.Key)
+			assert.Equal(t, param.IsRequired, got.IsRequired, param.Key)
+			assert.Equal(t, param.Description, got.Description, param.Key)
+		}
+		assert.Equal(t, schema, FromParameters(back))
+	})
+
+	t.Run("no parameters still has properties and required", func(t *testing.T) {
+		encoded, err := json.Marshal(FromParameters(nil))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type": "object", "properties": {}, "required": []}`, string(encoded))
+	})
+}
+
+func TestToParameters(t *testing.T) {
+	params := ToParameters(common.JSONSchema{
+		Type: common.JSONSchemaTypeObject,
+		Properties: map[string]common.JSONSchemaProperty{
+			"tags": {Type: common.JSONSchemaTypeArray, Items: &common.JSONSchemaProperty{Type: common.JSONSchemaTypeString}},
+			"name": {Type: common.JSONSchemaTypeString},
+		},
+		Required: []string{"name"},
+	})
+	assert.Equal(t, []common.ParameterDefinition{
+		{Name: "name", Key: "name", Type: common.ParameterTypeString, IsRequired: true},
+		{Name: "tags", Key: "tags", Type: common.ParameterTypeJson},
+	}, params)
+}
+
+func TestActionTools(t *testing.T) {
+	action := common.ActionDefinition{
+		Name:        "search",
+		Description: "Search the web",
+		Parameters: []common.ParameterDefinition{
+			{Key: "query", Type: common.ParameterTypeString, IsRequired: true},
+			{Key: "limit", Type: common.ParameterTypeInteger},
+		},
+	}
+
+	tool := action.ToTool()
+	assert.Equal(t, "search", tool.Name)
+	assert.Equal(t, "search", tool.ActionType)
+	assert.Equal(t, "Search the web", tool.Description)
+	assert.Equal(t, FromParameters(action.Parameters), tool.InputSchema)
+	assert.Equal(t, map[string]any{"query": "{{query}}", "limit": "{{limit?}}"}, tool.Parameters)
+
+	exported := common.ExportActionsResponse{Services: map[string]common.ServiceDefinition{
+		"websearch": {Name: "websearch", Actions: []common.ActionDefinition{action}},
+		"llm":       {Name: "llm", Actions: []common.ActionDefinition{{Name: "llm.message"}}},
+	}}
+	tools := exported.Tools()
+	require.Len(t, tools, 2)
+	assert.Equal(t, "llm.message", tools[0].ActionType)
+	assert.Equal(t, "llm_message", tools[0].Name)
+	assert.Equal(t, "websearch.search", tools[1].ActionType)
+	assert.Equal(t, "websearch_search", tools[1].Name)
+	assert.Equal(t, []string{"query"}, tools[1].InputSchema.Required)
+}
@@ -0,0 +1,15 @@
+package schema
+
+import (
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// ValidateOptions configures validation; see common.SchemaValidateOptions
+type ValidateOptions = common.SchemaValidateOptions
+
+// ValidateToolInput checks the input of a call to the tool against its
+// RawInputSchema or InputSchema, and returns the input after any coercion and
+// its violations
+func ValidateToolInput(tool common.Tool, input any, opts ValidateOptions) (any, common.Violations) {
+	return tool.Schema().ValidateWithOptions(input, opts)
+}
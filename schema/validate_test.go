@@ -0,0 +1,207 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const searchSchema = `{
+	"type": "object",
+	"properties": {
+		"query": {"type": "string", "minLength": 1},
+		"limit": {"type": "integer", "minimum": 1, "maximum": 50},
+		"exact": {"type": "boolean"},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+		"filter": {"$ref": "#/$defs/Filter"},
+		"sort": {"enum": ["relevance", "date"]},
+		"cursor": {"type": ["integer", "null"]},
+		"range": {"anyOf": [{"type": "string", "format": "date"}, {"type": "object", "properties": {"from": {"type": "string"}}, "required": ["from"]}]}
+	},
+	"required": ["query"],
+	"additionalProperties": false,
+	"$defs": {
+		"Filter": {"type": "object", "properties": {"field": {"type": "string"}, "value": {"type": "number"}}, "required": ["field"]}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	s := parseSchema(t, searchSchema)
+
+	t.Run("valid input", func(t *testing.T) {
+		assert.Empty(t, s.Validate(map[string]any{
+			"query":  "erdo",
+			"limit":  10,
+			"exact":  false,
+			"tags":   []string{"a", "b"},
+			"filter": map[string]any{"field": "year", "value": 2024.5},
+			"sort":   "date",
+			"cursor": nil,
+			"range":  map[string]any{"from": "2024-01-01"},
+		}))
+	})
+
+	t.Run("every violation is reported with its path", func(t *testing.T) {
+		violations := s.Validate(map[string]any{
+			"query":  "",
+			"limit":  2.5,
+			"tags":   []any{"a", "a", 3},
+			"filter": map[string]any{"value": "high"},
+			"sort":   "name",
+			"range":  map[string]any{},
+			"extra":  true,
+		})
+		assert.Equal(t, common.Violations{
+			{Path: "extra", Expected: "no additional properties", Got: "unexpected property"},
+			{Path: "filter.field", Expected: "a required property", Got: "missing"},
+			{Path: "filter.value", Expected: "number", Got: `string "high"`},
+			{Path: "limit", Expected: "integer", Got: "number 2.5"},
+			{Path: "query", Expected: "a string of at least 1 characters", Got: `string ""`},
+			{Path: "range.from", Expected: "a required property", Got: "missing"},
+			{Path: "sort", Expected: `one of "relevance", "date"`, Got: `string "name"`},
+			{Path: "tags[1]", Expected: "a unique item (same as item 0)", Got: `string "a"`},
+			{Path: "tags[2]", Expected: "string", Got: "number 3"},
+		}, violations)
+	})
+
+	t.Run("missing required property and wrong root type", func(t *testing.T) {
+		assert.Equal(t, common.Violations{{Path: "query", Expected: "a required property", Got: "missing"}}, s.Validate(map[string]any{}))
+		assert.Equal(t, common.Violations{{Path: "", Expected: "object", Got: "array of 0 items"}}, s.Validate([]any{}))
+	})
+
+	t.Run("anyOf without a matching type lists the options", func(t *testing.T) {
+		violations := s.Validate(map[string]any{"query": "q", "range": 5})
+		assert.Equal(t, common.Violations{{Path: "range", Expected: "one of: string; object", Got: "number 5"}}, violations)
+	})
+
+	t.Run("oneOf, not and numeric bounds", func(t *testing.T) {
+		s := parseSchema(t, `{
+			"oneOf": [{"type": "number", "multipleOf": 5}, {"type": "number", "multipleOf": 3}],
+			"not": {"const": 30},
+			"exclusiveMaximum": 100
+		}`)
+		assert.Empty(t, s.Validate(10))
+		assert.Len(t, s.Validate(15), 1, "matches both branches")
+		assert.Len(t, s.Validate(30), 2, "matches both branches and the not schema")
+		assert.Equal(t, common.Violations{{Path: "", Expected: "a number < 100", Got: "number 100"}}, s.Validate(100))
+	})
+}
+
+func TestValidateCircularRefs(t *testing.T) {
+	t.Run("self and mutual references are violations", func(t *testing.T) {
+		self := parseSchema(t, `{"$ref": "#/$defs/a", "$defs": {"a": {"$ref": "#/$defs/a"}}}`)
+		assert.Equal(t, common.Violations{
+			{Path: "", Expected: "a schema without circular references", Got: "#/$defs/a refers back to itself"},
+		}, self.Validate("x"))
+
+		mutual := parseSchema(t, `{
+			"type": "object",
+			"properties": {"v": {"$ref": "#/$defs/a"}},
+			"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}
+		}`)
+		assert.Equal(t, common.Violations{
+			{Path: "v", Expected: "a schema without circular references", Got: "#/$defs/a refers back to itself"},
+		}, mutual.Validate(map[string]any{"v": 1}))
+		assert.Empty(t, mutual.Validate(map[string]any{}), "references are only followed for values")
+
+		composed := parseSchema(t, `{"$ref": "#/$defs/a", "$defs": {"a": {"anyOf": [{"$ref": "#/$defs/a"}]}}}`)
+		assert.NotEmpty(t, composed.Validate(1))
+	})
+
+	t.Run("recursive schemas that consume the value are fine", func(t *testing.T) {
+		tree := parseSchema(t, `{
+			"$ref": "#/$defs/node",
+			"$defs": {"node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}}}}
+		}`)
+		assert.Empty(t, tree.Validate(map[string]any{"children": []any{map[string]any{"children": []any{}}}}))
+		assert.Equal(t, common.Violations{
+			{Path: "children[0].children", Expected: "array", Got: "number 1"},
+		}, tree.Validate(map[string]any{"children": []any{map[string]any{"children": 1}}}))
+	})
+}
+
+func TestValidateCoercion(t *testing.T) {
+	s := parseSchema(t, searchSchema)
+	opts := ValidateOptions{Coerce: true}
+
+	input := map[string]any{
+		"query":  "erdo",
+		"limit":  "10",
+		"exact":  "TRUE",
+		"tags":   "news",
+		"filter": `{"field": "year", "value": 2024}`,
+		"cursor": "null",
+	}
+	value, violations := s.ValidateWithOptions(input, opts)
+	assert.Empty(t, violations)
+	assert.Equal(t, map[string]any{
+		"query":  "erdo",
+		"limit":  10.0,
+		"exact":  true,
+		"tags":   []any{"news"},
+		"filter": map[string]any{"field": "year", "value": 2024.0},
+		"cursor": nil,
+	}, value)
+	assert.Equal(t, "10", input["limit"], "the input isn't modified")
+
+	t.Run("JSON-encoded arrays and nested coercion", func(t *testing.T) {
+		s := parseSchema(t, `{"type": "array", "items": {"type": "integer"}}`)
+		value, violations := s.ValidateWithOptions(`["1", 2]`, opts)
+		assert.Empty(t, violations)
+		assert.Equal(t, []any{1.0, 2.0}, value)
+
+		value, violations = s.ValidateWithOptions("7", opts)
+		assert.Empty(t, violations)
+		assert.Equal(t, []any{7.0}, value)
+	})
+
+	t.Run("values that can't be coerced are still violations", func(t *testing.T) {
+		_, violations := s.ValidateWithOptions(map[string]any{"query": "q", "limit": "ten", "exact": "maybe"}, opts)
+		assert.Equal(t, common.Violations{
+			{Path: "exact", Expected: "boolean", Got: `string "maybe"`},
+			{Path: "limit", Expected: "integer", Got: `string "ten"`},
+		}, violations)
+
+		_, violations = s.ValidateWithOptions(map[string]any{"query": "q", "limit": "2.5"}, opts)
+		assert.Equal(t, common.Violations{{Path: "limit", Expected: "integer", Got: `string "2.5"`}}, violations)
+	})
+
+	t.Run("coercion picks the matching anyOf branch", func(t *testing.T) {
+		s := parseSchema(t, `{"anyOf": [{"type": "boolean"}, {"type": "array", "items": {"type": "integer"}}]}`)
+		value, violations := s.ValidateWithOptions(`[1, "2"]`, opts)
+		assert.Empty(t, violations)
+		assert.Equal(t, []any{1.0, 2.0}, value)
+
+		value, violations = s.ValidateWithOptions("yes", opts)
+		assert.Equal(t, "yes", value)
+		assert.Equal(t, common.Violations{{Path: "", Expected: "one of: boolean; array", Got: `string "yes"`}}, violations)
+	})
+}
+
+func TestValidateToolInput(t *testing.T) {
+	tool := common.Tool{
+		Name: "search",
+		InputSchema: common.JSONSchema{
+			Type:       common.JSONSchemaTypeObject,
+			Properties: map[string]common.JSONSchemaProperty{"limit": {Type: common.JSONSchemaTypeInteger}},
+			Required:   []string{"limit"},
+		},
+	}
+
+	value, violations := ValidateToolInput(tool, map[string]any{"limit": "5"}, ValidateOptions{Coerce: true})
+	assert.Empty(t, violations)
+	assert.Equal(t, map[string]any{"limit": 5.0}, value)
+
+	_, violations = ValidateToolInput(tool, map[string]any{"limit": "five"}, ValidateOptions{})
+	assert.Equal(t,
+		"The arguments for tool \"search\" didn't match the expected schema:\n"+
+			"- limit: expected integer, got string \"five\"\n"+
+			"Fix these problems and try again.",
+		violations.CorrectionMessage(`the arguments for tool "search"`))
+
+	// Invalid schemas are rejected when the tool is decoded
+	err := json.Unmarshal([]byte(`{"name": "search", "raw_input_schema": {"type": 5}}`), &tool)
+	assert.ErrorContains(t, err, "invalid schema type")
+}
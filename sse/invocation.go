@@ -0,0 +1,36 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// EncodeInvocationEvent writes an invocation event, named by its type, with its
+// sequence as the ID and the JSON-encoded event as the data
+func (e *Encoder) EncodeInvocationEvent(event common.InvocationEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+	var id string
+	if event.Sequence > 0 {
+		id = strconv.FormatInt(event.Sequence, 10)
+	}
+	return e.Encode(Event{ID: id, Event: string(event.Type), Data: string(data)})
+}
+
+// DecodeInvocationEvent reads the next invocation event, with its typed payload
+func (d *Decoder) DecodeInvocationEvent() (common.InvocationEvent, error) {
+	event, err := d.Decode()
+	if err != nil {
+		return common.InvocationEvent{}, err
+	}
+	var invocationEvent common.InvocationEvent
+	if err := json.Unmarshal([]byte(event.Data), &invocationEvent); err != nil {
+		return common.InvocationEvent{}, fmt.Errorf("failed to decode %q event %q: %w", event.Event, event.ID, err)
+	}
+	return invocationEvent, nil
+}
@@ -0,0 +1,200 @@
+// Package sse reads and writes text/event-stream framing, as used by bot
+// invocation streams. It follows the HTML Server-Sent Events spec: events are
+// blocks of "field: value" lines ended by a blank line, data can span lines,
+// and the last event ID carries over to later events until it's changed.
+package sse
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the MIME type of event streams
+const ContentType = "text/event-stream"
+
+// Event is a server-sent event
+type Event struct {
+	// ID is the event's ID, or when decoding, the last ID sent on the stream
+	ID string
+	// Event is the event's type; an empty type is "message" to browsers
+	Event string
+	// Data is the event's data, which may contain newlines
+	Data string
+	// Retry is the reconnection time sent with the event, or 0 if none was
+	Retry time.Duration
+}
+
+// ErrInvalidField is returned when encoding an ID or event type containing a newline
+var ErrInvalidField = errors.New("invalid event field")
+
+// Encoder writes events to a stream
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an encoder writing to w. If w has a Flush method, like
+// http.ResponseWriter or bufio.Writer, it's flushed after every event.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes an event
+func (e *Encoder) Encode(event Event) error {
+	var b strings.Builder
+	if event.ID != "" {
+		if strings.ContainsAny(event.ID, "\r\n\x00") {
+			return fmt.Errorf("%w: id %q", ErrInvalidField, event.ID)
+		}
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		if strings.ContainsAny(event.Event, "\r\n") {
+			return fmt.Errorf("%w: event %q", ErrInvalidField, event.Event)
+		}
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range splitLines(event.Data) {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return e.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore; it's used to keep idle
+// connections open
+func (e *Encoder) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return e.write(b.String())
+}
+
+func (e *Encoder) write(s string) error {
+	if _, err := io.WriteString(e.w, s); err != nil {
+		return err
+	}
+	switch f := e.w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
+
+// splitLines splits text on any of the line endings the format allows
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
+
+// Decoder reads events from a stream
+type Decoder struct {
+	r       *bufio.Reader
+	started bool
+	lastID  string
+	// skipLF is set after a \r, so a \n right after it doesn't end another line
+	skipLF bool
+}
+
+// NewDecoder returns a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// LastEventID returns the ID of the last event, for resuming the stream with a
+// Last-Event-ID header
+func (d *Decoder) LastEventID() string {
+	return d.lastID
+}
+
+// Decode reads the next event. Comments and blocks without data are skipped,
+// as browsers do. It returns io.EOF at the end of the stream, dropping an
+// event that wasn't ended by a blank line.
+func (d *Decoder) Decode() (Event, error) {
+	var event Event
+	var data strings.Builder
+	hasData := false
+	for {
+		// A partial line at the end of the stream is dropped with its event
+		line, err := d.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+
+		if line == "" {
+			if !hasData {
+				event = Event{}
+				continue
+			}
+			event.ID = d.lastID
+			event.Data = strings.TrimSuffix(data.String(), "\n")
+			return event, nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			// Comment
+		case "event":
+			event.Event = value
+		case "data":
+			data.WriteString(value + "\n")
+			hasData = true
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				d.lastID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine reads a line ended by \r\n, \n or \r, without the line ending
+func (d *Decoder) readLine() (string, error) {
+	var line strings.Builder
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return line.String(), err
+		}
+		skipLF := d.skipLF
+		d.skipLF = false
+		switch c {
+		case '\n':
+			if skipLF {
+				continue
+			}
+			return d.stripBOM(line.String()), nil
+		case '\r':
+			d.skipLF = true
+			return d.stripBOM(line.String()), nil
+		default:
+			line.WriteByte(c)
+		}
+	}
+}
+
+// stripBOM removes a byte order mark from the start of the stream
+func (d *Decoder) stripBOM(line string) string {
+	if !d.started {
+		d.started = true
+		return strings.TrimPrefix(line, "\ufeff")
+	}
+	return line
+}
@@ -0,0 +1,119 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAll(t *testing.T, stream string) []Event {
+	t.Helper()
+	d := NewDecoder(strings.NewReader(stream))
+	var events []Event
+	for {
+		event, err := d.Decode()
+		if err == io.EOF {
+			return events
+		}
+		require.NoError(t, err)
+		events = append(events, event)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	require.NoError(t, e.Encode(Event{ID: "1", Event: "status", Data: "line one\nline two\r\nline three", Retry: 3 * time.Second}))
+	require.NoError(t, e.Comment("ping"))
+	require.NoError(t, e.Encode(Event{Data: ""}))
+	assert.Equal(t, "id: 1\nevent: status\nretry: 3000\ndata: line one\ndata: line two\ndata: line three\n\n: ping\n\ndata: \n\n", buf.String())
+
+	assert.ErrorIs(t, e.Encode(Event{ID: "a\nb"}), ErrInvalidField)
+	assert.ErrorIs(t, e.Encode(Event{Event: "a\rb"}), ErrInvalidField)
+}
+
+func TestEncoderFlushes(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	require.NoError(t, NewEncoder(w).Encode(Event{Data: "x"}))
+	assert.Equal(t, "data: x\n\n", buf.String())
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("spec examples", func(t *testing.T) {
+		events := decodeAll(t, "\ufeff: comment\n"+
+			"data: first\ndata:second\n\n"+
+			"id: 7\nevent: status\nretry: 500\ndata:  two spaces\n\n"+
+			"data: keeps the last id\n\n"+
+			"id\ndata: clears the id\n\n"+
+			"event: no data\n\n"+
+			"retry: soon\nunknown: field\ndata\n\n"+
+			"data: unterminated")
+		assert.Equal(t, []Event{
+			{Data: "first\nsecond"},
+			{ID: "7", Event: "status", Data: " two spaces", Retry: 500 * time.Millisecond},
+			{ID: "7", Data: "keeps the last id"},
+			{Data: "clears the id"},
+			{Data: ""},
+		}, events)
+	})
+
+	t.Run("line endings", func(t *testing.T) {
+		events := decodeAll(t, "data: a\r\ndata: b\rdata: c\n\r\ndata: d\r\r")
+		assert.Equal(t, []Event{{Data: "a\nb\nc"}, {Data: "d"}}, events)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		sent := []Event{
+			{ID: "1", Event: "message content delta", Data: "{\"delta\":\"a\\nb\"}"},
+			{ID: "2", Data: "multi\nline\n\ndata"},
+		}
+		for _, event := range sent {
+			require.NoError(t, e.Encode(event))
+		}
+		assert.Equal(t, sent, decodeAll(t, buf.String()))
+	})
+}
+
+func TestInvocationEvents(t *testing.T) {
+	invocationID := uuid.MustParse("6f1d7a2e-3b4c-4d5e-8f90-1a2b3c4d5e6f")
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	sent := []common.InvocationEvent{
+		{Type: common.InvocationEventTypeStepStarted, InvocationID: invocationID, Sequence: 1, CreatedAt: createdAt,
+			Payload: &common.StepStartedPayload{StepID: "s1", StepKey: "search", ActionType: "llm.message"}},
+		{Type: common.InvocationEventTypeMessageContentDelta, InvocationID: invocationID, Sequence: 2, CreatedAt: createdAt,
+			Payload: &common.ContentDeltaPayload{MessageID: "m1", Delta: "Hello\nworld"}},
+		{Type: common.InvocationEventTypeLog, InvocationID: invocationID, Sequence: 3, CreatedAt: createdAt,
+			Payload: &common.LogPayload{Level: common.LogLevelWarn, Message: "slow", Fields: map[string]any{"ms": 1200.0}}},
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, event := range sent {
+		require.NoError(t, e.EncodeInvocationEvent(event))
+	}
+	assert.True(t, strings.HasPrefix(buf.String(), "id: 1\nevent: step started\ndata: {"), buf.String())
+
+	d := NewDecoder(&buf)
+	for _, want := range sent {
+		got, err := d.DecodeInvocationEvent()
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := d.DecodeInvocationEvent()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "3", d.LastEventID())
+
+	_, err = NewDecoder(strings.NewReader("event: status\ndata: not json\n\n")).DecodeInvocationEvent()
+	assert.ErrorContains(t, err, `failed to decode "status" event`)
+}
@@ -0,0 +1,408 @@
+// Package stream reduces a bot invocation's event stream to the messages, step
+// outputs, step statuses and result it describes, so the CLI, tests and
+// transcript storage don't each stitch content deltas together.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/google/uuid"
+)
+
+// Content is a block of a message's or step output's content
+type Content struct {
+	ID          string `json:"id,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     string `json:"content"`
+	// Final is set once the block's content result has been received, after
+	// which Content is the result rather than the joined deltas
+	Final bool `json:"final"`
+}
+
+// Message is a message the bot created
+type Message struct {
+	ID       string    `json:"id"`
+	Role     string    `json:"role"`
+	StepID   string    `json:"step_id,omitempty"`
+	Contents []Content `json:"contents"`
+	Finished bool      `json:"finished"`
+}
+
+// Text joins the message's content blocks
+func (m Message) Text() string {
+	return joinContents(m.Contents)
+}
+
+// StepOutput is the output of a step that isn't sent as a message
+type StepOutput struct {
+	ID       string    `json:"id"`
+	StepID   string    `json:"step_id,omitempty"`
+	StepKey  string    `json:"step_key,omitempty"`
+	Contents []Content `json:"contents"`
+	Finished bool      `json:"finished"`
+}
+
+// Text joins the step output's content blocks
+func (o StepOutput) Text() string {
+	return joinContents(o.Contents)
+}
+
+func joinContents(contents []Content) string {
+	texts := make([]string, len(contents))
+	for i, content := range contents {
+		texts[i] = content.Content
+	}
+	return strings.Join(texts, "\n")
+}
+
+// StepStatusRunning is the status of steps that have started but have no result yet
+const StepStatusRunning common.Status = "running"
+
+// Step is the state of a step the invocation ran
+type Step struct {
+	ID         string `json:"id"`
+	Key        string `json:"key,omitempty"`
+	ActionType string `json:"action_type"`
+	// Status is StepStatusRunning until the step's result, then the result's status
+	Status common.Status `json:"status"`
+	// RunningStatus is the step's latest status message
+	RunningStatus string         `json:"running_status,omitempty"`
+	Result        *common.Result `json:"result,omitempty"`
+}
+
+// Snapshot is the state of an invocation after the events reduced so far
+type Snapshot struct {
+	InvocationID uuid.UUID `json:"invocation_id"`
+	// LastSequence is the sequence of the last event applied
+	LastSequence int64                       `json:"last_sequence"`
+	Messages     []Message                   `json:"messages"`
+	StepOutputs  []StepOutput                `json:"step_outputs"`
+	Steps        []Step                      `json:"steps"`
+	Statuses     []common.StatusPayload      `json:"statuses"`
+	RequiresInfo *common.RequiresInfoPayload `json:"requires_info,omitempty"`
+	Errors       []common.ErrorPayload       `json:"errors"`
+	Datasets     []common.Dataset            `json:"datasets"`
+	Logs         []common.LogPayload         `json:"logs"`
+	Result       *common.Result              `json:"result,omitempty"`
+}
+
+// Reducer applies invocation events to a Snapshot. It's safe for concurrent
+// use, so a renderer can take snapshots while events are applied.
+type Reducer struct {
+	mu    sync.Mutex
+	state Snapshot
+}
+
+// NewReducer returns a reducer with an empty snapshot
+func NewReducer() *Reducer {
+	return &Reducer{}
+}
+
+// Reduce applies the events to an empty snapshot
+func Reduce(events []common.InvocationEvent) (Snapshot, error) {
+	r := NewReducer()
+	for _, event := range events {
+		if err := r.Apply(event); err != nil {
+			return r.Snapshot(), err
+		}
+	}
+	return r.Snapshot(), nil
+}
+
+// Snapshot returns a copy of the current state
+func (r *Reducer) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.clone()
+}
+
+// Apply updates the state with an event. Events with a sequence at or before
+// the last one applied are ignored, so streams can be resumed and replayed.
+// Content for messages and step outputs that weren't created in the events seen
+// creates them. Events without a typed payload, like bot started, only advance
+// the sequence.
+func (r *Reducer) Apply(event common.InvocationEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &r.state
+	if event.Sequence > 0 && event.Sequence <= s.LastSequence {
+		return nil
+	}
+	if s.InvocationID == uuid.Nil {
+		s.InvocationID = event.InvocationID
+	}
+
+	if err := s.apply(event); err != nil {
+		return fmt.Errorf("event %d (%s): %w", event.Sequence, event.Type, err)
+	}
+	if event.Sequence > 0 {
+		s.LastSequence = event.Sequence
+	}
+	return nil
+}
+
+func (s *Snapshot) apply(event common.InvocationEvent) error {
+	switch event.Type {
+	case common.InvocationEventTypeMessageCreated:
+		p, err := payload[common.MessageCreatedPayload](event)
+		if err != nil {
+			return err
+		}
+		m := s.message(p.MessageID)
+		m.Role, m.StepID = p.Role, p.StepID
+
+	case common.InvocationEventTypeMessageFinished:
+		p, err := payload[common.MessageFinishedPayload](event)
+		if err != nil {
+			return err
+		}
+		s.message(p.MessageID).Finished = true
+
+	case common.InvocationEventTypeStepOutputCreated:
+		p, err := payload[common.StepOutputCreatedPayload](event)
+		if err != nil {
+			return err
+		}
+		o := s.stepOutput(p.StepOutputID)
+		o.StepID, o.StepKey = p.StepID, p.StepKey
+
+	case common.InvocationEventTypeStepOutputFinished:
+		p, err := payload[common.StepOutputFinishedPayload](event)
+		if err != nil {
+			return err
+		}
+		s.stepOutput(p.StepOutputID).Finished = true
+
+	case common.InvocationEventTypeCreateMessageContent, common.InvocationEventTypeCreateStepOutputContent:
+		p, err := payload[common.CreateContentPayload](event)
+		if err != nil {
+			return err
+		}
+		contents := s.contents(event.Type == common.InvocationEventTypeCreateMessageContent, p.MessageID, p.StepOutputID)
+		*contents = append(*contents, Content{ID: p.ContentID, ContentType: p.ContentType})
+
+	case common.InvocationEventTypeMessageContentDelta, common.InvocationEventTypeStepOutputContentDelta:
+		p, err := payload[common.ContentDeltaPayload](event)
+		if err != nil {
+			return err
+		}
+		contents := s.contents(event.Type == common.InvocationEventTypeMessageContentDelta, p.MessageID, p.StepOutputID)
+		block := contentBlock(contents, p.ContentID, p.ContentType)
+		block.Content += p.Delta
+
+	case common.InvocationEventTypeMessageContentResult, common.InvocationEventTypeStepOutputContentResult:
+		p, err := payload[common.ContentResultPayload](event)
+		if err != nil {
+			return err
+		}
+		contents := s.contents(event.Type == common.InvocationEventTypeMessageContentResult, p.MessageID, p.StepOutputID)
+		block := contentBlock(contents, p.ContentID, p.ContentType)
+		block.Content, block.Final = p.Content, true
+
+	case common.InvocationEventTypeStepStarted:
+		p, err := payload[common.StepStartedPayload](event)
+		if err != nil {
+			return err
+		}
+		step := s.step(p.StepID)
+		step.Key, step.ActionType = p.StepKey, p.ActionType
+		step.Status, step.RunningStatus, step.Result = StepStatusRunning, p.RunningStatus, nil
+
+	case common.InvocationEventTypeStepResult:
+		p, err := payload[common.StepResultPayload](event)
+		if err != nil {
+			return err
+		}
+		step := s.step(p.StepID)
+		if p.StepKey != "" {
+			step.Key = p.StepKey
+		}
+		if p.ActionType != "" {
+			step.ActionType = p.ActionType
+		}
+		result := p.Result
+		step.Status, step.Result = result.Status, &result
+
+	case common.InvocationEventTypeStatus:
+		p, err := payload[common.StatusPayload](event)
+		if err != nil {
+			return err
+		}
+		s.Statuses = append(s.Statuses, *p)
+		if p.StepID != "" {
+			s.step(p.StepID).RunningStatus = p.Message
+		}
+
+	case common.InvocationEventTypeRequiresInfo:
+		p, err := payload[common.RequiresInfoPayload](event)
+		if err != nil {
+			return err
+		}
+		s.RequiresInfo = p
+		if p.StepID != "" {
+			s.step(p.StepID).Status = common.StatusRequiresInfo
+		}
+
+	case common.InvocationEventTypeError:
+		p, err := payload[common.ErrorPayload](event)
+		if err != nil {
+			return err
+		}
+		s.Errors = append(s.Errors, *p)
+		if p.StepID != "" {
+			s.step(p.StepID).Status = common.StatusError
+		}
+
+	case common.InvocationEventTypeDatasetCreated:
+		p, err := payload[common.DatasetCreatedPayload](event)
+		if err != nil {
+			return err
+		}
+		s.Datasets = append(s.Datasets, p.Dataset)
+
+	case common.InvocationEventTypeLog:
+		p, err := payload[common.LogPayload](event)
+		if err != nil {
+			return err
+		}
+		s.Logs = append(s.Logs, *p)
+
+	case common.InvocationEventTypeResult:
+		p, err := payload[common.ResultPayload](event)
+		if err != nil {
+			return err
+		}
+		result := p.Result
+		s.Result = &result
+	}
+	return nil
+}
+
+// payload returns an event's payload as T, decoding raw JSON payloads
+func payload[T any](event common.InvocationEvent) (*T, error) {
+	switch p := event.Payload.(type) {
+	case *T:
+		if p != nil {
+			return p, nil
+		}
+	case T:
+		return &p, nil
+	case json.RawMessage:
+		var decoded T
+		if err := json.Unmarshal(p, &decoded); err != nil {
+			return nil, fmt.Errorf("invalid payload: %w", err)
+		}
+		return &decoded, nil
+	}
+	return nil, fmt.Errorf("expected a %T payload, got %T", *new(T), event.Payload)
+}
+
+// message returns the message with the ID, creating it if it's new
+func (s *Snapshot) message(id string) *Message {
+	for i := range s.Messages {
+		if s.Messages[i].ID == id {
+			return &s.Messages[i]
+		}
+	}
+	s.Messages = append(s.Messages, Message{ID: id, Contents: []Content{}})
+	return &s.Messages[len(s.Messages)-1]
+}
+
+// stepOutput returns the step output with the ID, creating it if it's new
+func (s *Snapshot) stepOutput(id string) *StepOutput {
+	for i := range s.StepOutputs {
+		if s.StepOutputs[i].ID == id {
+			return &s.StepOutputs[i]
+		}
+	}
+	s.StepOutputs = append(s.StepOutputs, StepOutput{ID: id, Contents: []Content{}})
+	return &s.StepOutputs[len(s.StepOutputs)-1]
+}
+
+// step returns the step with the ID, creating it if it's new
+func (s *Snapshot) step(id string) *Step {
+	for i := range s.Steps {
+		if s.Steps[i].ID == id {
+			return &s.Steps[i]
+		}
+	}
+	s.Steps = append(s.Steps, Step{ID: id, Status: StepStatusRunning})
+	return &s.Steps[len(s.Steps)-1]
+}
+
+// contents returns the content blocks of a message or a step output
+func (s *Snapshot) contents(isMessage bool, messageID, stepOutputID string) *[]Content {
+	if isMessage {
+		return &s.message(messageID).Contents
+	}
+	return &s.stepOutput(stepOutputID).Contents
+}
+
+// contentBlock returns the block content goes in: the block with the ID, or
+// without an ID, the last block if it's still streaming and has the same
+// content type. Otherwise it starts a new block.
+func contentBlock(contents *[]Content, id, contentType string) *Content {
+	blocks := *contents
+	if id != "" {
+		for i := range blocks {
+			if blocks[i].ID == id {
+				return &blocks[i]
+			}
+		}
+	} else if n := len(blocks); n > 0 {
+		last := &blocks[n-1]
+		if !last.Final && (contentType == "" || last.ContentType == "" || last.ContentType == contentType) {
+			if last.ContentType == "" {
+				last.ContentType = contentType
+			}
+			return last
+		}
+	}
+	*contents = append(blocks, Content{ID: id, ContentType: contentType})
+	return &(*contents)[len(*contents)-1]
+}
+
+// clone deep-copies the snapshot, so it doesn't change as more events are applied
+func (s Snapshot) clone() Snapshot {
+	c := s
+	c.Messages = make([]Message, len(s.Messages))
+	for i, m := range s.Messages {
+		m.Contents = slices.Clone(m.Contents)
+		c.Messages[i] = m
+	}
+	c.StepOutputs = make([]StepOutput, len(s.StepOutputs))
+	for i, o := range s.StepOutputs {
+		o.Contents = slices.Clone(o.Contents)
+		c.StepOutputs[i] = o
+	}
+	c.Steps = slices.Clone(s.Steps)
+	if c.Steps == nil {
+		c.Steps = []Step{}
+	}
+	c.Statuses = cloneOrEmpty(s.Statuses)
+	c.Errors = cloneOrEmpty(s.Errors)
+	c.Datasets = cloneOrEmpty(s.Datasets)
+	c.Logs = cloneOrEmpty(s.Logs)
+	if s.RequiresInfo != nil {
+		requiresInfo := *s.RequiresInfo
+		c.RequiresInfo = &requiresInfo
+	}
+	if s.Result != nil {
+		result := *s.Result
+		c.Result = &result
+	}
+	return c
+}
+
+func cloneOrEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return slices.Clone(s)
+}
@@ -0,0 +1,133 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var invocationID = uuid.MustParse("6f1c1c4e-8a3b-4b53-9d7e-2a4a6a0b9c01")
+
+// events numbers the payloads' events from 1
+func events(payloads ...common.InvocationEvent) []common.InvocationEvent {
+	for i := range payloads {
+		payloads[i].InvocationID = invocationID
+		payloads[i].Sequence = int64(i + 1)
+	}
+	return payloads
+}
+
+func event(eventType common.InvocationEventType, payload any) common.InvocationEvent {
+	return common.InvocationEvent{Type: eventType, Payload: payload}
+}
+
+func invocation() []common.InvocationEvent {
+	return events(
+		event(common.InvocationEventTypeBotStarted, json.RawMessage(`{"bot_key":"analyst"}`)),
+		event(common.InvocationEventTypeStepStarted, &common.StepStartedPayload{StepID: "s1", StepKey: "answer", ActionType: "llm.message"}),
+		event(common.InvocationEventTypeStatus, &common.StatusPayload{StepID: "s1", Message: "Thinking"}),
+		event(common.InvocationEventTypeMessageCreated, &common.MessageCreatedPayload{MessageID: "m1", Role: "assistant", StepID: "s1"}),
+		event(common.InvocationEventTypeMessageContentDelta, &common.ContentDeltaPayload{MessageID: "m1", ContentType: "text", Delta: "Revenue "}),
+		event(common.InvocationEventTypeMessageContentDelta, &common.ContentDeltaPayload{MessageID: "m1", ContentType: "text", Delta: "grew"}),
+		event(common.InvocationEventTypeMessageContentResult, &common.ContentResultPayload{MessageID: "m1", ContentType: "text", Content: "Revenue grew 12%"}),
+		event(common.InvocationEventTypeCreateMessageContent, &common.CreateContentPayload{MessageID: "m1", ContentID: "c2", ContentType: "json"}),
+		event(common.InvocationEventTypeMessageContentDelta, &common.ContentDeltaPayload{MessageID: "m1", ContentID: "c2", Delta: `{"q3":`}),
+		event(common.InvocationEventTypeMessageContentDelta, &common.ContentDeltaPayload{MessageID: "m1", ContentID: "c2", Delta: `4.2}`}),
+		event(common.InvocationEventTypeMessageFinished, &common.MessageFinishedPayload{MessageID: "m1"}),
+		event(common.InvocationEventTypeStepOutputContentDelta, &common.ContentDeltaPayload{StepOutputID: "o1", ContentType: "text", Delta: "raw output"}),
+		event(common.InvocationEventTypeStepResult, &common.StepResultPayload{StepID: "s1", ActionType: "llm.message", Result: common.Result{Status: common.StatusSuccess}}),
+		event(common.InvocationEventTypeResult, &common.ResultPayload{Result: common.Result{Status: common.StatusSuccess}}),
+	)
+}
+
+func TestReduce(t *testing.T) {
+	snapshot, err := Reduce(invocation())
+	require.NoError(t, err)
+
+	assert.Equal(t, invocationID, snapshot.InvocationID)
+	assert.Equal(t, int64(14), snapshot.LastSequence)
+	assert.Equal(t, []Message{{
+		ID:     "m1",
+		Role:   "assistant",
+		StepID: "s1",
+		Contents: []Content{
+			{ContentType: "text", Content: "Revenue grew 12%", Final: true},
+			{ID: "c2", ContentType: "json", Content: `{"q3":4.2}`},
+		},
+		Finished: true,
+	}}, snapshot.Messages)
+	assert.Equal(t, "Revenue grew 12%\n{\"q3\":4.2}", snapshot.Messages[0].Text())
+
+	assert.Equal(t, []StepOutput{{ID: "o1", Contents: []Content{{ContentType: "text", Content: "raw output"}}}}, snapshot.StepOutputs)
+	require.Len(t, snapshot.Steps, 1)
+	assert.Equal(t, "answer", snapshot.Steps[0].Key)
+	assert.Equal(t, common.StatusSuccess, snapshot.Steps[0].Status)
+	assert.Equal(t, "Thinking", snapshot.Steps[0].RunningStatus)
+	assert.Equal(t, []common.StatusPayload{{StepID: "s1", Message: "Thinking"}}, snapshot.Statuses)
+	require.NotNil(t, snapshot.Result)
+	assert.Equal(t, common.StatusSuccess, snapshot.Result.Status)
+}
+
+func TestReducerSnapshotsAreIndependent(t *testing.T) {
+	all := invocation()
+	r := NewReducer()
+	for _, e := range all[:5] {
+		require.NoError(t, r.Apply(e))
+	}
+	snapshot := r.Snapshot()
+	assert.Equal(t, "Revenue ", snapshot.Messages[0].Text())
+	assert.Equal(t, StepStatusRunning, snapshot.Steps[0].Status)
+
+	for _, e := range all[5:] {
+		require.NoError(t, r.Apply(e))
+	}
+	assert.Equal(t, "Revenue ", snapshot.Messages[0].Text(), "earlier snapshots don't change")
+	assert.Nil(t, snapshot.Result)
+	assert.Equal(t, "Revenue grew 12%\n{\"q3\":4.2}", r.Snapshot().Messages[0].Text())
+}
+
+func TestReducerIgnoresReplayedEvents(t *testing.T) {
+	all := invocation()
+	r := NewReducer()
+	for _, e := range all[:6] {
+		require.NoError(t, r.Apply(e))
+	}
+	// A resumed stream can repeat events before the last one seen
+	for _, e := range all[3:] {
+		require.NoError(t, r.Apply(e))
+	}
+	want, err := Reduce(all)
+	require.NoError(t, err)
+	assert.Equal(t, want, r.Snapshot())
+}
+
+func TestReduceDecodedEvents(t *testing.T) {
+	encoded, err := json.Marshal(invocation())
+	require.NoError(t, err)
+	var decoded []common.InvocationEvent
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+
+	got, err := Reduce(decoded)
+	require.NoError(t, err)
+	want, err := Reduce(invocation())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestReducerPayloads(t *testing.T) {
+	snapshot, err := Reduce(events(
+		event(common.InvocationEventTypeRequiresInfo, common.RequiresInfoPayload{StepID: "s1", Message: "Which quarter?"}),
+		event(common.InvocationEventTypeError, json.RawMessage(`{"step_id":"s2","error":"timeout","message":"timed out"}`)),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, &common.RequiresInfoPayload{StepID: "s1", Message: "Which quarter?"}, snapshot.RequiresInfo)
+	assert.Equal(t, []common.ErrorPayload{{StepID: "s2", Error: common.ErrorTimeout, Message: "timed out"}}, snapshot.Errors)
+	assert.Equal(t, []Step{{ID: "s1", Status: common.StatusRequiresInfo}, {ID: "s2", Status: common.StatusError}}, snapshot.Steps)
+
+	_, err = Reduce(events(event(common.InvocationEventTypeStatus, "Thinking")))
+	assert.EqualError(t, err, "event 1 (status): expected a types.StatusPayload payload, got string")
+}
@@ -7,8 +7,10 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 )
@@ -43,6 +45,18 @@ var basicFuncMap = template.FuncMap{
 	"endsWith":         endsWith,
 	"startsWith":       startsWith,
 	"has":              has,
+	"upper":            upper,
+	"lower":            lower,
+	"title":            title,
+	"trim":             trim,
+	"replace":          replace,
+	"join":             join,
+	"first":            first,
+	"last":             last,
+	"default":          _default,
+	"compare":          compare,
+	"toInt":            toInt,
+	"toFloat":          toFloat,
 }
 
 func genUUID() string {
@@ -562,3 +576,210 @@ func has(needle any, haystack any) bool {
 
 	return false
 }
+
+// upper converts a value to an upper case string
+// Usage: {{upper (get "name")}}
+func upper(value any) string {
+	return strings.ToUpper(toString(value))
+}
+
+// lower converts a value to a lower case string
+// Usage: {{lower (get "name")}}
+func lower(value any) string {
+	return strings.ToLower(toString(value))
+}
+
+// title upper-cases the first letter of each word and lower-cases the rest
+// Usage: {{title (get "name")}}
+func title(value any) string {
+	runes := []rune(toString(value))
+	startOfWord := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			startOfWord = true
+			continue
+		}
+		if startOfWord {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+		startOfWord = false
+	}
+	return string(runes)
+}
+
+// trim removes leading and trailing whitespace
+// Usage: {{trim (get "name")}}
+func trim(value any) string {
+	return strings.TrimSpace(toString(value))
+}
+
+// replace replaces all occurrences of old with new
+// Usage: {{replace (get "name") "-" "_"}}
+func replace(value any, old string, new string) string {
+	return strings.ReplaceAll(toString(value), old, new)
+}
+
+// join joins the items of a slice into a string with a separator
+// Usage: {{join (get "tags") ", "}}
+func join(value any, sep string) string {
+	if value == nil {
+		return ""
+	}
+
+	val := reflect.ValueOf(derefValue(value))
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return toString(value)
+	}
+
+	parts := make([]string, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		parts[i] = toString(val.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
+// first returns the first item of a slice (or first character of a string), or nil if empty
+// Usage: {{first (get "messages")}}
+func first(value any) any {
+	if s, ok := derefValue(value).(string); ok {
+		runes := []rune(s)
+		if len(runes) == 0 {
+			return ""
+		}
+		return string(runes[0])
+	}
+
+	val := reflect.ValueOf(derefValue(value))
+	if (val.Kind() != reflect.Slice && val.Kind() != reflect.Array) || val.Len() == 0 {
+		return nil
+	}
+	return val.Index(0).Interface()
+}
+
+// last returns the last item of a slice (or last character of a string), or nil if empty
+// Usage: {{last (get "messages")}}
+func last(value any) any {
+	if s, ok := derefValue(value).(string); ok {
+		runes := []rune(s)
+		if len(runes) == 0 {
+			return ""
+		}
+		return string(runes[len(runes)-1])
+	}
+
+	val := reflect.ValueOf(derefValue(value))
+	if (val.Kind() != reflect.Slice && val.Kind() != reflect.Array) || val.Len() == 0 {
+		return nil
+	}
+	return val.Index(val.Len() - 1).Interface()
+}
+
+// _default returns the fallback when the value is nil, an invalid null type or an empty string
+// Usage: {{default (get "name?") "anonymous"}}
+func _default(value any, fallback any) any {
+	unwrapped, valid := unwrapNullValue(value)
+	if !valid || unwrapped == nil {
+		return fallback
+	}
+	if s, ok := unwrapped.(string); ok && s == "" {
+		return fallback
+	}
+	return unwrapped
+}
+
+// compare compares two values with one of the operators <, <=, >, >=, == or !=.
+// Numbers (including numeric strings) are compared numerically and other values as strings.
+// Usage: {{if compare ">=" (get "score") 0.5}}...{{end}}
+func compare(op string, a any, b any) (bool, error) {
+	if op == "==" {
+		return eq(a, b), nil
+	}
+	if op == "!=" {
+		return ne(a, b), nil
+	}
+
+	var result int
+	aNum, aOk := toNumber(a)
+	bNum, bOk := toNumber(b)
+	if aOk && bOk {
+		switch {
+		case aNum < bNum:
+			result = -1
+		case aNum > bNum:
+			result = 1
+		}
+	} else {
+		result = strings.Compare(toString(a), toString(b))
+	}
+
+	switch op {
+	case "<":
+		return result < 0, nil
+	case "<=":
+		return result <= 0, nil
+	case ">":
+		return result > 0, nil
+	case ">=":
+		return result >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparison operator %q", op)
+}
+
+// toInt converts a number or numeric string to an int, returning 0 if it can't be converted
+// Usage: {{toInt (get "count")}}
+func toInt(value any) int {
+	if n, ok := toNumber(value); ok {
+		return int(n)
+	}
+	return 0
+}
+
+// toFloat converts a number or numeric string to a float64, returning 0 if it can't be converted
+// Usage: {{toFloat (get "score")}}
+func toFloat(value any) float64 {
+	if n, ok := toNumber(value); ok {
+		return n
+	}
+	return 0
+}
+
+// toNumber converts numeric types and numeric strings to float64
+func toNumber(value any) (float64, bool) {
+	unwrapped, valid := unwrapNullValue(value)
+	if !valid {
+		return 0, false
+	}
+
+	switch v := unwrapped.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
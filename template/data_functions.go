@@ -23,29 +23,32 @@ func addMissingKey(missingKeys *[]string, key string) {
 
 // Functions that require .Data and .MissingKeys parameters
 var dataFuncMap = template.FuncMap{
-	"get":                          get,
-	"concat":                       concat,
-	"getOrOriginal":                getOrOriginal,
-	"sliceEnd":                     sliceEnd,
-	"sliceEndKeepFirstUserMessage": sliceEndKeepFirstUserMessage,
-	"slice":                        slice,
-	"extractSlice":                 extractSlice,
-	"flattenField":                 flattenField,
-	"dedupeBy":                     dedupeBy,
-	"find":                         find,
-	"findByValue":                  findByValue,
-	"getAtIndex":                   getAtIndex,
-	"merge":                        merge,
-	"coalescelist":                 coalescelist,
-	"addkey":                       addkey,
-	"removekey":                    removekey,
-	"mapToDict":                    mapToDict,
-	"mapToArray":                   mapToArray,
-	"addkeytoall":                  addkeytoall,
-	"incrementCounter":             incrementCounter,
-	"incrementCounterBy":           incrementCounterBy,
-	"coalesce":                     coalesce,
-	"filter":                       filter,
+	"get":                               get,
+	"concat":                            concat,
+	"getOrOriginal":                     getOrOriginal,
+	"sliceEnd":                          sliceEnd,
+	"sliceEndKeepFirstUserMessage":      sliceEndKeepFirstUserMessage,
+	"sliceByTokenBudget":                sliceByTokenBudget,
+	"sliceByTokenBudgetWithPlaceholder": sliceByTokenBudgetWithPlaceholder,
+	"slice":                             slice,
+	"extractSlice":                      extractSlice,
+	"flattenField":                      flattenField,
+	"dedupeBy":                          dedupeBy,
+	"find":                              find,
+	"findByValue":                       findByValue,
+	"getAtIndex":                        getAtIndex,
+	"merge":                             merge,
+	"coalescelist":                      coalescelist,
+	"addkey":                            addkey,
+	"removekey":                         removekey,
+	"mapToDict":                         mapToDict,
+	"mapToArray":                        mapToArray,
+	"addkeytoall":                       addkeytoall,
+	"incrementCounter":                  incrementCounter,
+	"incrementCounterBy":                incrementCounterBy,
+	"coalesce":                          coalesce,
+	"filter":                            filter,
+	"pyFormat":                          pyFormat,
 }
 
 func addkey(toObj string, key string, value any, data map[string]any, missingKeys *[]string) map[string]any {
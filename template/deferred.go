@@ -0,0 +1,264 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// Deferred is a lazily hydrated value. Dicts and slices are exposed as trees of
+// Deferred nodes; a node's templates are only hydrated when it is first read
+// (via Value, Get, iteration or JSON marshalling) and the result is cached, so
+// missing keys only surface as an InfoNeededError for the values actually read.
+// A dict whose keys are hydrated (see WithKeyHydration) isn't known until it's
+// hydrated, so it's a single node like a string.
+// Deferred is safe for concurrent use.
+type Deferred struct {
+	raw       any
+	state     *map[string]any
+	behaviour *map[string]any
+	// hydrate is false for values marked raw, which are returned as-is
+	hydrate bool
+
+	mu       sync.Mutex
+	built    bool
+	keys     []string
+	children map[string]*Deferred
+	items    []*Deferred
+
+	done  bool
+	value any
+	err   error
+}
+
+// HydrateLazy returns a Deferred tree for the value, hydrated on access with the state parameters
+func HydrateLazy(value any, stateParameters *map[string]any, parameterHydrationBehaviour ...*map[string]any) *Deferred {
+	var behaviour *map[string]any
+	if len(parameterHydrationBehaviour) > 0 {
+		behaviour = parameterHydrationBehaviour[0]
+	}
+	return newDeferred(value, stateParameters, behaviour, true)
+}
+
+func newDeferred(value any, stateParameters *map[string]any, parameterHydrationBehaviour *map[string]any, hydrate bool) *Deferred {
+	return &Deferred{raw: value, state: stateParameters, behaviour: parameterHydrationBehaviour, hydrate: hydrate}
+}
+
+// build creates the child nodes of a dict or slice. Callers must hold d.mu.
+func (d *Deferred) build() {
+	if d.built {
+		return
+	}
+	d.built = true
+	if !d.hydrate {
+		return
+	}
+
+	switch v := d.raw.(type) {
+	case map[string]any:
+		if hydratesKeys(d.behaviour) {
+			return
+		}
+		d.children = make(map[string]*Deferred, len(v))
+		for key, item := range v {
+			if fieldBehaviour(key, d.behaviour) == common.ParameterHydrationBehaviourNone {
+				continue
+			}
+			shouldHydrate, childBehaviour := shouldHydrateField(key, d.behaviour)
+			d.children[key] = newDeferred(item, d.state, childBehaviour, shouldHydrate)
+			d.keys = append(d.keys, key)
+		}
+		slices.Sort(d.keys)
+	case []any:
+		d.items = make([]*Deferred, len(v))
+		for i, item := range v {
+			// Slice elements share the slice's behaviour, as in hydrateSlice
+			d.items[i] = newDeferred(item, d.state, d.behaviour, true)
+		}
+	}
+}
+
+// IsDict reports whether the node is a dict with child nodes
+func (d *Deferred) IsDict() bool {
+	_, ok := d.raw.(map[string]any)
+	return ok && d.hydrate && !hydratesKeys(d.behaviour)
+}
+
+// IsSlice reports whether the node is a slice
+func (d *Deferred) IsSlice() bool {
+	_, ok := d.raw.([]any)
+	return ok && d.hydrate
+}
+
+// Raw returns the unhydrated value
+func (d *Deferred) Raw() any {
+	return d.raw
+}
+
+// Keys returns the keys of a dict node in sorted order
+func (d *Deferred) Keys() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.build()
+	return slices.Clone(d.keys)
+}
+
+// Len returns the number of entries in a dict or slice node
+func (d *Deferred) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.build()
+	if d.children != nil {
+		return len(d.children)
+	}
+	return len(d.items)
+}
+
+// Child returns the node for a key of a dict node
+func (d *Deferred) Child(key string) (*Deferred, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.build()
+	child, ok := d.children[key]
+	return child, ok
+}
+
+// Index returns the node for an element of a slice node
+func (d *Deferred) Index(i int) (*Deferred, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.build()
+	if i < 0 || i >= len(d.items) {
+		return nil, false
+	}
+	return d.items[i], true
+}
+
+// Lookup returns the node at a path like "tools.0.name" or "tools[0].name"
+func (d *Deferred) Lookup(path string) (*Deferred, bool) {
+	node := d
+	for _, part := range splitDeferredPath(path) {
+		var ok bool
+		if node.IsSlice() {
+			index, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, false
+			}
+			node, ok = node.Index(index)
+		} else {
+			node, ok = node.Child(part)
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// Get hydrates and returns the value at a path. Only the value at the path is
+// hydrated, so missing keys elsewhere in the tree don't cause an error.
+func (d *Deferred) Get(path string) (any, error) {
+	node, ok := d.Lookup(path)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	return node.Value()
+}
+
+// All iterates over the entries of a dict node in key order
+func (d *Deferred) All() iter.Seq2[string, *Deferred] {
+	return func(yield func(string, *Deferred) bool) {
+		for _, key := range d.Keys() {
+			child, _ := d.Child(key)
+			if !yield(key, child) {
+				return
+			}
+		}
+	}
+}
+
+// Items iterates over the elements of a slice node
+func (d *Deferred) Items() iter.Seq2[int, *Deferred] {
+	return func(yield func(int, *Deferred) bool) {
+		for i := 0; i < d.Len(); i++ {
+			item, _ := d.Index(i)
+			if !yield(i, item) {
+				return
+			}
+		}
+	}
+}
+
+// Value hydrates the node and everything under it. As with Hydrate, an
+// InfoNeededError is returned along with the partially hydrated value.
+func (d *Deferred) Value() (any, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done {
+		return d.value, d.err
+	}
+
+	d.value, d.err = d.resolve()
+	d.done = true
+	return d.value, d.err
+}
+
+// resolve hydrates the node. Callers must hold d.mu.
+func (d *Deferred) resolve() (result any, err error) {
+	defer recoverPanic(&err)
+
+	if !d.hydrate || d.state == nil {
+		return d.raw, nil
+	}
+
+	d.build()
+	var errs errorCollector
+
+	switch {
+	case d.children != nil:
+		dict := make(map[string]any, len(d.children))
+		for _, key := range d.keys {
+			value, err := d.children[key].Value()
+			dict[key] = value
+			if err != nil {
+				errs.add(key, err)
+			}
+		}
+		result = dict
+	case d.items != nil:
+		list := make([]any, len(d.items))
+		for i, item := range d.items {
+			value, err := item.Value()
+			list[i] = value
+			if err != nil {
+				errs.add(fmt.Sprintf("[%d]", i), err)
+			}
+		}
+		result = list
+	default:
+		return hydrate(d.raw, d.state, d.behaviour)
+	}
+
+	return result, errs.err(d.state, "deferred value")
+}
+
+// MarshalJSON hydrates the node and marshals the result
+func (d *Deferred) MarshalJSON() ([]byte, error) {
+	value, err := d.Value()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func splitDeferredPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	})
+}
@@ -0,0 +1,143 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHydrateLazy(t *testing.T) {
+	params := map[string]any{
+		"query": "{{query}}",
+		"tools": []any{
+			map[string]any{"name": "{{tool}}", "description": "{{missing_description}}"},
+		},
+		"context": map[string]any{"history": "{{missing_history}}"},
+		"code":    "{{raw}}",
+		"secret":  "{{secret}}",
+	}
+	behaviour := map[string]any{
+		"code":   common.ParameterHydrationBehaviourRaw,
+		"secret": common.ParameterHydrationBehaviourNone,
+	}
+
+	newState := func() map[string]any {
+		return map[string]any{"query": "weather", "tool": "search"}
+	}
+
+	t.Run("only values that are read are hydrated", func(t *testing.T) {
+		state := newState()
+		deferred := HydrateLazy(params, &state, &behaviour)
+
+		query, err := deferred.Get("query")
+		require.NoError(t, err)
+		assert.Equal(t, "weather", query)
+
+		name, err := deferred.Get("tools[0].name")
+		require.NoError(t, err)
+		assert.Equal(t, "search", name)
+
+		code, err := deferred.Get("code")
+		require.NoError(t, err)
+		assert.Equal(t, "{{raw}}", code)
+	})
+
+	t.Run("missing keys surface for the values read", func(t *testing.T) {
+		state := newState()
+		deferred := HydrateLazy(params, &state, &behaviour)
+
+		_, err := deferred.Get("tools.0.description")
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Equal(t, []string{"missing_description"}, infoErr.MissingKeys)
+
+		_, err = deferred.Get("context")
+		require.True(t, errors.As(err, &infoErr))
+		assert.Equal(t, []string{"missing_history"}, infoErr.MissingKeys)
+	})
+
+	t.Run("results are cached", func(t *testing.T) {
+		state := newState()
+		deferred := HydrateLazy(params, &state, &behaviour)
+
+		first, err := deferred.Get("query")
+		require.NoError(t, err)
+
+		state["query"] = "changed"
+		second, err := deferred.Get("query")
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("iteration", func(t *testing.T) {
+		state := newState()
+		deferred := HydrateLazy(params, &state, &behaviour)
+
+		var keys []string
+		for key := range deferred.All() {
+			keys = append(keys, key)
+		}
+		assert.Equal(t, []string{"code", "context", "query", "tools"}, keys)
+
+		tools, ok := deferred.Lookup("tools")
+		require.True(t, ok)
+		for i, tool := range tools.Items() {
+			assert.Equal(t, 0, i)
+			name, err := tool.Get("name")
+			require.NoError(t, err)
+			assert.Equal(t, "search", name)
+		}
+	})
+
+	t.Run("JSON marshalling hydrates the node", func(t *testing.T) {
+		state := newState()
+		deferred := HydrateLazy(params, &state, &behaviour)
+
+		tools, ok := deferred.Lookup("tools.0")
+		require.True(t, ok)
+		_, err := json.Marshal(tools)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+
+		query, _ := deferred.Lookup("query")
+		data, err := json.Marshal(map[string]any{"q": query})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"q": "weather"}`, string(data))
+	})
+
+	t.Run("unknown paths", func(t *testing.T) {
+		state := newState()
+		deferred := HydrateLazy(params, &state, &behaviour)
+
+		_, err := deferred.Get("tools.3.name")
+		assert.ErrorIs(t, err, ErrPathNotFound)
+		_, err = deferred.Get("secret")
+		assert.ErrorIs(t, err, ErrPathNotFound)
+	})
+
+	t.Run("templated keys are hydrated like Hydrate", func(t *testing.T) {
+		state := map[string]any{"segment_id": "seg_1", "name": "Ada"}
+		params := map[string]any{
+			"headers":        map[string]any{"X-{{segment_id}}": "{{name}}"},
+			"{{segment_id}}": "{{name}}",
+		}
+		behaviour := WithKeyHydration(nil, true)
+
+		expected, err := Hydrate(params, &state, behaviour)
+		require.NoError(t, err)
+
+		deferred := HydrateLazy(params, &state, behaviour)
+		assert.False(t, deferred.IsDict())
+		value, err := deferred.Value()
+		require.NoError(t, err)
+		assert.Equal(t, expected, value)
+		assert.Equal(t, map[string]any{
+			"headers": map[string]any{"X-seg_1": "Ada"},
+			"seg_1":   "Ada",
+		}, value)
+	})
+}
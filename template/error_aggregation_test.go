@@ -0,0 +1,110 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHydrationErrorAggregation(t *testing.T) {
+	state := map[string]any{"name": "Ada"}
+
+	t.Run("every failing field is reported", func(t *testing.T) {
+		params := map[string]any{
+			"a":    "{{nosuchfunc name}}",
+			"b":    "{{name}}",
+			"c":    map[string]any{"d": "{{add 1}}", "e": "{{name}}"},
+			"list": []any{"{{name}}", "{{add 1}}"},
+			"f":    "{{missing}}",
+		}
+
+		result, err := Hydrate(params, &state, nil)
+		var hydrationErrs *HydrationErrors
+		require.True(t, errors.As(err, &hydrationErrs))
+
+		var paths []string
+		for _, fieldErr := range hydrationErrs.FieldErrors() {
+			paths = append(paths, fieldErr.Path)
+			assert.Error(t, fieldErr.Err)
+		}
+		assert.Equal(t, []string{"a", "c.d", "list[1]"}, paths)
+		assert.Contains(t, err.Error(), "error hydrating key 'c.d'")
+
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Equal(t, []string{"missing"}, infoErr.MissingKeys)
+
+		// Failing fields keep their original value and the rest are hydrated
+		assert.Equal(t, map[string]any{
+			"a":    "{{nosuchfunc name}}",
+			"b":    "Ada",
+			"c":    map[string]any{"d": "{{add 1}}", "e": "Ada"},
+			"list": []any{"Ada", "{{add 1}}"},
+			"f":    "{{missing}}",
+		}, result)
+
+		// The typed wrappers return the same partial result
+		dict, err := HydrateDict(params, &state)
+		require.ErrorAs(t, err, &hydrationErrs)
+		assert.Equal(t, result, dict)
+
+		slice, err := HydrateSlice([]any{"{{name}}", "{{add 1}}", "{{missing}}"}, &state)
+		require.ErrorAs(t, err, &hydrationErrs)
+		require.ErrorAs(t, err, &infoErr)
+		assert.Equal(t, []any{"Ada", "{{add 1}}", "{{missing}}"}, slice)
+	})
+
+	t.Run("only missing keys return an InfoNeededError", func(t *testing.T) {
+		_, err := Hydrate(map[string]any{"a": "{{x}}", "b": []any{"{{y}}"}}, &state, nil)
+		var infoErr *InfoNeededError
+		require.ErrorAs(t, err, &infoErr)
+		assert.ElementsMatch(t, []string{"x", "y"}, infoErr.MissingKeys)
+		var hydrationErrs *HydrationErrors
+		assert.False(t, errors.As(err, &hydrationErrs))
+	})
+
+	t.Run("typed causes are preserved", func(t *testing.T) {
+		behaviour := map[string]any{"a": map[string]any{"b": common.ParameterHydrationBehaviourRaw}}
+		_, err := Hydrate(map[string]any{"a": "{{name}}", "b": "{{nosuchfunc name}}"}, &state, &behaviour)
+		assert.ErrorIs(t, err, ErrInvalidHydrationBehaviour)
+
+		var fieldErr *FieldError
+		require.ErrorAs(t, err, &fieldErr)
+		assert.Equal(t, "a", fieldErr.Path)
+	})
+
+	t.Run("key collisions don't stop hydration", func(t *testing.T) {
+		params := map[string]any{"{{name}}": 1, "Ada": 2, "other": "{{name}}"}
+		result, err := Hydrate(params, &state, WithKeyHydration(nil, true))
+		var collisionErr *KeyCollisionError
+		require.ErrorAs(t, err, &collisionErr)
+		assert.Equal(t, "Ada", result.(map[string]any)["other"])
+	})
+
+	t.Run("policies report every failing path", func(t *testing.T) {
+		params := map[string]any{"a": "{{nosuchfunc name}}", "b": []any{"{{add 1}}"}, "c": "{{name}}"}
+		result, _, err := HydrateWithPolicy(params, &state, nil)
+		var hydrationErrs *HydrationErrors
+		require.ErrorAs(t, err, &hydrationErrs)
+		require.Len(t, hydrationErrs.FieldErrors(), 2)
+		assert.Equal(t, "a", hydrationErrs.FieldErrors()[0].Path)
+		assert.Equal(t, "b[0]", hydrationErrs.FieldErrors()[1].Path)
+		assert.Equal(t, "Ada", result.(map[string]any)["c"])
+	})
+
+	t.Run("hydrators and deferred values report every failing path", func(t *testing.T) {
+		params := map[string]any{"a": "{{nosuchfunc name}}", "b": "{{add 1}}", "c": "{{name}}"}
+
+		_, err := NewHydrator(params, nil).Hydrate(&state)
+		var hydrationErrs *HydrationErrors
+		require.ErrorAs(t, err, &hydrationErrs)
+		assert.Len(t, hydrationErrs.FieldErrors(), 2)
+
+		_, err = HydrateLazy(params, &state).Value()
+		require.ErrorAs(t, err, &hydrationErrs)
+		assert.Len(t, hydrationErrs.FieldErrors(), 2)
+	})
+}
@@ -0,0 +1,214 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidHydrationBehaviour is returned when a hydration behaviour doesn't fit
+// the value it's applied to, e.g. a nested behaviour map applied to a string
+var ErrInvalidHydrationBehaviour = errors.New("invalid hydration behaviour")
+
+// ErrPathNotFound is returned when a path doesn't exist in a value
+var ErrPathNotFound = errors.New("path not found")
+
+// KeyCollisionError is returned when templated dict keys render to the same key
+type KeyCollisionError struct {
+	Key string
+	// Sources are the original keys that rendered to Key
+	Sources []string
+}
+
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("keys %q all hydrate to %q", e.Sources, e.Key)
+}
+
+// UnsupportedJinjaError is returned when a Jinja2 template uses a construct
+// outside the subset CompileJinja supports, e.g. the * operator or range()
+type UnsupportedJinjaError struct {
+	Line int
+	// Construct names what isn't supported, e.g. operator "*"
+	Construct string
+}
+
+func (e *UnsupportedJinjaError) Error() string {
+	return fmt.Sprintf("jinja syntax error at line %d: unsupported %s", e.Line, e.Construct)
+}
+
+// FunctionCallError is returned when a template function can't be called with
+// the arguments it was given
+type FunctionCallError struct {
+	Function string
+	Err      error
+}
+
+func (e *FunctionCallError) Error() string {
+	return fmt.Sprintf("error calling function %s: %v", e.Function, e.Err)
+}
+
+func (e *FunctionCallError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned when hydration recovers from a panic, so a bad template
+// or unexpected state value fails the hydration instead of the caller
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recovered from panic during hydration: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it was an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// recoverPanic converts a panic into a *PanicError. It must be deferred directly.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
+
+// FieldError is an error hydrating the value at a path in the parameters
+type FieldError struct {
+	// Path is the location of the value, e.g. tools[0].parameters.query
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("error hydrating key '%s': %v", e.Path, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// HydrationErrors is returned when several values fail to hydrate. It holds a
+// *FieldError per failing value and, if any keys were missing, a single merged
+// *InfoNeededError. It unwraps to all of them, so errors.As and errors.Is
+// work as they do with errors.Join.
+type HydrationErrors struct {
+	Errors []error
+}
+
+func (e *HydrationErrors) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e *HydrationErrors) Unwrap() []error {
+	return e.Errors
+}
+
+// FieldErrors returns the errors of the values that failed to hydrate for a
+// reason other than missing keys
+func (e *HydrationErrors) FieldErrors() []*FieldError {
+	var fieldErrors []*FieldError
+	for _, err := range e.Errors {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fieldErrors = append(fieldErrors, fieldErr)
+		}
+	}
+	return fieldErrors
+}
+
+// errorCollector gathers the errors from hydrating sibling values so hydration
+// can carry on past a failing value and report every failure at once
+type errorCollector struct {
+	fieldErrors     []*FieldError
+	missingKeys     []string
+	missingKeyPaths []MissingKeyInfo
+}
+
+// add records the error from hydrating the value at path
+func (c *errorCollector) add(path string, err error) {
+	var hydrationErrs *HydrationErrors
+	var fieldErr *FieldError
+	var infoNeededErr *InfoNeededError
+
+	switch {
+	case errors.As(err, &hydrationErrs) && hydrationErrs == err:
+		for _, inner := range hydrationErrs.Errors {
+			c.add(path, inner)
+		}
+	case errors.As(err, &fieldErr) && fieldErr == err:
+		c.fieldErrors = append(c.fieldErrors, &FieldError{Path: joinErrorPath(path, fieldErr.Path), Err: fieldErr.Err})
+	case errors.As(err, &infoNeededErr):
+		// Don't prepend paths - templates reference absolute paths via .Data
+		// so prepending parent paths would corrupt the correct paths from the template system
+		c.missingKeys = append(c.missingKeys, infoNeededErr.MissingKeys...)
+		c.missingKeyPaths = append(c.missingKeyPaths, infoNeededErr.MissingKeyPaths...)
+	default:
+		c.fieldErrors = append(c.fieldErrors, &FieldError{Path: path, Err: err})
+	}
+}
+
+// len returns the number of problems collected so far
+func (c *errorCollector) len() int {
+	return len(c.fieldErrors) + len(c.missingKeys) + len(c.missingKeyPaths)
+}
+
+// err returns the collected errors: nil, a plain *InfoNeededError if only keys
+// were missing, or *HydrationErrors
+func (c *errorCollector) err(stateParameters *map[string]any, context string) error {
+	var infoNeededErr *InfoNeededError
+	if len(c.missingKeys) > 0 || len(c.missingKeyPaths) > 0 {
+		var availableKeys []string
+		if stateParameters != nil {
+			availableKeys = getKeys(*stateParameters)
+		}
+		infoNeededErr = &InfoNeededError{
+			MissingKeys:     c.missingKeys,
+			MissingKeyPaths: c.missingKeyPaths,
+			AvailableKeys:   availableKeys,
+			Err:             fmt.Errorf("missing keys in %s", context),
+		}
+	}
+
+	if len(c.fieldErrors) == 0 {
+		if infoNeededErr == nil {
+			return nil
+		}
+		return infoNeededErr
+	}
+
+	slices.SortStableFunc(c.fieldErrors, func(a, b *FieldError) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	errs := make([]error, 0, len(c.fieldErrors)+1)
+	for _, fieldErr := range c.fieldErrors {
+		errs = append(errs, fieldErr)
+	}
+	if infoNeededErr != nil {
+		errs = append(errs, infoNeededErr)
+	}
+	return &HydrationErrors{Errors: errs}
+}
+
+// joinErrorPath joins a parent path and a child path like tools and [0].name
+func joinErrorPath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	case strings.HasPrefix(child, "["):
+		return parent + child
+	default:
+		return parent + "." + child
+	}
+}
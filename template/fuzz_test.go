@@ -0,0 +1,138 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fuzzSeeds = []string{
+	"",
+	"plain text",
+	"{{name}}",
+	"{{name?}}",
+	"Hello {{user.name}}!",
+	"{{items.0.id}}",
+	"%(name)s",
+	"%(count)05d %(score).2f %%",
+	`{{get "user.name"}}`,
+	`{{len (get "items")}}`,
+	`{{gt (len (get "items")) 0}}`,
+	`{{toJSON (get "user")}}`,
+	`{{if (get "flag")}}yes{{else}}no{{end}}`,
+	`{{range $i := (get "items")}}{{$i.id}}{{end}}`,
+	`{{add 1 "two"}}`,
+	`{{truncateString "abc"}}`,
+	`{{include "missing" .}}`,
+	"{{",
+	"}}",
+	"{{}}",
+	"{{{}}}",
+	"{{end}}",
+	"{{ . }}",
+	"%(",
+	"%(name",
+	"{{$.Data}}",
+}
+
+func fuzzState() map[string]any {
+	return map[string]any{
+		"name":  "Ada",
+		"count": 7,
+		"score": 1.5,
+		"flag":  true,
+		"user":  map[string]any{"name": "Ada", "tags": []any{"a", "b"}},
+		"items": []any{map[string]any{"id": 1}, map[string]any{"id": 2}},
+		"empty": nil,
+	}
+}
+
+// checkFuzzError fails if hydration returned a recovered panic
+func checkFuzzError(t *testing.T, input string, err error) {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		t.Fatalf("hydrating %q panicked: %v\n%s", input, panicErr.Value, panicErr.Stack)
+	}
+}
+
+func FuzzHydrateString(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		state := fuzzState()
+		_, err := HydrateString(input, &state)
+		checkFuzzError(t, input, err)
+	})
+}
+
+func FuzzHydrateDict(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, "raw")
+	}
+
+	f.Fuzz(func(t *testing.T, input string, behaviour string) {
+		state := fuzzState()
+		dict := map[string]any{
+			"value":  input,
+			"nested": map[string]any{"value": input, "list": []any{input, 1, nil}},
+		}
+		behaviourMap := map[string]any{"nested": map[string]any{"value": behaviour}}
+
+		_, err := HydrateDict(dict, &state, &behaviourMap)
+		checkFuzzError(t, input, err)
+
+		// Values decoded from JSON are hydrated too
+		var decoded map[string]any
+		if json.Unmarshal([]byte(input), &decoded) == nil {
+			_, err = HydrateDict(decoded, &state)
+			checkFuzzError(t, input, err)
+		}
+	})
+}
+
+func FuzzParseTemplate(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		_, _ = parseTemplate(input)
+	})
+}
+
+func TestHydrateReturnsTypedErrors(t *testing.T) {
+	state := fuzzState()
+
+	t.Run("behaviour map on a string", func(t *testing.T) {
+		behaviour := map[string]any{"key": "raw"}
+		_, err := Hydrate("{{name}}", &state, &behaviour)
+		assert.ErrorIs(t, err, ErrInvalidHydrationBehaviour)
+	})
+
+	t.Run("behaviour map on a slice of strings", func(t *testing.T) {
+		params := map[string]any{"list": []any{"{{name}}"}}
+		behaviour := map[string]any{"list": map[string]any{"key": "raw"}}
+		_, err := HydrateDict(params, &state, &behaviour)
+		assert.ErrorIs(t, err, ErrInvalidHydrationBehaviour)
+	})
+
+	t.Run("function panics are returned as errors", func(t *testing.T) {
+		basicFuncMap["panicky"] = func() any { panic("boom") }
+		funcMap["panicky"] = basicFuncMap["panicky"]
+		t.Cleanup(func() {
+			delete(basicFuncMap, "panicky")
+			delete(funcMap, "panicky")
+		})
+
+		_, err := executeFunctionCall("panicky", nil, state, &[]string{}, nil)
+		var callErr *FunctionCallError
+		require.True(t, errors.As(err, &callErr))
+		assert.Equal(t, "panicky", callErr.Function)
+		assert.Contains(t, err.Error(), "boom")
+	})
+}
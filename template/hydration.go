@@ -24,6 +24,13 @@ type Key struct {
 
 type KeyDefinitions map[string]Key
 
+// templateContext is the root value (.) templates are executed with
+type templateContext struct {
+	Data           map[string]any
+	MissingKeys    *[]string
+	KeyDefinitions KeyDefinitions
+}
+
 type MissingKeyInfo struct {
 	Key  string
 	Path string
@@ -61,6 +68,20 @@ func (e *InfoNeededError) Unwrap() error {
 	return e.Err
 }
 
+// Is matches common.ErrorInfoNeeded
+func (e *InfoNeededError) Is(target error) bool {
+	return target == common.ErrorInfoNeeded
+}
+
+// RequiresInfo returns a requires info result for the error, with the missing
+// keys in the output under "missing_keys"
+func RequiresInfo(err *InfoNeededError) common.Result {
+	kind := common.ErrorInfoNeeded
+	message := err.Error()
+	output := map[string]any{"missing_keys": append([]string{}, err.MissingKeys...)}
+	return common.Result{Status: common.StatusRequiresInfo, Output: &output, Message: &message, Error: &kind}
+}
+
 // Helper to add path to error - prepends the path segment
 func addPathToError(err error, pathSegment string) {
 	if err == nil || pathSegment == "" {
@@ -105,7 +126,15 @@ func getData(stateParameters *map[string]any) (*map[string]any, error) {
 	return stateParameters, nil
 }
 
-func Hydrate(value any, stateParameters *map[string]any, parameterHydrationBehaviour *map[string]any) (any, error) {
+// Hydrate hydrates the templates in a value (string, dict or slice) with the
+// state parameters. It never panics: a panic while evaluating a template is
+// recovered and returned as a *PanicError.
+func Hydrate(value any, stateParameters *map[string]any, parameterHydrationBehaviour *map[string]any) (result any, err error) {
+	defer recoverPanic(&err)
+	return hydrate(value, stateParameters, parameterHydrationBehaviour)
+}
+
+func hydrate(value any, stateParameters *map[string]any, parameterHydrationBehaviour *map[string]any) (any, error) {
 	if stateParameters == nil {
 		return value, nil
 	}
@@ -117,10 +146,14 @@ func Hydrate(value any, stateParameters *map[string]any, parameterHydrationBehav
 
 	switch v := value.(type) {
 	case string:
-		if parameterHydrationBehaviour != nil {
-			panic(fmt.Sprintf("hydrating string with behaviour %+v", parameterHydrationBehaviour))
+		opts := stringOptionsOf(parameterHydrationBehaviour)
+		if templateSyntax(parameterHydrationBehaviour) == common.TemplateSyntaxJinja {
+			return hydrateJinjaString(v, data, opts)
 		}
-		return hydrateString(v, data)
+		if parameterHydrationBehaviour != nil && !isDirectiveOnlyBehaviour(parameterHydrationBehaviour) {
+			return nil, fmt.Errorf("%w: can't apply behaviour %+v to a string", ErrInvalidHydrationBehaviour, *parameterHydrationBehaviour)
+		}
+		return hydrateString(v, data, opts)
 	case map[string]any:
 		return hydrateDict(v, data, parameterHydrationBehaviour)
 	case []any:
@@ -183,20 +216,180 @@ func shouldHydrateField(key string, parameterHydrationBehaviour *map[string]any)
 			// The nested object has its own behavior configuration
 			childBehaviour = &behaviour
 		}
+		if isJinjaBehaviour(nestedBehaviour) {
+			childBehaviour = WithTemplateSyntax(childBehaviour, common.TemplateSyntaxJinja)
+		}
+	}
+
+	// Children inherit behaviour directives (template syntax, key hydration) unless they set their own
+	for _, directive := range behaviourDirectives {
+		value, ok := (*parameterHydrationBehaviour)[directive]
+		if !ok {
+			continue
+		}
+		if childBehaviour != nil {
+			if _, set := (*childBehaviour)[directive]; set {
+				continue
+			}
+		}
+		childBehaviour = withBehaviourDirective(childBehaviour, directive, value)
 	}
 
 	return true, childBehaviour // Default to hydrating if not explicitly marked as raw
 }
 
-var varRegexStr = `(?:{{|%\()\s*([^\s.$][^\s]*?(?:\.[^\s]+?)*)\s*(?:}}|\)s)`
-var funcRegexStr = `{{([^}]+)}}`
-var funcRegex = regexp.MustCompile(funcRegexStr)
-var wholeVarRegexStr = fmt.Sprintf("^%s$", varRegexStr)
-var wholeFuncRegexStr = fmt.Sprintf("^%s$", funcRegexStr)
-var directVarRegex = regexp.MustCompile(varRegexStr)
-var wholeVarRegex = regexp.MustCompile(wholeVarRegexStr)
-var wholeFuncRegex = regexp.MustCompile(wholeFuncRegexStr)
-var optionalVarRegex = regexp.MustCompile(`{{([^{}]+?)\?}}`)
+// fieldBehaviour returns the behaviour set directly on a field, if any
+func fieldBehaviour(key string, parameterHydrationBehaviour *map[string]any) common.ParameterHydrationBehaviour {
+	if parameterHydrationBehaviour == nil {
+		return ""
+	}
+	switch behaviour := (*parameterHydrationBehaviour)[key].(type) {
+	case common.ParameterHydrationBehaviour:
+		return behaviour
+	case string:
+		return common.ParameterHydrationBehaviour(behaviour)
+	}
+	return ""
+}
+
+// Behaviour directives are reserved behaviour keys that configure how a value
+// and everything below it is hydrated
+const (
+	// templateSyntaxKey selects the template syntax of strings
+	templateSyntaxKey = "$syntax"
+	// hydrateKeysKey enables hydration of templated dict keys
+	hydrateKeysKey = "$hydrate_keys"
+	// partialsKey holds the *Partials registry that include and template actions use
+	partialsKey = "$partials"
+	// tokenizerKey holds the common.Tokenizer the token budget functions count with
+	tokenizerKey = "$tokenizer"
+)
+
+var behaviourDirectives = []string{templateSyntaxKey, hydrateKeysKey, partialsKey, tokenizerKey}
+
+// withBehaviourDirective returns a copy of the hydration behaviour with the directive set
+func withBehaviourDirective(parameterHydrationBehaviour *map[string]any, directive string, value any) *map[string]any {
+	behaviour := map[string]any{}
+	if parameterHydrationBehaviour != nil {
+		for k, v := range *parameterHydrationBehaviour {
+			behaviour[k] = v
+		}
+	}
+	behaviour[directive] = value
+	return &behaviour
+}
+
+// WithTemplateSyntax returns a copy of the hydration behaviour that hydrates
+// strings using the given template syntax
+func WithTemplateSyntax(parameterHydrationBehaviour *map[string]any, syntax common.TemplateSyntax) *map[string]any {
+	return withBehaviourDirective(parameterHydrationBehaviour, templateSyntaxKey, syntax)
+}
+
+// WithKeyHydration returns a copy of the hydration behaviour that also hydrates
+// templated dict keys, e.g. {"{{segment_id}}": "..."}. Nested behaviours can
+// turn it off again with WithKeyHydration(behaviour, false).
+func WithKeyHydration(parameterHydrationBehaviour *map[string]any, enabled bool) *map[string]any {
+	return withBehaviourDirective(parameterHydrationBehaviour, hydrateKeysKey, enabled)
+}
+
+// WithPartials returns a copy of the hydration behaviour that resolves partials
+// in the registry before the shared ones, e.g. a bot's partials
+func WithPartials(parameterHydrationBehaviour *map[string]any, partials *Partials) *map[string]any {
+	return withBehaviourDirective(parameterHydrationBehaviour, partialsKey, partials)
+}
+
+// WithTokenizer returns a copy of the hydration behaviour whose token budget
+// functions, e.g. sliceByTokenBudget, count tokens with the tokenizer instead
+// of common.DefaultTokenizer
+func WithTokenizer(parameterHydrationBehaviour *map[string]any, tokenizer common.Tokenizer) *map[string]any {
+	return withBehaviourDirective(parameterHydrationBehaviour, tokenizerKey, tokenizer)
+}
+
+// stringOptions are the behaviour directives that apply when hydrating a string
+type stringOptions struct {
+	partials  *Partials
+	tokenizer common.Tokenizer
+}
+
+// stringOptionsOf returns the string options set by a hydration behaviour
+func stringOptionsOf(parameterHydrationBehaviour *map[string]any) stringOptions {
+	opts := stringOptions{partials: partialsOf(parameterHydrationBehaviour)}
+	if parameterHydrationBehaviour != nil {
+		opts.tokenizer, _ = (*parameterHydrationBehaviour)[tokenizerKey].(common.Tokenizer)
+	}
+	return opts
+}
+
+// funcs returns the functions bound to the options, which take precedence over funcMap
+func (opts stringOptions) funcs() template.FuncMap {
+	if opts.tokenizer == nil {
+		return nil
+	}
+	return tokenBudgetFuncs(opts.tokenizer)
+}
+
+// lookupFunc returns the function named name in funcs, or else in funcMap
+func lookupFunc(name string, funcs template.FuncMap) (any, bool) {
+	if fn, ok := funcs[name]; ok {
+		return fn, true
+	}
+	fn, ok := funcMap[name]
+	return fn, ok
+}
+
+// partialsOf returns the partials registry set by a hydration behaviour, or nil
+// for only the shared partials
+func partialsOf(parameterHydrationBehaviour *map[string]any) *Partials {
+	if parameterHydrationBehaviour == nil {
+		return nil
+	}
+	partials, _ := (*parameterHydrationBehaviour)[partialsKey].(*Partials)
+	return partials
+}
+
+// templateSyntax returns the template syntax selected by a hydration behaviour, if any
+func templateSyntax(parameterHydrationBehaviour *map[string]any) common.TemplateSyntax {
+	if parameterHydrationBehaviour == nil {
+		return ""
+	}
+	switch syntax := (*parameterHydrationBehaviour)[templateSyntaxKey].(type) {
+	case common.TemplateSyntax:
+		return syntax
+	case string:
+		return common.TemplateSyntax(syntax)
+	}
+	return ""
+}
+
+// hydratesKeys reports whether a hydration behaviour enables key hydration
+func hydratesKeys(parameterHydrationBehaviour *map[string]any) bool {
+	if parameterHydrationBehaviour == nil {
+		return false
+	}
+	enabled, _ := (*parameterHydrationBehaviour)[hydrateKeysKey].(bool)
+	return enabled
+}
+
+// isDirectiveOnlyBehaviour reports whether a hydration behaviour only holds
+// directives, which apply to strings as well as dicts and slices
+func isDirectiveOnlyBehaviour(parameterHydrationBehaviour *map[string]any) bool {
+	for key := range *parameterHydrationBehaviour {
+		if !slices.Contains(behaviourDirectives, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func isJinjaBehaviour(behaviour any) bool {
+	switch b := behaviour.(type) {
+	case common.ParameterHydrationBehaviour:
+		return b == common.ParameterHydrationBehaviourJinja
+	case string:
+		return b == string(common.ParameterHydrationBehaviourJinja)
+	}
+	return false
+}
 
 // hasDataPrefix checks if a string starts with .Data. or $.Data.
 func hasDataPrefix(str string) bool {
@@ -214,21 +407,6 @@ func removeDataPrefix(str string) string {
 	return str
 }
 
-// containsDataSuffix checks if a string already has .Data and .MissingKeys parameters at the end
-func containsDataSuffix(str string) bool {
-	// We need to check if the function already has both .Data and .MissingKeys at the END
-	// not inside nested function calls
-	trimmed := strings.TrimSpace(str)
-
-	// Check if it ends with the pattern: ... .Data .MissingKeys or ... $.Data $.MissingKeys
-	// This regex will match if the string ends with either pattern (with optional whitespace)
-	pattern1 := `\.Data\s+\.MissingKeys\s*$`
-	pattern2 := `\$\.Data\s+\$\.MissingKeys\s*$`
-	matched1, _ := regexp.MatchString(pattern1, trimmed)
-	matched2, _ := regexp.MatchString(pattern2, trimmed)
-	return matched1 || matched2
-}
-
 // containsMissingKeysSuffix checks if a string contains .MissingKeys or $.MissingKeys suffix
 func containsMissingKeysSuffix(str string) bool {
 	return strings.Contains(str, ".MissingKeys") || strings.Contains(str, "$.MissingKeys")
@@ -250,52 +428,17 @@ func ParseTemplateKey(key string) Key {
 	}
 }
 
-// findTemplateKeysToHydrate extracts template keys from a string using a regex
-// and creates Key objects representing each extracted key with its optionality.
-// The regex should extract the exact content (including the optional ? suffix) from template variables.
-func findTemplateKeysToHydrate(s any, regex *regexp.Regexp, parameterHydrationBehaviour *map[string]any) []Key {
-	// Convert input to string if possible
-	var str string
-	switch v := s.(type) {
-	case string:
-		str = v
-	case []byte:
-		str = string(v)
-	default:
-		// For non-string types, return empty result
-		return []Key{}
-	}
-
-	// Early exit: if string doesn't contain template markers, return empty
-	// This is a huge optimization when scanning hydrated output with many non-template strings
-	if !strings.Contains(str, "{{") && !strings.Contains(str, "%(") {
-		return []Key{}
-	}
-
-	matches := regex.FindAllStringSubmatch(str, -1)
-	keys := make([]Key, 0, len(matches))
-	for _, match := range matches {
-		if len(match) != 2 {
-			continue // Skip if we didn't get the expected capture group
-		}
-
-		// match[0] is the entire match including braces: "{{key?}}"
-		// match[1] is the extracted content inside the braces: "key?"
-		keyStr := strings.TrimSpace(match[1])
-
-		// Check for optional marker (? suffix)
-		isOptional := strings.HasSuffix(keyStr, "?")
-		cleanKey := strings.TrimSuffix(keyStr, "?")
-
-		// Remove .Data. prefix if present
-		cleanKey = removeDataPrefix(cleanKey)
-
-		keys = append(keys, Key{
-			Key:        cleanKey,
-			IsOptional: isOptional,
-		})
-	}
-
+// findStringTemplateKeys returns every key referenced by a template string: direct
+// variables, Python format specifiers and keys used inside referenced partials.
+// Jinja2 strings report the data keys their compiled template reads.
+func findStringTemplateKeys(str string, parameterHydrationBehaviour *map[string]any) []Key {
+	if templateSyntax(parameterHydrationBehaviour) == common.TemplateSyntaxJinja {
+		return findJinjaTemplateKeys(str)
+	}
+	// Literal text is never scanned for keys
+	str, _ = protectLiterals(str)
+	keys := templateKeys(str)
+	keys = append(keys, findPartialKeys(str, partialsOf(parameterHydrationBehaviour), map[string]bool{})...)
 	return keys
 }
 
@@ -315,7 +458,7 @@ func cleanKey(key string) (string, bool) {
 func FindTemplateKeysToHydrate(s any, includeOptional bool, parameterHydrationBehaviour *map[string]any) []Key {
 	// For simple string inputs, use the existing function
 	if str, ok := s.(string); ok {
-		keys := findTemplateKeysToHydrate(str, directVarRegex, parameterHydrationBehaviour)
+		keys := findStringTemplateKeys(str, parameterHydrationBehaviour)
 
 		res := make([]Key, 0, len(keys))
 		for _, key := range keys {
@@ -359,14 +502,22 @@ func findKeysInDict(dict map[string]any, includeOptional bool, parameterHydratio
 	for k, v := range dict {
 		// Check if this specific field should be hydrated
 		shouldHydrate, childBehaviour := shouldHydrateField(k, parameterHydrationBehaviour)
-		if !shouldHydrate {
+		if !shouldHydrate || fieldBehaviour(k, parameterHydrationBehaviour) == common.ParameterHydrationBehaviourNone {
 			continue
 		}
 
+		if hydratesKeys(parameterHydrationBehaviour) {
+			for _, key := range findStringTemplateKeys(k, keyTemplateBehaviour(parameterHydrationBehaviour)) {
+				if includeOptional || !key.IsOptional {
+					keys = append(keys, key)
+				}
+			}
+		}
+
 		// Process this value based on its type
 		switch tv := v.(type) {
 		case string:
-			fieldKeys := findTemplateKeysToHydrate(tv, directVarRegex, childBehaviour)
+			fieldKeys := findStringTemplateKeys(tv, childBehaviour)
 			for _, key := range fieldKeys {
 				if includeOptional || !key.IsOptional {
 					keys = append(keys, key)
@@ -392,7 +543,7 @@ func findKeysInSlice(slice []any, includeOptional bool, parameterHydrationBehavi
 		switch tv := v.(type) {
 		case string:
 			// We pass down the same behaviour for each element in the slice
-			fieldKeys := findTemplateKeysToHydrate(tv, directVarRegex, parameterHydrationBehaviour)
+			fieldKeys := findStringTemplateKeys(tv, parameterHydrationBehaviour)
 			for _, key := range fieldKeys {
 				if includeOptional || !key.IsOptional {
 					keys = append(keys, key)
@@ -415,73 +566,13 @@ func FindTemplateKeyStringsToHydrate(s any, includeOptional bool, parameterHydra
 	return getKeyStrings(keys)
 }
 
-var reservedWords = []string{"if", "range", "with", "end", "else", "template", "block", "define"}
+var reservedWords = []string{"if", "range", "with", "end", "else", "template", "block", "define", "break", "continue"}
 
+// parseTemplate rewrites an erdo template into a text/template one: key
+// references become getOrOriginal calls and data functions are passed the
+// data and missing keys
 func parseTemplate(input string) (string, error) {
-	// First, replace function calls
-	res := funcRegex.ReplaceAllStringFunc(input, func(match string) string {
-		// Skip if already contains .Data
-		if containsDataSuffix(match) {
-			return match
-		}
-
-		// Extract the function call from the match
-		funcCall := strings.TrimSpace(funcRegex.FindStringSubmatch(match)[1])
-
-		// Check if this is actually a function call (has a space) vs a variable
-		if !strings.Contains(funcCall, " ") {
-			// This is a variable, not a function call
-			return match
-		}
-
-		firstWord := strings.Fields(funcCall)[0]
-
-		// Skip reserved words unless they contain nested functions
-		if slices.Contains(reservedWords, firstWord) {
-			// Check if this reserved word statement contains nested function calls
-			if strings.Contains(funcCall, "(") && strings.Contains(funcCall, ")") {
-				// Process any nested function calls within reserved word statements
-				processedCall := processReservedWordWithNestedFunctions(funcCall)
-				return fmt.Sprintf("{{%s}}", processedCall)
-			}
-			return match
-		}
-
-		// Skip special characters
-		firstLetter := string(funcCall[0])
-		if slices.Contains([]string{"$", "-"}, firstLetter) {
-			return match
-		}
-
-		// Process the function call recursively to handle nested functions
-		// This will add .Data and .MissingKeys to any data functions
-		processedCall := processNestedFunctionCalls(funcCall)
-
-		return fmt.Sprintf("{{%s}}", processedCall)
-	})
-
-	// Then, replace direct variable references
-	res = directVarRegex.ReplaceAllStringFunc(res, func(match string) string {
-		content := strings.TrimSpace(match[2 : len(match)-2])
-		firstWord := strings.Fields(content)[0]
-
-		if slices.Contains(reservedWords, firstWord) {
-			return match
-		}
-
-		firstLetter := string(content[0])
-		if firstLetter == "$" {
-			return match
-		}
-
-		// Check if the match is already wrapped in getOrOriginal
-		if strings.HasPrefix(content, "getOrOriginal") {
-			return match
-		}
-
-		// Wrap the result in nilToEmptyString to handle nil values
-		return fmt.Sprintf("{{nilToEmptyString (getOrOriginal \"%v\" $.KeyDefinitions $.Data $.MissingKeys)}}", content)
-	})
+	res := rewriteTemplate(input)
 
 	// Create a template with our custom functions to test for errors
 	t := template.New("test").Funcs(funcMap)
@@ -495,158 +586,108 @@ func parseTemplate(input string) (string, error) {
 	return res, nil
 }
 
-// processNestedFunctionCalls recursively processes function calls and ensures that
-// all functions have the necessary .Data and .MissingKeys parameters
-func processNestedFunctionCalls(funcCall string) string {
-	// Parse the function call to get structured fields
-	fields := parseQuotedFields(funcCall)
-	if len(fields) == 0 {
-		return funcCall
-	}
-
-	funcName := fields[0]
-
-	// Process each field
-	var processedFields []string
-	processedFields = append(processedFields, funcName)
-
-	for i := 1; i < len(fields); i++ {
-		field := fields[i]
+// rewriteTemplate rewrites every segment of a template for text/template.
+// In a template with format keys, %% in the text is a literal %, as in Python.
+func rewriteTemplate(input string) string {
+	segments := splitTemplate(input)
+	formatted := slices.ContainsFunc(segments, func(seg segment) bool {
+		return seg.kind == segmentFormatKey
+	})
 
-		// Check if this field is a nested function call
-		if strings.HasPrefix(field, "(") && strings.HasSuffix(field, ")") {
-			// Extract the nested function
-			nestedFunc := field[1 : len(field)-1]
-			// Process the nested function recursively
-			processedNested := processNestedFunctionCalls(nestedFunc)
-			// Re-wrap in parentheses
-			processedFields = append(processedFields, "("+processedNested+")")
-		} else {
-			// Regular field, keep as-is
-			processedFields = append(processedFields, field)
+	var b strings.Builder
+	for _, seg := range segments {
+		if formatted && seg.kind == segmentText {
+			b.WriteString(strings.ReplaceAll(seg.src, "%%", "%"))
+			continue
 		}
+		b.WriteString(rewriteSegment(seg))
 	}
+	return b.String()
+}
 
-	// Reconstruct the function call
-	finalResult := strings.Join(processedFields, " ")
-
-	// Check if this function needs .Data and .MissingKeys added
-	_, requiresData := dataFuncMap[funcName]
-	if requiresData {
-		// Check if the function already has the required parameters
-		hasMissingKeys := false
-		hasData := false
-
-		// Check the last few fields for .Data and .MissingKeys
-		for i := len(processedFields) - 1; i >= 0 && i >= len(processedFields)-2; i-- {
-			field := processedFields[i]
-			if field == ".MissingKeys" || field == "$.MissingKeys" {
-				hasMissingKeys = true
-			}
-			if field == ".Data" || field == "$.Data" {
-				hasData = true
-			}
+// rewriteSegment rewrites a single segment of a template for text/template
+func rewriteSegment(seg segment) string {
+	if seg.format != nil {
+		return seg.render(fmt.Sprintf("pyFormat %q %q $.Data $.MissingKeys", seg.format.String(), seg.format.Key))
+	}
+	if key, ok := seg.key(); ok {
+		// Wrap the result in nilToEmptyString to handle nil values
+		keySrc := key.Key
+		if key.IsOptional {
+			keySrc += "?"
 		}
+		return seg.render(fmt.Sprintf("nilToEmptyString (getOrOriginal %q $.KeyDefinitions $.Data $.MissingKeys)", keySrc))
+	}
+	if seg.kind == segmentLiteral {
+		return seg.render(strconv.Quote(seg.text))
+	}
+	if seg.kind != segmentAction || seg.action == nil || seg.action.pipe == nil {
+		return seg.src
+	}
 
-		if !hasMissingKeys {
-			// Special handling for get with nested function as data parameter
-			if funcName == "get" && len(processedFields) >= 2 {
-				// Check if the second parameter is a nested function
-				hasNestedDataParam := false
-				for i := 1; i < len(processedFields); i++ {
-					if strings.HasPrefix(processedFields[i], "(") && strings.HasSuffix(processedFields[i], ")") {
-						// Found a nested function parameter
-						if i == 2 && funcName == "get" {
-							// This is the second parameter to get, which provides the data
-							hasNestedDataParam = true
-							break
-						}
-					}
-				}
-
-				if hasNestedDataParam {
-					// The second parameter is a nested function that provides the data
-					// Only add .MissingKeys
-					finalResult = finalResult + " $.MissingKeys"
-				} else if !hasData {
-					// Normal case - add both if not already present
-					finalResult = finalResult + " $.Data $.MissingKeys"
-				} else {
-					// Has data but not missingKeys
-					finalResult = finalResult + " $.MissingKeys"
-				}
-			} else if !hasData {
-				// For all other data functions, add both parameters if not present
-				finalResult = finalResult + " $.Data $.MissingKeys"
-			} else {
-				// Has data but not missingKeys
-				finalResult = finalResult + " $.MissingKeys"
-			}
-		}
+	rewritten := rewritePipe(seg.action.pipe)
+	if rewritten == seg.action.pipe.String() {
+		// Keep the original formatting of actions that don't change
+		return seg.src
 	}
-	return finalResult
+	return seg.render(strings.Join(append(slices.Clone(seg.action.keywords), rewritten), " "))
 }
 
-// processReservedWordWithNestedFunctions handles reserved word statements (like if, range)
-// that contain nested function calls, processing only the nested function calls
-func processReservedWordWithNestedFunctions(statement string) string {
-
-	// Parse parentheses manually to handle nested function calls correctly
-	result := strings.Builder{}
-	i := 0
-
-	for i < len(statement) {
-		if statement[i] == '(' {
-			// Find the matching closing parenthesis
-			parenCount := 1
-			start := i
-			i++ // skip the opening parenthesis
-
-			for i < len(statement) && parenCount > 0 {
-				if statement[i] == '(' {
-					parenCount++
-				} else if statement[i] == ')' {
-					parenCount--
-				}
-				i++
-			}
+// rewritePipe rewrites every command in a pipeline, including those in parens
+func rewritePipe(pipe *pipeNode) string {
+	cmds := make([]string, len(pipe.cmds))
+	for i, cmd := range pipe.cmds {
+		cmds[i] = rewriteCommand(cmd)
+	}
+	res := strings.Join(cmds, " | ")
+	if len(pipe.decl) > 0 {
+		res = strings.Join(pipe.decl, ", ") + " " + pipe.declare + " " + res
+	}
+	return res
+}
 
-			if parenCount == 0 {
-				// Extract the content within parentheses
-				inner := strings.TrimSpace(statement[start+1 : i-1])
-
-				// Check if this looks like a function call
-				if strings.Contains(inner, " ") {
-					parts := strings.Fields(inner)
-					if len(parts) > 0 {
-						funcName := parts[0]
-						// Skip if it's a reserved word itself
-						if slices.Contains(reservedWords, funcName) {
-							result.WriteString(statement[start:i])
-							continue
-						}
+// rewriteCommand ensures that calls to data functions have the necessary
+// .Data and .MissingKeys parameters
+func rewriteCommand(cmd *commandNode) string {
+	args := make([]string, len(cmd.args))
+	for i, arg := range cmd.args {
+		if arg.pipe != nil {
+			args[i] = "(" + rewritePipe(arg.pipe) + ")"
+		} else {
+			args[i] = arg.src
+		}
+	}
+	res := strings.Join(args, " ")
 
-						// Process the function call
-						processed := processNestedFunctionCalls(inner)
-						result.WriteString("(" + processed + ")")
-						continue
-					}
-				}
+	funcName := cmd.name()
+	if _, requiresData := dataFuncMap[funcName]; !requiresData {
+		return res
+	}
 
-				// Not a function call, keep original
-				result.WriteString(statement[start:i])
-			} else {
-				// Malformed parentheses, keep original
-				result.WriteString(statement[start:])
-				break
-			}
-		} else {
-			result.WriteByte(statement[i])
-			i++
+	// Check the last two arguments for .Data and .MissingKeys
+	hasMissingKeys := false
+	hasData := false
+	for i := len(args) - 1; i >= 1 && i >= len(args)-2; i-- {
+		switch args[i] {
+		case ".MissingKeys", "$.MissingKeys":
+			hasMissingKeys = true
+		case ".Data", "$.Data":
+			hasData = true
 		}
 	}
 
-	return result.String()
+	switch {
+	case hasMissingKeys:
+		return res
+	case funcName == "get" && len(cmd.args) > 2 && cmd.args[2].pipe != nil:
+		// The second parameter to get is a nested function that provides the data,
+		// so only add .MissingKeys
+		return res + " $.MissingKeys"
+	case hasData:
+		return res + " $.MissingKeys"
+	default:
+		return res + " $.Data $.MissingKeys"
+	}
 }
 
 func getKeys(data map[string]any) []string {
@@ -666,6 +707,10 @@ func init() {
 	for name, fn := range basicFuncMap {
 		funcMap[name] = fn
 	}
+	// include renders partials through hydrateString, so it is registered here
+	// to avoid an initialization cycle with dataFuncMap
+	dataFuncMap["include"] = include
+
 	for name, fn := range dataFuncMap {
 		funcMap[name] = fn
 		funcsThatNeedData = append(funcsThatNeedData, name)
@@ -763,22 +808,44 @@ func getKeyStrings(keys []Key) []string {
 	return res
 }
 
-func hydrateString(userTemplate string, data *map[string]any) (any, error) {
+func hydrateString(userTemplate string, data *map[string]any, opts stringOptions) (any, error) {
+	// Early exit: if string doesn't contain template markers, return as-is
+	// This is a huge optimization - most strings don't have templates!
+	if !hasTemplateSyntax(userTemplate) {
+		return userTemplate, nil
+	}
+
+	// Literal text is taken out before hydration and put back in the result,
+	// so it's never read as a template
+	protected, literals := protectLiterals(userTemplate)
+	value, err := hydrateTemplateString(protected, data, opts)
+	if str, ok := value.(string); ok {
+		value = restoreLiterals(str, literals)
+	}
+	return value, err
+}
+
+// hydrateTemplateString hydrates a template without literal text
+func hydrateTemplateString(userTemplate string, data *map[string]any, opts stringOptions) (any, error) {
 	if data == nil {
 		data = &map[string]any{}
 	}
 
-	// Early exit: if string doesn't contain template markers, return as-is
-	// This is a huge optimization - most strings don't have templates!
 	if !strings.Contains(userTemplate, "{{") && !strings.Contains(userTemplate, "%(") {
 		return userTemplate, nil
 	}
 
+	// A string that is a single Python format specifier (other than a plain
+	// %(key)s) keeps numeric types, e.g. %(count)d returns an int
+	if value, handled, err := hydrateWholePyFormat(userTemplate, *data); handled {
+		return value, err
+	}
+
 	var missingKeys []string
 
 	// Check if the entire string is a single template variable
-	if keys := findTemplateKeysToHydrate(userTemplate, wholeVarRegex, nil); len(keys) == 1 {
-		key := keys[0]
+	whole, isWhole := singleSegment(userTemplate)
+	if key, isKey := whole.key(); isWhole && isKey {
 
 		// Before treating as variable, check if this is actually a known function
 		// This handles parameterless functions like genUUID, now, noop, etc.
@@ -787,6 +854,10 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 			if value, err := processSingleFunction(key.Key, *data, &missingKeys); err == nil {
 				return value, nil
 			}
+			// A state key can share its name with a function that takes arguments
+			if value := get(key.Key, *data, &[]string{}); value != nil {
+				return value, nil
+			}
 			// If function processing fails, fall through to template parsing
 		} else {
 			// This is actually a variable, process normally
@@ -805,19 +876,18 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 			}
 
 			return nil, &InfoNeededError{
-				MissingKeys:   getKeyStrings(keys),
+				MissingKeys:   []string{key.Key},
 				AvailableKeys: getKeys(*data),
 				Err:           fmt.Errorf("missing keys in template"),
 			}
 		}
 	}
 
-	// Or if the entire string is a function
-	if keys := findTemplateKeysToHydrate(userTemplate, wholeFuncRegex, nil); len(keys) == 1 {
-		key := keys[0]
-
+	// Or if the entire string is a function. Partials are skipped here as keys
+	// missing inside them are only reported by a full template execution
+	if isWhole && whole.action != nil && !usesPartials(userTemplate) {
 		// Try to process as a single function call (optimization path)
-		if value, err := processSingleFunction(key.Key, *data, &missingKeys); err == nil {
+		if value, err := evalAction(whole.action, *data, &missingKeys, opts.funcs()); err == nil {
 			return value, nil
 		} else {
 			// Single function processing failed, falling back to full template parsing
@@ -830,18 +900,9 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 
 	// Pre-process the template to handle optional parameters
 	// Replace optional parameters that are missing with empty strings in the template
-	preprocessedTemplate := userTemplate
-	for _, key := range allKeys {
-		if key.IsOptional {
-			// Check if the parameter exists in data
-			value := get(key.Key, *data, &[]string{})
-			if value == nil {
-				// Replace the optional parameter with empty string using string replacement
-				optPattern := fmt.Sprintf("{{%s?}}", key.Key)
-				preprocessedTemplate = strings.ReplaceAll(preprocessedTemplate, optPattern, "")
-			}
-		}
-	}
+	preprocessedTemplate := removeOptionalKeys(userTemplate, func(key Key) bool {
+		return get(key.Key, *data, &[]string{}) != nil
+	})
 
 	// Parse the user template and convert it to use our custom get function
 	parsedTemplate, err := parseTemplate(preprocessedTemplate)
@@ -855,6 +916,9 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 	// Add custom helpers for variable processing
 	t = addCustomTemplateHelpers(t, *data)
 
+	// Bind functions to the hydration options, e.g. the tokenizer
+	t.Funcs(opts.funcs())
+
 	// Set option to error on missing keys
 	t.Option("missingkey=error")
 
@@ -864,6 +928,13 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 		return nil, fmt.Errorf("error parsing template: %w", err)
 	}
 
+	// Make registered partials available to {{template "name" .}} actions
+	if usesPartials(userTemplate) {
+		if err := addPartialTemplates(t, opts); err != nil {
+			return nil, err
+		}
+	}
+
 	keyDefinitions := KeyDefinitions{}
 	// Only include non-optional keys or optional keys that exist in data
 	for _, key := range allKeys {
@@ -874,11 +945,7 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 
 	// Execute the template
 	var result bytes.Buffer
-	templateData := struct {
-		Data           map[string]any
-		MissingKeys    *[]string
-		KeyDefinitions KeyDefinitions
-	}{Data: *data, MissingKeys: &missingKeys, KeyDefinitions: keyDefinitions}
+	templateData := templateContext{Data: *data, MissingKeys: &missingKeys, KeyDefinitions: keyDefinitions}
 
 	err = t.Execute(&result, templateData)
 
@@ -924,7 +991,7 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 
 	// Manually handle any remaining optional parameters in the template result
 	// Replace any remaining {{key?}} patterns with empty strings
-	strResult = optionalVarRegex.ReplaceAllString(strResult, "")
+	strResult = removeOptionalKeys(strResult, func(Key) bool { return false })
 
 	// Check for missing keys from both error and get function
 	// Filter out optional keys from missingKeys
@@ -955,13 +1022,9 @@ func hydrateString(userTemplate string, data *map[string]any) (any, error) {
 
 	// Special case for optional variables that were returned as template strings
 	// If the result matches the pattern {{key?}}, and key is optional, return nil
-	if optVarMatches := wholeVarRegex.FindStringSubmatch(strResult); len(optVarMatches) > 0 {
-		matchedKey := strings.TrimSpace(optVarMatches[1])
-		isOptional := strings.HasSuffix(matchedKey, "?")
-		if isOptional {
-			// It's an optional parameter that wasn't hydrated, so return nil
-			return nil, nil
-		}
+	if isOptionalKeyTemplate(strResult) {
+		// It's an optional parameter that wasn't hydrated, so return nil
+		return nil, nil
 	}
 
 	// Check if the value is a number and preserve its type
@@ -995,72 +1058,73 @@ func processSingleVariable(key Key, data map[string]any, missingKeys *[]string)
 }
 
 func processSingleFunction(funcCall string, data map[string]any, missingKeys *[]string) (any, error) {
-	// Handle reserved words
-	if err := validateFunctionName(funcCall); err != nil {
-		return nil, err
-	}
-
-	// Handle nested function calls
-	if result, handled, err := processNestedFunction(funcCall, data, missingKeys); handled {
-		return result, err
-	}
-
-	// Parse regular function call
-	funcName, processedArgs, err := parseFunctionCall(funcCall, data, missingKeys)
+	action, err := parseAction(funcCall)
 	if err != nil {
 		return nil, err
 	}
-
-	// Execute the function
-	return executeFunctionCall(funcName, processedArgs, data, missingKeys)
+	return evalAction(action, data, missingKeys, nil)
 }
 
-// validateFunctionName checks if the function call starts with a reserved word
-func validateFunctionName(funcCall string) error {
-	for _, reserved := range reservedWords {
-		if strings.HasPrefix(funcCall, reserved+" ") {
-			return fmt.Errorf("reserved word used as function: %s", reserved)
-		}
+// evalAction evaluates an action that is a single function call. Functions in
+// funcs take precedence over funcMap.
+func evalAction(action *actionNode, data map[string]any, missingKeys *[]string, funcs template.FuncMap) (any, error) {
+	// Handle reserved words
+	if len(action.keywords) > 0 {
+		return nil, fmt.Errorf("reserved word used as function: %s", action.keywords[0])
 	}
-	return nil
+	return evalPipe(action.pipe, data, missingKeys, funcs)
 }
 
-// processNestedFunction handles nested function calls like "toJSON (mapToDict ...)"
-func processNestedFunction(funcCall string, data map[string]any, missingKeys *[]string) (any, bool, error) {
-	if !strings.Contains(funcCall, "(") || !strings.Contains(funcCall, ")") {
-		return nil, false, nil // Not a nested function
+// evalPipe evaluates a pipeline that is a single function call. Pipelines with
+// several commands or declarations are left to text/template.
+func evalPipe(pipe *pipeNode, data map[string]any, missingKeys *[]string, funcs template.FuncMap) (any, error) {
+	if len(pipe.decl) > 0 || len(pipe.cmds) != 1 {
+		return nil, fmt.Errorf("unsupported pipeline: %s", pipe)
 	}
+	cmd := pipe.cmds[0]
 
-	// Use parseQuotedFields to properly parse the function call
-	parts := parseQuotedFields(funcCall)
-	if len(parts) < 2 {
-		return nil, false, nil // Not enough parts for a nested function
+	funcName := cmd.name()
+	if funcName == "" || slices.Contains(reservedWords, funcName) {
+		return nil, fmt.Errorf("unknown function: %s", cmd.args[0])
 	}
 
-	outerFunc := parts[0]
-
-	// Check if this looks like "func1 (func2 args...)" pattern
-	// This should have exactly 2 parts: the outer function and one parenthesized expression
-	if len(parts) != 2 {
-		return nil, false, nil // Multiple arguments, let parseFunctionCall handle it
+	// Handle nested function calls like "toJSON (mapToDict ...)"
+	if len(cmd.args) == 2 && cmd.args[1].pipe != nil {
+		return processNestedFunction(funcName, cmd.args[1].pipe, data, missingKeys, funcs)
 	}
 
-	innerCall := strings.TrimSpace(parts[1])
-	if !strings.HasPrefix(innerCall, "(") || !strings.HasSuffix(innerCall, ")") {
-		return nil, false, nil // Not a single parenthesized expression
+	// Process each argument, evaluating nested functions recursively
+	processedArgs := make([]any, len(cmd.args)-1)
+	for i, arg := range cmd.args[1:] {
+		if arg.pipe != nil {
+			// A nested call that can't be evaluated here leaves the whole action
+			// to text/template rather than passing its source on as a string
+			result, err := evalPipe(arg.pipe, data, missingKeys, funcs)
+			if err != nil {
+				return nil, err
+			}
+			processedArgs[i] = result
+		} else {
+			processedArgs[i] = processArgument(arg.src, data, missingKeys)
+		}
 	}
 
-	// Extract and process inner function
-	innerFunc := innerCall[1 : len(innerCall)-1]
-	innerResult, err := processSingleFunction(innerFunc, data, missingKeys)
+	// Execute the function
+	return executeFunctionCall(funcName, processedArgs, data, missingKeys, funcs)
+}
+
+// processNestedFunction calls a function with the result of a nested function call
+func processNestedFunction(outerFunc string, inner *pipeNode, data map[string]any, missingKeys *[]string, funcs template.FuncMap) (any, error) {
+	innerFunc := inner.String()
+	innerResult, err := evalPipe(inner, data, missingKeys, funcs)
 	if err != nil {
-		return nil, true, err
+		return nil, err
 	}
 
 	// Execute outer function with inner result
-	fn, ok := funcMap[outerFunc]
+	fn, ok := lookupFunc(outerFunc, funcs)
 	if !ok {
-		return nil, true, fmt.Errorf("unknown function: %s", outerFunc)
+		return nil, fmt.Errorf("unknown function: %s", outerFunc)
 	}
 
 	fnValue := reflect.ValueOf(fn)
@@ -1078,15 +1142,15 @@ func processNestedFunction(funcCall string, data map[string]any, missingKeys *[]
 	if fnType.NumIn() == 1 {
 		// Simple function with one argument
 		if innerResult == nil {
-			return nil, true, fmt.Errorf("cannot call function %s with nil argument from inner function %s", outerFunc, innerFunc)
+			return nil, fmt.Errorf("cannot call function %s with nil argument from inner function %s", outerFunc, innerFunc)
 		}
 		callArgs := []reflect.Value{reflect.ValueOf(innerResult)}
 		results := fnValue.Call(callArgs)
-		return processResults(results), true, nil
+		return processResults(results), nil
 	} else if isDataFunction && fnType.NumIn() == 3 {
 		// Data function with (arg, data, missingKeys)
 		if innerResult == nil {
-			return nil, true, fmt.Errorf("cannot call function %s with nil argument from inner function %s", outerFunc, innerFunc)
+			return nil, fmt.Errorf("cannot call function %s with nil argument from inner function %s", outerFunc, innerFunc)
 		}
 		callArgs := []reflect.Value{
 			reflect.ValueOf(innerResult),
@@ -1094,96 +1158,10 @@ func processNestedFunction(funcCall string, data map[string]any, missingKeys *[]
 			reflect.ValueOf(missingKeys),
 		}
 		results := fnValue.Call(callArgs)
-		return processResults(results), true, nil
-	}
-
-	return nil, true, fmt.Errorf("unsupported function signature for nested call: %s (numIn: %d, isDataFunction: %v)", outerFunc, fnType.NumIn(), isDataFunction)
-}
-
-// parseQuotedFields parses a string into fields, respecting quoted strings (both single and double quotes)
-// and parentheses for nested function calls
-func parseQuotedFields(s string) []string {
-	var fields []string
-	var current strings.Builder
-	inQuotes := false
-	quoteChar := byte(0)
-	parenDepth := 0
-
-	for i := 0; i < len(s); i++ {
-		ch := s[i]
-
-		if !inQuotes {
-			if ch == '"' || ch == '\'' {
-				inQuotes = true
-				quoteChar = ch
-				current.WriteByte(ch)
-			} else if ch == '(' {
-				parenDepth++
-				current.WriteByte(ch)
-			} else if ch == ')' {
-				parenDepth--
-				current.WriteByte(ch)
-			} else if (ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r') && parenDepth == 0 {
-				// Whitespace outside quotes and parentheses - end current field
-				if current.Len() > 0 {
-					fields = append(fields, current.String())
-					current.Reset()
-				}
-			} else {
-				current.WriteByte(ch)
-			}
-		} else {
-			// Inside quotes
-			current.WriteByte(ch)
-			if ch == quoteChar {
-				inQuotes = false
-				quoteChar = 0
-			}
-		}
-	}
-
-	// Add final field if any
-	if current.Len() > 0 {
-		fields = append(fields, current.String())
-	}
-
-	return fields
-}
-
-// parseFunctionCall parses a function call string and returns the function name and processed arguments
-func parseFunctionCall(funcCall string, data map[string]any, missingKeys *[]string) (string, []any, error) {
-	parts := parseQuotedFields(funcCall)
-	if len(parts) == 0 {
-		return "", nil, fmt.Errorf("empty function call")
-	}
-
-	funcName := parts[0]
-	if slices.Contains(reservedWords, funcName) {
-		return "", nil, fmt.Errorf("unknown function: %s", funcName)
+		return processResults(results), nil
 	}
 
-	args := parts[1:]
-	processedArgs := make([]any, len(args))
-
-	// Process each argument, evaluating nested functions recursively
-	for i, arg := range args {
-		// Check if this argument is a nested function call
-		if strings.HasPrefix(arg, "(") && strings.HasSuffix(arg, ")") {
-			// Extract the nested function and evaluate it
-			nestedFunc := arg[1 : len(arg)-1]
-			if result, err := processSingleFunction(nestedFunc, data, missingKeys); err == nil {
-				processedArgs[i] = result
-			} else {
-				// If evaluation fails, pass the string as-is
-				processedArgs[i] = arg
-			}
-		} else {
-			// Process non-function arguments normally
-			processedArgs[i] = processArgument(arg, data, missingKeys)
-		}
-	}
-
-	return funcName, processedArgs, nil
+	return nil, fmt.Errorf("unsupported function signature for nested call: %s (numIn: %d, isDataFunction: %v)", outerFunc, fnType.NumIn(), isDataFunction)
 }
 
 // interpretEscapeSequences converts common escape sequences to their actual characters
@@ -1200,7 +1178,7 @@ func interpretEscapeSequences(s string) string {
 
 // processArgument processes a single function argument, handling data references and quote stripping
 func processArgument(arg string, data map[string]any, missingKeys *[]string) any {
-	// Note: Nested function calls are now handled in parseFunctionCall
+	// Note: Nested function calls are now handled in evalPipe
 	// This function only handles non-function arguments
 
 	// Check if this is a nested function call that wasn't processed yet
@@ -1216,10 +1194,8 @@ func processArgument(arg string, data map[string]any, missingKeys *[]string) any
 	}
 
 	// Handle quoted strings
-	if strings.HasPrefix(arg, "\"") && strings.HasSuffix(arg, "\"") {
-		// Remove quotes and interpret escape sequences
-		unquoted := strings.Trim(arg, "\"")
-		return interpretEscapeSequences(unquoted)
+	if len(arg) >= 2 && strings.HasPrefix(arg, "\"") && strings.HasSuffix(arg, "\"") {
+		return unquoteLiteral(arg)
 	}
 
 	clean := strings.Trim(arg, "\"'")
@@ -1247,8 +1223,8 @@ func processArgument(arg string, data map[string]any, missingKeys *[]string) any
 }
 
 // executeFunctionCall executes a function with the given arguments
-func executeFunctionCall(funcName string, processedArgs []any, data map[string]any, missingKeys *[]string) (any, error) {
-	fn, ok := funcMap[funcName]
+func executeFunctionCall(funcName string, processedArgs []any, data map[string]any, missingKeys *[]string, funcs template.FuncMap) (any, error) {
+	fn, ok := lookupFunc(funcName, funcs)
 	if !ok {
 		return nil, fmt.Errorf("unknown function: %s", funcName)
 	}
@@ -1270,6 +1246,18 @@ func executeFunctionCall(funcName string, processedArgs []any, data map[string]a
 		return nil, err
 	}
 
+	return callFunction(funcName, fnValue, callArgs)
+}
+
+// callFunction calls a template function, returning a *FunctionCallError
+// rather than panicking if the call fails
+func callFunction(funcName string, fnValue reflect.Value, callArgs []reflect.Value) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &FunctionCallError{Function: funcName, Err: fmt.Errorf("%v", r)}
+		}
+	}()
+
 	results := fnValue.Call(callArgs)
 	return processResults(results), nil
 }
@@ -1397,6 +1385,12 @@ func convertArgument(paramValue any, paramType reflect.Type, argIndex int) (refl
 		}
 	}
 
+	// Values that already have the parameter's type, such as the data map and
+	// missing keys passed explicitly to a data function, are used as-is
+	if reflect.TypeOf(paramValue).AssignableTo(paramType) {
+		return reflect.ValueOf(paramValue), nil
+	}
+
 	switch paramType.Kind() {
 	case reflect.String:
 		if strVal, ok := paramValue.(string); ok {
@@ -1481,47 +1475,69 @@ func hydrateDict(dict any, stateParameters *map[string]any, parameterHydrationBe
 
 	// Pre-allocate result map with same capacity as input to avoid resizing
 	result := make(map[string]any, len(typedDict))
-	var missingKeys []string
-	var missingKeyPaths []MissingKeyInfo
+	// Errors are collected so every failing field is reported, not just the first
+	var errs errorCollector
+
+	hydrateKeys := hydratesKeys(parameterHydrationBehaviour)
+	keySources := map[string]string{}
 
 	// First process all values that need hydration
 	for key, value := range typedDict {
+		if fieldBehaviour(key, parameterHydrationBehaviour) == common.ParameterHydrationBehaviourNone {
+			// Fields marked none are dropped
+			continue
+		}
+
+		outKey := key
+		if hydrateKeys {
+			renderedKey, err := hydrateKey(key, stateParameters, parameterHydrationBehaviour)
+			if err != nil {
+				// Keep the key template so the entry can be hydrated once the keys are available
+				errs.add(key, err)
+			} else if renderedKey == "" {
+				// Optional key templates that render to nothing drop the entry
+				continue
+			} else {
+				outKey = renderedKey
+			}
+
+			if source, exists := keySources[outKey]; exists {
+				sources := []string{source, key}
+				slices.Sort(sources)
+				errs.add(key, &KeyCollisionError{Key: outKey, Sources: sources})
+				continue
+			}
+			keySources[outKey] = key
+		}
+
 		// Check if this specific field should be hydrated
 		shouldHydrate, childBehaviour := shouldHydrateField(key, parameterHydrationBehaviour)
 
 		if !shouldHydrate {
 			// If not hydrating, just copy the original value
-			result[key] = value
+			result[outKey] = value
 			continue
 		}
 
 		// For values that need hydration, process them
-		hydratedValue, err := Hydrate(value, stateParameters, childBehaviour)
+		hydratedValue, err := hydrate(value, stateParameters, childBehaviour)
 
 		// Handle string values that might contain unhydrated optional templates
 		if strValue, ok := hydratedValue.(string); ok {
-			if optVarMatches := wholeVarRegex.FindStringSubmatch(strValue); len(optVarMatches) > 0 {
-				matchedKey := strings.TrimSpace(optVarMatches[1])
-				isOptional := strings.HasSuffix(matchedKey, "?")
-				if isOptional {
-					// It's an optional parameter that wasn't hydrated, we should return nil
-					result[key] = nil
-					continue
-				}
+			if isOptionalKeyTemplate(strValue) && !hasLiterals(value) {
+				// It's an optional parameter that wasn't hydrated, we should return nil
+				result[outKey] = nil
+				continue
 			}
 		}
 
 		// Check for optional string patterns in the original value
 		if hydratedValue == nil && err == nil {
 			if strOriginal, ok := value.(string); ok {
-				if optVarMatches := wholeVarRegex.FindStringSubmatch(strOriginal); len(optVarMatches) > 0 {
-					matchedKey := strings.TrimSpace(optVarMatches[1])
-					isOptional := strings.HasSuffix(matchedKey, "?")
-					if isOptional {
-						// It's an optional parameter, set to nil explicitly
-						result[key] = nil
-						continue
-					}
+				if isOptionalKeyTemplate(strOriginal) {
+					// It's an optional parameter, set to nil explicitly
+					result[outKey] = nil
+					continue
 				}
 			}
 		}
@@ -1529,42 +1545,49 @@ func hydrateDict(dict any, stateParameters *map[string]any, parameterHydrationBe
 		// Check for other nil values
 		if hydratedValue == nil && err == nil {
 			// It's an optional parameter that returned nil, keep it as nil
-			result[key] = nil
+			result[outKey] = nil
 			continue
 		}
 
 		// For all other cases, store the hydrated value if not nil
 		if hydratedValue != nil {
-			result[key] = hydratedValue
+			result[outKey] = hydratedValue
 		} else {
 			// Default - use original value if hydrated is nil but there was an error
-			result[key] = value
+			result[outKey] = value
 		}
 
 		if err != nil {
-			var infoNeededErr *InfoNeededError
-			if errors.As(err, &infoNeededErr) {
-				// Don't prepend paths - templates reference absolute paths via .Data
-				// so prepending parent paths would corrupt the correct paths from the template system
-				missingKeys = append(missingKeys, infoNeededErr.MissingKeys...)
-				missingKeyPaths = append(missingKeyPaths, infoNeededErr.MissingKeyPaths...)
-				continue
-			}
-
-			return nil, fmt.Errorf("error hydrating key '%s': %w", key, err)
+			errs.add(key, err)
 		}
 	}
 
-	if len(missingKeys) > 0 || len(missingKeyPaths) > 0 {
-		return result, &InfoNeededError{
-			MissingKeys:     missingKeys,
-			MissingKeyPaths: missingKeyPaths,
-			AvailableKeys:   getKeys(*stateParameters),
-			Err:             fmt.Errorf("missing keys in dict"),
-		}
+	return result, errs.err(stateParameters, "dict")
+}
+
+// keyTemplateBehaviour returns the behaviour key templates are hydrated with:
+// only the template syntax applies to them
+func keyTemplateBehaviour(parameterHydrationBehaviour *map[string]any) *map[string]any {
+	if syntax := templateSyntax(parameterHydrationBehaviour); syntax != "" {
+		return WithTemplateSyntax(nil, syntax)
 	}
+	return nil
+}
 
-	return result, nil
+// hydrateKey renders a templated dict key. Keys that aren't templates are returned as-is.
+func hydrateKey(key string, stateParameters *map[string]any, parameterHydrationBehaviour *map[string]any) (string, error) {
+	value, err := hydrate(key, stateParameters, keyTemplateBehaviour(parameterHydrationBehaviour))
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
 }
 
 func hydrateSlice(slice []any, stateParameters *map[string]any, parameterHydrationBehaviour *map[string]any) ([]any, error) {
@@ -1573,39 +1596,30 @@ func hydrateSlice(slice []any, stateParameters *map[string]any, parameterHydrati
 	}
 
 	result := make([]any, len(slice))
-	var missingKeys []string
-	var missingKeyPaths []MissingKeyInfo
+	var errs errorCollector
 
 	// Process each slice element
 	for i, v := range slice {
 		// Hydrate the value
 		// We pass down the same behaviour for each element in the slice
-		hydratedValue, err := Hydrate(v, stateParameters, parameterHydrationBehaviour)
+		hydratedValue, err := hydrate(v, stateParameters, parameterHydrationBehaviour)
 
 		// Handle string values that might contain unhydrated optional templates
 		if strValue, ok := hydratedValue.(string); ok {
-			if optVarMatches := wholeVarRegex.FindStringSubmatch(strValue); len(optVarMatches) > 0 {
-				matchedKey := strings.TrimSpace(optVarMatches[1])
-				isOptional := strings.HasSuffix(matchedKey, "?")
-				if isOptional {
-					// It's an optional parameter that wasn't hydrated, we should return nil
-					result[i] = nil
-					continue
-				}
+			if isOptionalKeyTemplate(strValue) && !hasLiterals(v) {
+				// It's an optional parameter that wasn't hydrated, we should return nil
+				result[i] = nil
+				continue
 			}
 		}
 
 		// Check for optional string patterns in the original value
 		if hydratedValue == nil && err == nil {
 			if strOriginal, ok := v.(string); ok {
-				if optVarMatches := wholeVarRegex.FindStringSubmatch(strOriginal); len(optVarMatches) > 0 {
-					matchedKey := strings.TrimSpace(optVarMatches[1])
-					isOptional := strings.HasSuffix(matchedKey, "?")
-					if isOptional {
-						// It's an optional parameter, set to nil explicitly
-						result[i] = nil
-						continue
-					}
+				if isOptionalKeyTemplate(strOriginal) {
+					// It's an optional parameter, set to nil explicitly
+					result[i] = nil
+					continue
 				}
 			}
 		}
@@ -1626,28 +1640,11 @@ func hydrateSlice(slice []any, stateParameters *map[string]any, parameterHydrati
 
 		// Handle errors
 		if err != nil {
-			var infoNeededErr *InfoNeededError
-			if errors.As(err, &infoNeededErr) {
-				// Don't prepend paths - templates reference absolute paths via .Data
-				// so prepending array indices would corrupt the correct paths from the template system
-				missingKeys = append(missingKeys, infoNeededErr.MissingKeys...)
-				missingKeyPaths = append(missingKeyPaths, infoNeededErr.MissingKeyPaths...)
-			} else {
-				return nil, fmt.Errorf("error hydrating slice index %d: %w", i, err)
-			}
+			errs.add(fmt.Sprintf("[%d]", i), err)
 		}
 	}
 
-	if len(missingKeys) > 0 || len(missingKeyPaths) > 0 {
-		return result, &InfoNeededError{
-			MissingKeys:     missingKeys,
-			MissingKeyPaths: missingKeyPaths,
-			AvailableKeys:   getKeys(*stateParameters),
-			Err:             fmt.Errorf("missing keys in slice"),
-		}
-	}
-
-	return result, nil
+	return result, errs.err(stateParameters, "slice")
 }
 
 // Public API helper methods - these use Hydrate but then case back to the original type,
@@ -1661,16 +1658,16 @@ func HydrateDict(dict any, stateParameters *map[string]any, parameterHydrationBe
 	}
 
 	value, err := Hydrate(dict, stateParameters, behaviour)
-	if err != nil {
-		return nil, err
-	}
-
+	// Like Hydrate, the partially hydrated result is returned with any error
 	typedDict, ok := value.(map[string]any)
 	if !ok {
+		if err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("expected map[string]any, got %T", value)
 	}
 
-	return typedDict, nil
+	return typedDict, err
 }
 
 func HydrateSlice(slice []any, stateParameters *map[string]any, parameterHydrationBehaviour ...*map[string]any) ([]any, error) {
@@ -1680,16 +1677,16 @@ func HydrateSlice(slice []any, stateParameters *map[string]any, parameterHydrati
 	}
 
 	value, err := Hydrate(slice, stateParameters, behaviour)
-	if err != nil {
-		return nil, err
-	}
-
+	// Like Hydrate, the partially hydrated result is returned with any error
 	typedSlice, ok := value.([]any)
 	if !ok {
+		if err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("expected []any, got %T", value)
 	}
 
-	return typedSlice, nil
+	return typedSlice, err
 }
 
 func Get(key string, data map[string]any, missingKeys *[]string) any {
@@ -1717,7 +1714,11 @@ func Set(data map[string]any, key string, value any) (map[string]any, error) {
 	}
 
 	// Deep copy data to avoid modifying the original
-	copiedData, err := utils.JSONToDict(utils.JSON(data))
+	raw, err := utils.TryJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error copying data: %w", err)
+	}
+	copiedData, err := utils.JSONToDict(raw)
 	if err != nil {
 		return nil, fmt.Errorf("error copying data: %w", err)
 	}
@@ -8,6 +8,7 @@ import (
 
 	common "github.com/erdoai/erdo-common/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHydrateString(t *testing.T) {
@@ -3462,3 +3463,19 @@ func TestGreaterThanConditionWithMissingField(t *testing.T) {
 		})
 	}
 }
+
+func TestRequiresInfo(t *testing.T) {
+	_, err := Hydrate(map[string]any{"q": "{{question}}"}, &map[string]any{}, nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, common.ErrorInfoNeeded)
+	assert.Equal(t, common.ErrorInfoNeeded, common.ErrorOf(err))
+
+	var infoErr *InfoNeededError
+	require.ErrorAs(t, err, &infoErr)
+	result := RequiresInfo(infoErr)
+	assert.Equal(t, common.StatusRequiresInfo, result.Status)
+	assert.Equal(t, map[string]any{"missing_keys": []string{"question"}}, *result.Output)
+	assert.ErrorIs(t, result.Err(), common.ErrorInfoNeeded)
+	assert.False(t, result.Retryable())
+	assert.Equal(t, 422, result.HTTPStatus())
+}
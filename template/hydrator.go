@@ -0,0 +1,439 @@
+package template
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// Hydrator hydrates the same parameters repeatedly as state changes, e.g. for a
+// step waiting in a long-running agent loop. It records which state keys each
+// templated value depends on, and Update only re-hydrates the values affected
+// by the changed keys, reusing the previous results for the rest.
+//
+// Dependencies are found from the keys, string arguments and fields used in
+// the parsed template actions, so they over-approximate what a template reads: an unrelated change
+// can cause a re-hydration, but a relevant change is never missed. Templates that
+// pass the whole state around (e.g. {{include "partial" .}} or $.Data) depend on
+// every key.
+type Hydrator struct {
+	behaviour *map[string]any
+
+	mu     sync.Mutex
+	root   *hydratorNode
+	leaves []*hydratorLeaf
+	ready  bool
+}
+
+// hydratorNode mirrors the structure of the parameters, with templated values
+// replaced by leaves
+type hydratorNode struct {
+	static   any
+	leaf     *hydratorLeaf
+	children map[string]*hydratorNode
+	items    []*hydratorNode
+}
+
+type hydratorLeaf struct {
+	path      string
+	raw       any
+	behaviour *map[string]any
+	deps      []string
+	// all is true when the template depends on the whole state
+	all bool
+
+	value any
+	err   error
+}
+
+// NewHydrator returns a Hydrator for the value (string, dict or slice)
+func NewHydrator(value any, parameterHydrationBehaviour *map[string]any) *Hydrator {
+	h := &Hydrator{behaviour: parameterHydrationBehaviour}
+	h.root = h.buildNode(value, valuePath{}, parameterHydrationBehaviour)
+	return h
+}
+
+func (h *Hydrator) buildNode(value any, path valuePath, behaviour *map[string]any) *hydratorNode {
+	switch v := value.(type) {
+	case map[string]any:
+		// Templated keys are hydrated along with the values, so the dict is a single unit
+		if hydratesKeys(behaviour) {
+			return h.leafNode(v, path, behaviour)
+		}
+		node := &hydratorNode{children: make(map[string]*hydratorNode, len(v))}
+		for key, item := range v {
+			if fieldBehaviour(key, behaviour) == common.ParameterHydrationBehaviourNone {
+				continue
+			}
+			shouldHydrate, childBehaviour := shouldHydrateField(key, behaviour)
+			if !shouldHydrate {
+				node.children[key] = &hydratorNode{static: item}
+				continue
+			}
+			node.children[key] = h.buildNode(item, path.child(pathElem{key: key}), childBehaviour)
+		}
+		return node
+	case []any:
+		node := &hydratorNode{items: make([]*hydratorNode, len(v))}
+		for i, item := range v {
+			node.items[i] = h.buildNode(item, path.child(pathElem{index: i, isIndex: true}), behaviour)
+		}
+		return node
+	case string:
+		if !isTemplated(v) {
+			return &hydratorNode{static: v}
+		}
+		return h.leafNode(v, path, behaviour)
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return &hydratorNode{static: v}
+	default:
+		return h.leafNode(v, path, behaviour)
+	}
+}
+
+func (h *Hydrator) leafNode(value any, path valuePath, behaviour *map[string]any) *hydratorNode {
+	leaf := &hydratorLeaf{path: path.String(), raw: value, behaviour: behaviour}
+	leaf.deps, leaf.all = valueDependencies(value, behaviour)
+	h.leaves = append(h.leaves, leaf)
+	return &hydratorNode{leaf: leaf}
+}
+
+// Hydrate hydrates every templated value with the state and records the results
+func (h *Hydrator) Hydrate(stateParameters *map[string]any) (any, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, leaf := range h.leaves {
+		h.hydrateLeaf(leaf, stateParameters)
+	}
+	h.ready = true
+	return h.assemble(stateParameters)
+}
+
+// Update re-hydrates the values that depend on the changed state keys and
+// reuses the previous results for the rest. Changed keys are paths like
+// "messages" or "steps.search.output"; a change to a key affects templates
+// reading it, anything under it or anything above it. If Hydrate hasn't been
+// called yet, everything is hydrated.
+func (h *Hydrator) Update(stateParameters *map[string]any, changedKeys ...string) (any, error) {
+	h.mu.Lock()
+	if !h.ready {
+		h.mu.Unlock()
+		return h.Hydrate(stateParameters)
+	}
+	defer h.mu.Unlock()
+
+	for _, leaf := range h.leaves {
+		if leaf.affectedBy(changedKeys) {
+			h.hydrateLeaf(leaf, stateParameters)
+		}
+	}
+	return h.assemble(stateParameters)
+}
+
+// UpdateDiff re-hydrates the values affected by the differences between two states
+func (h *Hydrator) UpdateDiff(before, after *map[string]any) (any, error) {
+	var beforeState, afterState map[string]any
+	if before != nil {
+		beforeState = *before
+	}
+	if after != nil {
+		afterState = *after
+	}
+	return h.Update(after, ChangedKeys(beforeState, afterState)...)
+}
+
+// Dependencies returns the state keys the value at a path (e.g. "tools[0].name")
+// depends on, and whether it depends on the whole state
+func (h *Hydrator) Dependencies(path string) ([]string, bool) {
+	for _, leaf := range h.leaves {
+		if leaf.path == path {
+			return slices.Clone(leaf.deps), leaf.all
+		}
+	}
+	return nil, false
+}
+
+// AffectedPaths returns the paths of the templated values a change to the keys would re-hydrate
+func (h *Hydrator) AffectedPaths(changedKeys ...string) []string {
+	var paths []string
+	for _, leaf := range h.leaves {
+		if leaf.affectedBy(changedKeys) {
+			paths = append(paths, leaf.path)
+		}
+	}
+	return paths
+}
+
+func (h *Hydrator) hydrateLeaf(leaf *hydratorLeaf, stateParameters *map[string]any) {
+	leaf.value, leaf.err = Hydrate(leaf.raw, stateParameters, leaf.behaviour)
+}
+
+// assemble builds the result from the leaf results, returning the errors from
+// every leaf together, with missing keys merged into a single InfoNeededError
+func (h *Hydrator) assemble(stateParameters *map[string]any) (any, error) {
+	var errs errorCollector
+	for _, leaf := range h.leaves {
+		if leaf.err != nil {
+			errs.add(leaf.path, leaf.err)
+		}
+	}
+	return h.root.value(), errs.err(stateParameters, "value")
+}
+
+// value builds a fresh copy of the node's current value, so callers can't
+// modify the cached results
+func (n *hydratorNode) value() any {
+	switch {
+	case n.leaf != nil:
+		if n.leaf.value == nil && n.leaf.err != nil {
+			return n.leaf.raw
+		}
+		return n.leaf.value
+	case n.children != nil:
+		dict := make(map[string]any, len(n.children))
+		for key, child := range n.children {
+			dict[key] = child.value()
+		}
+		return dict
+	case n.items != nil:
+		list := make([]any, len(n.items))
+		for i, item := range n.items {
+			list[i] = item.value()
+		}
+		return list
+	default:
+		return n.static
+	}
+}
+
+func (l *hydratorLeaf) affectedBy(changedKeys []string) bool {
+	if l.all {
+		return len(changedKeys) > 0
+	}
+	for _, changed := range changedKeys {
+		changed = normalizeKeyPath(changed)
+		for _, dep := range l.deps {
+			if pathsOverlap(changed, dep) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathsOverlap reports whether one dotted path is the other or is under it
+func pathsOverlap(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return a == b || strings.HasPrefix(b, a+".")
+}
+
+func normalizeKeyPath(path string) string {
+	return strings.Join(strings.FieldsFunc(strings.TrimSuffix(path, "?"), func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	}), ".")
+}
+
+func isTemplated(s string) bool {
+	return strings.Contains(s, "{{") || strings.Contains(s, "{%") || strings.Contains(s, "%(")
+}
+
+// valueDependencies returns the state keys the templates in a value may read,
+// and whether they may read the whole state
+func valueDependencies(value any, behaviour *map[string]any) ([]string, bool) {
+	seen := map[string]bool{}
+	var deps []string
+	all := false
+
+	var visit func(v any, behaviour *map[string]any)
+	addString := func(s string, behaviour *map[string]any) {
+		if all {
+			return
+		}
+		stringDeps, stringAll := templateDependencies(s, behaviour)
+		if stringAll {
+			all = true
+			return
+		}
+		for _, dep := range stringDeps {
+			// Hyphens are valid in keys but also mean subtraction, so cover both readings
+			readings := []string{dep}
+			if strings.Contains(dep, "-") {
+				for _, part := range strings.Split(dep, "-") {
+					if part = normalizeKeyPath(part); part != "" {
+						readings = append(readings, part)
+					}
+				}
+			}
+			for _, reading := range readings {
+				if !seen[reading] {
+					seen[reading] = true
+					deps = append(deps, reading)
+				}
+			}
+		}
+	}
+	visit = func(v any, behaviour *map[string]any) {
+		switch tv := v.(type) {
+		case string:
+			addString(tv, behaviour)
+		case map[string]any:
+			for key, item := range tv {
+				addString(key, behaviour)
+				if shouldHydrate, childBehaviour := shouldHydrateField(key, behaviour); shouldHydrate {
+					visit(item, childBehaviour)
+				}
+			}
+		case []any:
+			for _, item := range tv {
+				visit(item, behaviour)
+			}
+		default:
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+				for i := 0; i < rv.Len(); i++ {
+					visit(rv.Index(i).Interface(), behaviour)
+				}
+			}
+		}
+	}
+	visit(value, behaviour)
+
+	if all {
+		return nil, true
+	}
+	slices.Sort(deps)
+	return deps, false
+}
+
+// templateDependencies returns the key paths a template reads: its keys, the
+// keys named by string arguments (e.g. get "messages") and the fields of
+// .Data. Jinja2 templates are compiled first. A template that passes the whole
+// state to a function (e.g. {{include "partial" .}} or {{toJSON $.Data}}) or
+// has an action that doesn't parse depends on everything, which is reported
+// along with the paths it names.
+func templateDependencies(s string, behaviour *map[string]any) ([]string, bool) {
+	if templateSyntax(behaviour) == common.TemplateSyntaxJinja {
+		compiled, err := compileJinjaCached(s)
+		if err != nil {
+			// Hydration fails the same way whatever the state is
+			return nil, false
+		}
+		s = compiled.template
+	}
+
+	var deps []string
+	addPath := func(path string) {
+		path = normalizeKeyPath(path)
+		if path != "" {
+			deps = append(deps, path)
+		}
+	}
+
+	s, _ = protectLiterals(s)
+	all := false
+	for _, seg := range splitTemplate(s) {
+		switch {
+		case seg.kind == segmentFormatKey:
+			key, _ := seg.key()
+			addPath(key.Key)
+		case seg.kind != segmentAction || strings.HasPrefix(seg.inner, "/*"):
+		case seg.action == nil:
+			all = true
+		default:
+			seg.action.pipe.walk(func(cmd *commandNode) {
+				_, isDataFunction := dataFuncMap[cmd.name()]
+				for i, arg := range cmd.args {
+					switch arg.kind {
+					case tokenWord:
+						// A word followed by arguments is a function call, e.g. print
+						_, isFunction := funcMap[arg.src]
+						if i > 0 || !(isFunction || len(cmd.args) > 1) {
+							addPath(ParseTemplateKey(arg.src).Key)
+						}
+					case tokenString:
+						if str, ok := arg.unquote(); ok && isKeyPath(str) {
+							addPath(ParseTemplateKey(str).Key)
+						}
+					case tokenField, tokenVariable:
+						field := strings.TrimPrefix(arg.src, "$")
+						switch {
+						case field == "" || field == ".":
+							all = true
+						case field == ".Data":
+							// Data functions are passed the state to read the keys they name
+							all = all || !isDataFunction || cmd.name() == "include"
+						case strings.HasPrefix(field, ".Data."):
+							addPath(strings.TrimPrefix(field, ".Data."))
+						case arg.kind == tokenField && field != ".MissingKeys" && field != ".KeyDefinitions":
+							// A field of the range element; cover it as a key too
+							addPath(strings.TrimPrefix(field, "."))
+						}
+					}
+				}
+			})
+		}
+	}
+	return deps, all
+}
+
+// isKeyPath reports whether a string literal could name a key path like
+// messages, user.name or items[0].id
+func isKeyPath(s string) bool {
+	s = strings.TrimSuffix(s, "?")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.[]", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ChangedKeys returns the paths that differ between two states. Dicts are
+// compared key by key; any other value that differs is reported at its path.
+func ChangedKeys(before, after map[string]any) []string {
+	var changed []string
+	diffKeys("", before, after, &changed)
+	slices.Sort(changed)
+	return changed
+}
+
+func diffKeys(prefix string, before, after map[string]any, changed *[]string) {
+	for key, beforeValue := range before {
+		path := joinKeyPath(prefix, key)
+		afterValue, ok := after[key]
+		if !ok {
+			*changed = append(*changed, path)
+			continue
+		}
+		beforeDict, beforeIsDict := beforeValue.(map[string]any)
+		afterDict, afterIsDict := afterValue.(map[string]any)
+		if beforeIsDict && afterIsDict {
+			diffKeys(path, beforeDict, afterDict, changed)
+			continue
+		}
+		if !reflect.DeepEqual(beforeValue, afterValue) {
+			*changed = append(*changed, path)
+		}
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			*changed = append(*changed, joinKeyPath(prefix, key))
+		}
+	}
+}
+
+func joinKeyPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
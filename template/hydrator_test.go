@@ -0,0 +1,147 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHydrator(t *testing.T) {
+	params := map[string]any{
+		"prompt":  "{{system_prompt}}",
+		"history": `{{range $m := (get "messages")}}{{$m.role}}: {{$m.content}} {{end}}`,
+		"search":  "{{steps.search.output}}",
+		"answer":  "{{steps.answer.output?}}",
+		"model":   "fixed",
+		"tools":   []any{map[string]any{"name": "{{tool_name}}"}},
+	}
+
+	state := map[string]any{
+		"system_prompt": "be brief",
+		"messages":      []any{map[string]any{"role": "user", "content": "hi"}},
+		"steps":         map[string]any{"search": map[string]any{"output": "results"}},
+		"tool_name":     "lookup",
+	}
+
+	h := NewHydrator(params, nil)
+	result, err := h.Hydrate(&state)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"prompt":  "be brief",
+		"history": "user: hi ",
+		"search":  "results",
+		"answer":  nil,
+		"model":   "fixed",
+		"tools":   []any{map[string]any{"name": "lookup"}},
+	}, result)
+
+	t.Run("dependencies", func(t *testing.T) {
+		deps, all := h.Dependencies("history")
+		assert.False(t, all)
+		assert.Contains(t, deps, "messages")
+
+		deps, _ = h.Dependencies("tools[0].name")
+		assert.Equal(t, []string{"tool_name"}, deps)
+	})
+
+	t.Run("only affected paths are re-hydrated", func(t *testing.T) {
+		assert.Equal(t, []string{"history"}, h.AffectedPaths("messages"))
+		assert.ElementsMatch(t, []string{"search", "answer"}, h.AffectedPaths("steps"))
+		assert.Equal(t, []string{"answer"}, h.AffectedPaths("steps.answer.output"))
+		assert.Empty(t, h.AffectedPaths("unrelated"))
+	})
+
+	t.Run("update reuses previous results", func(t *testing.T) {
+		next := map[string]any{
+			"system_prompt": "changed, but not reported",
+			"messages": []any{
+				map[string]any{"role": "user", "content": "hi"},
+				map[string]any{"role": "assistant", "content": "hello"},
+			},
+			"steps":     state["steps"],
+			"tool_name": "lookup",
+		}
+
+		result, err := h.Update(&next, "messages")
+		require.NoError(t, err)
+		dict := result.(map[string]any)
+		assert.Equal(t, "user: hi assistant: hello ", dict["history"])
+		assert.Equal(t, "be brief", dict["prompt"])
+	})
+
+	t.Run("update from a state diff", func(t *testing.T) {
+		before := map[string]any{
+			"system_prompt": "be brief",
+			"messages":      state["messages"],
+			"steps":         map[string]any{"search": map[string]any{"output": "results"}},
+			"tool_name":     "lookup",
+		}
+		after := map[string]any{
+			"system_prompt": "be brief",
+			"messages":      state["messages"],
+			"steps": map[string]any{
+				"search": map[string]any{"output": "results"},
+				"answer": map[string]any{"output": "42"},
+			},
+			"tool_name": "lookup",
+		}
+		assert.Equal(t, []string{"steps.answer"}, ChangedKeys(before, after))
+
+		result, err := h.UpdateDiff(&before, &after)
+		require.NoError(t, err)
+		assert.Equal(t, "42", result.(map[string]any)["answer"])
+	})
+
+	t.Run("missing keys are reported until provided", func(t *testing.T) {
+		h := NewHydrator(map[string]any{"a": "{{a}}", "b": "{{b}}"}, nil)
+		partial := map[string]any{"a": "x"}
+
+		result, err := h.Hydrate(&partial)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Equal(t, []string{"b"}, infoErr.MissingKeys)
+		assert.Equal(t, "x", result.(map[string]any)["a"])
+
+		// An unrelated change still reports the missing key
+		_, err = h.Update(&partial, "c")
+		require.True(t, errors.As(err, &infoErr))
+
+		complete := map[string]any{"a": "x", "b": "y"}
+		result, err = h.Update(&complete, "b")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"a": "x", "b": "y"}, result)
+	})
+
+	t.Run("dependencies come from the parsed template", func(t *testing.T) {
+		params := map[string]any{
+			"literal": `{{print "%(count)d {{other}}"}} %(score).2f`,
+			"data":    `{{get "user.name" .Data}} {{.Data.messages}}`,
+			"jinja":   "{% for m in messages %}{{ m.role | upper }}{% endfor %}",
+		}
+		behaviour := map[string]any{"jinja": common.ParameterHydrationBehaviourJinja}
+		h := NewHydrator(params, &behaviour)
+
+		deps, all := h.Dependencies("literal")
+		assert.False(t, all)
+		assert.Equal(t, []string{"score"}, deps)
+
+		deps, all = h.Dependencies("data")
+		assert.False(t, all)
+		assert.ElementsMatch(t, []string{"messages", "user.name"}, deps)
+
+		deps, all = h.Dependencies("jinja")
+		assert.False(t, all)
+		assert.Contains(t, deps, "messages")
+		assert.NotContains(t, deps, "m")
+	})
+
+	t.Run("templates passing the whole state depend on everything", func(t *testing.T) {
+		h := NewHydrator(map[string]any{"p": `{{include "greeting" .}}`, "q": "{{q}}"}, nil)
+		_, all := h.Dependencies("p")
+		assert.True(t, all)
+		assert.Equal(t, []string{"p"}, h.AffectedPaths("anything"))
+	})
+}
@@ -0,0 +1,1397 @@
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Jinja2 front end. A practical subset of Jinja2 is compiled into the erdo
+// template dialect, so it runs on the same evaluator and function registry and
+// reports missing keys the same way:
+//
+//   - output: {{ user.name }}, {{ items[0]['id'] }}, {{ "a" ~ b }}, {{ len(items) }}
+//   - filters: {{ name | upper }}, {{ tags | join(", ") }}, {{ x | default("n/a") }}
+//   - tests: {% if x is defined %}, {% if x is none %}
+//   - statements: if/elif/else/endif, for/else/endfor, set
+//   - loop variables: loop.index, loop.index0, loop.first, loop.last, loop.length,
+//     loop.revindex, loop.revindex0
+//   - whitespace control with {%- -%}, {{- -}} and {#- -#}, and {# comments #}
+//   - {% raw %}...{% endraw %} blocks, whose content is output as-is
+//   - operators: + and - on numbers, ~, comparisons, in, not in, and, or, not
+//   - literals: strings, numbers, true/false/none, [lists] and {dicts}
+//   - functions: any template function, e.g. {{ get("user.name") }}
+//
+// Iterating a mapping directly yields its values; use .items() to get key/value pairs.
+//
+// Anything else, such as *, /, %, range(), inline x if c else y, macros and
+// other tests or statements, fails to compile with an *UnsupportedJinjaError
+// naming the construct.
+
+// jinjaFilters maps Jinja filter names to template functions. Filters are
+// called with the filtered value as the first argument.
+var jinjaFilters = map[string]string{
+	"upper":    "upper",
+	"lower":    "lower",
+	"title":    "title",
+	"trim":     "trim",
+	"replace":  "replace",
+	"join":     "join",
+	"first":    "first",
+	"last":     "last",
+	"default":  "default",
+	"d":        "default",
+	"length":   "len",
+	"count":    "len",
+	"tojson":   "toJSON",
+	"string":   "toString",
+	"int":      "toInt",
+	"float":    "toFloat",
+	"truncate": "truncateString",
+}
+
+// jinjaGlobals are Jinja's global functions, none of which are supported
+var jinjaGlobals = map[string]bool{"range": true, "lipsum": true, "cycler": true, "joiner": true, "namespace": true}
+
+// jinjaFilterDefaults holds the arguments used when a filter is called without any
+var jinjaFilterDefaults = map[string][]string{
+	"join":     {`""`},
+	"default":  {`""`},
+	"d":        {`""`},
+	"truncate": {"255"},
+}
+
+// maxCachedJinja bounds the number of compiled templates kept in jinjaCache
+const maxCachedJinja = 1024
+
+// jinjaCache holds compiled templates by source. When it's full an arbitrary
+// entry is evicted, so templates built from user input can't grow it forever.
+var jinjaCache = struct {
+	sync.Mutex
+	entries map[string]*compiledJinja
+}{entries: map[string]*compiledJinja{}}
+
+type compiledJinja struct {
+	template string
+	keys     []Key
+}
+
+// CompileJinja compiles a Jinja2 template into the erdo template dialect
+func CompileJinja(src string) (string, error) {
+	compiled, err := compileJinjaCached(src)
+	if err != nil {
+		return "", err
+	}
+	return compiled.template, nil
+}
+
+func compileJinjaCached(src string) (*compiledJinja, error) {
+	jinjaCache.Lock()
+	cached, ok := jinjaCache.entries[src]
+	jinjaCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	c := &jinjaCompiler{}
+	tmpl, err := c.compile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &compiledJinja{template: tmpl, keys: c.keys}
+	jinjaCache.Lock()
+	defer jinjaCache.Unlock()
+	if len(jinjaCache.entries) >= maxCachedJinja {
+		for k := range jinjaCache.entries {
+			delete(jinjaCache.entries, k)
+			break
+		}
+	}
+	jinjaCache.entries[src] = compiled
+	return compiled, nil
+}
+
+// hydrateJinjaString compiles a Jinja2 template and hydrates the result
+func hydrateJinjaString(src string, data *map[string]any, opts stringOptions) (any, error) {
+	compiled, err := compileJinjaCached(src)
+	if err != nil {
+		return nil, err
+	}
+	return hydrateString(compiled.template, data, opts)
+}
+
+// findJinjaTemplateKeys returns the data keys referenced by a Jinja2 template.
+// Templates that don't compile have no keys; the error surfaces on hydration.
+func findJinjaTemplateKeys(src string) []Key {
+	compiled, err := compileJinjaCached(src)
+	if err != nil {
+		return []Key{}
+	}
+	return append([]Key{}, compiled.keys...)
+}
+
+// Segments
+// ========
+
+type jinjaSegmentKind int
+
+const (
+	jinjaText jinjaSegmentKind = iota
+	jinjaOutput
+	jinjaStatement
+	jinjaComment
+	// jinjaRaw is the content of a {% raw %} block
+	jinjaRaw
+)
+
+type jinjaSegment struct {
+	kind      jinjaSegmentKind
+	content   string
+	trimLeft  bool
+	trimRight bool
+	line      int
+}
+
+// splitJinja splits a template into text, output, statement and comment
+// segments and applies whitespace control
+func splitJinja(src string) ([]jinjaSegment, error) {
+	var segments []jinjaSegment
+	line := 1
+	pos := 0
+
+	for pos < len(src) {
+		start := nextJinjaTag(src, pos)
+		if start < 0 {
+			segments = append(segments, jinjaSegment{kind: jinjaText, content: src[pos:], line: line})
+			break
+		}
+		if start > pos {
+			segments = append(segments, jinjaSegment{kind: jinjaText, content: src[pos:start], line: line})
+			line += strings.Count(src[pos:start], "\n")
+		}
+
+		var kind jinjaSegmentKind
+		var closing string
+		switch src[start+1] {
+		case '{':
+			kind, closing = jinjaOutput, "}}"
+		case '%':
+			kind, closing = jinjaStatement, "%}"
+		default:
+			kind, closing = jinjaComment, "#}"
+		}
+
+		var end int
+		if kind == jinjaComment {
+			end = strings.Index(src[start+2:], closing)
+			if end >= 0 {
+				end += start + 2
+			}
+		} else {
+			end = findJinjaTagEnd(src, start+2, closing)
+		}
+		if end < 0 {
+			return nil, jinjaError(line, "unclosed tag %q", src[start:start+2])
+		}
+
+		content := src[start+2 : end]
+		seg := jinjaSegment{kind: kind, line: line}
+		if strings.HasPrefix(content, "-") {
+			seg.trimLeft = true
+			content = content[1:]
+		}
+		if strings.HasSuffix(content, "-") {
+			seg.trimRight = true
+			content = content[:len(content)-1]
+		}
+		seg.content = strings.TrimSpace(content)
+		line += strings.Count(src[start:end+2], "\n")
+		pos = end + 2
+
+		if kind == jinjaStatement && seg.content == "raw" {
+			raw, next, err := readJinjaRaw(src, pos, seg, line)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, raw)
+			line += strings.Count(src[pos:next], "\n")
+			pos = next
+			continue
+		}
+		segments = append(segments, seg)
+	}
+
+	// Whitespace control trims the text on either side of a tag
+	for i, seg := range segments {
+		if seg.kind == jinjaText {
+			continue
+		}
+		if seg.trimLeft && i > 0 && segments[i-1].kind == jinjaText {
+			segments[i-1].content = strings.TrimRightFunc(segments[i-1].content, unicode.IsSpace)
+		}
+		if seg.trimRight && i+1 < len(segments) && segments[i+1].kind == jinjaText {
+			segments[i+1].content = strings.TrimLeftFunc(segments[i+1].content, unicode.IsSpace)
+		}
+	}
+
+	return segments, nil
+}
+
+// readJinjaRaw reads the content of a raw block up to its endraw tag, applying
+// the whitespace control of both tags, and returns the position after endraw
+func readJinjaRaw(src string, pos int, open jinjaSegment, line int) (jinjaSegment, int, error) {
+	for i := pos; ; {
+		start := strings.Index(src[i:], "{%")
+		if start < 0 {
+			return jinjaSegment{}, 0, jinjaError(line, "unclosed \"raw\" block")
+		}
+		start += i
+		end := strings.Index(src[start+2:], "%}")
+		if end < 0 {
+			return jinjaSegment{}, 0, jinjaError(line, "unclosed \"raw\" block")
+		}
+		end += start + 2
+
+		tag := src[start+2 : end]
+		if strings.TrimSpace(strings.Trim(tag, "-")) != "endraw" {
+			i = start + 2
+			continue
+		}
+
+		content := src[pos:start]
+		if open.trimRight {
+			content = strings.TrimLeftFunc(content, unicode.IsSpace)
+		}
+		if strings.HasPrefix(tag, "-") {
+			content = strings.TrimRightFunc(content, unicode.IsSpace)
+		}
+		raw := jinjaSegment{
+			kind:      jinjaRaw,
+			content:   content,
+			trimLeft:  open.trimLeft,
+			trimRight: strings.HasSuffix(tag, "-"),
+			line:      open.line,
+		}
+		return raw, end + 2, nil
+	}
+}
+
+func nextJinjaTag(src string, pos int) int {
+	for i := pos; i+1 < len(src); i++ {
+		if src[i] == '{' && (src[i+1] == '{' || src[i+1] == '%' || src[i+1] == '#') {
+			return i
+		}
+	}
+	return -1
+}
+
+// findJinjaTagEnd finds the closing delimiter of a tag, skipping quoted strings
+func findJinjaTagEnd(src string, pos int, closing string) int {
+	var quote byte
+	for i := pos; i < len(src); i++ {
+		ch := src[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		if ch == '"' || ch == '\'' {
+			quote = ch
+			continue
+		}
+		if strings.HasPrefix(src[i:], closing) {
+			return i
+		}
+	}
+	return -1
+}
+
+func jinjaError(line int, format string, args ...any) error {
+	return fmt.Errorf("jinja syntax error at line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func jinjaUnsupported(line int, format string, args ...any) error {
+	return &UnsupportedJinjaError{Line: line, Construct: fmt.Sprintf(format, args...)}
+}
+
+// Expression tokens
+// =================
+
+type jinjaTokenKind int
+
+const (
+	jinjaTokenEOF jinjaTokenKind = iota
+	jinjaTokenName
+	jinjaTokenNumber
+	jinjaTokenString
+	jinjaTokenOp
+)
+
+type jinjaToken struct {
+	kind  jinjaTokenKind
+	value string
+}
+
+var jinjaOperators = []string{"==", "!=", "<=", ">=", "<", ">", "+", "-", "~", "|", "(", ")", "[", "]", "{", "}", ",", ".", ":", "=", "*", "/", "%"}
+
+func tokenizeJinja(s string, line int) ([]jinjaToken, error) {
+	var tokens []jinjaToken
+	i := 0
+	for i < len(s) {
+		ch := s[i]
+		switch {
+		case unicode.IsSpace(rune(ch)):
+			i++
+		case ch == '"' || ch == '\'':
+			var b strings.Builder
+			j := i + 1
+			closed := false
+			for j < len(s) {
+				if s[j] == '\\' && j+1 < len(s) {
+					switch s[j+1] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case 'r':
+						b.WriteByte('\r')
+					default:
+						b.WriteByte(s[j+1])
+					}
+					j += 2
+					continue
+				}
+				if s[j] == ch {
+					closed = true
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			if !closed {
+				return nil, jinjaError(line, "unterminated string")
+			}
+			tokens = append(tokens, jinjaToken{kind: jinjaTokenString, value: b.String()})
+			i = j + 1
+		case ch >= '0' && ch <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' && j+1 < len(s) && s[j+1] >= '0' && s[j+1] <= '9') {
+				j++
+			}
+			tokens = append(tokens, jinjaToken{kind: jinjaTokenNumber, value: s[i:j]})
+			i = j
+		case ch == '_' || unicode.IsLetter(rune(ch)):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, jinjaToken{kind: jinjaTokenName, value: s[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, op := range jinjaOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, jinjaToken{kind: jinjaTokenOp, value: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, jinjaError(line, "unexpected character %q", ch)
+			}
+		}
+	}
+	return append(tokens, jinjaToken{kind: jinjaTokenEOF}), nil
+}
+
+// Compiler
+// ========
+
+// jinjaFrame is a variable scope: the template itself or a for loop body
+type jinjaFrame struct {
+	vars     map[string]string
+	declared []string
+	loop     *jinjaLoop
+}
+
+type jinjaLoop struct {
+	seqVar   string
+	indexVar string
+	isItems  bool
+}
+
+type jinjaBlock struct {
+	kind     string
+	line     int
+	seenElse bool
+
+	// for loops only
+	header   string
+	frame    *jinjaFrame
+	body     *strings.Builder
+	elseBody *strings.Builder
+}
+
+type jinjaCompiler struct {
+	frames  []*jinjaFrame
+	blocks  []*jinjaBlock
+	writers []*strings.Builder
+	counter int
+	keys    []Key
+
+	// expression state
+	tokens   []jinjaToken
+	pos      int
+	line     int
+	optional bool
+}
+
+func (c *jinjaCompiler) compile(src string) (string, error) {
+	segments, err := splitJinja(src)
+	if err != nil {
+		return "", err
+	}
+
+	root := &jinjaFrame{vars: map[string]string{}}
+	body := &strings.Builder{}
+	c.frames = []*jinjaFrame{root}
+	c.writers = []*strings.Builder{body}
+
+	// A template that is a single output expression keeps the value's type
+	if expr, ok := singleJinjaOutput(segments); ok {
+		compiled, bare, err := c.compileOutput(expr.content, expr.line)
+		if err != nil {
+			return "", err
+		}
+		if bare != "" {
+			return "{{" + bare + "}}", nil
+		}
+		return "{{" + strings.TrimSuffix(strings.TrimPrefix(compiled, "("), ")") + "}}", nil
+	}
+
+	for _, seg := range segments {
+		switch seg.kind {
+		case jinjaText:
+			c.writer().WriteString(escapeErdoText(seg.content))
+		case jinjaRaw:
+			if seg.content != "" {
+				c.writer().WriteString(rawOpen + seg.content + rawClose)
+			}
+		case jinjaComment:
+		case jinjaOutput:
+			compiled, _, err := c.compileOutput(seg.content, seg.line)
+			if err != nil {
+				return "", err
+			}
+			c.writer().WriteString("{{nilToEmptyString " + compiled + "}}")
+		case jinjaStatement:
+			if err := c.compileStatement(seg.content, seg.line); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if len(c.blocks) > 0 {
+		block := c.blocks[len(c.blocks)-1]
+		return "", jinjaError(block.line, "unclosed %q block", block.kind)
+	}
+
+	return declarations(root) + body.String(), nil
+}
+
+func singleJinjaOutput(segments []jinjaSegment) (jinjaSegment, bool) {
+	var output *jinjaSegment
+	for i := range segments {
+		switch segments[i].kind {
+		case jinjaText:
+			if segments[i].content != "" {
+				return jinjaSegment{}, false
+			}
+		case jinjaOutput:
+			if output != nil {
+				return jinjaSegment{}, false
+			}
+			output = &segments[i]
+		case jinjaStatement, jinjaRaw:
+			return jinjaSegment{}, false
+		}
+	}
+	if output == nil {
+		return jinjaSegment{}, false
+	}
+	return *output, true
+}
+
+// escapeErdoText escapes Jinja text that the erdo dialect would read as a format key
+func escapeErdoText(s string) string {
+	return strings.ReplaceAll(s, "%(", "%%(")
+}
+
+func declarations(frame *jinjaFrame) string {
+	var b strings.Builder
+	for _, v := range frame.declared {
+		b.WriteString("{{" + v + ` := ""}}`)
+	}
+	return b.String()
+}
+
+func (c *jinjaCompiler) writer() *strings.Builder {
+	return c.writers[len(c.writers)-1]
+}
+
+func (c *jinjaCompiler) frame() *jinjaFrame {
+	return c.frames[len(c.frames)-1]
+}
+
+func (c *jinjaCompiler) nextID() int {
+	c.counter++
+	return c.counter
+}
+
+// compileOutput compiles the expression of an output tag. If the expression
+// is a bare data path, bare holds it so a whole-string template can use the
+// erdo variable syntax and keep the value's type.
+func (c *jinjaCompiler) compileOutput(content string, line int) (compiled string, bare string, err error) {
+	if err := c.startExpression(content, line); err != nil {
+		return "", "", err
+	}
+	if path, ok := c.bareDataPath(); ok {
+		c.keys = append(c.keys, Key{Key: path})
+		return fmt.Sprintf(`(get %s $.Data $.MissingKeys)`, quoteJinjaString(path)), path, nil
+	}
+
+	compiled, err = c.parseExpression()
+	if err != nil {
+		return "", "", err
+	}
+	if err := c.expectEnd(); err != nil {
+		return "", "", err
+	}
+	return compiled, "", nil
+}
+
+// bareDataPath reports whether the current expression is a plain dotted path into the data
+func (c *jinjaCompiler) bareDataPath() (string, bool) {
+	if len(c.tokens) == 0 || c.tokens[0].kind != jinjaTokenName || c.resolveLocal(c.tokens[0].value) != "" || isJinjaKeyword(c.tokens[0].value) {
+		return "", false
+	}
+	if c.tokens[0].value == "loop" && c.currentLoop() != nil {
+		return "", false
+	}
+	parts := []string{c.tokens[0].value}
+	i := 1
+	for ; i+1 < len(c.tokens) && c.tokens[i].value == "." && c.tokens[i+1].kind == jinjaTokenName; i += 2 {
+		parts = append(parts, c.tokens[i+1].value)
+	}
+	if c.tokens[i].kind != jinjaTokenEOF {
+		return "", false
+	}
+	return strings.Join(parts, "."), true
+}
+
+func (c *jinjaCompiler) compileStatement(content string, line int) error {
+	if err := c.startExpression(content, line); err != nil {
+		return err
+	}
+	keyword := c.next()
+	if keyword.kind != jinjaTokenName {
+		return jinjaError(line, "expected a statement, got %q", keyword.value)
+	}
+
+	switch keyword.value {
+	case "if":
+		cond, err := c.parseCondition()
+		if err != nil {
+			return err
+		}
+		c.blocks = append(c.blocks, &jinjaBlock{kind: "if", line: line})
+		c.writer().WriteString("{{if " + cond + "}}")
+
+	case "elif":
+		block, err := c.currentBlock("if", "elif", line)
+		if err != nil {
+			return err
+		}
+		if block.seenElse {
+			return jinjaError(line, "elif after else")
+		}
+		cond, err := c.parseCondition()
+		if err != nil {
+			return err
+		}
+		c.writer().WriteString("{{else if " + cond + "}}")
+
+	case "else":
+		if len(c.blocks) == 0 {
+			return jinjaError(line, "else outside of an if or for block")
+		}
+		block := c.blocks[len(c.blocks)-1]
+		if block.seenElse {
+			return jinjaError(line, "duplicate else")
+		}
+		block.seenElse = true
+		if err := c.expectEnd(); err != nil {
+			return err
+		}
+		if block.kind == "for" {
+			// The else branch runs outside of the loop scope
+			c.writers[len(c.writers)-1] = block.elseBody
+			c.frames = c.frames[:len(c.frames)-1]
+		} else {
+			c.writer().WriteString("{{else}}")
+		}
+
+	case "endif":
+		if _, err := c.currentBlock("if", "endif", line); err != nil {
+			return err
+		}
+		if err := c.expectEnd(); err != nil {
+			return err
+		}
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		c.writer().WriteString("{{end}}")
+
+	case "for":
+		return c.compileFor(line)
+
+	case "endfor":
+		block, err := c.currentBlock("for", "endfor", line)
+		if err != nil {
+			return err
+		}
+		if err := c.expectEnd(); err != nil {
+			return err
+		}
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		c.writers = c.writers[:len(c.writers)-1]
+		if !block.seenElse {
+			c.frames = c.frames[:len(c.frames)-1]
+		}
+
+		out := c.writer()
+		out.WriteString(block.header)
+		out.WriteString(declarations(block.frame))
+		out.WriteString(block.body.String())
+		if block.seenElse {
+			out.WriteString("{{else}}")
+			out.WriteString(block.elseBody.String())
+		}
+		out.WriteString("{{end}}")
+
+	case "set":
+		name := c.next()
+		if name.kind != jinjaTokenName || isJinjaKeyword(name.value) {
+			return jinjaError(line, "expected a variable name after set")
+		}
+		if c.next().value != "=" {
+			return jinjaError(line, "expected = after set %s", name.value)
+		}
+		value, err := c.parseExpression()
+		if err != nil {
+			return err
+		}
+		if err := c.expectEnd(); err != nil {
+			return err
+		}
+
+		frame := c.frame()
+		goVar, ok := frame.vars[name.value]
+		if !ok {
+			goVar = "$" + name.value
+			frame.vars[name.value] = goVar
+			frame.declared = append(frame.declared, goVar)
+		}
+		c.writer().WriteString("{{" + goVar + " = " + value + "}}")
+
+	default:
+		return jinjaUnsupported(line, "statement %q", keyword.value)
+	}
+
+	return nil
+}
+
+func (c *jinjaCompiler) compileFor(line int) error {
+	var targets []string
+	for {
+		target := c.next()
+		if target.kind != jinjaTokenName || isJinjaKeyword(target.value) {
+			return jinjaError(line, "expected a loop variable")
+		}
+		targets = append(targets, target.value)
+		if c.peek().value != "," {
+			break
+		}
+		c.next()
+	}
+	if len(targets) > 2 {
+		return jinjaError(line, "at most two loop variables are supported")
+	}
+	if in := c.next(); in.value != "in" {
+		return jinjaError(line, "expected in after loop variables")
+	}
+
+	iter, err := c.parseFilterExpression()
+	if err != nil {
+		return err
+	}
+
+	// Iterating mapping.items() yields key/value pairs
+	isItems := false
+	if c.peek().value == "." && c.peekAt(1).value == "items" {
+		c.next()
+		c.next()
+		if c.next().value != "(" || c.next().value != ")" {
+			return jinjaError(line, "expected items()")
+		}
+		isItems = true
+	}
+	if err := c.expectEnd(); err != nil {
+		return err
+	}
+
+	if len(targets) == 2 && !isItems {
+		return jinjaError(line, "two loop variables require iterating over .items()")
+	}
+
+	id := c.nextID()
+	loop := &jinjaLoop{seqVar: fmt.Sprintf("$__seq%d", id), indexVar: fmt.Sprintf("$__idx%d", id), isItems: isItems}
+	frame := &jinjaFrame{vars: map[string]string{}, loop: loop}
+
+	var header string
+	if isItems {
+		keyVar, valueVar := "$"+targets[0], "$"+targets[1]
+		frame.vars[targets[0]] = keyVar
+		frame.vars[targets[1]] = valueVar
+		header = fmt.Sprintf("{{%s := %s}}{{range %s, %s := %s}}", loop.seqVar, iter, keyVar, valueVar, loop.seqVar)
+	} else {
+		valueVar := "$" + targets[0]
+		frame.vars[targets[0]] = valueVar
+		header = fmt.Sprintf("{{%s := %s}}{{range %s, %s := %s}}", loop.seqVar, iter, loop.indexVar, valueVar, loop.seqVar)
+	}
+
+	block := &jinjaBlock{
+		kind:     "for",
+		line:     line,
+		header:   header,
+		frame:    frame,
+		body:     &strings.Builder{},
+		elseBody: &strings.Builder{},
+	}
+	c.blocks = append(c.blocks, block)
+	c.frames = append(c.frames, frame)
+	c.writers = append(c.writers, block.body)
+	return nil
+}
+
+func (c *jinjaCompiler) currentBlock(kind string, tag string, line int) (*jinjaBlock, error) {
+	if len(c.blocks) == 0 || c.blocks[len(c.blocks)-1].kind != kind {
+		return nil, jinjaError(line, "unexpected %s", tag)
+	}
+	return c.blocks[len(c.blocks)-1], nil
+}
+
+// Expressions
+// ===========
+
+func (c *jinjaCompiler) startExpression(content string, line int) error {
+	tokens, err := tokenizeJinja(content, line)
+	if err != nil {
+		return err
+	}
+	c.tokens = tokens
+	c.pos = 0
+	c.line = line
+	c.optional = false
+	return nil
+}
+
+func (c *jinjaCompiler) peek() jinjaToken {
+	return c.peekAt(0)
+}
+
+func (c *jinjaCompiler) peekAt(offset int) jinjaToken {
+	if c.pos+offset >= len(c.tokens) {
+		return jinjaToken{kind: jinjaTokenEOF}
+	}
+	return c.tokens[c.pos+offset]
+}
+
+func (c *jinjaCompiler) next() jinjaToken {
+	tok := c.peek()
+	if c.pos < len(c.tokens) {
+		c.pos++
+	}
+	return tok
+}
+
+func (c *jinjaCompiler) expectEnd() error {
+	if tok := c.peek(); tok.kind != jinjaTokenEOF {
+		return jinjaError(c.line, "unexpected %q", tok.value)
+	}
+	return nil
+}
+
+func (c *jinjaCompiler) expect(value string) error {
+	if tok := c.next(); tok.value != value || tok.kind == jinjaTokenString {
+		return jinjaError(c.line, "expected %q, got %q", value, tok.value)
+	}
+	return nil
+}
+
+// parseCondition parses the expression of an if/elif. Data lookups in
+// conditions are optional, so testing for a missing key is falsy rather
+// than an info needed error.
+func (c *jinjaCompiler) parseCondition() (string, error) {
+	c.optional = true
+	defer func() { c.optional = false }()
+
+	cond, err := c.parseExpression()
+	if err != nil {
+		return "", err
+	}
+	return cond, c.expectEnd()
+}
+
+func (c *jinjaCompiler) parseExpression() (string, error) {
+	expr, err := c.parseOr()
+	if err != nil {
+		return "", err
+	}
+	if tok := c.peek(); tok.kind == jinjaTokenName && tok.value == "if" {
+		return "", jinjaUnsupported(c.line, "inline if expression (x if c else y)")
+	}
+	return expr, nil
+}
+
+func (c *jinjaCompiler) parseOr() (string, error) {
+	left, err := c.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for c.peek().kind == jinjaTokenName && c.peek().value == "or" {
+		c.next()
+		right, err := c.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = "(or " + left + " " + right + ")"
+	}
+	return left, nil
+}
+
+func (c *jinjaCompiler) parseAnd() (string, error) {
+	left, err := c.parseNot()
+	if err != nil {
+		return "", err
+	}
+	for c.peek().kind == jinjaTokenName && c.peek().value == "and" {
+		c.next()
+		right, err := c.parseNot()
+		if err != nil {
+			return "", err
+		}
+		left = "(and " + left + " " + right + ")"
+	}
+	return left, nil
+}
+
+func (c *jinjaCompiler) parseNot() (string, error) {
+	if c.peek().kind == jinjaTokenName && c.peek().value == "not" {
+		c.next()
+		operand, err := c.parseNot()
+		if err != nil {
+			return "", err
+		}
+		return "(not " + operand + ")", nil
+	}
+	return c.parseComparison()
+}
+
+func (c *jinjaCompiler) parseComparison() (string, error) {
+	left, err := c.parseConcat()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		tok := c.peek()
+		switch {
+		case tok.kind == jinjaTokenOp && (tok.value == "==" || tok.value == "!=" || tok.value == "<" || tok.value == ">" || tok.value == "<=" || tok.value == ">="):
+			c.next()
+			right, err := c.parseConcat()
+			if err != nil {
+				return "", err
+			}
+			left = fmt.Sprintf("(compare %q %s %s)", tok.value, left, right)
+
+		case tok.kind == jinjaTokenName && tok.value == "in":
+			c.next()
+			right, err := c.parseConcat()
+			if err != nil {
+				return "", err
+			}
+			left = "(has " + left + " " + right + ")"
+
+		case tok.kind == jinjaTokenName && tok.value == "not" && c.peekAt(1).value == "in":
+			c.next()
+			c.next()
+			right, err := c.parseConcat()
+			if err != nil {
+				return "", err
+			}
+			left = "(not (has " + left + " " + right + "))"
+
+		case tok.kind == jinjaTokenName && tok.value == "is":
+			c.next()
+			negate := false
+			if c.peek().value == "not" {
+				c.next()
+				negate = true
+			}
+			test := c.next()
+			isNil := `(eq (printf "%T" ` + left + `) "<nil>")`
+			var result string
+			switch test.value {
+			case "defined":
+				result = "(not " + isNil + ")"
+			case "undefined", "none":
+				result = isNil
+			default:
+				return "", jinjaUnsupported(c.line, "test %q", test.value)
+			}
+			if negate {
+				result = "(not " + result + ")"
+			}
+			left = result
+
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (c *jinjaCompiler) parseConcat() (string, error) {
+	left, err := c.parseAdditive()
+	if err != nil {
+		return "", err
+	}
+	for c.peek().kind == jinjaTokenOp && c.peek().value == "~" {
+		c.next()
+		right, err := c.parseAdditive()
+		if err != nil {
+			return "", err
+		}
+		left = "(print (toString " + left + ") (toString " + right + "))"
+	}
+	return left, nil
+}
+
+func (c *jinjaCompiler) parseAdditive() (string, error) {
+	left, err := c.parseMultiplicative()
+	if err != nil {
+		return "", err
+	}
+	for c.peek().kind == jinjaTokenOp && (c.peek().value == "+" || c.peek().value == "-") {
+		op := c.next().value
+		right, err := c.parseMultiplicative()
+		if err != nil {
+			return "", err
+		}
+		if op == "+" {
+			left = "(add " + left + " " + right + ")"
+		} else {
+			left = "(sub " + left + " " + right + ")"
+		}
+	}
+	return left, nil
+}
+
+// parseMultiplicative parses an operand and rejects the multiplicative
+// operators, which the template dialect has no functions for
+func (c *jinjaCompiler) parseMultiplicative() (string, error) {
+	operand, err := c.parseUnary()
+	if err != nil {
+		return "", err
+	}
+	if tok := c.peek(); tok.kind == jinjaTokenOp && (tok.value == "*" || tok.value == "/" || tok.value == "%") {
+		op := tok.value
+		if next := c.peekAt(1); next.kind == jinjaTokenOp && next.value == tok.value && op != "%" {
+			op += op
+		}
+		return "", jinjaUnsupported(c.line, "operator %q", op)
+	}
+	return operand, nil
+}
+
+func (c *jinjaCompiler) parseUnary() (string, error) {
+	if c.peek().kind == jinjaTokenOp && c.peek().value == "-" && c.peekAt(1).kind == jinjaTokenNumber {
+		c.next()
+		return "-" + c.next().value, nil
+	}
+	return c.parseFilterExpression()
+}
+
+// parseFilterExpression parses a postfix expression followed by any filters
+func (c *jinjaCompiler) parseFilterExpression() (string, error) {
+	// A default filter makes the filtered lookup optional
+	if c.hasDefaultFilter() {
+		wasOptional := c.optional
+		c.optional = true
+		defer func() { c.optional = wasOptional }()
+	}
+
+	value, err := c.parsePostfix()
+	if err != nil {
+		return "", err
+	}
+
+	for c.peek().kind == jinjaTokenOp && c.peek().value == "|" {
+		c.next()
+		name := c.next()
+		if name.kind != jinjaTokenName {
+			return "", jinjaError(c.line, "expected a filter name")
+		}
+
+		fn, ok := jinjaFilters[name.value]
+		if !ok {
+			if _, isBasic := basicFuncMap[name.value]; !isBasic {
+				return "", jinjaError(c.line, "unknown filter %q", name.value)
+			}
+			fn = name.value
+		}
+
+		var args []string
+		if c.peek().value == "(" && c.peek().kind == jinjaTokenOp {
+			c.next()
+			args, err = c.parseArguments()
+			if err != nil {
+				return "", err
+			}
+		}
+		if len(args) == 0 {
+			args = jinjaFilterDefaults[name.value]
+		}
+
+		value = "(" + strings.Join(append([]string{fn, value}, args...), " ") + ")"
+	}
+
+	return value, nil
+}
+
+// hasDefaultFilter looks ahead (without consuming) for a default filter on the current operand
+func (c *jinjaCompiler) hasDefaultFilter() bool {
+	depth := 0
+	for i := c.pos; i < len(c.tokens); i++ {
+		tok := c.tokens[i]
+		if tok.kind == jinjaTokenOp {
+			switch tok.value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 {
+					return false
+				}
+				depth--
+			case "|":
+				if depth == 0 && i+1 < len(c.tokens) && (c.tokens[i+1].value == "default" || c.tokens[i+1].value == "d") {
+					return true
+				}
+			case ",", "==", "!=", "<", ">", "<=", ">=", "~", "+", "-":
+				if depth == 0 {
+					return false
+				}
+			}
+		}
+		if tok.kind == jinjaTokenName && depth == 0 && (tok.value == "and" || tok.value == "or" || tok.value == "in" || tok.value == "is") {
+			return false
+		}
+	}
+	return false
+}
+
+func (c *jinjaCompiler) parseArguments() ([]string, error) {
+	var args []string
+	if c.peek().value == ")" {
+		c.next()
+		return args, nil
+	}
+	for {
+		arg, err := c.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		tok := c.next()
+		if tok.value == ")" {
+			return args, nil
+		}
+		if tok.value != "," {
+			return nil, jinjaError(c.line, "expected , or ) in arguments, got %q", tok.value)
+		}
+	}
+}
+
+// parsePostfix parses a primary expression with attribute access, indexing and calls
+func (c *jinjaCompiler) parsePostfix() (string, error) {
+	tok := c.peek()
+
+	// Names are resolved against locals, loop variables, functions and finally the data
+	if tok.kind == jinjaTokenName && !isJinjaKeyword(tok.value) {
+		c.next()
+
+		if tok.value == "loop" && c.currentLoop() != nil && c.peek().value == "." {
+			return c.parseLoopAttribute()
+		}
+
+		if local := c.resolveLocal(tok.value); local != "" {
+			return c.parseAccessors(local, nil)
+		}
+
+		if c.peek().value == "(" && c.peek().kind == jinjaTokenOp {
+			if _, ok := funcMap[tok.value]; !ok {
+				if jinjaGlobals[tok.value] {
+					return "", jinjaUnsupported(c.line, "function %s()", tok.value)
+				}
+				return "", jinjaError(c.line, "unknown function %q", tok.value)
+			}
+			c.next()
+			args, err := c.parseArguments()
+			if err != nil {
+				return "", err
+			}
+			if _, isData := dataFuncMap[tok.value]; isData {
+				args = append(args, "$.Data", "$.MissingKeys")
+			}
+			return c.parseAccessors("("+strings.Join(append([]string{tok.value}, args...), " ")+")", nil)
+		}
+
+		return c.parseAccessors("", []string{tok.value})
+	}
+
+	operand, err := c.parsePrimary()
+	if err != nil {
+		return "", err
+	}
+	return c.parseAccessors(operand, nil)
+}
+
+// parseAccessors applies .attr and [index] accessors. While path is non-nil the
+// expression is still a static path into the data and is emitted as a single get.
+func (c *jinjaCompiler) parseAccessors(base string, path []string) (string, error) {
+	for {
+		tok := c.peek()
+		if tok.kind != jinjaTokenOp {
+			break
+		}
+
+		var segment string
+		dynamic := ""
+		switch tok.value {
+		case ".":
+			if c.peekAt(1).kind != jinjaTokenName && c.peekAt(1).kind != jinjaTokenNumber {
+				return "", jinjaError(c.line, "expected an attribute name after .")
+			}
+			// Leave .items() for the for loop
+			if c.peekAt(1).value == "items" && c.peekAt(2).value == "(" {
+				return c.finishAccess(base, path), nil
+			}
+			c.next()
+			segment = c.next().value
+		case "[":
+			c.next()
+			if (c.peek().kind == jinjaTokenString || c.peek().kind == jinjaTokenNumber) && c.peekAt(1).value == "]" {
+				segment = c.next().value
+			} else {
+				expr, err := c.parseExpression()
+				if err != nil {
+					return "", err
+				}
+				dynamic = expr
+			}
+			if err := c.expect("]"); err != nil {
+				return "", err
+			}
+		default:
+			return c.finishAccess(base, path), nil
+		}
+
+		if dynamic != "" {
+			base = "(index " + c.finishAccess(base, path) + " " + dynamic + ")"
+			path = nil
+			continue
+		}
+
+		if path != nil {
+			path = append(path, segment)
+			continue
+		}
+		base = fmt.Sprintf("(get %s %s $.MissingKeys)", quoteJinjaString(segment+"?"), base)
+	}
+
+	return c.finishAccess(base, path), nil
+}
+
+// finishAccess emits a pending data path lookup
+func (c *jinjaCompiler) finishAccess(base string, path []string) string {
+	if path == nil {
+		return base
+	}
+	key := strings.Join(path, ".")
+	c.keys = append(c.keys, Key{Key: key, IsOptional: c.optional})
+	if c.optional {
+		key += "?"
+	}
+	return fmt.Sprintf("(get %s $.Data $.MissingKeys)", quoteJinjaString(key))
+}
+
+func (c *jinjaCompiler) parseLoopAttribute() (string, error) {
+	loop := c.currentLoop()
+	c.next()
+	attr := c.next().value
+
+	if loop.isItems {
+		return "", jinjaError(c.line, "loop.%s is not supported when iterating over items()", attr)
+	}
+
+	var expr string
+	switch attr {
+	case "index":
+		expr = "(add " + loop.indexVar + " 1)"
+	case "index0":
+		expr = loop.indexVar
+	case "first":
+		expr = "(eq " + loop.indexVar + " 0)"
+	case "last":
+		expr = "(eq (add " + loop.indexVar + " 1) (len " + loop.seqVar + "))"
+	case "length":
+		expr = "(len " + loop.seqVar + ")"
+	case "revindex":
+		expr = "(sub (len " + loop.seqVar + ") " + loop.indexVar + ")"
+	case "revindex0":
+		expr = "(sub (sub (len " + loop.seqVar + ") " + loop.indexVar + ") 1)"
+	default:
+		return "", jinjaUnsupported(c.line, "loop attribute %q", attr)
+	}
+	return expr, nil
+}
+
+func (c *jinjaCompiler) parsePrimary() (string, error) {
+	tok := c.next()
+	switch tok.kind {
+	case jinjaTokenString:
+		return quoteJinjaString(tok.value), nil
+	case jinjaTokenNumber:
+		return tok.value, nil
+	case jinjaTokenName:
+		switch tok.value {
+		case "true", "True":
+			return "true", nil
+		case "false", "False":
+			return "false", nil
+		case "none", "None":
+			return "nil", nil
+		}
+		return "", jinjaError(c.line, "unexpected %q", tok.value)
+	case jinjaTokenOp:
+		switch tok.value {
+		case "(":
+			expr, err := c.parseExpression()
+			if err != nil {
+				return "", err
+			}
+			if err := c.expect(")"); err != nil {
+				return "", err
+			}
+			return expr, nil
+		case "[":
+			var items []string
+			for c.peek().value != "]" {
+				item, err := c.parseExpression()
+				if err != nil {
+					return "", err
+				}
+				items = append(items, item)
+				if c.peek().value == "," {
+					c.next()
+				} else if c.peek().value != "]" {
+					return "", jinjaError(c.line, "expected , or ] in list")
+				}
+			}
+			c.next()
+			return "(" + strings.Join(append([]string{"list"}, items...), " ") + ")", nil
+		case "{":
+			var items []string
+			for c.peek().value != "}" {
+				key, err := c.parseExpression()
+				if err != nil {
+					return "", err
+				}
+				if err := c.expect(":"); err != nil {
+					return "", err
+				}
+				value, err := c.parseExpression()
+				if err != nil {
+					return "", err
+				}
+				items = append(items, key, value)
+				if c.peek().value == "," {
+					c.next()
+				} else if c.peek().value != "}" {
+					return "", jinjaError(c.line, "expected , or } in dict")
+				}
+			}
+			c.next()
+			return "(" + strings.Join(append([]string{"dict"}, items...), " ") + ")", nil
+		}
+	}
+	if tok.kind == jinjaTokenEOF {
+		return "", jinjaError(c.line, "unexpected end of expression")
+	}
+	return "", jinjaError(c.line, "unexpected %q", tok.value)
+}
+
+func (c *jinjaCompiler) resolveLocal(name string) string {
+	for i := len(c.frames) - 1; i >= 0; i-- {
+		if v, ok := c.frames[i].vars[name]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c *jinjaCompiler) currentLoop() *jinjaLoop {
+	for i := len(c.frames) - 1; i >= 0; i-- {
+		if c.frames[i].loop != nil {
+			return c.frames[i].loop
+		}
+	}
+	return nil
+}
+
+func isJinjaKeyword(name string) bool {
+	switch name {
+	case "and", "or", "not", "in", "is", "if", "else", "true", "false", "none", "True", "False", "None":
+		return true
+	}
+	return false
+}
+
+// quoteJinjaString quotes a string literal for the template dialect. Quotes,
+// braces and percent signs are hex-escaped so the dialect's rewrite regexes
+// never see them.
+func quoteJinjaString(s string) string {
+	quoted := strconv.Quote(s)
+	inner := quoted[1 : len(quoted)-1]
+	inner = strings.ReplaceAll(inner, `\"`, `\x22`)
+	inner = strings.ReplaceAll(inner, "{", `\x7b`)
+	inner = strings.ReplaceAll(inner, "}", `\x7d`)
+	inner = strings.ReplaceAll(inner, "%", `\x25`)
+	return `"` + inner + `"`
+}
@@ -0,0 +1,224 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hydrateJinja(t *testing.T, tmpl string, data map[string]any) (any, error) {
+	t.Helper()
+	return Hydrate(tmpl, &data, WithTemplateSyntax(nil, common.TemplateSyntaxJinja))
+}
+
+func TestJinjaTemplates(t *testing.T) {
+	data := map[string]any{
+		"name": "ada",
+		"user": map[string]any{"name": "Ada", "roles": []any{"admin", "dev"}},
+		"messages": []any{
+			map[string]any{"role": "user", "content": "hi"},
+			map[string]any{"role": "assistant", "content": "hello"},
+		},
+		"count":  3,
+		"score":  7.5,
+		"empty":  "",
+		"config": map[string]any{"mode": "fast"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected any
+	}{
+		{"plain text", "no templates here", "no templates here"},
+		{"variable", "Hello {{ name }}!", "Hello ada!"},
+		{"nested attribute", "{{ user.name }} is here", "Ada is here"},
+		{"subscript", "{{ messages[1]['content'] }}.", "hello."},
+		{"whole expression keeps type", "{{ count }}", 3},
+		{"whole expression with filter keeps type", "{{ user.roles | first }}", "admin"},
+		{"filters", "{{ name | upper }} {{ user.roles | join(', ') }}", "ADA admin, dev"},
+		{"chained filters", "{{ name | title | replace('A', 'E') }}!", "Eda!"},
+		{"default filter", "{{ missing | default('n/a') }}.", "n/a."},
+		{"length filter", "{{ messages | length }} messages", "2 messages"},
+		{"whole expression with filter arguments", "{{ user.roles|join(', ') }}", "admin, dev"},
+		{"whole expression with default", "{{ missing | default('none') }}", "none"},
+		{"whole expression with chained filter arguments", "{{ name|replace('a','b')|title }}", "Bdb"},
+		{"concat", `{{ "Dr. " ~ user.name }}!`, "Dr. Ada!"},
+		{"arithmetic", "{{ count + 2 }} {{ count - 1 }}.", "5 2."},
+		{"comments", "a{# ignored #}b", "ab"},
+		{"string literal with braces", `{{ "{{literal}}" }}!`, "{{literal}}!"},
+		{"whole string literal with braces", "{{ '{{' }}", "{{"},
+		{"string literal with percent", `{{ "%(count)d" }} {{ count }}`, "%(count)d 3"},
+		{"if", "{% if count > 2 %}many{% endif %}", "many"},
+		{"if else", "{% if count > 5 %}many{% else %}few{% endif %}", "few"},
+		{"elif", "{% if score < 5 %}low{% elif score < 8 %}mid{% else %}high{% endif %}", "mid"},
+		{"if missing key is falsy", "{% if missing %}yes{% else %}no{% endif %}", "no"},
+		{"boolean operators", "{% if count > 1 and not empty %}yes{% endif %}", "yes"},
+		{"in", "{% if 'admin' in user.roles %}admin{% endif %}", "admin"},
+		{"not in", "{% if 'ops' not in user.roles %}not ops{% endif %}", "not ops"},
+		{"is defined", "{% if user is defined %}a{% endif %}{% if missing is not defined %}b{% endif %}", "ab"},
+		{"is none", "{% if missing is none %}none{% endif %}", "none"},
+		{"string comparison", "{% if config.mode == 'fast' %}fast{% endif %}", "fast"},
+		{
+			"for loop",
+			"{% for m in messages %}{{ m.role }}: {{ m.content }}\n{% endfor %}",
+			"user: hi\nassistant: hello\n",
+		},
+		{
+			"loop variables",
+			"{% for r in user.roles %}{{ loop.index }}/{{ loop.length }} {{ r }}{% if not loop.last %}, {% endif %}{% endfor %}",
+			"1/2 admin, 2/2 dev",
+		},
+		{"loop first", "{% for r in user.roles %}{% if loop.first %}{{ r }}{% endif %}{% endfor %}", "admin"},
+		{"for else", "{% for x in [] %}{{ x }}{% else %}empty{% endfor %}", "empty"},
+		{"for items", "{% for k, v in config.items() %}{{ k }}={{ v }}{% endfor %}", "mode=fast"},
+		{"literal list", "{% for n in ['a', 'b', 'c'] %}{{ n }}{% endfor %}", "abc"},
+		{"nested loops", "{% for m in messages %}{% for r in user.roles %}{{ loop.index0 }}{% endfor %}|{% endfor %}", "01|01|"},
+		{"set", "{% set greeting = 'Hi ' ~ name %}{{ greeting }}!", "Hi ada!"},
+		{"set inside if", "{% set label = 'none' %}{% if count > 1 %}{% set label = 'some' %}{% endif %}{{ label }}", "some"},
+		{"set inside loop is scoped", "{% set x = 'outer' %}{% for r in user.roles %}{% set x = r %}{% endfor %}{{ x }}", "outer"},
+		{"whitespace control", "{% for r in user.roles -%}\n  {{ r }}\n{%- endfor %}", "admindev"},
+		{"function call", "{{ len(messages) }} total", "2 total"},
+		{"data function call", "{{ get('user.name') }}!", "Ada!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := hydrateJinja(t, tt.template, data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestJinjaMissingKeys(t *testing.T) {
+	_, err := hydrateJinja(t, "Hello {{ user.name }}", map[string]any{"other": 1})
+	var infoErr *InfoNeededError
+	require.True(t, errors.As(err, &infoErr))
+	assert.Contains(t, infoErr.MissingKeys, "user.name")
+
+	_, err = hydrateJinja(t, "{% for m in messages %}{{ m }}{% endfor %}", map[string]any{"other": 1})
+	require.True(t, errors.As(err, &infoErr))
+	assert.Contains(t, infoErr.MissingKeys, "messages")
+}
+
+func TestJinjaCacheIsBounded(t *testing.T) {
+	for i := 0; i < maxCachedJinja+10; i++ {
+		_, err := CompileJinja(fmt.Sprintf("{{ key_%d }}", i))
+		require.NoError(t, err)
+	}
+
+	jinjaCache.Lock()
+	defer jinjaCache.Unlock()
+	assert.LessOrEqual(t, len(jinjaCache.entries), maxCachedJinja)
+}
+
+func TestJinjaSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		message  string
+	}{
+		{"unclosed tag", "{{ name", "unclosed tag"},
+		{"unclosed block", "{% if x %}a", `unclosed "if" block`},
+		{"unexpected end", "{% endif %}", "unexpected endif"},
+		{"unknown filter", "{{ x | nope }}", `unknown filter "nope"`},
+		{"unsupported statement", "{% macro m() %}{% endmacro %}", `unsupported statement "macro"`},
+		{"line numbers", "a\nb\n{{ x | nope }}", "line 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileJinja(tt.template)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestJinjaUnsupportedConstructs(t *testing.T) {
+	tests := []struct {
+		template  string
+		construct string
+	}{
+		{"{{ price * 2 }}", `operator "*"`},
+		{"{{ total / count }}", `operator "/"`},
+		{"{{ total // count }}", `operator "//"`},
+		{"{{ n ** 2 }}", `operator "**"`},
+		{"{{ n % 2 }}", `operator "%"`},
+		{"{% for i in range(3) %}{{ i }}{% endfor %}", "function range()"},
+		{`{{ "yes" if ok else "no" }}`, "inline if expression (x if c else y)"},
+		{"{% set label = name if name else 'anon' %}", "inline if expression (x if c else y)"},
+		{"{% if x is even %}{% endif %}", `test "even"`},
+		{"{% macro m() %}{% endmacro %}", `statement "macro"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := CompileJinja(tt.template)
+			var unsupported *UnsupportedJinjaError
+			require.ErrorAs(t, err, &unsupported)
+			assert.Equal(t, tt.construct, unsupported.Construct)
+			assert.Contains(t, err.Error(), "unsupported "+tt.construct)
+		})
+	}
+}
+
+func TestJinjaHydrationBehaviour(t *testing.T) {
+	data := map[string]any{"name": "Ada"}
+
+	t.Run("per parameter", func(t *testing.T) {
+		params := map[string]any{
+			"prompt": "Hi {{ name | upper }}",
+			"other":  "Hi {{name}}",
+		}
+		behaviour := map[string]any{"prompt": common.ParameterHydrationBehaviourJinja}
+
+		result, err := Hydrate(params, &data, &behaviour)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"prompt": "Hi ADA", "other": "Hi Ada"}, result)
+	})
+
+	t.Run("inherited by nested values", func(t *testing.T) {
+		params := map[string]any{
+			"messages": []any{map[string]any{"content": "{% if name %}{{ name }}{% endif %}"}},
+		}
+
+		result, err := Hydrate(params, &data, WithTemplateSyntax(nil, common.TemplateSyntaxJinja))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"messages": []any{map[string]any{"content": "Ada"}},
+		}, result)
+	})
+
+	t.Run("keys are found", func(t *testing.T) {
+		keys := FindTemplateKeyStringsToHydrate(map[string]any{
+			"prompt": "{% for m in messages %}{{ m.content }}{% endfor %}{{ user.name | default('x') }}",
+		}, true, WithTemplateSyntax(nil, common.TemplateSyntaxJinja))
+		assert.ElementsMatch(t, []string{"messages", "user.name"}, keys)
+
+		required := FindTemplateKeyStringsToHydrate(map[string]any{
+			"prompt": "{% if flag %}{{ name }}{% endif %}",
+		}, false, WithTemplateSyntax(nil, common.TemplateSyntaxJinja))
+		assert.Equal(t, []string{"name"}, required)
+	})
+}
+
+func TestJinjaRawBlocks(t *testing.T) {
+	state := map[string]any{"name": "Ada"}
+	behaviour := WithTemplateSyntax(nil, common.TemplateSyntaxJinja)
+
+	result, err := HydrateString("{% raw -%}\n  {{ user }} {% if x %}\n{%- endraw %} {{ name }} 100%(x)", &state, behaviour)
+	require.NoError(t, err)
+	assert.Equal(t, "{{ user }} {% if x %} Ada 100%(x)", result)
+
+	_, err = CompileJinja("{% raw %}{{ x }}")
+	assert.ErrorContains(t, err, `unclosed "raw" block`)
+
+	keys := FindTemplateKeyStringsToHydrate("{% raw %}{{ missing }}{% endraw %}{{ name }}", true, behaviour)
+	assert.Equal(t, []string{"name"}, keys)
+}
@@ -0,0 +1,100 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyHydration(t *testing.T) {
+	state := map[string]any{
+		"segment_id": "seg_1",
+		"resource":   map[string]any{"name": "orders", "id": 42},
+		"name":       "Ada",
+	}
+
+	t.Run("keys are left alone by default", func(t *testing.T) {
+		result, err := HydrateDict(map[string]any{"{{segment_id}}": "{{name}}"}, &state)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"{{segment_id}}": "Ada"}, result)
+	})
+
+	t.Run("templated keys are hydrated when enabled", func(t *testing.T) {
+		params := map[string]any{
+			"headers": map[string]any{
+				"X-{{segment_id}}": "{{name}}",
+				"Plain":            "value",
+			},
+			"{{resource.name}}_{{resource.id}}": "{{resource.id}}",
+		}
+
+		result, err := HydrateDict(params, &state, WithKeyHydration(nil, true))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"headers":   map[string]any{"X-seg_1": "Ada", "Plain": "value"},
+			"orders_42": 42,
+		}, result)
+	})
+
+	t.Run("nested behaviours can turn it off", func(t *testing.T) {
+		behaviour := map[string]any{
+			"raw_keys":     map[string]any{hydrateKeysKey: false},
+			hydrateKeysKey: true,
+		}
+		params := map[string]any{
+			"{{segment_id}}": map[string]any{"{{name}}": "x"},
+			"raw_keys":       map[string]any{"{{name}}": "x"},
+		}
+
+		result, err := HydrateDict(params, &state, &behaviour)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"seg_1":    map[string]any{"Ada": "x"},
+			"raw_keys": map[string]any{"{{name}}": "x"},
+		}, result)
+	})
+
+	t.Run("optional keys that render to nothing are dropped", func(t *testing.T) {
+		result, err := HydrateDict(map[string]any{"{{missing?}}": "x", "kept": "y"}, &state, WithKeyHydration(nil, true))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"kept": "y"}, result)
+	})
+
+	t.Run("collisions are reported", func(t *testing.T) {
+		params := map[string]any{
+			"{{segment_id}}": 1,
+			"seg_1":          2,
+		}
+
+		_, err := HydrateDict(params, &state, WithKeyHydration(nil, true))
+		var collisionErr *KeyCollisionError
+		require.True(t, errors.As(err, &collisionErr))
+		assert.Equal(t, "seg_1", collisionErr.Key)
+		assert.Equal(t, []string{"seg_1", "{{segment_id}}"}, collisionErr.Sources)
+	})
+
+	t.Run("missing keys in key templates are reported", func(t *testing.T) {
+		result, err := Hydrate(map[string]any{"{{missing}}": "{{name}}"}, &state, WithKeyHydration(nil, true))
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Contains(t, infoErr.MissingKeys, "missing")
+		assert.Equal(t, map[string]any{"{{missing}}": "Ada"}, result)
+	})
+
+	t.Run("key templates are found", func(t *testing.T) {
+		keys := FindTemplateKeyStringsToHydrate(map[string]any{
+			"{{segment_id}}": "{{name}}",
+		}, false, WithKeyHydration(nil, true))
+		assert.ElementsMatch(t, []string{"segment_id", "name"}, keys)
+	})
+
+	t.Run("jinja key templates", func(t *testing.T) {
+		behaviour := WithKeyHydration(WithTemplateSyntax(nil, common.TemplateSyntaxJinja), true)
+		result, err := HydrateDict(map[string]any{"{{ name | lower }}": "{{ name | upper }}"}, &state, behaviour)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"ada": "ADA"}, result)
+	})
+}
@@ -0,0 +1,348 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Partials are named template snippets that can be reused across parameters,
+// either with the include function ({{include "history" .}}) or with a
+// text/template action ({{template "history" .}}). Partial bodies use the same
+// dialect as any other hydrated string, so {{key}}, {{key?}} and data functions
+// all work inside them and missing keys are reported like any other template.
+//
+// Partials live in a Partials registry. Bots pass their own registry to
+// hydration with WithPartials, so bots with partials of the same name don't
+// see each other's. The package-level functions manage a registry shared by
+// the whole process, which every hydration falls back to.
+
+// Partials is a registry of named partials. It's safe for concurrent use.
+type Partials struct {
+	mu     sync.RWMutex
+	bodies map[string]string
+}
+
+// defaultPartials holds the partials registered with RegisterPartial
+var defaultPartials = &Partials{bodies: map[string]string{}}
+
+// NewPartials returns a registry holding the partials, e.g. the partials
+// declared on a bot definition
+func NewPartials(defs map[string]string) (*Partials, error) {
+	p := &Partials{bodies: map[string]string{}}
+	if err := p.RegisterAll(defs); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Register registers a named partial. The body is validated with the same
+// parser used for hydration, and registering a partial that would create an
+// include cycle is rejected. Registering an existing name replaces it.
+func (p *Partials) Register(name string, body string) error {
+	return p.RegisterAll(map[string]string{name: body})
+}
+
+// RegisterAll registers every partial in the map. All partials are validated
+// before any are registered, so either all or none of them are added.
+func (p *Partials) RegisterAll(defs map[string]string) error {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := validatePartialName(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := parseTemplate(defs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid partial %q: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidate := p.visibleLocked()
+	for k, v := range defs {
+		candidate[k] = v
+	}
+	for _, name := range names {
+		if cycle := findPartialCycle(name, candidate); cycle != nil {
+			return fmt.Errorf("partial %q creates an include cycle: %s", name, strings.Join(cycle, " -> "))
+		}
+	}
+
+	if p.bodies == nil {
+		p.bodies = map[string]string{}
+	}
+	for k, v := range defs {
+		p.bodies[k] = v
+	}
+	return nil
+}
+
+// Unregister removes a named partial if it exists
+func (p *Partials) Unregister(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.bodies, name)
+}
+
+// Names returns the names of the registry's partials in sorted order
+func (p *Partials) Names() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	names := make([]string, 0, len(p.bodies))
+	for name := range p.bodies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// visibleLocked returns the partials hydration with the registry can use: the
+// registry's own and the shared ones it doesn't override. p.mu must be held.
+func (p *Partials) visibleLocked() map[string]string {
+	visible := map[string]string{}
+	if p != defaultPartials {
+		defaultPartials.mu.RLock()
+		for k, v := range defaultPartials.bodies {
+			visible[k] = v
+		}
+		defaultPartials.mu.RUnlock()
+	}
+	for k, v := range p.bodies {
+		visible[k] = v
+	}
+	return visible
+}
+
+// visible returns the partials hydration with the registry can use. A nil
+// registry only has the shared partials.
+func (p *Partials) visible() map[string]string {
+	if p == nil {
+		p = defaultPartials
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.visibleLocked()
+}
+
+// lookup returns the body of a partial in the registry, or a shared one
+func (p *Partials) lookup(name string) (string, bool) {
+	if p != nil {
+		p.mu.RLock()
+		body, ok := p.bodies[name]
+		p.mu.RUnlock()
+		if ok {
+			return body, true
+		}
+	}
+	defaultPartials.mu.RLock()
+	defer defaultPartials.mu.RUnlock()
+	body, ok := defaultPartials.bodies[name]
+	return body, ok
+}
+
+// RegisterPartial registers a partial shared by every hydration in the process
+func RegisterPartial(name string, body string) error {
+	return defaultPartials.Register(name, body)
+}
+
+// RegisterPartials registers shared partials, either all or none of them. Use
+// NewPartials for partials that belong to a bot.
+func RegisterPartials(defs map[string]string) error {
+	return defaultPartials.RegisterAll(defs)
+}
+
+// UnregisterPartial removes a shared partial if it exists
+func UnregisterPartial(name string) {
+	defaultPartials.Unregister(name)
+}
+
+// PartialNames returns the names of the shared partials in sorted order
+func PartialNames() []string {
+	return defaultPartials.Names()
+}
+
+func validatePartialName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("partial name cannot be empty")
+	}
+	if strings.ContainsAny(name, "\"`{}") {
+		return fmt.Errorf("invalid partial name %q", name)
+	}
+	return nil
+}
+
+// partialFunctions are the functions and keywords whose first argument names a partial
+var partialFunctions = []string{"include", "template", "block"}
+
+// findPartialReferences returns the names of the partials referenced by a template
+// in include calls and template/block actions
+func findPartialReferences(s string) []string {
+	if !strings.Contains(s, "{{") {
+		return nil
+	}
+
+	var refs []string
+	addRef := func(name string, args []*argNode) {
+		if !slices.Contains(partialFunctions, name) || len(args) == 0 {
+			return
+		}
+		if ref, ok := args[0].unquote(); ok {
+			refs = append(refs, ref)
+		}
+	}
+	for _, seg := range splitTemplate(s) {
+		if seg.action == nil {
+			continue
+		}
+		if keywords := seg.action.keywords; len(keywords) > 0 && seg.action.pipe != nil {
+			addRef(keywords[len(keywords)-1], seg.action.pipe.cmds[0].args)
+		}
+		seg.action.pipe.walk(func(cmd *commandNode) {
+			addRef(cmd.name(), cmd.args[1:])
+		})
+	}
+	return refs
+}
+
+// findPartialCycle returns the include path of a cycle reachable from start, or nil
+func findPartialCycle(start string, defs map[string]string) []string {
+	visiting := map[string]bool{}
+	done := map[string]bool{}
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if visiting[name] {
+			idx := slices.Index(path, name)
+			return append(append([]string{}, path[idx:]...), name)
+		}
+		if done[name] {
+			return nil
+		}
+		body, ok := defs[name]
+		if !ok {
+			return nil
+		}
+
+		visiting[name] = true
+		path = append(path, name)
+		for _, ref := range findPartialReferences(body) {
+			if cycle := visit(ref); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		visiting[name] = false
+		done[name] = true
+		return nil
+	}
+
+	return visit(start)
+}
+
+// usesPartials reports whether a template references any partial
+func usesPartials(s string) bool {
+	return len(findPartialReferences(s)) > 0
+}
+
+// findPartialKeys returns the template keys used inside the partials referenced by s,
+// following nested partial references
+func findPartialKeys(s string, partials *Partials, seen map[string]bool) []Key {
+	var keys []Key
+	for _, ref := range findPartialReferences(s) {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		body, ok := partials.lookup(ref)
+		if !ok {
+			continue
+		}
+		keys = append(keys, templateKeys(body)...)
+		keys = append(keys, findPartialKeys(body, partials, seen)...)
+	}
+	return keys
+}
+
+// addPartialTemplates associates every partial visible with the options' registry
+// with t so that {{template "name" .}} actions resolve, and binds include to the
+// options. Templates already defined on t (including ones declared inline with
+// define) take precedence.
+func addPartialTemplates(t *template.Template, opts stringOptions) error {
+	t.Funcs(template.FuncMap{
+		"include": opts.include,
+	})
+	for name, body := range opts.partials.visible() {
+		if t.Lookup(name) != nil {
+			continue
+		}
+		parsed, err := parseTemplate(body)
+		if err != nil {
+			return fmt.Errorf("invalid partial %q: %w", name, err)
+		}
+		if _, err := t.New(name).Parse(parsed); err != nil {
+			return fmt.Errorf("error parsing partial %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// include renders a partial and returns the result as a string.
+// The context can be the template root (.), in which case the partial sees the
+// same data as the caller, or a map that becomes the partial's data.
+// Keys missing inside the partial are added to missingKeys.
+// Example: {{include "history" .}} or {{include "row" (get "item")}}
+func (opts stringOptions) include(name string, ctx any, data map[string]any, missingKeys *[]string) (string, error) {
+	body, ok := opts.partials.lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown partial: %s", name)
+	}
+
+	partialData := data
+	switch c := ctx.(type) {
+	case templateContext:
+		partialData = c.Data
+	case *templateContext:
+		if c != nil {
+			partialData = c.Data
+		}
+	case map[string]any:
+		partialData = c
+	}
+
+	value, err := hydrateString(body, &partialData, opts)
+	if err != nil {
+		var infoErr *InfoNeededError
+		if !errors.As(err, &infoErr) {
+			return "", fmt.Errorf("error rendering partial %q: %w", name, err)
+		}
+		for _, key := range infoErr.MissingKeys {
+			addMissingKey(missingKeys, key)
+		}
+	}
+
+	return nilToEmptyString(value), nil
+}
+
+// include renders a shared partial. Hydration binds include to the registry
+// in use, so this is only the function the template validator sees.
+func include(name string, ctx any, data map[string]any, missingKeys *[]string) (string, error) {
+	return stringOptions{}.include(name, ctx, data, missingKeys)
+}
@@ -0,0 +1,183 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartials(t *testing.T) {
+	require.NoError(t, RegisterPartial("greeting", "Hello {{name}}!"))
+	require.NoError(t, RegisterPartial("history", `{{range $m := (get "messages")}}{{$m.role}}: {{$m.content}}
+{{end}}`))
+	require.NoError(t, RegisterPartial("wrapped", `[{{include "greeting" .}}]`))
+	t.Cleanup(func() {
+		UnregisterPartial("greeting")
+		UnregisterPartial("history")
+		UnregisterPartial("wrapped")
+	})
+
+	data := map[string]any{
+		"name": "World",
+		"messages": []any{
+			map[string]any{"role": "user", "content": "hi"},
+			map[string]any{"role": "assistant", "content": "hello"},
+		},
+	}
+
+	t.Run("include with root context", func(t *testing.T) {
+		result, err := HydrateString(`{{include "greeting" .}}`, &data)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello World!", result)
+	})
+
+	t.Run("include mixed with text", func(t *testing.T) {
+		result, err := HydrateString(`History:
+{{include "history" .}}`, &data)
+		require.NoError(t, err)
+		assert.Equal(t, "History:\nuser: hi\nassistant: hello\n", result)
+	})
+
+	t.Run("include with map context", func(t *testing.T) {
+		data := map[string]any{"user": map[string]any{"name": "Ada"}}
+		result, err := HydrateString(`{{include "greeting" (get "user")}}`, &data)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello Ada!", result)
+	})
+
+	t.Run("nested include", func(t *testing.T) {
+		result, err := HydrateString(`{{include "wrapped" .}}`, &data)
+		require.NoError(t, err)
+		assert.Equal(t, "[Hello World!]", result)
+	})
+
+	t.Run("template action", func(t *testing.T) {
+		result, err := HydrateString(`> {{template "greeting" .}}`, &data)
+		require.NoError(t, err)
+		assert.Equal(t, "> Hello World!", result)
+	})
+
+	t.Run("missing keys inside include are reported", func(t *testing.T) {
+		empty := map[string]any{"other": 1}
+		_, err := HydrateString(`{{include "greeting" .}}`, &empty)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Contains(t, infoErr.MissingKeys, "name")
+	})
+
+	t.Run("missing keys inside template action are reported", func(t *testing.T) {
+		empty := map[string]any{"other": 1}
+		_, err := HydrateString(`> {{template "greeting" .}}`, &empty)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Contains(t, infoErr.MissingKeys, "name")
+	})
+
+	t.Run("keys inside partials are found", func(t *testing.T) {
+		keys := FindTemplateKeyStringsToHydrate(map[string]any{
+			"prompt": `{{include "wrapped" .}}`,
+		}, false, nil)
+		assert.Contains(t, keys, "name")
+	})
+
+	t.Run("unknown partial", func(t *testing.T) {
+		_, err := HydrateString(`{{include "nope" .}}`, &data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown partial: nope")
+	})
+}
+
+func TestRegisterPartialValidation(t *testing.T) {
+	t.Run("invalid syntax", func(t *testing.T) {
+		err := RegisterPartial("broken", "{{if}}")
+		assert.Error(t, err)
+		assert.NotContains(t, PartialNames(), "broken")
+	})
+
+	t.Run("empty name", func(t *testing.T) {
+		assert.Error(t, RegisterPartial(" ", "body"))
+	})
+
+	t.Run("cycles are rejected", func(t *testing.T) {
+		require.NoError(t, RegisterPartial("cycle_a", `a {{include "cycle_b" .}}`))
+		t.Cleanup(func() { UnregisterPartial("cycle_a") })
+
+		err := RegisterPartial("cycle_b", `b {{include "cycle_a" .}}`)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cycle_b -> cycle_a -> cycle_b")
+	})
+
+	t.Run("register many from a bot definition", func(t *testing.T) {
+		defs := map[string]string{
+			"bot_header": "# {{title}}",
+			"bot_body":   `{{include "bot_header" .}} {{body}}`,
+		}
+		require.NoError(t, RegisterPartials(defs))
+		t.Cleanup(func() {
+			UnregisterPartial("bot_header")
+			UnregisterPartial("bot_body")
+		})
+
+		data := map[string]any{"title": "T", "body": "B"}
+		result, err := HydrateString(`{{include "bot_body" .}}`, &data)
+		require.NoError(t, err)
+		assert.Equal(t, "# T B", result)
+	})
+
+	t.Run("register many is all or nothing", func(t *testing.T) {
+		err := RegisterPartials(map[string]string{
+			"good_one": "ok",
+			"bad_one":  "{{end}}",
+		})
+		require.Error(t, err)
+		assert.NotContains(t, PartialNames(), "good_one")
+	})
+}
+
+func TestPartialsRegistry(t *testing.T) {
+	require.NoError(t, RegisterPartial("shared_footer", "-- {{name}}"))
+	t.Cleanup(func() { UnregisterPartial("shared_footer") })
+
+	first, err := NewPartials(map[string]string{"greeting": "Hello {{name}}", "signed": `{{include "greeting" .}} {{include "shared_footer" .}}`})
+	require.NoError(t, err)
+	second, err := NewPartials(map[string]string{"greeting": "Bye {{name}}"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"greeting", "signed"}, first.Names())
+	assert.NotContains(t, PartialNames(), "greeting", "bot partials aren't shared")
+
+	data := map[string]any{"name": "Ada"}
+	params := map[string]any{"include": `{{include "greeting" .}}`, "action": `> {{template "greeting" .}}`}
+
+	t.Run("each bot sees its own partials", func(t *testing.T) {
+		result, err := Hydrate(params, &data, WithPartials(nil, first))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"include": "Hello Ada", "action": "> Hello Ada"}, result)
+
+		result, err = Hydrate(params, &data, WithPartials(nil, second))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"include": "Bye Ada", "action": "> Bye Ada"}, result)
+
+		_, err = Hydrate(params, &data, nil)
+		assert.ErrorContains(t, err, "unknown partial: greeting")
+	})
+
+	t.Run("shared partials are visible to every registry", func(t *testing.T) {
+		result, err := HydrateString(`{{include "signed" .}}`, &data, WithPartials(nil, first))
+		require.NoError(t, err)
+		assert.Equal(t, "Hello Ada -- Ada", result)
+	})
+
+	t.Run("keys are found in the registry's partials", func(t *testing.T) {
+		keys := FindTemplateKeyStringsToHydrate(map[string]any{"p": `{{include "signed" .}}`}, false, WithPartials(nil, first))
+		assert.Contains(t, keys, "name")
+	})
+
+	t.Run("cycles through shared partials are rejected", func(t *testing.T) {
+		require.NoError(t, RegisterPartial("shared_loop", `{{include "local_loop" .}}`))
+		t.Cleanup(func() { UnregisterPartial("shared_loop") })
+		_, err := NewPartials(map[string]string{"local_loop": `{{include "shared_loop" .}}`})
+		assert.ErrorContains(t, err, "include cycle")
+	})
+}
@@ -0,0 +1,350 @@
+package template
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+// pathElem is one step of a concrete path through a value: a map key or a slice index
+type pathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type valuePath []pathElem
+
+// String renders the path the way patterns are written, e.g. tools[0].parameters
+func (p valuePath) String() string {
+	var b strings.Builder
+	for _, elem := range p {
+		if elem.isIndex {
+			fmt.Fprintf(&b, "[%d]", elem.index)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(elem.key)
+	}
+	return b.String()
+}
+
+func (p valuePath) child(elem pathElem) valuePath {
+	return append(slices.Clip(p), elem)
+}
+
+type compiledRule struct {
+	segments  []string
+	behaviour common.ParameterHydrationBehaviour
+}
+
+// parsePathPattern splits a pattern like "tools[*].parameters" or "**.code" into segments
+func parsePathPattern(pattern string) ([]string, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, fmt.Errorf("%w: empty path pattern", ErrInvalidHydrationBehaviour)
+	}
+
+	var segments []string
+	for _, part := range strings.Split(pattern, ".") {
+		key, rest, hasIndex := strings.Cut(part, "[")
+		if key == "" && !hasIndex {
+			return nil, fmt.Errorf("%w: empty segment in path pattern %q", ErrInvalidHydrationBehaviour, pattern)
+		}
+		if key != "" {
+			segments = append(segments, key)
+		}
+		for hasIndex {
+			var index string
+			var ok bool
+			index, rest, ok = strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("%w: unclosed [ in path pattern %q", ErrInvalidHydrationBehaviour, pattern)
+			}
+			if _, err := strconv.Atoi(index); err != nil && index != "*" {
+				return nil, fmt.Errorf("%w: invalid index %q in path pattern %q", ErrInvalidHydrationBehaviour, index, pattern)
+			}
+			segments = append(segments, index)
+
+			if rest == "" {
+				break
+			}
+			if !strings.HasPrefix(rest, "[") {
+				return nil, fmt.Errorf("%w: unexpected %q in path pattern %q", ErrInvalidHydrationBehaviour, rest, pattern)
+			}
+			rest = rest[1:]
+		}
+	}
+	return segments, nil
+}
+
+func matchSegment(segment string, elem pathElem) bool {
+	if segment == "*" {
+		return true
+	}
+	if elem.isIndex {
+		return segment == strconv.Itoa(elem.index)
+	}
+	return segment == elem.key
+}
+
+// matchPattern reports whether the pattern matches the path exactly, or with
+// prefix set, whether it could match a path below it
+func matchPattern(segments []string, path valuePath, prefix bool) bool {
+	if len(segments) == 0 {
+		return len(path) == 0
+	}
+	if segments[0] == "**" {
+		return matchPattern(segments[1:], path, prefix) || (len(path) > 0 && matchPattern(segments, path[1:], prefix))
+	}
+	if len(path) == 0 {
+		return prefix
+	}
+	return matchSegment(segments[0], path[0]) && matchPattern(segments[1:], path[1:], prefix)
+}
+
+type policyHydrator struct {
+	rules  []compiledRule
+	frozen map[string]any
+	state  *map[string]any
+
+	errs errorCollector
+}
+
+// HydrateWithPolicy hydrates a value with the state parameters, applying the
+// policy's path rules to decide which values are hydrated, left raw or dropped.
+// It returns the policy's frozen values together with the ones hydrated by "once"
+// rules in this call; store them in policy.Frozen and persist the policy so later
+// calls use them in place of the parameters. The policy itself isn't modified.
+// A nil policy hydrates everything.
+func HydrateWithPolicy(value any, stateParameters *map[string]any, policy *common.HydrationPolicy) (result any, frozen map[string]any, err error) {
+	defer recoverPanic(&err)
+
+	if policy == nil {
+		policy = &common.HydrationPolicy{}
+	}
+	frozen = make(map[string]any, len(policy.Frozen))
+	for path, value := range policy.Frozen {
+		frozen[path] = value
+	}
+	if stateParameters == nil {
+		return value, frozen, nil
+	}
+
+	rules, err := compileRules(policy)
+	if err != nil {
+		return nil, frozen, err
+	}
+	h := &policyHydrator{rules: rules, state: stateParameters, frozen: frozen}
+
+	result, _ = h.hydrate(value, valuePath{}, defaultBehaviour(policy))
+	return result, frozen, h.errs.err(stateParameters, "value")
+}
+
+func compileRules(policy *common.HydrationPolicy) ([]compiledRule, error) {
+	var rules []compiledRule
+	for _, rule := range policy.Rules {
+		segments, err := parsePathPattern(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		switch rule.Behaviour {
+		case common.ParameterHydrationBehaviourHydrate, common.ParameterHydrationBehaviourRaw,
+			common.ParameterHydrationBehaviourNone, common.ParameterHydrationBehaviourOnce:
+		default:
+			return nil, fmt.Errorf("%w: unsupported behaviour %q for path %q", ErrInvalidHydrationBehaviour, rule.Behaviour, rule.Path)
+		}
+		rules = append(rules, compiledRule{segments: segments, behaviour: rule.Behaviour})
+	}
+	return rules, nil
+}
+
+func defaultBehaviour(policy *common.HydrationPolicy) common.ParameterHydrationBehaviour {
+	if policy.Default == "" {
+		return common.ParameterHydrationBehaviourHydrate
+	}
+	return policy.Default
+}
+
+// FindPolicyTemplateKeys returns the template keys in the values the policy
+// hydrates, skipping values it leaves raw, drops or has frozen. It returns an
+// error if the policy is invalid. A nil policy hydrates everything.
+func FindPolicyTemplateKeys(value any, includeOptional bool, policy *common.HydrationPolicy) ([]Key, error) {
+	keys := []Key{}
+	err := walkPolicyTemplates(value, policy, common.TemplateSyntaxErdo, func(_ valuePath, s string, syntax common.TemplateSyntax) {
+		keys = append(keys, FindTemplateKeysToHydrate(s, includeOptional, WithTemplateSyntax(nil, syntax))...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// FindPolicyDependencies returns the state paths read by the values the policy
+// hydrates: template keys, keys named by string arguments (e.g.
+// {{get "steps.search.output"}}) and .Data fields, as dot-separated paths like
+// steps.search.output.items.0. Templates that read the whole state only add the
+// paths they name. It returns an error if the policy is invalid.
+func FindPolicyDependencies(value any, policy *common.HydrationPolicy) ([]string, error) {
+	seen := map[string]bool{}
+	deps := []string{}
+	add := func(path string) {
+		if path = normalizeKeyPath(path); path != "" && !seen[path] {
+			seen[path] = true
+			deps = append(deps, path)
+		}
+	}
+	err := walkPolicyTemplates(value, policy, common.TemplateSyntaxErdo, func(_ valuePath, s string, syntax common.TemplateSyntax) {
+		behaviour := WithTemplateSyntax(nil, syntax)
+		for _, key := range FindTemplateKeysToHydrate(s, true, behaviour) {
+			add(key.Key)
+		}
+		paths, _ := templateDependencies(s, behaviour)
+		for _, path := range paths {
+			add(path)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// walkPolicyTemplates calls fn with every string the policy hydrates and the
+// syntax it's hydrated with, skipping values it leaves raw, drops or has
+// frozen. Map keys are visited in sorted order.
+func walkPolicyTemplates(value any, policy *common.HydrationPolicy, syntax common.TemplateSyntax, fn func(path valuePath, s string, syntax common.TemplateSyntax)) error {
+	if policy == nil {
+		policy = &common.HydrationPolicy{}
+	}
+	rules, err := compileRules(policy)
+	if err != nil {
+		return err
+	}
+	h := &policyHydrator{rules: rules, frozen: policy.Frozen}
+
+	var walk func(value any, path valuePath, inherited common.ParameterHydrationBehaviour)
+	walk = func(value any, path valuePath, inherited common.ParameterHydrationBehaviour) {
+		if _, ok := h.frozen[path.String()]; ok {
+			return
+		}
+		behaviour := h.behaviourAt(path, inherited)
+		if behaviour == common.ParameterHydrationBehaviourNone {
+			return
+		}
+		if behaviour == common.ParameterHydrationBehaviourRaw && !h.rulesBelow(path) {
+			return
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			for _, key := range slices.Sorted(maps.Keys(v)) {
+				walk(v[key], path.child(pathElem{key: key}), behaviour)
+			}
+		case []any:
+			for i, item := range v {
+				walk(item, path.child(pathElem{index: i, isIndex: true}), behaviour)
+			}
+		case string:
+			if behaviour == common.ParameterHydrationBehaviourRaw {
+				return
+			}
+			if behaviour == common.ParameterHydrationBehaviourJinja {
+				fn(path, v, common.TemplateSyntaxJinja)
+				return
+			}
+			fn(path, v, syntax)
+		}
+	}
+	walk(value, valuePath{}, defaultBehaviour(policy))
+	return nil
+}
+
+// behaviourAt returns the behaviour for a path given the behaviour inherited from its parent
+func (h *policyHydrator) behaviourAt(path valuePath, inherited common.ParameterHydrationBehaviour) common.ParameterHydrationBehaviour {
+	behaviour := inherited
+	for _, rule := range h.rules {
+		if matchPattern(rule.segments, path, false) {
+			behaviour = rule.behaviour
+		}
+	}
+	return behaviour
+}
+
+// rulesBelow reports whether any rule could match a path under the given one
+func (h *policyHydrator) rulesBelow(path valuePath) bool {
+	for _, rule := range h.rules {
+		if matchPattern(rule.segments, path, true) && !matchPattern(rule.segments, path, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// hydrate returns the hydrated value and false if the value should be dropped.
+// Errors are collected in h.errs and the failing value is left as-is.
+func (h *policyHydrator) hydrate(value any, path valuePath, inherited common.ParameterHydrationBehaviour) (any, bool) {
+	pathStr := path.String()
+	if frozen, ok := h.frozen[pathStr]; ok {
+		return frozen, true
+	}
+
+	behaviour := h.behaviourAt(path, inherited)
+	if behaviour == common.ParameterHydrationBehaviourNone {
+		return nil, false
+	}
+	if behaviour == common.ParameterHydrationBehaviourRaw && !h.rulesBelow(path) {
+		return value, true
+	}
+
+	// Once applies to the value its rule matched; below it, values are hydrated
+	// as usual and frozen together with it
+	ownsOnce := behaviour == common.ParameterHydrationBehaviourOnce && inherited != common.ParameterHydrationBehaviourOnce
+	errsBefore := h.errs.len()
+
+	var result any
+	var err error
+	switch v := value.(type) {
+	case map[string]any:
+		dict := make(map[string]any, len(v))
+		for key, item := range v {
+			if hydrated, keep := h.hydrate(item, path.child(pathElem{key: key}), behaviour); keep {
+				dict[key] = hydrated
+			}
+		}
+		result = dict
+	case []any:
+		list := make([]any, 0, len(v))
+		for i, item := range v {
+			if hydrated, keep := h.hydrate(item, path.child(pathElem{index: i, isIndex: true}), behaviour); keep {
+				list = append(list, hydrated)
+			}
+		}
+		result = list
+	case string:
+		if behaviour == common.ParameterHydrationBehaviourRaw {
+			return v, true
+		}
+		result, err = hydrate(v, h.state, nil)
+		if err != nil {
+			h.errs.add(pathStr, err)
+			return v, true
+		}
+	default:
+		result, err = hydrate(v, h.state, nil)
+		if err != nil {
+			h.errs.add(pathStr, err)
+			return v, true
+		}
+	}
+
+	// Only a fully hydrated value is frozen, so missing keys can be provided later
+	if ownsOnce && h.errs.len() == errsBefore {
+		h.frozen[pathStr] = result
+	}
+	return result, true
+}
@@ -0,0 +1,175 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHydrateWithPolicy(t *testing.T) {
+	state := map[string]any{"name": "Ada", "lang": "go"}
+
+	params := func() map[string]any {
+		return map[string]any{
+			"prompt": "Hi {{name}}",
+			"tools": []any{
+				map[string]any{"name": "{{lang}}_tool", "parameters": map[string]any{"q": "{{query}}"}},
+				map[string]any{"name": "other", "parameters": map[string]any{"q": "{{query}}"}},
+			},
+			"messages": []any{"{{name}} first", "{{name}} second"},
+			"script":   map[string]any{"code": "print('{{name}}')", "nested": map[string]any{"code": "{{x}}"}},
+		}
+	}
+
+	t.Run("wildcards, indexes and double wildcards", func(t *testing.T) {
+		policy := &common.HydrationPolicy{Rules: []common.HydrationRule{
+			{Path: "tools.*.parameters", Behaviour: common.ParameterHydrationBehaviourRaw},
+			{Path: "messages[0]", Behaviour: common.ParameterHydrationBehaviourRaw},
+			{Path: "**.code", Behaviour: common.ParameterHydrationBehaviourRaw},
+		}}
+
+		result, _, err := HydrateWithPolicy(params(), &state, policy)
+		require.NoError(t, err)
+
+		dict := result.(map[string]any)
+		assert.Equal(t, "Hi Ada", dict["prompt"])
+		assert.Equal(t, "go_tool", dict["tools"].([]any)[0].(map[string]any)["name"])
+		assert.Equal(t, map[string]any{"q": "{{query}}"}, dict["tools"].([]any)[1].(map[string]any)["parameters"])
+		assert.Equal(t, []any{"{{name}} first", "Ada second"}, dict["messages"])
+		assert.Equal(t, map[string]any{
+			"code":   "print('{{name}}')",
+			"nested": map[string]any{"code": "{{x}}"},
+		}, dict["script"])
+	})
+
+	t.Run("deeper rules override their ancestors", func(t *testing.T) {
+		policy := &common.HydrationPolicy{Rules: []common.HydrationRule{
+			{Path: "tools[0].name", Behaviour: common.ParameterHydrationBehaviourHydrate},
+			{Path: "tools", Behaviour: common.ParameterHydrationBehaviourRaw},
+		}}
+
+		result, _, err := HydrateWithPolicy(map[string]any{"tools": params()["tools"]}, &state, policy)
+		require.NoError(t, err)
+
+		tool := result.(map[string]any)["tools"].([]any)[0].(map[string]any)
+		assert.Equal(t, "go_tool", tool["name"])
+		assert.Equal(t, map[string]any{"q": "{{query}}"}, tool["parameters"])
+	})
+
+	t.Run("none drops fields and elements", func(t *testing.T) {
+		policy := &common.HydrationPolicy{
+			Default: common.ParameterHydrationBehaviourRaw,
+			Rules: []common.HydrationRule{
+				{Path: "script", Behaviour: common.ParameterHydrationBehaviourNone},
+				{Path: "tools[1]", Behaviour: common.ParameterHydrationBehaviourNone},
+				{Path: "messages.*", Behaviour: common.ParameterHydrationBehaviourHydrate},
+			},
+		}
+
+		result, _, err := HydrateWithPolicy(params(), &state, policy)
+		require.NoError(t, err)
+
+		dict := result.(map[string]any)
+		assert.NotContains(t, dict, "script")
+		assert.Len(t, dict["tools"], 1)
+		assert.Equal(t, "Hi {{name}}", dict["prompt"])
+		assert.Equal(t, []any{"Ada first", "Ada second"}, dict["messages"])
+	})
+
+	t.Run("once freezes the hydrated value", func(t *testing.T) {
+		policy := &common.HydrationPolicy{Rules: []common.HydrationRule{
+			{Path: "prompt", Behaviour: common.ParameterHydrationBehaviourOnce},
+		}}
+
+		first, frozen, err := HydrateWithPolicy(map[string]any{"prompt": "Hi {{name}}"}, &state, policy)
+		require.NoError(t, err)
+		assert.Equal(t, "Hi Ada", first.(map[string]any)["prompt"])
+		assert.Equal(t, map[string]any{"prompt": "Hi Ada"}, frozen)
+		assert.Empty(t, policy.Frozen, "the caller's policy isn't modified")
+
+		// Later calls use the frozen output, not the template passed in
+		policy.Frozen = frozen
+		again, frozen, err := HydrateWithPolicy(map[string]any{"prompt": "Hi {{name}} in {{lang}}"}, &state, policy)
+		require.NoError(t, err)
+		assert.Equal(t, "Hi Ada", again.(map[string]any)["prompt"])
+		assert.Equal(t, map[string]any{"prompt": "Hi Ada"}, frozen)
+
+		keys, err := FindPolicyTemplateKeys(map[string]any{"prompt": "Hi {{lang}}"}, true, policy)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("once with missing keys is not frozen", func(t *testing.T) {
+		policy := &common.HydrationPolicy{Rules: []common.HydrationRule{
+			{Path: "prompt", Behaviour: common.ParameterHydrationBehaviourOnce},
+		}}
+
+		_, frozen, err := HydrateWithPolicy(map[string]any{"prompt": "Hi {{missing}}"}, &state, policy)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Contains(t, infoErr.MissingKeys, "missing")
+		assert.Empty(t, frozen)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		for _, rule := range []common.HydrationRule{
+			{Path: "a..b", Behaviour: common.ParameterHydrationBehaviourRaw},
+			{Path: "a[x]", Behaviour: common.ParameterHydrationBehaviourRaw},
+			{Path: "a[0", Behaviour: common.ParameterHydrationBehaviourRaw},
+			{Path: "a", Behaviour: "sometimes"},
+		} {
+			policy := &common.HydrationPolicy{Rules: []common.HydrationRule{rule}}
+			_, _, err := HydrateWithPolicy(params(), &state, policy)
+			assert.ErrorIs(t, err, ErrInvalidHydrationBehaviour, rule.Path)
+		}
+	})
+}
+
+func TestLegacyBehaviourNone(t *testing.T) {
+	state := map[string]any{"name": "Ada"}
+	behaviour := map[string]any{"secret": common.ParameterHydrationBehaviourNone}
+
+	result, err := HydrateDict(map[string]any{"secret": "{{missing}}", "name": "{{name}}"}, &state, &behaviour)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "Ada"}, result)
+}
+
+func TestHydrationPolicySerialisation(t *testing.T) {
+	t.Run("round trips through Value and Scan", func(t *testing.T) {
+		policy := common.HydrationPolicy{
+			Rules:  []common.HydrationRule{{Path: "tools.*.parameters", Behaviour: common.ParameterHydrationBehaviourRaw}},
+			Frozen: map[string]any{"prompt": "Hi Ada"},
+		}
+		value, err := policy.Value()
+		require.NoError(t, err)
+
+		var scanned common.HydrationPolicy
+		require.NoError(t, scanned.Scan(value))
+		assert.Equal(t, policy, scanned)
+	})
+
+	t.Run("stored behaviours scan as the default", func(t *testing.T) {
+		raw := common.ParameterHydrationBehaviourRaw
+		value, err := raw.Value()
+		require.NoError(t, err)
+
+		var scanned common.HydrationPolicy
+		require.NoError(t, scanned.Scan(value))
+		assert.Equal(t, common.HydrationPolicy{Default: raw}, scanned)
+
+		policyValue, err := scanned.Value()
+		require.NoError(t, err)
+		assert.Equal(t, value, policyValue)
+	})
+
+	t.Run("step JSON", func(t *testing.T) {
+		var step common.Step
+		require.NoError(t, json.Unmarshal([]byte(`{"hydration_policy": {"rules": [{"path": "**.code", "behaviour": "raw"}]}}`), &step))
+		require.NotNil(t, step.HydrationPolicy)
+		assert.Equal(t, "**.code", step.HydrationPolicy.Rules[0].Path)
+	})
+}
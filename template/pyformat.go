@@ -0,0 +1,417 @@
+package template
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Python %-style mapping format support, e.g. %(count)05d or %(score).2f.
+// Format keys are split out of templates with the rest of the syntax (see
+// syntax.go). A bare %(key)s is a key reference like {{key}}; every other
+// specifier is rewritten into a call to pyFormat when the template is parsed.
+
+// pyFormatSpec is a parsed Python conversion specifier
+type pyFormatSpec struct {
+	Key        string
+	Flags      string
+	Width      string
+	Precision  string
+	Conversion byte
+}
+
+// parsePyFormatSpec parses the <flags><width>.<precision><conversion> following
+// the mapping key of a specifier and returns the number of bytes it spans
+func parsePyFormatSpec(s string) (pyFormatSpec, int, bool) {
+	var spec pyFormatSpec
+	i := 0
+	for i < len(s) && strings.IndexByte("-#0 +", s[i]) >= 0 {
+		i++
+	}
+	spec.Flags = s[:i]
+
+	start := i
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	spec.Width = s[start:i]
+
+	if i < len(s) && s[i] == '.' {
+		start = i + 1
+		j := start
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		// A dot without digits isn't part of the specifier
+		if j > start {
+			spec.Precision = s[start:j]
+			i = j
+		}
+	}
+
+	if i >= len(s) || strings.IndexByte("diouxXeEfFgGcrs", s[i]) < 0 {
+		return pyFormatSpec{}, 0, false
+	}
+	spec.Conversion = s[i]
+	return spec, i + 1, true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// String returns the specifier without its mapping key, e.g. "05d"
+func (s pyFormatSpec) String() string {
+	spec := s.Flags + s.Width
+	if s.Precision != "" {
+		spec += "." + s.Precision
+	}
+	return spec + string(s.Conversion)
+}
+
+// isPlain reports whether the specifier is a bare %(key)<conversion>
+func (s pyFormatSpec) isPlain() bool {
+	return s.Flags == "" && s.Width == "" && s.Precision == ""
+}
+
+// hydrateWholePyFormat handles a string that is a single Python specifier.
+// Plain numeric conversions keep their type (%(count)d returns an int and
+// %(score)f a float64); everything else returns the formatted string.
+func hydrateWholePyFormat(userTemplate string, data map[string]any) (any, bool, error) {
+	whole, ok := singleSegment(userTemplate)
+	if !ok || whole.format == nil {
+		// Regular variable path
+		return nil, false, nil
+	}
+
+	spec := *whole.format
+	key, isOptional := cleanKey(spec.Key)
+	var missingKeys []string
+	value := get(key, data, &missingKeys)
+	if value == nil {
+		if isOptional {
+			return nil, true, nil
+		}
+		return nil, true, &InfoNeededError{
+			MissingKeys:   []string{key},
+			AvailableKeys: getKeys(data),
+			Err:           fmt.Errorf("missing key in template"),
+		}
+	}
+
+	if spec.isPlain() {
+		switch spec.Conversion {
+		case 'd', 'i', 'u':
+			n, err := pyInt(value)
+			if err != nil {
+				return nil, true, pyFormatError(spec, key, value, err)
+			}
+			return int(n), true, nil
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			f, err := pyFloat(value)
+			if err != nil {
+				return nil, true, pyFormatError(spec, key, value, err)
+			}
+			return f, true, nil
+		}
+	}
+
+	formatted, err := formatPyValue(spec, value)
+	if err != nil {
+		return nil, true, pyFormatError(spec, key, value, err)
+	}
+	return formatted, true, nil
+}
+
+// pyFormat formats a value from data with a Python conversion specifier.
+// Missing keys are tracked like get; optional keys (key?) render as "".
+// Example: {{pyFormat "05d" "count"}} renders 42 as "00042"
+func pyFormat(spec string, key string, data map[string]any, missingKeys *[]string) (string, error) {
+	parsed, n, ok := parsePyFormatSpec(spec)
+	if !ok || n != len(spec) {
+		return "", fmt.Errorf("invalid format specifier %q for key %q", spec, key)
+	}
+	parsed.Key = key
+
+	value := get(key, data, missingKeys)
+	if value == nil {
+		return "", nil
+	}
+
+	formatted, err := formatPyValue(parsed, value)
+	if err != nil {
+		lookupKey, _ := cleanKey(key)
+		return "", pyFormatError(parsed, lookupKey, value, err)
+	}
+	return formatted, nil
+}
+
+func pyFormatError(spec pyFormatSpec, key string, value any, err error) error {
+	return fmt.Errorf("format %%(%s)%s: value of key '%s' (%T) %w", spec.Key, spec.String(), key, value, err)
+}
+
+// formatPyValue formats a single value following Python's % operator rules
+func formatPyValue(spec pyFormatSpec, value any) (string, error) {
+	value = derefValue(value)
+	flags := spec.Flags
+	width := spec.Width
+
+	switch spec.Conversion {
+	case 'd', 'i', 'u':
+		n, err := pyInt(value)
+		if err != nil {
+			return "", err
+		}
+		// Python ignores precision for integers except as a minimum digit count
+		verb := "%" + strings.ReplaceAll(flags, "#", "") + width
+		if spec.Precision != "" {
+			verb += "." + spec.Precision
+		}
+		return fmt.Sprintf(verb+"d", n), nil
+
+	case 'o', 'x', 'X':
+		n, err := pyInt(value)
+		if err != nil {
+			return "", err
+		}
+		verb := string(spec.Conversion)
+		if spec.Conversion == 'o' && strings.Contains(flags, "#") {
+			// Python's alternate octal form is 0o, which is Go's %O
+			flags = strings.ReplaceAll(flags, "#", "")
+			verb = "O"
+		}
+		format := "%" + flags + width
+		if spec.Precision != "" {
+			format += "." + spec.Precision
+		}
+		return fmt.Sprintf(format+verb, n), nil
+
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		f, err := pyFloat(value)
+		if err != nil {
+			return "", err
+		}
+		precision := spec.Precision
+		if precision == "" {
+			precision = "6"
+		}
+		verb := spec.Conversion
+		if verb == 'F' {
+			verb = 'f'
+		}
+		result := fmt.Sprintf("%"+flags+width+"."+precision+string(verb), f)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			result = pyNonFinite(result, spec.Conversion)
+		}
+		return result, nil
+
+	case 'c':
+		var ch string
+		switch v := value.(type) {
+		case string:
+			if len([]rune(v)) != 1 {
+				return "", fmt.Errorf("requires an int or a single character, got a string of length %d", len([]rune(v)))
+			}
+			ch = v
+		default:
+			n, err := pyInt(value)
+			if err != nil {
+				return "", fmt.Errorf("requires an int or a single character")
+			}
+			ch = string(rune(n))
+		}
+		return fmt.Sprintf("%"+strings.ReplaceAll(flags, "0", "")+width+"s", ch), nil
+
+	case 's', 'r':
+		var str string
+		if spec.Conversion == 'r' {
+			str = pyRepr(value)
+		} else {
+			str = pyStr(value)
+		}
+		format := "%" + strings.ReplaceAll(flags, "0", "") + width
+		if spec.Precision != "" {
+			format += "." + spec.Precision
+		}
+		return fmt.Sprintf(format+"s", str), nil
+	}
+
+	return "", fmt.Errorf("unsupported conversion %q", spec.Conversion)
+}
+
+// pyNonFinite renders inf/nan the way Python does (inf, -inf, nan)
+func pyNonFinite(s string, conversion byte) string {
+	s = strings.ReplaceAll(s, "+Inf", "inf")
+	s = strings.ReplaceAll(s, "Inf", "inf")
+	s = strings.ReplaceAll(s, "NaN", "nan")
+	if conversion == 'E' || conversion == 'F' || conversion == 'G' {
+		s = strings.ToUpper(s)
+	}
+	return s
+}
+
+// pyInt converts a value for an integer conversion; like Python, floats are
+// truncated and booleans count as 0/1, but strings are rejected
+func pyInt(value any) (int64, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float32:
+		return int64(v), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return 0, fmt.Errorf("cannot be converted to an integer")
+		}
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("is not a number")
+}
+
+// pyFloat converts a value for a float conversion; strings are rejected as in Python
+func pyFloat(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	}
+	n, err := pyInt(value)
+	if err != nil {
+		return 0, err
+	}
+	return float64(n), nil
+}
+
+// pyStr renders a value the way Python's str() would for JSON-like data
+func pyStr(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "None"
+	case string:
+		return v
+	case bool, float64, float32:
+		return pyRepr(v)
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		return pyRepr(value)
+	}
+	return toString(value)
+}
+
+// pyRepr renders a value the way Python's repr() would for JSON-like data
+func pyRepr(value any) string {
+	value = derefValue(value)
+	switch v := value.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case string:
+		return pyQuote(v)
+	case float64:
+		return pyFloatRepr(v)
+	case float32:
+		return pyFloatRepr(float64(v))
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			items[i] = pyRepr(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprintf("%v", keys[i].Interface()) < fmt.Sprintf("%v", keys[j].Interface())
+		})
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = pyRepr(k.Interface()) + ": " + pyRepr(rv.MapIndex(k).Interface())
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+func pyFloatRepr(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
+
+// pyQuote quotes a string like Python's repr: single quotes unless the string
+// contains a single quote and no double quotes
+func pyQuote(s string) string {
+	quote := byte('\'')
+	if strings.Contains(s, "'") && !strings.Contains(s, "\"") {
+		quote = '"'
+	}
+
+	var b strings.Builder
+	b.WriteByte(quote)
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == rune(quote):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte(quote)
+	return b.String()
+}
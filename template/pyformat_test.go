@@ -0,0 +1,105 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPythonFormatSpecifiers(t *testing.T) {
+	data := map[string]any{
+		"count": 42,
+		"score": 3.14159,
+		"name":  "Ada",
+		"big":   255,
+		"flag":  true,
+		"items": []any{"a", 1.5, nil},
+		"quote": "it's",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected any
+	}{
+		{name: "plain s is unchanged", template: "%(name)s", expected: "Ada"},
+		{name: "zero padded int", template: "%(count)05d", expected: "00042"},
+		{name: "precision float", template: "%(score).2f", expected: "3.14"},
+		{name: "bare d keeps int type", template: "%(count)d", expected: 42},
+		{name: "bare i truncates float", template: "%(score)i", expected: 3},
+		{name: "bare f keeps float type", template: "%(count)f", expected: float64(42)},
+		{name: "exponent", template: "%(score).3e", expected: "3.142e+00"},
+		{name: "hex", template: "%(big)x", expected: "ff"},
+		{name: "alternate hex upper", template: "%(big)#X", expected: "0XFF"},
+		{name: "alternate octal", template: "%(big)#o", expected: "0o377"},
+		{name: "left aligned string", template: "[%(name)-6s]", expected: "[Ada   ]"},
+		{name: "right aligned string", template: "[%(name)6s]", expected: "[   Ada]"},
+		{name: "truncated string", template: "%(name).2s!", expected: "Ad!"},
+		{name: "sign flag", template: "%(count)+d", expected: "+42"},
+		{name: "bool as int", template: "%(flag)d", expected: 1},
+		{name: "repr string", template: "%(name)r", expected: "'Ada'"},
+		{name: "repr with quote", template: "%(quote)r", expected: `"it's"`},
+		{name: "repr list", template: "%(items)r", expected: "['a', 1.5, None]"},
+		{name: "percent escape", template: "%(count)d%% of %(name)s", expected: "42% of Ada"},
+		{name: "percent escape before key", template: "%%(name)s is %(name)s", expected: "%(name)s is Ada"},
+		{name: "percent without specifiers is untouched", template: "100%% sure", expected: "100%% sure"},
+		{name: "mixed with go templates", template: "{{name}} scored %(score)06.2f", expected: "Ada scored 003.14"},
+		{name: "several specifiers", template: "%(count)d/%(big)d", expected: "42/255"},
+		{name: "specifiers in string literals are text", template: `{{print "%(count)d"}} is %(count)03d`, expected: "%(count)d is 042"},
+		{name: "dot without precision is text", template: "%(name)s.", expected: "Ada."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Hydrate(tt.template, &data, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestPythonFormatErrors(t *testing.T) {
+	data := map[string]any{"name": "Ada", "count": 1}
+
+	t.Run("string with numeric conversion", func(t *testing.T) {
+		_, err := Hydrate("%(name)05d", &data, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "format %(name)05d: value of key 'name' (string) is not a number")
+	})
+
+	t.Run("string with numeric conversion in a larger template", func(t *testing.T) {
+		_, err := Hydrate("value: %(name).2f", &data, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not a number")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := Hydrate("%(missing)03d", &data, nil)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Equal(t, []string{"missing"}, infoErr.MissingKeys)
+	})
+
+	t.Run("missing key in a larger template", func(t *testing.T) {
+		_, err := Hydrate("n=%(missing)03d c=%(count)d", &data, nil)
+		var infoErr *InfoNeededError
+		require.True(t, errors.As(err, &infoErr))
+		assert.Equal(t, []string{"missing"}, infoErr.MissingKeys)
+	})
+
+	t.Run("optional missing key", func(t *testing.T) {
+		result, err := Hydrate("[%(missing?)03d]", &data, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "[]", result)
+	})
+}
+
+func TestFindPythonFormatKeys(t *testing.T) {
+	keys := FindTemplateKeyStringsToHydrate("%(count)05d %(score).2f %(name)s %(opt?)d", false, nil)
+	assert.ElementsMatch(t, []string{"count", "score", "name"}, keys)
+
+	keys = FindTemplateKeyStringsToHydrate(`{{print "%(quoted)d"}} %(count)d`, false, nil)
+	assert.Equal(t, []string{"count"}, keys)
+}
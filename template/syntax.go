@@ -0,0 +1,782 @@
+package template
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Erdo template syntax. A template is split into text, {{ }} actions and
+// Python format keys like %(key)s or %(count)05d, and each action is parsed
+// into control keywords and a pipeline of commands. Key discovery, the fast
+// paths in hydrateString and the rewrite to text/template in parseTemplate all
+// work on this one parse:
+//
+//   - keys: {{name}}, {{user.name}}, {{items.0.id}}, optional {{name?}} and %(name)s
+//   - function calls: {{add 1 2}}, nested with parens: {{toJSON (get "a")}}
+//   - pipelines and declarations: {{get "a" | toJSON}}, {{$x := get "a"}}
+//   - control actions: {{if ...}}, {{range $i, $v := ...}}, {{else if ...}}, {{end}}
+//   - string literals "..." and '...' with backslash escapes, and raw `...`,
+//     inside which braces, parens and pipes have no special meaning
+//   - whitespace control with {{- and -}}, and {{/* comments */}}
+//
+// Literal text that would otherwise be read as template syntax can be escaped:
+//
+//   - an action that is just a string literal outputs it as-is: {{"{{"}} or {{"{{name}}"}}
+//   - {% raw %}...{% endraw %} outputs everything in between as-is
+//   - %%( outputs a literal %(
+//
+// Literal text is never scanned for keys or treated as a template once output.
+//
+// Actions that don't parse are passed through unchanged so text/template
+// reports the error.
+
+// Segments
+// ========
+
+type segmentKind int
+
+const (
+	segmentText segmentKind = iota
+	segmentAction
+	segmentFormatKey
+	// segmentLiteral is escaped text: a raw region, string literal action or %%(
+	segmentLiteral
+)
+
+// segment is a piece of a template
+type segment struct {
+	kind segmentKind
+	// src is the segment's exact source
+	src string
+	// inner is the content of an action or format key without delimiters,
+	// whitespace control markers and surrounding whitespace
+	inner     string
+	trimLeft  bool
+	trimRight bool
+	// action is the parsed action; nil for comments and actions that don't parse
+	action *actionNode
+	// format is the conversion of a format key other than a plain %(key)s
+	format *pyFormatSpec
+	// text is the output of a literal segment
+	text string
+}
+
+const (
+	rawOpen  = "{% raw %}"
+	rawClose = "{% endraw %}"
+)
+
+// splitTemplate splits a template into text, action and format key segments.
+// Unterminated actions are left in the text.
+func splitTemplate(src string) []segment {
+	var segments []segment
+	textStart := 0
+	addText := func(end int) {
+		if end > textStart {
+			segments = append(segments, segment{kind: segmentText, src: src[textStart:end]})
+		}
+	}
+
+	pos := 0
+	for pos < len(src) {
+		next := nextDelimiter(src, pos)
+		if next < 0 {
+			break
+		}
+
+		var seg segment
+		var end int
+		var ok bool
+		switch {
+		case strings.HasPrefix(src[next:], rawOpen):
+			closing := strings.Index(src[next+len(rawOpen):], rawClose)
+			if ok = closing >= 0; ok {
+				end = next + len(rawOpen) + closing + len(rawClose)
+				seg = segment{kind: segmentLiteral, src: src[next:end], text: src[next+len(rawOpen) : end-len(rawClose)]}
+			}
+		case strings.HasPrefix(src[next:], "%%("):
+			seg, end, ok = segment{kind: segmentLiteral, src: "%%(", text: "%("}, next+3, true
+		case strings.HasPrefix(src[next:], "{{"):
+			end, ok = findActionEnd(src, next+2)
+			if ok {
+				seg = newActionSegment(src[next:end])
+			}
+		default:
+			seg, end, ok = formatKeySegment(src, next)
+		}
+		if !ok {
+			pos = next + 2
+			continue
+		}
+
+		addText(next)
+		segments = append(segments, seg)
+		pos = end
+		textStart = end
+	}
+	addText(len(src))
+	return segments
+}
+
+// nextDelimiter returns the index of the next {{, %(, %%( or raw region at or after pos, or -1
+func nextDelimiter(src string, pos int) int {
+	next := -1
+	for _, delim := range []string{"{{", "%%(", "%(", rawOpen} {
+		if i := strings.Index(src[pos:], delim); i >= 0 && (next < 0 || pos+i < next) {
+			next = pos + i
+		}
+	}
+	return next
+}
+
+// findActionEnd returns the index just past the }} closing the action whose
+// content starts at pos, skipping string literals and comments
+func findActionEnd(src string, pos int) (int, bool) {
+	i := pos
+	if hasTrimMarker(src[i:]) {
+		i += 2
+	}
+	if rest := strings.TrimLeft(src[i:], " \t\r\n"); strings.HasPrefix(rest, "/*") {
+		closing := strings.Index(rest, "*/")
+		if closing < 0 {
+			return 0, false
+		}
+		i = len(src) - len(rest) + closing + 2
+	}
+
+	for i < len(src) {
+		switch c := src[i]; c {
+		case '"', '\'':
+			end, ok := skipQuoted(src, i)
+			if !ok {
+				return 0, false
+			}
+			i = end
+		case '`':
+			closing := strings.IndexByte(src[i+1:], '`')
+			if closing < 0 {
+				return 0, false
+			}
+			i += closing + 2
+		case '}':
+			if strings.HasPrefix(src[i:], "}}") {
+				return i + 2, true
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return 0, false
+}
+
+// skipQuoted returns the index just past the quoted string starting at pos
+func skipQuoted(src string, pos int) (int, bool) {
+	quote := src[pos]
+	for i := pos + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// hasTrimMarker reports whether s starts with a whitespace control marker: a
+// dash followed by a space. A dash followed by a digit is a negative number.
+func hasTrimMarker(s string) bool {
+	return len(s) >= 2 && s[0] == '-' && isSpace(s[1])
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func newActionSegment(src string) segment {
+	seg := segment{kind: segmentAction, src: src}
+	inner := src[2 : len(src)-2]
+	if hasTrimMarker(inner) {
+		seg.trimLeft = true
+		inner = inner[2:]
+	}
+	if len(inner) >= 2 && inner[len(inner)-1] == '-' && isSpace(inner[len(inner)-2]) {
+		seg.trimRight = true
+		inner = inner[:len(inner)-2]
+	}
+	seg.inner = strings.TrimSpace(inner)
+
+	if !strings.HasPrefix(seg.inner, "/*") {
+		if action, err := parseAction(seg.inner); err == nil {
+			seg.action = action
+		}
+	}
+
+	// An action that is just a string literal is an escape for literal text
+	if arg, ok := seg.action.singleArg(); ok && arg.kind == tokenString {
+		seg.kind = segmentLiteral
+		seg.text, _ = arg.unquote()
+	}
+	return seg
+}
+
+// formatKeySegment parses a Python format key like %(key)s or %(count)05d at
+// pos. Keys can't contain whitespace or parens, or start with . or $.
+func formatKeySegment(src string, pos int) (segment, int, bool) {
+	i := pos + 2
+	for i < len(src) && isSpace(src[i]) {
+		i++
+	}
+	start := i
+	for i < len(src) && !isSpace(src[i]) && src[i] != '(' && src[i] != ')' {
+		i++
+	}
+	key := src[start:i]
+	for i < len(src) && isSpace(src[i]) {
+		i++
+	}
+	if key == "" || key[0] == '.' || key[0] == '$' || !strings.HasPrefix(src[i:], ")") {
+		return segment{}, 0, false
+	}
+	spec, n, ok := parsePyFormatSpec(src[i+1:])
+	if !ok {
+		return segment{}, 0, false
+	}
+	end := i + 1 + n
+	seg := segment{kind: segmentFormatKey, src: src[pos:end], inner: key}
+	if !spec.isPlain() || spec.Conversion != 's' {
+		spec.Key = key
+		seg.format = &spec
+	}
+	return seg, end, true
+}
+
+// key returns the key referenced by a plain key action like {{user.name?}} or a format key
+func (s segment) key() (Key, bool) {
+	switch s.kind {
+	case segmentFormatKey:
+		return ParseTemplateKey(s.inner), true
+	case segmentAction:
+		arg, ok := s.action.singleArg()
+		if !ok || arg.kind != tokenWord || isNiladicFunction(arg.src) {
+			return Key{}, false
+		}
+		return ParseTemplateKey(arg.src), true
+	}
+	return Key{}, false
+}
+
+// isNiladicFunction reports whether name is a function that takes no
+// arguments, like {{genUUID}} or {{noop}}, rather than a key
+func isNiladicFunction(name string) bool {
+	fn, ok := funcMap[name]
+	if !ok {
+		return false
+	}
+	fnType := reflect.TypeOf(fn)
+	return fnType.NumIn() == 0 || (fnType.NumIn() == 1 && fnType.IsVariadic())
+}
+
+// render wraps action content in delimiters, keeping the segment's whitespace control
+func (s segment) render(inner string) string {
+	var b strings.Builder
+	b.WriteString("{{")
+	if s.trimLeft {
+		b.WriteString("- ")
+	}
+	b.WriteString(inner)
+	if s.trimRight {
+		b.WriteString(" -")
+	}
+	b.WriteString("}}")
+	return b.String()
+}
+
+// hasTemplateSyntax reports whether s may contain template syntax, including escapes
+func hasTemplateSyntax(s string) bool {
+	return strings.Contains(s, "{{") || strings.Contains(s, "%(") || strings.Contains(s, rawOpen)
+}
+
+// literalPlaceholder marks where protectLiterals took out literal text. It uses
+// private use characters so it can't be mistaken for template syntax.
+const literalPlaceholder = "\uE000%d\uE001"
+
+// protectLiterals replaces the literal segments of a template with
+// placeholders, so the literal text isn't hydrated or scanned for keys, and
+// returns the literal text for restoreLiterals. Whitespace control on string
+// literal actions is kept with empty actions around the placeholder.
+func protectLiterals(src string) (string, []string) {
+	if !strings.Contains(src, "{{") && !strings.Contains(src, "%%(") && !strings.Contains(src, rawOpen) {
+		return src, nil
+	}
+
+	segments := splitTemplate(src)
+	var literals []string
+	var b strings.Builder
+	for _, seg := range segments {
+		if seg.kind != segmentLiteral {
+			b.WriteString(seg.src)
+			continue
+		}
+		if seg.trimLeft {
+			b.WriteString(`{{- "" }}`)
+		}
+		fmt.Fprintf(&b, literalPlaceholder, len(literals))
+		literals = append(literals, seg.text)
+		if seg.trimRight {
+			b.WriteString(`{{ "" -}}`)
+		}
+	}
+	if literals == nil {
+		return src, nil
+	}
+	return b.String(), literals
+}
+
+// hasLiterals reports whether a value is a template with literal text
+func hasLiterals(value any) bool {
+	str, ok := value.(string)
+	if !ok {
+		return false
+	}
+	_, literals := protectLiterals(str)
+	return len(literals) > 0
+}
+
+// restoreLiterals puts back the literal text taken out by protectLiterals
+func restoreLiterals(s string, literals []string) string {
+	if len(literals) == 0 || !strings.Contains(s, "\uE000") {
+		return s
+	}
+	pairs := make([]string, 0, 2*len(literals))
+	for i, literal := range literals {
+		pairs = append(pairs, fmt.Sprintf(literalPlaceholder, i), literal)
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// templateKeys returns the keys referenced by the key actions and format keys in a template
+func templateKeys(src string) []Key {
+	keys := []Key{}
+	if !strings.Contains(src, "{{") && !strings.Contains(src, "%(") {
+		return keys
+	}
+	for _, seg := range splitTemplate(src) {
+		if key, ok := seg.key(); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// singleSegment returns the segment when the whole template is one action or format key
+func singleSegment(src string) (segment, bool) {
+	if !strings.HasPrefix(src, "{{") && !strings.HasPrefix(src, "%(") {
+		return segment{}, false
+	}
+	segments := splitTemplate(src)
+	if len(segments) != 1 || segments[0].kind == segmentText {
+		return segment{}, false
+	}
+	return segments[0], true
+}
+
+// isOptionalKeyTemplate reports whether s is a single optional key reference like {{name?}}
+func isOptionalKeyTemplate(s string) bool {
+	seg, ok := singleSegment(s)
+	if !ok {
+		return false
+	}
+	key, ok := seg.key()
+	return ok && key.IsOptional
+}
+
+// removeOptionalKeys removes the optional key references for which keep returns false
+func removeOptionalKeys(src string, keep func(Key) bool) string {
+	if !strings.Contains(src, "?") {
+		return src
+	}
+	var b strings.Builder
+	for _, seg := range splitTemplate(src) {
+		if key, ok := seg.key(); ok && key.IsOptional && !keep(key) {
+			continue
+		}
+		b.WriteString(seg.src)
+	}
+	return b.String()
+}
+
+// Tokens
+// ======
+
+type tokenKind int
+
+const (
+	// tokenWord is a key, function name or keyword
+	tokenWord tokenKind = iota
+	// tokenField is a field of the template context like .Data.x, or . itself
+	tokenField
+	// tokenVariable is a template variable like $x or $.Data
+	tokenVariable
+	tokenString
+	tokenNumber
+	tokenLeftParen
+	tokenRightParen
+	tokenPipe
+	tokenComma
+	// tokenDeclare is := or =
+	tokenDeclare
+)
+
+type token struct {
+	kind tokenKind
+	src  string
+}
+
+// tokenizeAction splits the content of an action into tokens
+func tokenizeAction(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, src: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, src: ")"})
+			i++
+		case c == '|':
+			tokens = append(tokens, token{kind: tokenPipe, src: "|"})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, src: ","})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{kind: tokenDeclare, src: "="})
+			i++
+		case c == ':' && strings.HasPrefix(s[i:], ":="):
+			tokens = append(tokens, token{kind: tokenDeclare, src: ":="})
+			i += 2
+		case c == '"' || c == '\'':
+			end, ok := skipQuoted(s, i)
+			if !ok {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			tokens = append(tokens, token{kind: tokenString, src: s[i:end]})
+			i = end
+		case c == '`':
+			closing := strings.IndexByte(s[i+1:], '`')
+			if closing < 0 {
+				return nil, fmt.Errorf("unterminated raw string in %q", s)
+			}
+			tokens = append(tokens, token{kind: tokenString, src: s[i : i+closing+2]})
+			i += closing + 2
+		default:
+			end := i + 1
+			for end < len(s) && !isWordBoundary(s, end, c == '$') {
+				end++
+			}
+			tokens = append(tokens, token{kind: wordKind(s[i:end]), src: s[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// isWordBoundary reports whether the word being scanned ends at s[i]. Variable
+// names also end at a declaration so $x:=1 splits like $x := 1.
+func isWordBoundary(s string, i int, variable bool) bool {
+	switch c := s[i]; {
+	case isSpace(c), strings.IndexByte("()|,\"'`", c) >= 0:
+		return true
+	case variable && (c == '=' || (c == ':' && strings.HasPrefix(s[i:], ":="))):
+		return true
+	}
+	return false
+}
+
+func wordKind(word string) tokenKind {
+	isDigit := func(i int) bool { return i < len(word) && word[i] >= '0' && word[i] <= '9' }
+	switch {
+	case isDigit(0), (word[0] == '-' || word[0] == '+' || word[0] == '.') && isDigit(1):
+		return tokenNumber
+	case word[0] == '.':
+		return tokenField
+	case word[0] == '$':
+		return tokenVariable
+	default:
+		return tokenWord
+	}
+}
+
+// AST
+// ===
+
+// actionNode is a parsed action: optional control keywords followed by a pipeline
+type actionNode struct {
+	// keywords holds the control keywords, e.g. [else if] for {{else if x}}
+	keywords []string
+	// pipe is nil for actions that are just keywords, like {{end}}
+	pipe *pipeNode
+}
+
+// pipeNode is a pipeline: optional variable declarations, then commands separated by |
+type pipeNode struct {
+	decl []string
+	// declare is the declaration operator, := or =
+	declare string
+	cmds    []*commandNode
+}
+
+// commandNode is a function call or a single value
+type commandNode struct {
+	args []*argNode
+}
+
+// argNode is an argument: a literal, key, field, variable or parenthesised pipeline
+type argNode struct {
+	kind tokenKind
+	src  string
+	// pipe is set for parenthesised pipelines, whose kind is tokenLeftParen
+	pipe *pipeNode
+}
+
+func (a *actionNode) String() string {
+	parts := slices.Clone(a.keywords)
+	if a.pipe != nil {
+		parts = append(parts, a.pipe.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *pipeNode) String() string {
+	cmds := make([]string, len(p.cmds))
+	for i, cmd := range p.cmds {
+		cmds[i] = cmd.String()
+	}
+	s := strings.Join(cmds, " | ")
+	if len(p.decl) > 0 {
+		s = strings.Join(p.decl, ", ") + " " + p.declare + " " + s
+	}
+	return s
+}
+
+func (c *commandNode) String() string {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.String()
+	}
+	return strings.Join(args, " ")
+}
+
+func (a *argNode) String() string {
+	if a.pipe != nil {
+		return "(" + a.pipe.String() + ")"
+	}
+	return a.src
+}
+
+// singleArg returns the argument of an action that is a single value
+func (a *actionNode) singleArg() (*argNode, bool) {
+	if a == nil || len(a.keywords) > 0 {
+		return nil, false
+	}
+	return a.pipe.singleArg()
+}
+
+// singleArg returns the argument of a pipeline that is a single value
+func (p *pipeNode) singleArg() (*argNode, bool) {
+	if p == nil || len(p.decl) > 0 || len(p.cmds) != 1 || len(p.cmds[0].args) != 1 {
+		return nil, false
+	}
+	return p.cmds[0].args[0], true
+}
+
+// name returns the function name of a command, or "" if it doesn't start with a word
+func (c *commandNode) name() string {
+	if c.args[0].kind != tokenWord {
+		return ""
+	}
+	return c.args[0].src
+}
+
+// walk calls fn for every command in the pipeline, including those in parens
+func (p *pipeNode) walk(fn func(*commandNode)) {
+	if p == nil {
+		return
+	}
+	for _, cmd := range p.cmds {
+		fn(cmd)
+		for _, arg := range cmd.args {
+			arg.pipe.walk(fn)
+		}
+	}
+}
+
+// unquote returns the value of a string literal argument
+func (a *argNode) unquote() (string, bool) {
+	if a.kind != tokenString {
+		return "", false
+	}
+	return unquoteLiteral(a.src), true
+}
+
+// unquoteLiteral returns the value of a "...", '...' or `...` string literal.
+// Quoted strings take Go escape sequences like \x7b and \u00e9; ones Go doesn't
+// accept fall back to the common escapes (\n, \t, \r, quotes and backslash).
+func unquoteLiteral(src string) string {
+	body := src[1 : len(src)-1]
+	switch src[0] {
+	case '`':
+		return body
+	case '\'':
+		// Rewrite as a double-quoted literal: \' needs no escape there, but " does
+		var b strings.Builder
+		for i := 0; i < len(body); i++ {
+			switch {
+			case body[i] == '\\' && i+1 < len(body):
+				if body[i+1] != '\'' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(body[i+1])
+				i++
+			case body[i] == '"':
+				b.WriteString(`\"`)
+			default:
+				b.WriteByte(body[i])
+			}
+		}
+		if value, err := strconv.Unquote(`"` + b.String() + `"`); err == nil {
+			return value
+		}
+	default:
+		if value, err := strconv.Unquote(src); err == nil {
+			return value
+		}
+	}
+	return interpretEscapeSequences(body)
+}
+
+// Parser
+// ======
+
+// parseAction parses the content of an action
+func parseAction(s string) (*actionNode, error) {
+	tokens, err := tokenizeAction(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty action")
+	}
+
+	action := &actionNode{}
+	for len(tokens) > 0 && tokens[0].kind == tokenWord && slices.Contains(reservedWords, tokens[0].src) {
+		action.keywords = append(action.keywords, tokens[0].src)
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return action, nil
+	}
+
+	p := &actionParser{tokens: tokens}
+	action.pipe, err = p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in action %q", p.tokens[p.pos].src, s)
+	}
+	return action, nil
+}
+
+type actionParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *actionParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// parsePipeline parses a pipeline up to the end of the tokens or a closing paren
+func (p *actionParser) parsePipeline() (*pipeNode, error) {
+	pipe := &pipeNode{}
+	p.parseDeclaration(pipe)
+
+	for {
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		pipe.cmds = append(pipe.cmds, cmd)
+
+		tok, ok := p.peek()
+		if !ok || tok.kind != tokenPipe {
+			return pipe, nil
+		}
+		p.pos++
+	}
+}
+
+// parseDeclaration parses variable declarations like $x := or $i, $v :=
+// at the start of a pipeline, if there are any
+func (p *actionParser) parseDeclaration(pipe *pipeNode) {
+	var decl []string
+	for i := p.pos; i < len(p.tokens); i += 2 {
+		if p.tokens[i].kind != tokenVariable || i+1 >= len(p.tokens) {
+			return
+		}
+		decl = append(decl, p.tokens[i].src)
+		switch p.tokens[i+1].kind {
+		case tokenComma:
+			continue
+		case tokenDeclare:
+			pipe.decl = decl
+			pipe.declare = p.tokens[i+1].src
+			p.pos = i + 2
+		}
+		return
+	}
+}
+
+func (p *actionParser) parseCommand() (*commandNode, error) {
+	cmd := &commandNode{}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokenPipe || tok.kind == tokenRightParen {
+			break
+		}
+		p.pos++
+
+		switch tok.kind {
+		case tokenLeftParen:
+			pipe, err := p.parsePipeline()
+			if err != nil {
+				return nil, err
+			}
+			if closing, ok := p.peek(); !ok || closing.kind != tokenRightParen {
+				return nil, fmt.Errorf("unclosed paren")
+			}
+			p.pos++
+			cmd.args = append(cmd.args, &argNode{kind: tokenLeftParen, pipe: pipe})
+		case tokenWord, tokenField, tokenVariable, tokenString, tokenNumber:
+			cmd.args = append(cmd.args, &argNode{kind: tok.kind, src: tok.src})
+		default:
+			return nil, fmt.Errorf("unexpected %q", tok.src)
+		}
+	}
+	if len(cmd.args) == 0 {
+		return nil, fmt.Errorf("missing value for command")
+	}
+	return cmd, nil
+}
@@ -0,0 +1,191 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitTemplate(t *testing.T) {
+	segments := splitTemplate(`a {{name}} b {{- printf "}}" -}} %(key?)s {{/* it's {{x}} */}} {{ unterminated`)
+
+	var kinds []segmentKind
+	var srcs []string
+	for _, seg := range segments {
+		kinds = append(kinds, seg.kind)
+		srcs = append(srcs, seg.src)
+	}
+	assert.Equal(t, []segmentKind{
+		segmentText, segmentAction, segmentText, segmentAction, segmentText,
+		segmentFormatKey, segmentText, segmentAction, segmentText,
+	}, kinds)
+	assert.Equal(t, []string{
+		"a ", "{{name}}", " b ", `{{- printf "}}" -}}`, " ",
+		"%(key?)s", " ", "{{/* it's {{x}} */}}", " {{ unterminated",
+	}, srcs)
+
+	assert.True(t, segments[3].trimLeft)
+	assert.True(t, segments[3].trimRight)
+	assert.Equal(t, `printf "}}"`, segments[3].inner)
+	assert.Nil(t, segments[7].action)
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		keywords []string
+		pipe     string
+		wantErr  bool
+	}{
+		{name: "key", action: "user.name?", pipe: "user.name?"},
+		{name: "function call", action: `get "a b" 1 -2 .Data $x`, pipe: `get "a b" 1 -2 .Data $x`},
+		{name: "nested calls", action: `toJSON (dict "a" (get "b"))`, pipe: `toJSON (dict "a" (get "b"))`},
+		{name: "pipeline", action: `get "a"|toJSON`, pipe: `get "a" | toJSON`},
+		{name: "declaration", action: `$x:=get "a"`, pipe: `$x := get "a"`},
+		{name: "range", action: `range $i, $v := get "items"`, keywords: []string{"range"}, pipe: `$i, $v := get "items"`},
+		{name: "else if", action: "else if .Data.x", keywords: []string{"else", "if"}, pipe: ".Data.x"},
+		{name: "end", action: "end", keywords: []string{"end"}},
+		{name: "raw string", action: "regexReplace `\\d{2}` \"#\" .Data.x", pipe: "regexReplace `\\d{2}` \"#\" .Data.x"},
+		{name: "escaped quote", action: `printf "a\"b)"`, pipe: `printf "a\"b)"`},
+		{name: "unclosed paren", action: `toJSON (get "a"`, wantErr: true},
+		{name: "unexpected paren", action: `get "a")`, wantErr: true},
+		{name: "unterminated string", action: `get "a`, wantErr: true},
+		{name: "empty command", action: `get "a" |`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := parseAction(tt.action)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.keywords, action.keywords)
+			if tt.pipe == "" {
+				assert.Nil(t, action.pipe)
+			} else {
+				assert.Equal(t, tt.pipe, action.pipe.String())
+			}
+		})
+	}
+}
+
+func TestTemplateGrammar(t *testing.T) {
+	state := map[string]any{
+		"name":  "Ada",
+		"code":  "a12b",
+		"items": []any{"x", "y"},
+	}
+
+	t.Run("braces in string arguments", func(t *testing.T) {
+		result, err := HydrateString(`{{replace (get "name") "A" "}"}}`, &state)
+		require.NoError(t, err)
+		assert.Equal(t, "}da", result)
+
+		result, err = HydrateString(`code: {{regexReplace "[0-9]{2}" "#" (get "code")}}`, &state)
+		require.NoError(t, err)
+		assert.Equal(t, "code: a#b", result)
+	})
+
+	t.Run("keys are found with the same grammar", func(t *testing.T) {
+		keys := FindTemplateKeysToHydrate(
+			`{{a}} {{if b}}{{c?}}{{else}}{{end}} %(d)s {{printf "{{e}}"}} {{- f -}} {{genUUID}}`, true, nil)
+		assert.Equal(t, []Key{
+			{Key: "a"},
+			{Key: "c", IsOptional: true},
+			{Key: "d"},
+			{Key: "f"},
+		}, keys)
+	})
+
+	t.Run("keys keep whitespace control", func(t *testing.T) {
+		result, err := HydrateString("Hello,\n  {{- name -}}  \n!", &state)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello,Ada!", result)
+	})
+
+	t.Run("declarations and pipelines get data", func(t *testing.T) {
+		result, err := HydrateString(`{{$n := get "name"}}{{$n}} {{get "items" | len}}`, &state)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada 2", result)
+
+		result, err = HydrateString(`{{range $i, $v := get "items"}}{{$i}}={{$v}} {{end}}`, &state)
+		require.NoError(t, err)
+		assert.Equal(t, "0=x 1=y ", result)
+	})
+
+	t.Run("optional keys with spaces are removed", func(t *testing.T) {
+		result, err := HydrateString("a{{ missing? }}b", &state)
+		require.NoError(t, err)
+		assert.Equal(t, "ab", result)
+	})
+
+	t.Run("comments", func(t *testing.T) {
+		result, err := HydrateString("a{{/* {{name}} isn't used */}}b", &state)
+		require.NoError(t, err)
+		assert.Equal(t, "ab", result)
+		assert.Empty(t, FindTemplateKeysToHydrate("{{/* {{name}} */}}", true, nil))
+	})
+
+	t.Run("rewriting is idempotent", func(t *testing.T) {
+		parsed, err := parseTemplate(`{{name}} {{if gt (len (get "items")) 1}}many{{end}}`)
+		require.NoError(t, err)
+		again, err := parseTemplate(parsed)
+		require.NoError(t, err)
+		assert.Equal(t, parsed, again)
+	})
+}
+
+func TestLiteralText(t *testing.T) {
+	state := map[string]any{"name": "Ada", "count": 3}
+
+	tests := []struct {
+		name     string
+		template string
+		expected any
+	}{
+		{name: "string literal action", template: `{{"{{"}}name}} is {{name}}`, expected: "{{name}} is Ada"},
+		{name: "whole literal isn't a key", template: `{{"{{missing?}}"}}`, expected: "{{missing?}}"},
+		{name: "literal numbers stay strings", template: `{{"42"}}`, expected: "42"},
+		{name: "raw region", template: `{% raw %}Hello {{ user.name }}, %(greeting)s{% endraw %} from {{name}}`, expected: "Hello {{ user.name }}, %(greeting)s from Ada"},
+		{name: "raw region without template syntax", template: `{% raw %}{% for x in y %}{% endraw %}`, expected: "{% for x in y %}"},
+		{name: "escaped format key", template: `%%(count)d is %(count)d`, expected: "%(count)d is 3"},
+		{name: "whitespace control", template: "a  {{- \"{{\" -}}  b", expected: "a{{b"},
+		{name: "hex escapes", template: `{{"\x7b\x7b"}}name}}`, expected: "{{name}}"},
+		{name: "unicode escapes", template: `{{"caf\u00e9"}}`, expected: "café"},
+		{name: "single quoted escapes", template: `{{'it\'s "\x7b"'}}`, expected: `it's "{"`},
+		{name: "escapes in function arguments", template: `{{upper "\x7b\x7d"}} {{name}}`, expected: "{} Ada"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := HydrateString(tt.template, &state)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+
+	t.Run("literal text is never scanned for keys", func(t *testing.T) {
+		params := map[string]any{
+			"prompt": `{% raw %}{{ missing }} %(missing_format)d{% endraw %}{{"{{missing_literal}}"}}%%(missing_escaped)s`,
+			"other":  "{{name}}",
+		}
+		assert.Equal(t, []string{"name"}, FindTemplateKeyStringsToHydrate(params, true, nil))
+
+		result, err := Hydrate(params, &state, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"prompt": "{{ missing }} %(missing_format)d{{missing_literal}}%(missing_escaped)s",
+			"other":  "Ada",
+		}, result)
+	})
+
+	t.Run("literals are rewritten for text/template", func(t *testing.T) {
+		parsed, err := parseTemplate(`{% raw %}{{x}}{% endraw %}%%(y)s`)
+		require.NoError(t, err)
+		assert.Equal(t, `{{"{{x}}"}}{{"%("}}y)s`, parsed)
+	})
+}
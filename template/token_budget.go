@@ -0,0 +1,96 @@
+package template
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"text/template"
+
+	common "github.com/erdoai/erdo-common/types"
+	. "github.com/erdoai/erdo-common/utils"
+)
+
+// tokenBudgetFuncs returns the token budget functions bound to the tokenizer
+func tokenBudgetFuncs(t common.Tokenizer) template.FuncMap {
+	return template.FuncMap{
+		"sliceByTokenBudget": func(sliceKey string, budget int, data map[string]any, missingKeys *[]string) []any {
+			return sliceMessagesByTokenBudget(sliceKey, budget, nil, t, data, missingKeys)
+		},
+		"sliceByTokenBudgetWithPlaceholder": func(sliceKey string, budget int, placeholder string, data map[string]any, missingKeys *[]string) []any {
+			return sliceMessagesByTokenBudget(sliceKey, budget, &placeholder, t, data, missingKeys)
+		},
+	}
+}
+
+// sliceByTokenBudget returns the most recent messages that fit in budget tokens,
+// keeping the latest user message before them like sliceEndKeepFirstUserMessage.
+// See common.SliceByTokenBudget.
+func sliceByTokenBudget(sliceKey string, budget int, data map[string]any, missingKeys *[]string) []any {
+	return sliceMessagesByTokenBudget(sliceKey, budget, nil, common.DefaultTokenizer, data, missingKeys)
+}
+
+// sliceByTokenBudgetWithPlaceholder is sliceByTokenBudget that puts a user message
+// in place of the dropped messages. Any %d in the placeholder is replaced with
+// the number of dropped messages; an empty placeholder uses the text of
+// common.OmittedMessagesPlaceholder.
+// Example: {{sliceByTokenBudgetWithPlaceholder "messages" 8000 "[%d messages omitted]"}}
+func sliceByTokenBudgetWithPlaceholder(sliceKey string, budget int, placeholder string, data map[string]any, missingKeys *[]string) []any {
+	return sliceMessagesByTokenBudget(sliceKey, budget, &placeholder, common.DefaultTokenizer, data, missingKeys)
+}
+
+// sliceMessagesByTokenBudget implements the token budget functions. A nil
+// placeholder drops messages without a note.
+func sliceMessagesByTokenBudget(sliceKey string, budget int, placeholder *string, t common.Tokenizer, data map[string]any, missingKeys *[]string) []any {
+	_slice := get(sliceKey, data, missingKeys)
+	if _slice == nil {
+		addMissingKey(missingKeys, sliceKey)
+		return nil
+	}
+
+	slice := ToAnySlice(_slice)
+	if slice == nil {
+		log.Printf("sliceByTokenBudget: key %q is not a slice, got %T", sliceKey, _slice)
+		addMissingKey(missingKeys, sliceKey)
+		return nil
+	}
+
+	var placeholderFunc func([]any) (any, bool)
+	if placeholder != nil {
+		placeholderFunc = func(dropped []any) (any, bool) {
+			return placeholderMessage(*placeholder, len(dropped)), true
+		}
+	}
+
+	return common.SliceByTokenBudgetFunc(slice, budget,
+		func(msg any) int { return messageTokens(msg, t) },
+		isUserMessage,
+		placeholderFunc,
+	)
+}
+
+// placeholderMessage builds the user message standing in for dropped messages
+func placeholderMessage(text string, dropped int) map[string]any {
+	if text == "" {
+		message := common.OmittedMessagesPlaceholder(make([]common.Message, dropped))
+		return map[string]any{"role": message.Role, "content": message.Content}
+	}
+	return map[string]any{
+		"role":    "user",
+		"content": strings.ReplaceAll(text, "%d", strconv.Itoa(dropped)),
+	}
+}
+
+// messageTokens counts a message's content, or the whole message when its
+// content isn't a string
+func messageTokens(msg any, t common.Tokenizer) int {
+	content, ok := GetFieldValue(msg, "content").(string)
+	if !ok {
+		encoded, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("sliceByTokenBudget: error encoding message: %v", err)
+		}
+		content = string(encoded)
+	}
+	return t.CountTokens(content) + common.MessageTokenOverhead
+}
@@ -0,0 +1,110 @@
+package template
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSliceByTokenBudget(t *testing.T) {
+	messages := []any{
+		map[string]any{"role": "user", "content": "first question"},
+		map[string]any{"role": "assistant", "content": strings.Repeat("long answer ", 50)},
+		map[string]any{"role": "user", "content": "second question"},
+		map[string]any{"role": "assistant", "content": "short answer"},
+		map[string]any{"role": "assistant", "content": []any{map[string]any{"type": "text", "text": "parts"}}},
+	}
+
+	t.Run("template usage", func(t *testing.T) {
+		params := map[string]any{"messages": messages}
+		result, err := Hydrate(`{{sliceByTokenBudget "messages" 40}}`, &params, nil)
+		require.NoError(t, err)
+		assert.Equal(t, messages[2:], result)
+
+		result, err = Hydrate(`{{sliceByTokenBudget "messages" 30}}`, &params, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []any{messages[2], messages[4]}, result)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		missingKeys := []string{}
+		assert.Nil(t, sliceByTokenBudget("nonexistent", 100, map[string]any{}, &missingKeys))
+		assert.Equal(t, []string{"nonexistent"}, missingKeys)
+	})
+
+	t.Run("custom tokenizer", func(t *testing.T) {
+		params := map[string]any{"messages": messages}
+		behaviour := WithTokenizer(nil, common.TokenizerFunc(func(string) int { return 100 }))
+		result, err := Hydrate(`{{sliceByTokenBudget "messages" 250}}`, &params, behaviour)
+		require.NoError(t, err)
+		assert.Equal(t, []any{messages[2], messages[4]}, result)
+
+		// The default tokenizer keeps everything for the same budget
+		result, err = Hydrate(`{{sliceByTokenBudget "messages" 250}}`, &params, nil)
+		require.NoError(t, err)
+		assert.Equal(t, messages, result)
+	})
+
+	t.Run("tokenizer applies inside nested values and partials", func(t *testing.T) {
+		partials, err := NewPartials(map[string]string{"window": `{{toJSON (sliceByTokenBudget "messages" 250)}}`})
+		require.NoError(t, err)
+		params := map[string]any{"messages": messages}
+		behaviour := WithPartials(WithTokenizer(nil, common.TokenizerFunc(func(string) int { return 100 })), partials)
+
+		result, err := Hydrate(map[string]any{
+			"window":  `{{sliceByTokenBudget "messages" 250}}`,
+			"partial": `{{include "window" .}}`,
+		}, &params, behaviour)
+		require.NoError(t, err)
+		expected := []any{messages[2], messages[4]}
+		assert.Equal(t, expected, result.(map[string]any)["window"])
+		encoded, err := json.Marshal(expected)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(encoded), result.(map[string]any)["partial"].(string))
+	})
+
+	t.Run("concurrent tokenizers", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				params := map[string]any{"messages": messages}
+				tokens, expected := 1, messages
+				if i%2 == 0 {
+					tokens, expected = 100, []any{messages[2], messages[4]}
+				}
+				behaviour := WithTokenizer(nil, common.TokenizerFunc(func(string) int { return tokens }))
+				result, err := Hydrate(`{{sliceByTokenBudget "messages" 250}}`, &params, behaviour)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, result)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("placeholder", func(t *testing.T) {
+		params := map[string]any{"messages": messages}
+		result, err := Hydrate(`{{sliceByTokenBudgetWithPlaceholder "messages" 40 "[%d messages omitted]"}}`, &params, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []any{
+			messages[2],
+			map[string]any{"role": "user", "content": "[3 messages omitted]"},
+			messages[4],
+		}, result)
+	})
+
+	t.Run("default placeholder", func(t *testing.T) {
+		missingKeys := []string{}
+		result := sliceByTokenBudgetWithPlaceholder("messages", 60, "", map[string]any{"messages": messages}, &missingKeys)
+		assert.Equal(t, []any{
+			map[string]any{"role": "user", "content": "[2 earlier messages were omitted to fit the context window]"},
+			messages[2], messages[3], messages[4],
+		}, result)
+	})
+}
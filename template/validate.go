@@ -0,0 +1,46 @@
+package template
+
+import (
+	"fmt"
+	"text/template"
+
+	common "github.com/erdoai/erdo-common/types"
+)
+
+func init() {
+	// Let the Validate methods in the types package check template syntax
+	common.RegisterTemplateValidator(validateTemplates)
+}
+
+// validateTemplates checks the templates in a value hydrated with the policy,
+// skipping the values it leaves raw or drops
+func validateTemplates(value any, policy common.HydrationPolicy, syntax common.TemplateSyntax) ([]*common.ValidationError, error) {
+	var problems []*common.ValidationError
+	err := walkPolicyTemplates(value, &policy, syntax, func(path valuePath, s string, syntax common.TemplateSyntax) {
+		if err := ValidateTemplate(s, syntax); err != nil {
+			problems = append(problems, &common.ValidationError{Path: path.String(), Message: fmt.Sprintf("invalid template: %v", err)})
+		}
+	})
+	return problems, err
+}
+
+// ValidateTemplate checks that a template parses in the given syntax without
+// hydrating it, so it reports unknown functions and malformed actions but not
+// missing keys or errors that only happen at execution time
+func ValidateTemplate(s string, syntax common.TemplateSyntax) error {
+	if syntax == common.TemplateSyntaxJinja {
+		compiled, err := CompileJinja(s)
+		if err != nil {
+			return err
+		}
+		s = compiled
+	}
+	if !hasTemplateSyntax(s) {
+		return nil
+	}
+
+	if _, err := template.New("validate").Funcs(funcMap).Parse(rewriteTemplate(s)); err != nil {
+		return fmt.Errorf("invalid template syntax: %w", err)
+	}
+	return nil
+}
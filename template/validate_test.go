@@ -0,0 +1,187 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	common "github.com/erdoai/erdo-common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		syntax   common.TemplateSyntax
+		wantErr  bool
+	}{
+		{name: "plain text", template: "hello", syntax: common.TemplateSyntaxErdo},
+		{name: "key", template: "{{user.name?}} %(count)d", syntax: common.TemplateSyntaxErdo},
+		{name: "data function", template: `{{toJSON (get "messages")}}`, syntax: common.TemplateSyntaxErdo},
+		{name: "literals", template: `{% raw %}{{ x | y }}{% endraw %}{{"{{"}}`, syntax: common.TemplateSyntaxErdo},
+		{name: "unknown function", template: "{{nosuchfunc name}}", syntax: common.TemplateSyntaxErdo, wantErr: true},
+		{name: "unclosed if", template: `{{if get "a"}}x`, syntax: common.TemplateSyntaxErdo, wantErr: true},
+		{name: "jinja", template: "{{ name | upper }}{% if x %}y{% endif %}", syntax: common.TemplateSyntaxJinja},
+		{name: "jinja unclosed block", template: "{% if x %}y", syntax: common.TemplateSyntaxJinja, wantErr: true},
+		{name: "missing keys aren't errors", template: "{{not_in_state}}", syntax: common.TemplateSyntaxErdo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTemplate(tt.template, tt.syntax)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateBotDefinitions(t *testing.T) {
+	// Importing the template package registers its validator
+	require.True(t, common.TemplateChecksEnabled())
+
+	strPtr := func(s string) *string { return &s }
+	validationPaths := func(t *testing.T, err error) []string {
+		t.Helper()
+		var errs common.ValidationErrors
+		require.True(t, errors.As(err, &errs), "expected ValidationErrors, got %v", err)
+		var paths []string
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		return paths
+	}
+
+	validStep := func(key string) common.Step {
+		return common.Step{
+			ActionType:        "llm.message",
+			Key:               strPtr(key),
+			OutputContentType: string(common.OutputContentTypeText),
+			Parameters:        map[string]any{"prompt": "{{query}}"},
+		}
+	}
+
+	t.Run("valid step", func(t *testing.T) {
+		assert.NoError(t, validStep("a").Validate())
+	})
+
+	t.Run("step fields", func(t *testing.T) {
+		step := validStep("a")
+		step.OutputContentType = "xml"
+		step.UserOutputVisibility = "shown"
+		step.ExecutionMode = common.ExecutionMode{
+			Mode:        "sometimes",
+			IfCondition: &common.ConditionDefinition{Type: "and"},
+		}
+		step.OutputBehaviour = common.OutputBehavior{"result": "replace"}
+		step.Parameters = map[string]any{
+			"tools": []any{map[string]any{"name": "{{nosuchfunc x}}"}},
+		}
+
+		err := step.Validate()
+		assert.Equal(t, []string{
+			"output_content_type",
+			"user_output_visibility",
+			"execution_mode.mode",
+			"execution_mode.if_condition.conditions",
+			"output_behaviour.result",
+			"parameters.tools[0].name",
+		}, validationPaths(t, err))
+		assert.Contains(t, err.Error(), `output_content_type: invalid value "xml"`)
+	})
+
+	t.Run("raw parameters aren't templates", func(t *testing.T) {
+		step := validStep("a")
+		step.Parameters = map[string]any{"code": "{{ not a template"}
+		behaviour := common.ParameterHydrationBehaviourRaw
+		step.ParameterHydrationBehaviour = &behaviour
+		assert.NoError(t, step.Validate())
+	})
+
+	t.Run("policy paths left raw or dropped aren't templates", func(t *testing.T) {
+		step := validStep("a")
+		step.Parameters = map[string]any{
+			"prompt": "{{query}}",
+			"code":   "{{ not a template",
+			"tools":  []any{map[string]any{"example": "{{nosuchfunc}}", "name": "{{nosuchfunc x}}"}},
+		}
+		step.HydrationPolicy = &common.HydrationPolicy{Rules: []common.HydrationRule{
+			{Path: "code", Behaviour: common.ParameterHydrationBehaviourRaw},
+			{Path: "tools[*].example", Behaviour: common.ParameterHydrationBehaviourNone},
+		}}
+		assert.Equal(t, []string{"parameters.tools[0].name"}, validationPaths(t, step.Validate()))
+
+		step.HydrationPolicy.Rules = append(step.HydrationPolicy.Rules, common.HydrationRule{Path: "tools[x]", Behaviour: common.ParameterHydrationBehaviourRaw})
+		assert.Equal(t, []string{"hydration_policy"}, validationPaths(t, step.Validate()))
+	})
+
+	t.Run("conditions", func(t *testing.T) {
+		condition := common.ConditionDefinition{
+			Type: "or",
+			Conditions: []common.ConditionDefinition{
+				{Type: "IsSuccess"},
+				{Type: "not", Conditions: []common.ConditionDefinition{{}, {Type: "IsError"}}},
+				{Type: "GreaterThan", Leaf: map[string]any{"value": 1}, Conditions: []common.ConditionDefinition{{Type: "IsNull"}}},
+			},
+		}
+		assert.Equal(t, []string{
+			"conditions[1].conditions",
+			"conditions[1].conditions[0].type",
+			"conditions[2].conditions",
+		}, validationPaths(t, condition.Validate()))
+	})
+
+	t.Run("result handlers", func(t *testing.T) {
+		handler := common.ResultHandler{
+			Type:  "sometimes",
+			Steps: []common.StepWithHandlers{{Step: validStep("b")}, {Step: validStep("b")}},
+		}
+		assert.Equal(t, []string{"type"}, validationPaths(t, handler.Validate()))
+
+		step := common.StepWithHandlers{Step: validStep("a"), ResultHandlers: []common.ResultHandler{handler}}
+		assert.Equal(t, []string{
+			"result_handlers[0].type",
+			"result_handlers[0].steps[1].step.key",
+		}, validationPaths(t, step.Validate()))
+	})
+
+	t.Run("upsert request", func(t *testing.T) {
+		apiStep := func(key string, dependsOn ...string) common.APIStepWithHandlers {
+			return common.APIStepWithHandlers{Step: common.APIStep{
+				ActionType:        "llm.message",
+				Key:               strPtr(key),
+				DependsOn:         &dependsOn,
+				OutputContentType: string(common.OutputContentTypeText),
+				Parameters:        json.RawMessage(`{"prompt": "{{ query | upper }}"}`),
+			}}
+		}
+
+		first := apiStep("first")
+		first.ResultHandlers = []common.APIResultHandler{{
+			Type:         string(common.HandlerTypeFinal),
+			IfConditions: common.APIConditionDefinition{Type: "IsSuccess"},
+			Steps:        []common.APIStepWithHandlers{apiStep("handled", "first")},
+		}}
+		request := common.UpsertBotRequest{
+			Bot:   common.Bot{Name: "Bot", TemplateSyntax: common.TemplateSyntaxJinja},
+			Steps: []common.APIStepWithHandlers{first, apiStep("second", "first", "handled")},
+		}
+		require.NoError(t, request.Validate())
+
+		request.Bot.Name = ""
+		request.Steps = append(request.Steps, apiStep("second", "second", "nope"))
+		assert.Equal(t, []string{
+			"bot.name",
+			"steps[2].step.key",
+			"steps[2].step.depends_on[0]",
+			"steps[2].step.depends_on[1]",
+		}, validationPaths(t, request.Validate()))
+
+		request.Steps[2].Step.Parameters = json.RawMessage(`{`)
+		assert.Contains(t, validationPaths(t, request.Validate()), "steps[2]")
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,6 +30,10 @@ type Bot struct {
 	OrganizationID        string     `json:"organization_id"`
 	Visibility            string     `json:"visibility"`
 	Source                string     `json:"source"`
+	// Named template partials usable from step parameters via {{include "name" .}}
+	Partials map[string]string `json:"partials,omitempty"`
+	// TemplateSyntax selects the template syntax of the bot's step parameters (default: erdo)
+	TemplateSyntax TemplateSyntax `json:"template_syntax,omitempty"`
 }
 
 // Step represents a bot step for export/import across CLI and backend
@@ -52,6 +57,7 @@ type Step struct {
 	HistoryRole                 *string                      `json:"history_role,omitempty"` // "user" or "assistant" (default: "assistant")
 	UiContentType               *string                      `json:"ui_content_type,omitempty"`
 	ParameterHydrationBehaviour *ParameterHydrationBehaviour `json:"parameter_hydration_behaviour,omitempty"`
+	HydrationPolicy             *HydrationPolicy             `json:"hydration_policy,omitempty"`
 	ResultHandlerID             *string                      `json:"result_handler_id,omitempty"`
 	CreatedAt                   *time.Time                   `json:"created_at,omitempty"`
 	UpdatedAt                   *time.Time                   `json:"updated_at,omitempty"`
@@ -78,6 +84,18 @@ const (
 	ParameterHydrationBehaviourHydrate ParameterHydrationBehaviour = "hydrate"
 	ParameterHydrationBehaviourRaw     ParameterHydrationBehaviour = "raw"
 	ParameterHydrationBehaviourNone    ParameterHydrationBehaviour = "none"
+	// ParameterHydrationBehaviourJinja hydrates the parameter as a Jinja2 template
+	ParameterHydrationBehaviourJinja ParameterHydrationBehaviour = "jinja"
+	// ParameterHydrationBehaviourOnce hydrates the value and then freezes the result
+	ParameterHydrationBehaviourOnce ParameterHydrationBehaviour = "once"
+)
+
+// TemplateSyntax represents the syntax used by parameter templates
+type TemplateSyntax string
+
+const (
+	TemplateSyntaxErdo  TemplateSyntax = "erdo"
+	TemplateSyntaxJinja TemplateSyntax = "jinja"
 )
 
 // Scan implements sql.Scanner interface for ParameterHydrationBehaviour
@@ -110,6 +128,88 @@ func (phb ParameterHydrationBehaviour) Value() (driver.Value, error) {
 	return json.Marshal(string(phb))
 }
 
+// HydrationRule applies a hydration behaviour to the parameters matching a path pattern.
+// Patterns are dot-separated keys with [n] indexes, where * matches a single key or
+// index and ** matches any number of them, e.g. "tools.*.parameters", "messages[0]"
+// or "**.code".
+type HydrationRule struct {
+	Path      string                      `json:"path"`
+	Behaviour ParameterHydrationBehaviour `json:"behaviour"`
+}
+
+// HydrationPolicy controls how a step's parameters are hydrated. A rule applies to
+// the matching value and everything under it; a rule matching a deeper path
+// overrides one matching its ancestor, and among rules matching the same path
+// the last one wins. Values no rule matches use Default (hydrate if empty).
+type HydrationPolicy struct {
+	Default ParameterHydrationBehaviour `json:"default,omitempty"`
+	Rules   []HydrationRule             `json:"rules,omitempty"`
+	// Frozen holds the values hydrated by "once" rules by path, which are used in
+	// place of the parameter from then on
+	Frozen map[string]any `json:"frozen,omitempty"`
+}
+
+// UnmarshalJSON accepts either a policy object or a bare behaviour string, which
+// becomes the policy's default
+func (hp *HydrationPolicy) UnmarshalJSON(data []byte) error {
+	var behaviour string
+	if err := json.Unmarshal(data, &behaviour); err == nil {
+		*hp = HydrationPolicy{Default: ParameterHydrationBehaviour(behaviour)}
+		return nil
+	}
+
+	type policy HydrationPolicy
+	var p policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*hp = HydrationPolicy(p)
+	return nil
+}
+
+// Scan implements sql.Scanner interface for HydrationPolicy. Values stored as a
+// ParameterHydrationBehaviour are read as the policy's default.
+func (hp *HydrationPolicy) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return nil
+	}
+
+	return json.Unmarshal(data, hp)
+}
+
+// Value implements driver.Valuer interface for HydrationPolicy. A policy with only
+// a default is stored the same way as a ParameterHydrationBehaviour.
+func (hp HydrationPolicy) Value() (driver.Value, error) {
+	if len(hp.Rules) == 0 && len(hp.Frozen) == 0 {
+		return hp.Default.Value()
+	}
+	return json.Marshal(hp)
+}
+
+// EffectiveHydrationPolicy returns the policy the step's parameters are hydrated
+// with: its HydrationPolicy, with the step's ParameterHydrationBehaviour as the
+// default when the policy doesn't set one
+func (s Step) EffectiveHydrationPolicy() HydrationPolicy {
+	var policy HydrationPolicy
+	if s.HydrationPolicy != nil {
+		policy = *s.HydrationPolicy
+	}
+	if policy.Default == "" && s.ParameterHydrationBehaviour != nil {
+		policy.Default = *s.ParameterHydrationBehaviour
+	}
+	return policy
+}
+
 // OutputVisibility represents visibility levels for any type of output
 type OutputVisibility string
 
@@ -161,7 +261,7 @@ const (
 	ModelClaude4Dot6Sonnet Model = "claude-sonnet-4-6"
 	ModelClaude4Dot5Haiku  Model = "claude-haiku-4-5"
 
-	// OpenAI models - GPT-4.1 family (reasoning support)
+	// OpenAI models - GPT-4.1 family (1M context)
 	ModelGPT4Dot1     Model = "gpt-4.1"
 	ModelGPT4Dot1Mini Model = "gpt-4.1-mini"
 	ModelGPT4Dot1Nano Model = "gpt-4.1-nano"
@@ -476,8 +576,23 @@ type ConditionDefinition struct {
 	Leaf       map[string]any        `json:"leaf,omitempty"`
 }
 
-// UnmarshalJSON handles both array and object formats for nested conditions
+// UnmarshalJSON handles both array and object formats for nested conditions,
+// and conditions written as an expression string (see ParseCondition)
 func (cd *ConditionDefinition) UnmarshalJSON(data []byte) error {
+	var expr string
+	if err := json.Unmarshal(data, &expr); err == nil {
+		if strings.TrimSpace(expr) == "" {
+			*cd = ConditionDefinition{}
+			return nil
+		}
+		condition, err := ParseCondition(expr)
+		if err != nil {
+			return err
+		}
+		*cd = condition
+		return nil
+	}
+
 	// Use a temporary struct to avoid recursion
 	type TempCondition struct {
 		Type       string          `json:"type"`
@@ -560,10 +675,13 @@ type JSONSchemaType string
 
 const (
 	JSONSchemaTypeString  JSONSchemaType = "string"
+	JSONSchemaTypeInteger JSONSchemaType = "integer"
 	JSONSchemaTypeNumber  JSONSchemaType = "number"
 	JSONSchemaTypeBoolean JSONSchemaType = "boolean"
 	JSONSchemaTypeObject  JSONSchemaType = "object"
 	JSONSchemaTypeArray   JSONSchemaType = "array"
+	// JSONSchemaTypeNull only appears in Schema type unions like ["string", "null"]
+	JSONSchemaTypeNull JSONSchemaType = "null"
 )
 
 // JSONSchemaProperty represents a property in a JSON schema
@@ -589,7 +707,7 @@ type Tool struct {
 	Name                string         `json:"name"`
 	Description         string         `json:"description"`
 	InputSchema         JSONSchema     `json:"input_schema"`
-	RawInputSchema      map[string]any `json:"raw_input_schema,omitempty"` // When set, used instead of InputSchema (for complex schemas with $defs, anyOf, etc.)
+	RawInputSchema      *Schema        `json:"raw_input_schema,omitempty"` // When set, used instead of InputSchema (for complex schemas with $defs, anyOf, etc.); see Schema and SetSchema
 	ActionType          string         `json:"action_type"`
 	Parameters          map[string]any `json:"parameters"`
 	BotOutputVisibility string         `json:"bot_output_visibility,omitempty"`
@@ -605,12 +723,15 @@ type Tool struct {
 // Result Types (for actions and step execution)
 // =============================================
 
-// Message represents a message in the conversation
+// Message represents a message in the conversation. Content holds the text of
+// the message; messages with images, files, tool calls or thinking also have
+// Parts, which are encoded as the "content" array instead (see content.go).
 type Message struct {
-	ID        string     `json:"id"`
-	Role      string     `json:"role"`
-	Content   string     `json:"content"`
-	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ID        string        `json:"id"`
+	Role      string        `json:"role"`
+	Content   string        `json:"content"`
+	Parts     []ContentPart `json:"-"`
+	CreatedAt *time.Time    `json:"created_at,omitempty"`
 }
 
 // SystemParameters represents system-provided parameters available to agents
@@ -734,6 +855,7 @@ type APIStep struct {
 	HistoryRole                 *string                      `json:"history_role,omitempty"` // "user" or "assistant" (default: "assistant")
 	UiContentType               *string                      `json:"ui_content_type,omitempty"`
 	ParameterHydrationBehaviour *ParameterHydrationBehaviour `json:"parameter_hydration_behaviour,omitempty"`
+	HydrationPolicy             *HydrationPolicy             `json:"hydration_policy,omitempty"`
 	ResultHandlerID             *string                      `json:"result_handler_id,omitempty"`
 	CreatedAt                   *time.Time                   `json:"created_at,omitempty"`
 	UpdatedAt                   *time.Time                   `json:"updated_at,omitempty"`
@@ -791,6 +913,7 @@ type APITool struct {
 	Name                string          `json:"name"`
 	Description         string          `json:"description"`
 	InputSchema         json.RawMessage `json:"input_schema"`
+	RawInputSchema      json.RawMessage `json:"raw_input_schema,omitempty"`
 	ActionType          string          `json:"action_type"`
 	Parameters          json.RawMessage `json:"parameters"`
 	BotOutputVisibility string          `json:"bot_output_visibility,omitempty"`
@@ -799,6 +922,8 @@ type APITool struct {
 	AsRoot              bool            `json:"as_root,omitempty"`
 	RunningStatus       *string         `json:"running_status,omitempty"`
 	FinishedStatus      *string         `json:"finished_status,omitempty"`
+	Strict              bool            `json:"strict,omitempty"`
+	ApprovalRequired    bool            `json:"approval_required,omitempty"`
 }
 
 // APIResultHandler is an Encore-API-compatible version of ResultHandler
@@ -823,6 +948,9 @@ type APIParameterDefinition struct {
 	IsRequired  bool       `json:"is_required"`
 	CreatedAt   *time.Time `json:"created_at,omitempty"`
 	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	// Extended fields for agent discovery
+	ValueSources []APIParameterValueSource `json:"value_sources,omitempty"`
+	Interpreters []APIParameterInterpreter `json:"interpreters,omitempty"`
 }
 
 // APIStepWithHandlers is an Encore-API-compatible version of StepWithHandlers
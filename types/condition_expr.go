@@ -0,0 +1,553 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Condition Expressions
+// =====================
+//
+// Conditions can be written as expressions instead of ConditionDefinition trees:
+//
+//	status == "success" && len(output.items) > 0 || !truthy(output.error)
+//
+// && binds tighter than ||, ! negates, and parentheses group. Paths like
+// output.items or output.items[0].name compile to "{{output.items}}" templates
+// and len(path) to {{len (get "path")}}; literals are JSON values. The comparisons and functions
+// compile to these leaf conditions:
+//
+//	status == "success"    IsSuccess
+//	status == "error"      IsError
+//	a == b                 TextEquals{text: a, value: b}
+//	a != b                 not TextEquals
+//	a > b, a < b           GreaterThan, LessThan{number: a, value: b}
+//	a >= b, a <= b         not LessThan, not GreaterThan
+//	contains(a, b)         TextContains{text: a, value: b}
+//	startsWith(a, b)       TextStartsWith{text: a, value: b}
+//	endsWith(a, b)         TextEndsWith{text: a, value: b}
+//	truthy(a), a           IsTruthy{value: a}
+//	isNull(a)              IsNull{value: a}
+//	Type(key=value, ...)   any other leaf, e.g. IsAny(key=output.kind, value=["a", "b"])
+//
+// Leaf keys that aren't names are written as JSON strings, e.g. Type("my key"=1).
+
+// ErrInvalidCondition is returned when a condition expression can't be parsed
+var ErrInvalidCondition = errors.New("invalid condition expression")
+
+const (
+	conditionAnd = "and"
+	conditionOr  = "or"
+	conditionNot = "not"
+)
+
+// conditionComparisons are the leaf types of comparison operators. The other
+// operators are the negation of one of these leaves.
+var conditionComparisons = map[string]string{
+	"==": "TextEquals",
+	">":  "GreaterThan",
+	"<":  "LessThan",
+}
+
+// conditionNegations are the operators written for a negated leaf
+var conditionNegations = map[string]string{
+	"TextEquals":  "!=",
+	"LessThan":    ">=",
+	"GreaterThan": "<=",
+}
+
+// conditionFunction is a function compiled to a leaf, with its arguments as the
+// leaf's keys
+type conditionFunction struct {
+	leafType string
+	args     []string
+}
+
+var conditionFunctions = map[string]conditionFunction{
+	"contains":   {"TextContains", []string{"text", "value"}},
+	"startsWith": {"TextStartsWith", []string{"text", "value"}},
+	"endsWith":   {"TextEndsWith", []string{"text", "value"}},
+	"truthy":     {"IsTruthy", []string{"value"}},
+	"isNull":     {"IsNull", []string{"value"}},
+}
+
+// conditionPath matches a dotted path whose segments can be indexed, e.g. items[0].name
+const conditionPath = `[A-Za-z_][A-Za-z0-9_]*(?:\[[0-9]+\])*(?:\.[A-Za-z0-9_]+(?:\[[0-9]+\])*)*`
+
+var (
+	conditionNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	conditionPathRegex = regexp.MustCompile(`^` + conditionPath + `$`)
+	pathTemplateRegex  = regexp.MustCompile(`^\{\{\s*(` + conditionPath + `)\s*\}\}$`)
+	lenTemplateRegex   = regexp.MustCompile(`^\{\{\s*len \(get "(` + conditionPath + `)"\)\s*\}\}$`)
+)
+
+// ParseCondition compiles a condition expression to a ConditionDefinition
+func ParseCondition(expr string) (ConditionDefinition, error) {
+	p := &conditionParser{src: expr}
+	cd, err := p.or()
+	if err == nil && p.skipSpace() < len(p.src) {
+		err = p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	if err != nil {
+		return ConditionDefinition{}, err
+	}
+	return cd, nil
+}
+
+type conditionParser struct {
+	src string
+	pos int
+}
+
+func (p *conditionParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w %q at offset %d: %s", ErrInvalidCondition, p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpace moves past whitespace and returns the position of the next token
+func (p *conditionParser) skipSpace() int {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos
+}
+
+// accept consumes the token if it's next
+func (p *conditionParser) accept(token string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], token) {
+		return false
+	}
+	// Don't take the ! of != or the = of ==
+	if rest := p.src[p.pos+len(token):]; (token == "!" || token == "=") && strings.HasPrefix(rest, "=") {
+		return false
+	}
+	p.pos += len(token)
+	return true
+}
+
+func (p *conditionParser) expect(token string) error {
+	if !p.accept(token) {
+		return p.errorf("expected %q", token)
+	}
+	return nil
+}
+
+func (p *conditionParser) or() (ConditionDefinition, error) {
+	return p.composite(conditionOr, "||", p.and)
+}
+
+func (p *conditionParser) and() (ConditionDefinition, error) {
+	return p.composite(conditionAnd, "&&", p.unary)
+}
+
+// composite parses operands joined by op, flattening them into one condition
+func (p *conditionParser) composite(conditionType, op string, operand func() (ConditionDefinition, error)) (ConditionDefinition, error) {
+	first, err := operand()
+	if err != nil {
+		return ConditionDefinition{}, err
+	}
+	conditions := []ConditionDefinition{first}
+	for p.accept(op) {
+		next, err := operand()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		conditions = append(conditions, next)
+	}
+	if len(conditions) == 1 {
+		return first, nil
+	}
+	return ConditionDefinition{Type: conditionType, Conditions: conditions}, nil
+}
+
+func (p *conditionParser) unary() (ConditionDefinition, error) {
+	if p.accept("!") {
+		cd, err := p.unary()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		return not(cd), nil
+	}
+	if p.accept("(") {
+		cd, err := p.or()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		return cd, p.expect(")")
+	}
+
+	start := p.skipSpace()
+	if name := p.identifier(); conditionNameRegex.MatchString(name) && name != "len" && p.accept("(") {
+		return p.call(name)
+	}
+	p.pos = start
+
+	left, isPath, err := p.operand()
+	if err != nil {
+		return ConditionDefinition{}, err
+	}
+	for _, op := range []string{"==", "!=", ">=", "<=", ">", "<"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, _, err := p.operand()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		return comparison(op, left, right), nil
+	}
+	if !isPath {
+		p.pos = start
+		return ConditionDefinition{}, p.errorf("expected a condition")
+	}
+	return ConditionDefinition{Type: "IsTruthy", Leaf: map[string]any{"value": left}}, nil
+}
+
+// call parses the arguments of a function, after its opening paren
+func (p *conditionParser) call(name string) (ConditionDefinition, error) {
+	fn, ok := conditionFunctions[name]
+	if !ok {
+		return p.leaf(name)
+	}
+	leaf := map[string]any{}
+	for i, arg := range fn.args {
+		if i > 0 && !p.accept(",") {
+			return ConditionDefinition{}, p.errorf("%s takes %d arguments", name, len(fn.args))
+		}
+		value, _, err := p.operand()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		leaf[arg] = value
+	}
+	if !p.accept(")") {
+		return ConditionDefinition{}, p.errorf("%s takes %d arguments", name, len(fn.args))
+	}
+	return ConditionDefinition{Type: fn.leafType, Leaf: leaf}, nil
+}
+
+// leaf parses the key=value arguments of a leaf written as Type(...)
+func (p *conditionParser) leaf(leafType string) (ConditionDefinition, error) {
+	cd := ConditionDefinition{Type: leafType}
+	for !p.accept(")") {
+		if len(cd.Leaf) > 0 {
+			if err := p.expect(","); err != nil {
+				return ConditionDefinition{}, err
+			}
+		}
+		key, err := p.leafKey()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		if key == "" || !p.accept("=") {
+			return ConditionDefinition{}, p.errorf("expected key=value arguments to %s", leafType)
+		}
+		value, _, err := p.operand()
+		if err != nil {
+			return ConditionDefinition{}, err
+		}
+		if cd.Leaf == nil {
+			cd.Leaf = map[string]any{}
+		}
+		cd.Leaf[key] = value
+	}
+	return cd, nil
+}
+
+// leafKey consumes a leaf key, a name or a JSON string
+func (p *conditionParser) leafKey() (string, error) {
+	if p.skipSpace() == len(p.src) || p.src[p.pos] != '"' {
+		return p.identifier(), nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(p.src[p.pos:]))
+	var key string
+	if err := decoder.Decode(&key); err != nil {
+		return "", p.errorf("invalid key: %v", err)
+	}
+	p.pos += int(decoder.InputOffset())
+	return key, nil
+}
+
+// identifier consumes a name or dotted path, including indexes like [0]
+func (p *conditionParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && p.pos > start {
+			p.pos++
+			continue
+		}
+		if c == '[' && p.pos > start {
+			if end := strings.IndexByte(p.src[p.pos:], ']'); end > 1 && isDigits(p.src[p.pos+1:p.pos+end]) {
+				p.pos += end + 1
+				continue
+			}
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func isDigits(s string) bool {
+	return strings.Trim(s, "0123456789") == ""
+}
+
+// operand parses a path, len(path) or JSON literal, returning the value the
+// leaf holds and whether it was a path
+func (p *conditionParser) operand() (value any, isPath bool, err error) {
+	start := p.skipSpace()
+	if start == len(p.src) {
+		return nil, false, p.errorf("unexpected end of expression")
+	}
+	switch c := p.src[start]; {
+	case c == '"' || c == '[' || c == '{' || c == '-' || c >= '0' && c <= '9':
+		decoder := json.NewDecoder(strings.NewReader(p.src[start:]))
+		if err := decoder.Decode(&value); err != nil {
+			return nil, false, p.errorf("invalid literal: %v", err)
+		}
+		p.pos += int(decoder.InputOffset())
+		return value, false, nil
+	}
+
+	name := p.identifier()
+	switch name {
+	case "":
+		return nil, false, p.errorf("expected a value")
+	case "true", "false", "null":
+		_ = json.Unmarshal([]byte(name), &value)
+		return value, false, nil
+	case "len":
+		if p.accept("(") {
+			p.skipSpace()
+			path := p.identifier()
+			if !conditionPathRegex.MatchString(path) {
+				return nil, false, p.errorf("len takes a path")
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, false, err
+			}
+			return fmt.Sprintf(`{{len (get "%s")}}`, path), false, nil
+		}
+	}
+	if name != "" && p.pos < len(p.src) && p.src[p.pos] == '[' {
+		index := p.src[p.pos:]
+		if end := strings.IndexByte(index, ']'); end >= 0 {
+			index = index[:end+1]
+		}
+		p.pos = start
+		return nil, false, p.errorf("invalid index %s in path %q: indexes are non-negative integers, e.g. items[0]", index, name)
+	}
+	if !conditionPathRegex.MatchString(name) {
+		p.pos = start
+		return nil, false, p.errorf("invalid path %q", name)
+	}
+	return "{{" + name + "}}", true, nil
+}
+
+func comparison(op string, left, right any) ConditionDefinition {
+	switch op {
+	case "==":
+		if left == "{{status}}" {
+			switch right {
+			case string(StatusSuccess):
+				return ConditionDefinition{Type: "IsSuccess"}
+			case string(StatusError):
+				return ConditionDefinition{Type: "IsError"}
+			}
+		}
+		return comparisonLeaf("TextEquals", left, right)
+	case "!=":
+		return not(comparison("==", left, right))
+	case ">=":
+		return not(comparisonLeaf("LessThan", left, right))
+	case "<=":
+		return not(comparisonLeaf("GreaterThan", left, right))
+	}
+	return comparisonLeaf(conditionComparisons[op], left, right)
+}
+
+func comparisonLeaf(leafType string, left, right any) ConditionDefinition {
+	key := "text"
+	if leafType != "TextEquals" {
+		key = "number"
+	}
+	return ConditionDefinition{Type: leafType, Leaf: map[string]any{key: left, "value": right}}
+}
+
+func not(cd ConditionDefinition) ConditionDefinition {
+	return ConditionDefinition{Type: conditionNot, Conditions: []ConditionDefinition{cd}}
+}
+
+// Printing
+// ========
+
+// Operator precedence, for deciding when to parenthesize
+const (
+	precedenceOr = iota + 1
+	precedenceAnd
+	precedenceUnary
+)
+
+// String renders the condition as an expression that ParseCondition compiles
+// back to an equivalent condition. Composite conditions with a single condition
+// are printed as that condition, and not with several as the negation of their and.
+func (cd ConditionDefinition) String() string {
+	return cd.format(precedenceOr)
+}
+
+func (cd ConditionDefinition) format(precedence int) string {
+	var s string
+	own := precedenceUnary
+	switch strings.ToLower(cd.Type) {
+	case conditionAnd, conditionOr:
+		if len(cd.Conditions) == 0 {
+			return cd.Type + "()"
+		}
+		if len(cd.Conditions) == 1 {
+			return cd.Conditions[0].format(precedence)
+		}
+		op := "&&"
+		own = precedenceAnd
+		if strings.EqualFold(cd.Type, conditionOr) {
+			op, own = "||", precedenceOr
+		}
+		parts := make([]string, len(cd.Conditions))
+		for i, child := range cd.Conditions {
+			parts[i] = child.format(own + 1)
+		}
+		s = strings.Join(parts, " "+op+" ")
+	case conditionNot:
+		switch len(cd.Conditions) {
+		case 0:
+			return cd.Type + "()"
+		case 1:
+			s = cd.Conditions[0].negated()
+		default:
+			s = "!" + ConditionDefinition{Type: conditionAnd, Conditions: cd.Conditions}.format(precedenceUnary)
+		}
+	default:
+		s = cd.formatLeaf()
+	}
+	if own < precedence {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// negated renders the negation of the condition, using != and the inverse
+// comparisons where there is one
+func (cd ConditionDefinition) negated() string {
+	switch cd.Type {
+	case "IsSuccess", "IsError":
+		if len(cd.Leaf) == 0 {
+			return `status != "` + strings.ToLower(strings.TrimPrefix(cd.Type, "Is")) + `"`
+		}
+	}
+	if op, ok := conditionNegations[cd.Type]; ok {
+		if left, right, ok := cd.comparisonOperands(); ok {
+			return formatOperand(left) + " " + op + " " + formatOperand(right)
+		}
+	}
+	return "!" + cd.format(precedenceUnary)
+}
+
+// comparisonOperands returns the operands of a comparison leaf that has only them
+func (cd ConditionDefinition) comparisonOperands() (left, right any, ok bool) {
+	key := "text"
+	if cd.Type != "TextEquals" {
+		key = "number"
+	}
+	left, hasLeft := cd.Leaf[key]
+	right, hasRight := cd.Leaf["value"]
+	return left, right, hasLeft && hasRight && len(cd.Leaf) == 2 && len(cd.Conditions) == 0
+}
+
+func (cd ConditionDefinition) formatLeaf() string {
+	switch cd.Type {
+	case "IsSuccess", "IsError":
+		if len(cd.Leaf) == 0 {
+			return `status == "` + strings.ToLower(strings.TrimPrefix(cd.Type, "Is")) + `"`
+		}
+	}
+	for op, leafType := range conditionComparisons {
+		if leafType != cd.Type {
+			continue
+		}
+		if left, right, ok := cd.comparisonOperands(); ok {
+			return formatOperand(left) + " " + op + " " + formatOperand(right)
+		}
+	}
+	for name, fn := range conditionFunctions {
+		if fn.leafType != cd.Type || len(cd.Leaf) != len(fn.args) {
+			continue
+		}
+		args := make([]string, 0, len(fn.args))
+		for _, arg := range fn.args {
+			value, ok := cd.Leaf[arg]
+			if !ok {
+				break
+			}
+			args = append(args, formatOperand(value))
+		}
+		if len(args) == len(fn.args) {
+			return name + "(" + strings.Join(args, ", ") + ")"
+		}
+	}
+
+	keys := make([]string, 0, len(cd.Leaf))
+	for key := range cd.Leaf {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	args := make([]string, len(keys))
+	for i, key := range keys {
+		args[i] = formatLeafKey(key) + "=" + formatOperand(cd.Leaf[key])
+	}
+	return cd.Type + "(" + strings.Join(args, ", ") + ")"
+}
+
+// formatLeafKey renders a leaf key as a name, or a JSON string when it isn't one
+func formatLeafKey(key string) string {
+	if conditionNameRegex.MatchString(key) {
+		return key
+	}
+	return formatLiteral(key)
+}
+
+// formatOperand renders a leaf value as a path, len(path) or JSON literal
+func formatOperand(value any) string {
+	if s, ok := value.(string); ok {
+		if match := pathTemplateRegex.FindStringSubmatch(s); match != nil && !isConditionKeyword(match[1]) {
+			return match[1]
+		}
+		if match := lenTemplateRegex.FindStringSubmatch(s); match != nil {
+			return "len(" + match[1] + ")"
+		}
+	}
+	return formatLiteral(value)
+}
+
+// formatLiteral renders a value as JSON
+func formatLiteral(value any) string {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Sprintf("%q", fmt.Sprint(value))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// isConditionKeyword reports whether a path would be read as a literal or len
+func isConditionKeyword(path string) bool {
+	switch path {
+	case "true", "false", "null", "len":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func leaf(leafType string, keysAndValues ...any) ConditionDefinition {
+	cd := ConditionDefinition{Type: leafType}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if cd.Leaf == nil {
+			cd.Leaf = map[string]any{}
+		}
+		cd.Leaf[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	return cd
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		expr string
+		want ConditionDefinition
+	}{
+		{
+			`status == "success" && len(output.items) > 0 || !truthy(output.error)`,
+			ConditionDefinition{Type: "or", Conditions: []ConditionDefinition{
+				{Type: "and", Conditions: []ConditionDefinition{
+					leaf("IsSuccess"),
+					leaf("GreaterThan", "number", `{{len (get "output.items")}}`, "value", 0.0),
+				}},
+				not(leaf("IsTruthy", "value", "{{output.error}}")),
+			}},
+		},
+		{
+			`a && b && (c || d)`,
+			ConditionDefinition{Type: "and", Conditions: []ConditionDefinition{
+				leaf("IsTruthy", "value", "{{a}}"),
+				leaf("IsTruthy", "value", "{{b}}"),
+				{Type: "or", Conditions: []ConditionDefinition{
+					leaf("IsTruthy", "value", "{{c}}"),
+					leaf("IsTruthy", "value", "{{d}}"),
+				}},
+			}},
+		},
+		{`status == "error"`, leaf("IsError")},
+		{`status != "success"`, not(leaf("IsSuccess"))},
+		{`output.kind == "chart"`, leaf("TextEquals", "text", "{{output.kind}}", "value", "chart")},
+		{`output.kind != null`, not(leaf("TextEquals", "text", "{{output.kind}}", "value", nil))},
+		{`output.score>=0.5`, not(leaf("LessThan", "number", "{{output.score}}", "value", 0.5))},
+		{`-1 <= output.delta`, not(leaf("GreaterThan", "number", -1.0, "value", "{{output.delta}}"))},
+		{`contains(output.text, "error")`, leaf("TextContains", "text", "{{output.text}}", "value", "error")},
+		{`isNull(output.id)`, leaf("IsNull", "value", "{{output.id}}")},
+		{`IsAny(key=output.kind, value=["a", "b"])`, leaf("IsAny", "key", "{{output.kind}}", "value", []any{"a", "b"})},
+		{`EarlyExit()`, leaf("EarlyExit")},
+		{`output.items[0].name == "a"`, leaf("TextEquals", "text", "{{output.items[0].name}}", "value", "a")},
+		{`len(rows[1][2].cells) > 0`, leaf("GreaterThan", "number", `{{len (get "rows[1][2].cells")}}`, "value", 0.0)},
+		{`Custom("my key"=1, other=items[3])`, leaf("Custom", "my key", 1.0, "other", "{{items[3]}}")},
+		{`!!done`, not(not(leaf("IsTruthy", "value", "{{done}}")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseCondition(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.NoError(t, got.Validate())
+		})
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := map[string]string{
+		``:                     "unexpected end of expression",
+		`a &&`:                 "unexpected end of expression",
+		`(a || b`:              `expected ")"`,
+		`a b`:                  `unexpected "b"`,
+		`"done"`:               "expected a condition",
+		`contains(a)`:          "contains takes 2 arguments",
+		`IsAny(output.kind)`:   "expected key=value arguments to IsAny",
+		`len(1) > 0`:           "len takes a path",
+		`a == {"unterminated"`: "invalid literal",
+		`output..kind == "x"`:  `invalid path "output..kind"`,
+		`items[i] == "x"`:      `invalid index [i] in path "items"`,
+		`items[-1] == "x"`:     `invalid index [-1] in path "items"`,
+		`Custom("key=1)`:       "invalid key",
+	}
+	for expr, want := range tests {
+		_, err := ParseCondition(expr)
+		assert.ErrorIs(t, err, ErrInvalidCondition, expr)
+		assert.ErrorContains(t, err, want, expr)
+	}
+}
+
+func TestConditionString(t *testing.T) {
+	for _, expr := range []string{
+		`status == "success" && len(output.items) > 0 || !truthy(output.error)`,
+		`(truthy(a) || truthy(b)) && !(truthy(c) && truthy(d))`,
+		`status != "error" && output.score >= 0.5 && output.score <= 1`,
+		`output.kind == "chart" || output.kind != "table"`,
+		`contains(output.text, "a \"quoted\" <word>") || startsWith(x, "a") || endsWith(x, "z")`,
+		`isNull(output.id) || IsAny(key=output.kind, value=["a","b"]) || EarlyExit()`,
+		`!!truthy(done)`,
+		`output.items[0].name == "a" && len(rows[1][2].cells) > 0 || truthy(items[3])`,
+		`Custom("my key"=1, other=items[3], "x.y"="{{a b}}")`,
+	} {
+		cd, err := ParseCondition(expr)
+		require.NoError(t, err)
+		assert.Equal(t, expr, cd.String())
+	}
+
+	// Trees written by hand print as equivalent expressions
+	tests := []struct {
+		cd   ConditionDefinition
+		want string
+	}{
+		{ConditionDefinition{Type: "And", Conditions: []ConditionDefinition{leaf("IsSuccess", "x", 1)}}, `IsSuccess(x=1)`},
+		{ConditionDefinition{Type: "not", Conditions: []ConditionDefinition{leaf("IsSuccess"), leaf("IsTruthy", "value", "{{a}}")}}, `!(status == "success" && truthy(a))`},
+		{ConditionDefinition{Type: "or"}, `or()`},
+		{leaf("GreaterThan", "value", 3), `GreaterThan(value=3)`},
+		{leaf("TextEquals", "text", "{{true}}", "value", "{{ output.x }}"), `"{{true}}" == output.x`},
+		{leaf("Custom", "my key", "{{a}}", "{{b}}", 1), `Custom("my key"=a, "{{b}}"=1)`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.cd.String())
+	}
+}
+
+func TestConditionDefinitionUnmarshalExpression(t *testing.T) {
+	var handler ResultHandler
+	require.NoError(t, json.Unmarshal([]byte(`{"type": "final", "if_conditions": "status == \"error\" && !truthy(output.retried)"}`), &handler))
+	assert.Equal(t, ConditionDefinition{Type: "and", Conditions: []ConditionDefinition{
+		leaf("IsError"),
+		not(leaf("IsTruthy", "value", "{{output.retried}}")),
+	}}, handler.IfConditions)
+
+	var nested ConditionDefinition
+	require.NoError(t, json.Unmarshal([]byte(`{"type": "or", "conditions": ["a > 1", {"type": "IsError"}]}`), &nested))
+	assert.Equal(t, ConditionDefinition{Type: "or", Conditions: []ConditionDefinition{
+		leaf("GreaterThan", "number", "{{a}}", "value", 1.0),
+		{Type: "IsError"},
+	}}, nested)
+
+	var empty ConditionDefinition
+	require.NoError(t, json.Unmarshal([]byte(`""`), &empty))
+	assert.Equal(t, ConditionDefinition{}, empty)
+
+	err := json.Unmarshal([]byte(`"a &&"`), &empty)
+	assert.ErrorIs(t, err, ErrInvalidCondition)
+
+	// The object form is still what's written
+	encoded, err := json.Marshal(handler.IfConditions)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"and","conditions":[{"type":"IsError"},{"type":"not","conditions":[{"type":"IsTruthy","leaf":{"value":"{{output.retried}}"}}]}]}`, string(encoded))
+}
@@ -0,0 +1,168 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Message Content
+// ===============
+
+// Message roles
+const (
+	MessageRoleSystem    = "system"
+	MessageRoleUser      = "user"
+	MessageRoleAssistant = "assistant"
+	// MessageRoleTool messages hold the results of the assistant's tool calls
+	MessageRoleTool = "tool"
+)
+
+// ContentPartType identifies the kind of a ContentPart
+type ContentPartType string
+
+const (
+	ContentPartTypeText       ContentPartType = "text"
+	ContentPartTypeImage      ContentPartType = "image"
+	ContentPartTypeFile       ContentPartType = "file"
+	ContentPartTypeToolUse    ContentPartType = "tool_use"
+	ContentPartTypeToolResult ContentPartType = "tool_result"
+	ContentPartTypeThinking   ContentPartType = "thinking"
+)
+
+// ContentPart is one block of a message's content. Type says which fields apply:
+//   - text: Text
+//   - image and file: MediaType and one of Data (base64), URL or FileID (an
+//     upload to the provider), plus Filename for files
+//   - tool_use: ToolCallID, ToolName and Input
+//   - tool_result: ToolCallID, Content and IsError, plus ToolName where known
+//   - thinking: Text and the provider's Signature, or Data for redacted thinking
+type ContentPart struct {
+	Type       ContentPartType `json:"type"`
+	Text       string          `json:"text,omitempty"`
+	MediaType  string          `json:"media_type,omitempty"`
+	Data       string          `json:"data,omitempty"`
+	URL        string          `json:"url,omitempty"`
+	FileID     string          `json:"file_id,omitempty"`
+	Filename   string          `json:"filename,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	ToolName   string          `json:"tool_name,omitempty"`
+	Input      map[string]any  `json:"input,omitempty"`
+	Content    []ContentPart   `json:"content,omitempty"`
+	IsError    bool            `json:"is_error,omitempty"`
+	Signature  string          `json:"signature,omitempty"`
+}
+
+// TextPart returns a text content part
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: ContentPartTypeText, Text: text}
+}
+
+// ImagePart returns an image content part with base64 data
+func ImagePart(mediaType string, data string) ContentPart {
+	return ContentPart{Type: ContentPartTypeImage, MediaType: mediaType, Data: data}
+}
+
+// ImageURLPart returns an image content part that links to the image
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: ContentPartTypeImage, URL: url}
+}
+
+// FileRefPart returns a file content part for a file uploaded to the provider
+func FileRefPart(fileID string, mediaType string, filename string) ContentPart {
+	return ContentPart{Type: ContentPartTypeFile, FileID: fileID, MediaType: mediaType, Filename: filename}
+}
+
+// ToolUsePart returns a content part for a tool call
+func ToolUsePart(toolCallID string, toolName string, input map[string]any) ContentPart {
+	return ContentPart{Type: ContentPartTypeToolUse, ToolCallID: toolCallID, ToolName: toolName, Input: input}
+}
+
+// ToolResultPart returns a content part for the result of a tool call
+func ToolResultPart(toolCallID string, isError bool, content ...ContentPart) ContentPart {
+	return ContentPart{Type: ContentPartTypeToolResult, ToolCallID: toolCallID, IsError: isError, Content: content}
+}
+
+// ThinkingPart returns a content part for a model's thinking
+func ThinkingPart(text string, signature string) ContentPart {
+	return ContentPart{Type: ContentPartTypeThinking, Text: text, Signature: signature}
+}
+
+// NewMessage returns a message with the given parts. A message with just one
+// text part only has Content, so it's encoded as before parts existed.
+func NewMessage(role string, parts ...ContentPart) Message {
+	if len(parts) == 1 && parts[0].Type == ContentPartTypeText {
+		return Message{Role: role, Content: parts[0].Text}
+	}
+	return Message{Role: role, Content: partsText(parts), Parts: parts}
+}
+
+// ContentParts returns the message's parts, or its Content as a text part
+func (m Message) ContentParts() []ContentPart {
+	if len(m.Parts) > 0 {
+		return m.Parts
+	}
+	if m.Content == "" {
+		return nil
+	}
+	return []ContentPart{TextPart(m.Content)}
+}
+
+// Text returns the text of the message, without tool calls or thinking
+func (m Message) Text() string {
+	if len(m.Parts) > 0 {
+		return partsText(m.Parts)
+	}
+	return m.Content
+}
+
+// partsText joins the text parts' text with newlines
+func partsText(parts []ContentPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Type == ContentPartTypeText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// MarshalJSON encodes the message's content as a string, or as an array of parts
+// for messages with Parts
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{message(m), m.Parts})
+}
+
+// UnmarshalJSON accepts content as a string or an array of parts
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	var raw struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Message(raw.message)
+
+	if isNullJSON(raw.Content) {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Content, &m.Content); err == nil {
+		return nil
+	}
+	var parts []ContentPart
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return fmt.Errorf("message content must be a string or an array of parts: %w", err)
+	}
+	parsed := NewMessage(m.Role, parts...)
+	m.Content, m.Parts = parsed.Content, parsed.Parts
+	return nil
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageJSON(t *testing.T) {
+	t.Run("text messages keep string content", func(t *testing.T) {
+		message := NewMessage(MessageRoleUser, TextPart("Hello"))
+		encoded, err := json.Marshal(message)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id": "", "role": "user", "content": "Hello"}`, string(encoded))
+
+		var decoded Message
+		require.NoError(t, json.Unmarshal([]byte(`{"id": "m1", "role": "user", "content": "Hello"}`), &decoded))
+		assert.Equal(t, Message{ID: "m1", Role: "user", Content: "Hello"}, decoded)
+	})
+
+	t.Run("messages with parts encode an array", func(t *testing.T) {
+		message := NewMessage(MessageRoleAssistant,
+			TextPart("Searching"),
+			ToolUsePart("call_1", "search", map[string]any{"query": "erdo"}),
+		)
+		assert.Equal(t, "Searching", message.Content)
+
+		encoded, err := json.Marshal(message)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id": "", "role": "assistant", "content": [
+			{"type": "text", "text": "Searching"},
+			{"type": "tool_use", "tool_call_id": "call_1", "tool_name": "search", "input": {"query": "erdo"}}
+		]}`, string(encoded))
+
+		var decoded Message
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.Equal(t, message, decoded)
+	})
+
+	t.Run("null and invalid content", func(t *testing.T) {
+		var decoded Message
+		require.NoError(t, json.Unmarshal([]byte(`{"role": "assistant", "content": null}`), &decoded))
+		assert.Equal(t, Message{Role: "assistant"}, decoded)
+		assert.Empty(t, decoded.ContentParts())
+
+		assert.ErrorContains(t, json.Unmarshal([]byte(`{"content": 5}`), &decoded), "string or an array of parts")
+	})
+
+	t.Run("text skips other parts", func(t *testing.T) {
+		message := NewMessage(MessageRoleUser, TextPart("a"), ImageURLPart("https://example.com/a.png"), TextPart("b"))
+		assert.Equal(t, "a\nb", message.Text())
+		assert.Equal(t, 5, MessageTokens(message, nil))
+	})
+}
@@ -0,0 +1,601 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// API Conversion
+// ==============
+//
+// ToAPI and FromAPI convert between the domain types and their Encore-compatible
+// API versions, encoding the interface{} fields as JSON. Converting a domain
+// value to the API and back gives an equal value, as long as its interface{}
+// fields hold JSON values (as they do after decoding JSON). Nil maps and
+// values stay nil and empty ones stay empty.
+
+// ToAPI converts the step to its API version
+func (s Step) ToAPI() (APIStep, error) {
+	parameters, err := marshalMap(s.Parameters)
+	if err != nil {
+		return APIStep{}, fmt.Errorf("invalid parameters: %w", err)
+	}
+	executionMode, err := s.ExecutionMode.ToAPI()
+	if err != nil {
+		return APIStep{}, err
+	}
+	return APIStep{
+		ID:                          s.ID,
+		BotID:                       s.BotID,
+		ActionType:                  s.ActionType,
+		Parameters:                  parameters,
+		DependsOn:                   s.DependsOn,
+		Key:                         s.Key,
+		StepOrder:                   s.StepOrder,
+		OutputContentType:           s.OutputContentType,
+		UserOutputVisibility:        s.UserOutputVisibility,
+		BotOutputVisibility:         s.BotOutputVisibility,
+		ExecutionMode:               executionMode,
+		OutputBehaviour:             s.OutputBehaviour,
+		OutputChannels:              s.OutputChannels,
+		RunningStatus:               s.RunningStatus,
+		FinishedStatus:              s.FinishedStatus,
+		HistoryContentType:          s.HistoryContentType,
+		HistoryRole:                 s.HistoryRole,
+		UiContentType:               s.UiContentType,
+		ParameterHydrationBehaviour: s.ParameterHydrationBehaviour,
+		HydrationPolicy:             s.HydrationPolicy,
+		ResultHandlerID:             s.ResultHandlerID,
+		CreatedAt:                   s.CreatedAt,
+		UpdatedAt:                   s.UpdatedAt,
+	}, nil
+}
+
+// FromAPI sets the step from its API version
+func (s *Step) FromAPI(api APIStep) error {
+	parameters, err := unmarshalMap(api.Parameters)
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	var executionMode ExecutionMode
+	if err := executionMode.FromAPI(api.ExecutionMode); err != nil {
+		return err
+	}
+	*s = Step{
+		ID:                          api.ID,
+		BotID:                       api.BotID,
+		ActionType:                  api.ActionType,
+		Parameters:                  parameters,
+		DependsOn:                   api.DependsOn,
+		Key:                         api.Key,
+		StepOrder:                   api.StepOrder,
+		OutputContentType:           api.OutputContentType,
+		UserOutputVisibility:        api.UserOutputVisibility,
+		BotOutputVisibility:         api.BotOutputVisibility,
+		ExecutionMode:               executionMode,
+		OutputBehaviour:             api.OutputBehaviour,
+		OutputChannels:              api.OutputChannels,
+		RunningStatus:               api.RunningStatus,
+		FinishedStatus:              api.FinishedStatus,
+		HistoryContentType:          api.HistoryContentType,
+		HistoryRole:                 api.HistoryRole,
+		UiContentType:               api.UiContentType,
+		ParameterHydrationBehaviour: api.ParameterHydrationBehaviour,
+		HydrationPolicy:             api.HydrationPolicy,
+		ResultHandlerID:             api.ResultHandlerID,
+		CreatedAt:                   api.CreatedAt,
+		UpdatedAt:                   api.UpdatedAt,
+	}
+	return nil
+}
+
+// ToAPI converts the execution mode to its API version
+func (em ExecutionMode) ToAPI() (APIExecutionMode, error) {
+	data, err := marshalValue(em.Data)
+	if err != nil {
+		return APIExecutionMode{}, fmt.Errorf("invalid execution mode data: %w", err)
+	}
+	api := APIExecutionMode{Mode: em.Mode, Data: data}
+	if em.IfCondition != nil {
+		condition, err := em.IfCondition.ToAPI()
+		if err != nil {
+			return APIExecutionMode{}, err
+		}
+		api.IfCondition = &condition
+	}
+	return api, nil
+}
+
+// FromAPI sets the execution mode from its API version
+func (em *ExecutionMode) FromAPI(api APIExecutionMode) error {
+	data, err := unmarshalValue(api.Data)
+	if err != nil {
+		return fmt.Errorf("invalid execution mode data: %w", err)
+	}
+	mode := ExecutionMode{Mode: api.Mode, Data: data}
+	if api.IfCondition != nil {
+		var condition ConditionDefinition
+		if err := condition.FromAPI(*api.IfCondition); err != nil {
+			return err
+		}
+		mode.IfCondition = &condition
+	}
+	*em = mode
+	return nil
+}
+
+// ToAPI converts the condition tree to its API version
+func (cd ConditionDefinition) ToAPI() (APIConditionDefinition, error) {
+	leaf, err := marshalMap(cd.Leaf)
+	if err != nil {
+		return APIConditionDefinition{}, fmt.Errorf("invalid condition leaf: %w", err)
+	}
+	api := APIConditionDefinition{Type: cd.Type, Leaf: leaf}
+	if cd.Conditions != nil {
+		api.Conditions = make([]APIConditionDefinition, len(cd.Conditions))
+		for i, child := range cd.Conditions {
+			if api.Conditions[i], err = child.ToAPI(); err != nil {
+				return APIConditionDefinition{}, err
+			}
+		}
+	}
+	return api, nil
+}
+
+// FromAPI sets the condition tree from its API version
+func (cd *ConditionDefinition) FromAPI(api APIConditionDefinition) error {
+	leaf, err := unmarshalMap(api.Leaf)
+	if err != nil {
+		return fmt.Errorf("invalid condition leaf: %w", err)
+	}
+	condition := ConditionDefinition{Type: api.Type, Leaf: leaf}
+	if api.Conditions != nil {
+		condition.Conditions = make([]ConditionDefinition, len(api.Conditions))
+		for i, child := range api.Conditions {
+			if err := condition.Conditions[i].FromAPI(child); err != nil {
+				return err
+			}
+		}
+	}
+	*cd = condition
+	return nil
+}
+
+// ToAPI converts the tool to its API version
+func (t Tool) ToAPI() (APITool, error) {
+	inputSchema, err := json.Marshal(t.InputSchema)
+	if err != nil {
+		return APITool{}, fmt.Errorf("invalid input schema: %w", err)
+	}
+	rawInputSchema, err := marshalSchema(t.RawInputSchema)
+	if err != nil {
+		return APITool{}, fmt.Errorf("invalid raw input schema: %w", err)
+	}
+	parameters, err := marshalMap(t.Parameters)
+	if err != nil {
+		return APITool{}, fmt.Errorf("invalid parameters: %w", err)
+	}
+	return APITool{
+		Name:                t.Name,
+		Description:         t.Description,
+		InputSchema:         inputSchema,
+		RawInputSchema:      rawInputSchema,
+		ActionType:          t.ActionType,
+		Parameters:          parameters,
+		BotOutputVisibility: t.BotOutputVisibility,
+		HistoryContentType:  t.HistoryContentType,
+		UiContentType:       t.UiContentType,
+		AsRoot:              t.AsRoot,
+		RunningStatus:       t.RunningStatus,
+		FinishedStatus:      t.FinishedStatus,
+		Strict:              t.Strict,
+		ApprovalRequired:    t.ApprovalRequired,
+	}, nil
+}
+
+// FromAPI sets the tool from its API version
+func (t *Tool) FromAPI(api APITool) error {
+	var inputSchema JSONSchema
+	if !isNullJSON(api.InputSchema) {
+		if err := json.Unmarshal(api.InputSchema, &inputSchema); err != nil {
+			return fmt.Errorf("invalid input schema: %w", err)
+		}
+	}
+	rawInputSchema, err := unmarshalSchema(api.RawInputSchema)
+	if err != nil {
+		return fmt.Errorf("invalid raw input schema: %w", err)
+	}
+	parameters, err := unmarshalMap(api.Parameters)
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	*t = Tool{
+		Name:                api.Name,
+		Description:         api.Description,
+		InputSchema:         inputSchema,
+		RawInputSchema:      rawInputSchema,
+		ActionType:          api.ActionType,
+		Parameters:          parameters,
+		BotOutputVisibility: api.BotOutputVisibility,
+		HistoryContentType:  api.HistoryContentType,
+		UiContentType:       api.UiContentType,
+		AsRoot:              api.AsRoot,
+		RunningStatus:       api.RunningStatus,
+		FinishedStatus:      api.FinishedStatus,
+		Strict:              api.Strict,
+		ApprovalRequired:    api.ApprovalRequired,
+	}
+	return nil
+}
+
+// ToAPI converts the parameter definition, including its value sources and
+// interpreters, to its API version
+func (pd ParameterDefinition) ToAPI() (APIParameterDefinition, error) {
+	api := APIParameterDefinition{
+		ID:          pd.ID,
+		BotID:       pd.BotID,
+		Name:        pd.Name,
+		Key:         pd.Key,
+		Description: pd.Description,
+		Type:        string(pd.Type),
+		IsRequired:  pd.IsRequired,
+		CreatedAt:   pd.CreatedAt,
+		UpdatedAt:   pd.UpdatedAt,
+	}
+	if pd.ValueSources != nil {
+		api.ValueSources = make([]APIParameterValueSource, len(pd.ValueSources))
+		for i, source := range pd.ValueSources {
+			var err error
+			if api.ValueSources[i], err = source.ToAPI(); err != nil {
+				return APIParameterDefinition{}, fmt.Errorf("value source %d: %w", i, err)
+			}
+		}
+	}
+	if pd.Interpreters != nil {
+		api.Interpreters = make([]APIParameterInterpreter, len(pd.Interpreters))
+		for i, interpreter := range pd.Interpreters {
+			var err error
+			if api.Interpreters[i], err = interpreter.ToAPI(); err != nil {
+				return APIParameterDefinition{}, fmt.Errorf("interpreter %d: %w", i, err)
+			}
+		}
+	}
+	return api, nil
+}
+
+// FromAPI sets the parameter definition from its API version
+func (pd *ParameterDefinition) FromAPI(api APIParameterDefinition) error {
+	def := ParameterDefinition{
+		ID:          api.ID,
+		BotID:       api.BotID,
+		Name:        api.Name,
+		Key:         api.Key,
+		Description: api.Description,
+		Type:        ParameterType(api.Type),
+		IsRequired:  api.IsRequired,
+		CreatedAt:   api.CreatedAt,
+		UpdatedAt:   api.UpdatedAt,
+	}
+	if api.ValueSources != nil {
+		def.ValueSources = make([]ParameterValueSource, len(api.ValueSources))
+		for i, source := range api.ValueSources {
+			if err := def.ValueSources[i].FromAPI(source); err != nil {
+				return fmt.Errorf("value source %d: %w", i, err)
+			}
+		}
+	}
+	if api.Interpreters != nil {
+		def.Interpreters = make([]ParameterInterpreter, len(api.Interpreters))
+		for i, interpreter := range api.Interpreters {
+			if err := def.Interpreters[i].FromAPI(interpreter); err != nil {
+				return fmt.Errorf("interpreter %d: %w", i, err)
+			}
+		}
+	}
+	*pd = def
+	return nil
+}
+
+// ToAPI converts the value source and its handlers to its API version
+func (vs ParameterValueSource) ToAPI() (APIParameterValueSource, error) {
+	parameters, err := marshalMap(vs.Parameters)
+	if err != nil {
+		return APIParameterValueSource{}, fmt.Errorf("invalid parameters: %w", err)
+	}
+	api := APIParameterValueSource{
+		ID:                    vs.ID,
+		ParameterDefinitionID: vs.ParameterDefinitionID,
+		Type:                  vs.Type,
+		Parameters:            parameters,
+		CreatedAt:             vs.CreatedAt,
+		UpdatedAt:             vs.UpdatedAt,
+	}
+	if vs.OnPopulate != nil {
+		api.OnPopulate = make([]APIParameterValueSourceHandler, len(vs.OnPopulate))
+		for i, handler := range vs.OnPopulate {
+			if api.OnPopulate[i], err = handler.ToAPI(); err != nil {
+				return APIParameterValueSource{}, fmt.Errorf("on_populate handler %d: %w", i, err)
+			}
+		}
+	}
+	return api, nil
+}
+
+// FromAPI sets the value source from its API version
+func (vs *ParameterValueSource) FromAPI(api APIParameterValueSource) error {
+	parameters, err := unmarshalMap(api.Parameters)
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	source := ParameterValueSource{
+		ID:                    api.ID,
+		ParameterDefinitionID: api.ParameterDefinitionID,
+		Type:                  api.Type,
+		Parameters:            parameters,
+		CreatedAt:             api.CreatedAt,
+		UpdatedAt:             api.UpdatedAt,
+	}
+	if api.OnPopulate != nil {
+		source.OnPopulate = make([]ParameterValueSourceHandler, len(api.OnPopulate))
+		for i, handler := range api.OnPopulate {
+			if err := source.OnPopulate[i].FromAPI(handler); err != nil {
+				return fmt.Errorf("on_populate handler %d: %w", i, err)
+			}
+		}
+	}
+	*vs = source
+	return nil
+}
+
+// ToAPI converts the handler to its API version. The domain type only has an
+// execution mode name, so the API execution mode has no data or condition.
+func (h ParameterValueSourceHandler) ToAPI() (APIParameterValueSourceHandler, error) {
+	parameters, err := marshalMap(h.Parameters)
+	if err != nil {
+		return APIParameterValueSourceHandler{}, fmt.Errorf("invalid parameters: %w", err)
+	}
+	return APIParameterValueSourceHandler{
+		ID:                     h.ID,
+		ParameterValueSourceID: h.ParameterValueSourceID,
+		ActionType:             h.ActionType,
+		Parameters:             parameters,
+		ExecutionMode:          APIExecutionMode{Mode: ExecutionModeType(h.ExecutionMode)},
+		CreatedAt:              h.CreatedAt,
+		UpdatedAt:              h.UpdatedAt,
+	}, nil
+}
+
+// FromAPI sets the handler from its API version, keeping only the name of the
+// execution mode
+func (h *ParameterValueSourceHandler) FromAPI(api APIParameterValueSourceHandler) error {
+	parameters, err := unmarshalMap(api.Parameters)
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	*h = ParameterValueSourceHandler{
+		ID:                     api.ID,
+		ParameterValueSourceID: api.ParameterValueSourceID,
+		ActionType:             api.ActionType,
+		Parameters:             parameters,
+		ExecutionMode:          string(api.ExecutionMode.Mode),
+		CreatedAt:              api.CreatedAt,
+		UpdatedAt:              api.UpdatedAt,
+	}
+	return nil
+}
+
+// ToAPI converts the interpreter to its API version
+func (pi ParameterInterpreter) ToAPI() (APIParameterInterpreter, error) {
+	parameters, err := marshalMap(pi.Parameters)
+	if err != nil {
+		return APIParameterInterpreter{}, fmt.Errorf("invalid parameters: %w", err)
+	}
+	return APIParameterInterpreter{
+		ID:                    pi.ID,
+		ParameterDefinitionID: pi.ParameterDefinitionID,
+		ActionType:            pi.ActionType,
+		Parameters:            parameters,
+		InterpreterOrder:      pi.InterpreterOrder,
+		CreatedAt:             pi.CreatedAt,
+		UpdatedAt:             pi.UpdatedAt,
+	}, nil
+}
+
+// FromAPI sets the interpreter from its API version
+func (pi *ParameterInterpreter) FromAPI(api APIParameterInterpreter) error {
+	parameters, err := unmarshalMap(api.Parameters)
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	*pi = ParameterInterpreter{
+		ID:                    api.ID,
+		ParameterDefinitionID: api.ParameterDefinitionID,
+		ActionType:            api.ActionType,
+		Parameters:            parameters,
+		InterpreterOrder:      api.InterpreterOrder,
+		CreatedAt:             api.CreatedAt,
+		UpdatedAt:             api.UpdatedAt,
+	}
+	return nil
+}
+
+// ToAPI converts the result handler and its steps to its API version
+func (rh ResultHandler) ToAPI() (APIResultHandler, error) {
+	conditions, err := rh.IfConditions.ToAPI()
+	if err != nil {
+		return APIResultHandler{}, err
+	}
+	api := APIResultHandler{
+		Type:               rh.Type,
+		IfConditions:       conditions,
+		ResultHandlerOrder: rh.ResultHandlerOrder,
+		OutputContentType:  rh.OutputContentType,
+		HistoryContentType: rh.HistoryContentType,
+		UiContentType:      rh.UiContentType,
+	}
+	if rh.Steps != nil {
+		api.Steps = make([]APIStepWithHandlers, len(rh.Steps))
+		for i, step := range rh.Steps {
+			if api.Steps[i], err = step.ToAPI(); err != nil {
+				return APIResultHandler{}, fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+	}
+	return api, nil
+}
+
+// FromAPI sets the result handler from its API version
+func (rh *ResultHandler) FromAPI(api APIResultHandler) error {
+	handler := ResultHandler{
+		Type:               api.Type,
+		ResultHandlerOrder: api.ResultHandlerOrder,
+		OutputContentType:  api.OutputContentType,
+		HistoryContentType: api.HistoryContentType,
+		UiContentType:      api.UiContentType,
+	}
+	if err := handler.IfConditions.FromAPI(api.IfConditions); err != nil {
+		return err
+	}
+	if api.Steps != nil {
+		handler.Steps = make([]StepWithHandlers, len(api.Steps))
+		for i, step := range api.Steps {
+			if err := handler.Steps[i].FromAPI(step); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+	}
+	*rh = handler
+	return nil
+}
+
+// ToAPI converts the step and its result handlers to its API version
+func (s StepWithHandlers) ToAPI() (APIStepWithHandlers, error) {
+	step, err := s.Step.ToAPI()
+	if err != nil {
+		return APIStepWithHandlers{}, err
+	}
+	api := APIStepWithHandlers{Step: step}
+	if s.ResultHandlers != nil {
+		api.ResultHandlers = make([]APIResultHandler, len(s.ResultHandlers))
+		for i, handler := range s.ResultHandlers {
+			if api.ResultHandlers[i], err = handler.ToAPI(); err != nil {
+				return APIStepWithHandlers{}, fmt.Errorf("result handler %d: %w", i, err)
+			}
+		}
+	}
+	return api, nil
+}
+
+// FromAPI sets the step and its result handlers from its API version
+func (s *StepWithHandlers) FromAPI(api APIStepWithHandlers) error {
+	var step StepWithHandlers
+	if err := step.Step.FromAPI(api.Step); err != nil {
+		return err
+	}
+	if api.ResultHandlers != nil {
+		step.ResultHandlers = make([]ResultHandler, len(api.ResultHandlers))
+		for i, handler := range api.ResultHandlers {
+			if err := step.ResultHandlers[i].FromAPI(handler); err != nil {
+				return fmt.Errorf("result handler %d: %w", i, err)
+			}
+		}
+	}
+	*s = step
+	return nil
+}
+
+// ToUpsertRequest builds the request to create or update the discovered agent.
+// The bot's code and file path default to the discovered source and file.
+func (d AgentDiscovery) ToUpsertRequest() (UpsertBotRequest, error) {
+	bot := d.Bot
+	if bot.Code == "" {
+		bot.Code = d.SourceCode
+	}
+	if bot.FilePath == "" {
+		bot.FilePath = d.FilePath
+	}
+
+	request := UpsertBotRequest{
+		Bot:    bot,
+		Steps:  make([]APIStepWithHandlers, len(d.Steps)),
+		Source: bot.Source,
+	}
+	for i, step := range d.Steps {
+		var err error
+		if request.Steps[i], err = step.ToAPI(); err != nil {
+			return UpsertBotRequest{}, fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+	if d.ParameterDefinitions != nil {
+		request.ParameterDefinitions = make([]APIParameterDefinition, len(d.ParameterDefinitions))
+		for i, def := range d.ParameterDefinitions {
+			var err error
+			if request.ParameterDefinitions[i], err = def.ToAPI(); err != nil {
+				return UpsertBotRequest{}, fmt.Errorf("parameter definition %d: %w", i, err)
+			}
+		}
+	}
+	return request, nil
+}
+
+func isNullJSON(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
+
+// marshalMap encodes a map, keeping nil maps nil
+func marshalMap(m map[string]any) (json.RawMessage, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func unmarshalMap(raw json.RawMessage) (map[string]any, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func marshalSchema(s *Schema) (json.RawMessage, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+func unmarshalSchema(raw json.RawMessage) (*Schema, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// marshalValue encodes a value, keeping nil values nil
+func marshalValue(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func unmarshalValue(raw json.RawMessage) (any, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
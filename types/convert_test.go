@@ -0,0 +1,187 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var stepRoundTripSeeds = []string{
+	`{}`,
+	`{"step": {"parameters": null, "execution_mode": {"data": null}}}`,
+	`{"step": {"parameters": {}, "depends_on": [], "output_channels": []}, "result_handlers": []}`,
+	`{
+		"step": {
+			"id": "s1", "bot_id": "b1", "action_type": "llm.message", "key": "ask", "step_order": 2,
+			"parameters": {"prompt": "{{query}}", "n": 1.5, "tools": [{"name": "a", "strict": true}], "none": null},
+			"depends_on": ["search"], "output_content_type": "text",
+			"user_output_visibility": "visible", "bot_output_visibility": "hidden",
+			"execution_mode": {"mode": "iterate_over", "data": ["a", 1, {"b": false}],
+				"if_condition": {"type": "and", "conditions": [{"type": "IsSuccess", "leaf": {}}, {"type": "GreaterThan", "leaf": {"value": 3}}]}},
+			"output_behaviour": {"result": "merge"}, "output_channels": ["chat"],
+			"running_status": "Running", "history_role": "user",
+			"parameter_hydration_behaviour": "raw",
+			"hydration_policy": {"default": "hydrate", "rules": [{"path": "code", "behaviour": "raw"}]},
+			"created_at": "2025-01-02T03:04:05Z"
+		},
+		"result_handlers": [{
+			"type": "final", "if_conditions": {"type": "IsError"}, "result_handler_order": 1,
+			"output_content_type": "json", "ui_content_type": "table",
+			"steps": [{"step": {"action_type": "utils.echo", "execution_mode": {"data": "x"}}, "result_handlers": null}]
+		}]
+	}`,
+}
+
+// FuzzStepRoundTrip checks that converting any step decoded from JSON to the
+// API and back gives the same step, and that the API version is stable
+func FuzzStepRoundTrip(f *testing.F) {
+	for _, seed := range stepRoundTripSeeds {
+		// Seeds that don't decode would be skipped silently by the fuzz function
+		var step StepWithHandlers
+		if err := json.Unmarshal([]byte(seed), &step); err != nil {
+			f.Fatalf("seed doesn't decode: %v\n%s", err, seed)
+		}
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var step StepWithHandlers
+		if err := json.Unmarshal([]byte(input), &step); err != nil {
+			return
+		}
+
+		api, err := step.ToAPI()
+		require.NoError(t, err)
+		var back StepWithHandlers
+		require.NoError(t, back.FromAPI(api))
+		assert.Equal(t, step, back)
+
+		again, err := back.ToAPI()
+		require.NoError(t, err)
+		assert.Equal(t, api, again)
+	})
+}
+
+var parameterDefinitionRoundTripSeeds = []string{
+	`{"name": "Dataset", "key": "dataset", "type": "string", "is_required": true}`,
+	`{
+		"id": "8f5e2a8a-4a52-4b0e-9a35-1c4b1d7f7f10", "key": "dataset", "description": "Which dataset",
+		"value_sources": [{"type": "dataset", "parameters": {"limit": 10}, "on_populate": [
+			{"action_type": "utils.echo", "parameters": {"x": "{{dataset}}"}, "execution_mode": "all"}]}],
+		"interpreters": [{"action_type": "llm.message", "parameters": null, "interpreter_order": 1}]
+	}`,
+	`{"value_sources": [], "interpreters": []}`,
+}
+
+func FuzzParameterDefinitionRoundTrip(f *testing.F) {
+	for _, seed := range parameterDefinitionRoundTripSeeds {
+		var def ParameterDefinition
+		if err := json.Unmarshal([]byte(seed), &def); err != nil {
+			f.Fatalf("seed doesn't decode: %v\n%s", err, seed)
+		}
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var def ParameterDefinition
+		if err := json.Unmarshal([]byte(input), &def); err != nil {
+			return
+		}
+
+		api, err := def.ToAPI()
+		require.NoError(t, err)
+		var back ParameterDefinition
+		require.NoError(t, back.FromAPI(api))
+		assert.Equal(t, def, back)
+	})
+}
+
+func TestToolRoundTrip(t *testing.T) {
+	running := "Searching"
+	tool := Tool{
+		Name:        "search",
+		Description: "Search the web",
+		InputSchema: JSONSchema{
+			Type:       JSONSchemaTypeObject,
+			Properties: map[string]JSONSchemaProperty{"query": {Type: JSONSchemaTypeString}},
+			Required:   []string{"query"},
+		},
+		RawInputSchema:   &Schema{Type: []string{"object"}, Defs: map[string]*Schema{"query": {Type: []string{"string"}}}},
+		ActionType:       "websearch.search",
+		Parameters:       map[string]any{"query": "{{query}}"},
+		AsRoot:           true,
+		RunningStatus:    &running,
+		Strict:           true,
+		ApprovalRequired: true,
+	}
+
+	api, err := tool.ToAPI()
+	require.NoError(t, err)
+	assert.True(t, api.Strict)
+	assert.True(t, api.ApprovalRequired)
+	assert.JSONEq(t, `{"type": "object", "$defs": {"query": {"type": "string"}}}`, string(api.RawInputSchema))
+
+	var back Tool
+	require.NoError(t, back.FromAPI(api))
+	assert.Equal(t, tool, back)
+
+	t.Run("missing optional fields stay empty", func(t *testing.T) {
+		var tool Tool
+		require.NoError(t, tool.FromAPI(APITool{Name: "bare"}))
+		assert.Equal(t, Tool{Name: "bare"}, tool)
+	})
+}
+
+func TestFromAPIErrors(t *testing.T) {
+	var step StepWithHandlers
+	err := step.FromAPI(APIStepWithHandlers{
+		ResultHandlers: []APIResultHandler{{Steps: []APIStepWithHandlers{{Step: APIStep{Parameters: json.RawMessage(`[1]`)}}}}},
+	})
+	assert.ErrorContains(t, err, "result handler 0: step 0: invalid parameters")
+
+	var condition ConditionDefinition
+	assert.ErrorContains(t, condition.FromAPI(APIConditionDefinition{Leaf: json.RawMessage(`"x"`)}), "invalid condition leaf")
+
+	_, err = Step{Parameters: map[string]any{"ch": make(chan int)}}.ToAPI()
+	assert.ErrorContains(t, err, "invalid parameters")
+}
+
+func TestAgentDiscoveryToUpsertRequest(t *testing.T) {
+	key := "ask"
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	id := uuid.New()
+	discovery := AgentDiscovery{
+		Bot:      Bot{Name: "Assistant", Source: "cli"},
+		FilePath: "agents/assistant.py",
+		ParameterDefinitions: []ParameterDefinition{{
+			ID:           &id,
+			Key:          "dataset",
+			Type:         "string",
+			ValueSources: []ParameterValueSource{{Type: "dataset", Parameters: map[string]any{"limit": 10.0}}},
+			Interpreters: []ParameterInterpreter{{ActionType: "llm.message", InterpreterOrder: 1}},
+		}},
+		Steps: []StepWithHandlers{{
+			Step: Step{ActionType: "llm.message", Key: &key, Parameters: map[string]any{"prompt": "hi"}, CreatedAt: &created},
+		}},
+		SourceCode: "agent = Agent(name='Assistant')",
+	}
+
+	request, err := discovery.ToUpsertRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "agent = Agent(name='Assistant')", request.Bot.Code)
+	assert.Equal(t, "agents/assistant.py", request.Bot.FilePath)
+	assert.Equal(t, "cli", request.Source)
+	require.Len(t, request.Steps, 1)
+	assert.JSONEq(t, `{"prompt": "hi"}`, string(request.Steps[0].Step.Parameters))
+	require.Len(t, request.ParameterDefinitions, 1)
+	assert.JSONEq(t, `{"limit": 10}`, string(request.ParameterDefinitions[0].ValueSources[0].Parameters))
+	assert.Len(t, request.ParameterDefinitions[0].Interpreters, 1)
+
+	var step StepWithHandlers
+	require.NoError(t, step.FromAPI(request.Steps[0]))
+	assert.Equal(t, discovery.Steps[0], step)
+}
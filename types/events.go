@@ -0,0 +1,195 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invocation Events
+// =================
+
+// InvocationEvent is an event in a bot invocation's stream. Payload holds the
+// typed payload for the event type (e.g. *StepStartedPayload for step started),
+// or a json.RawMessage for event types without one.
+type InvocationEvent struct {
+	Type         InvocationEventType `json:"type"`
+	InvocationID uuid.UUID           `json:"invocation_id"`
+	// Sequence orders the invocation's events, starting at 1. It's sent as the
+	// SSE event ID so clients can resume a stream.
+	Sequence  int64     `json:"sequence"`
+	CreatedAt time.Time `json:"created_at"`
+	Payload   any       `json:"payload,omitempty"`
+}
+
+// MessageCreatedPayload is the payload of message created events
+type MessageCreatedPayload struct {
+	MessageID string `json:"message_id"`
+	Role      string `json:"role"`
+	StepID    string `json:"step_id,omitempty"`
+}
+
+// MessageFinishedPayload is the payload of message finished events, after which
+// no more content is added to the message
+type MessageFinishedPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// StepOutputCreatedPayload is the payload of step output created events
+type StepOutputCreatedPayload struct {
+	StepOutputID string `json:"step_output_id"`
+	StepID       string `json:"step_id,omitempty"`
+	StepKey      string `json:"step_key,omitempty"`
+}
+
+// StepOutputFinishedPayload is the payload of step output finished events
+type StepOutputFinishedPayload struct {
+	StepOutputID string `json:"step_output_id"`
+}
+
+// CreateContentPayload is the payload of create message content and create step
+// output content events, which start a new block of content
+type CreateContentPayload struct {
+	MessageID    string `json:"message_id,omitempty"`
+	StepOutputID string `json:"step_output_id,omitempty"`
+	ContentID    string `json:"content_id,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+}
+
+// ContentResultPayload is the payload of message content result and step output
+// content result events, which carry the final content of a block. Content is
+// JSON-encoded for JSON content types.
+type ContentResultPayload struct {
+	MessageID    string `json:"message_id,omitempty"`
+	StepOutputID string `json:"step_output_id,omitempty"`
+	ContentID    string `json:"content_id,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Content      string `json:"content"`
+}
+
+// ContentDeltaPayload is the payload of message content delta and step output
+// content delta events, which add Delta to the end of the content
+type ContentDeltaPayload struct {
+	MessageID    string `json:"message_id,omitempty"`
+	StepOutputID string `json:"step_output_id,omitempty"`
+	ContentID    string `json:"content_id,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Delta        string `json:"delta"`
+}
+
+// StepStartedPayload is the payload of step started events
+type StepStartedPayload struct {
+	StepID        string `json:"step_id"`
+	StepKey       string `json:"step_key,omitempty"`
+	ActionType    string `json:"action_type"`
+	RunningStatus string `json:"running_status,omitempty"`
+}
+
+// StepResultPayload is the payload of step result events
+type StepResultPayload struct {
+	StepID     string `json:"step_id"`
+	StepKey    string `json:"step_key,omitempty"`
+	ActionType string `json:"action_type"`
+	Result     Result `json:"result"`
+}
+
+// RequiresInfoPayload is the payload of requires info events, sent when a step
+// can't run without more information from the user
+type RequiresInfoPayload struct {
+	StepID      string   `json:"step_id,omitempty"`
+	Message     string   `json:"message"`
+	MissingKeys []string `json:"missing_keys,omitempty"`
+}
+
+// StatusPayload is the payload of status events, which only provide information
+type StatusPayload struct {
+	StepID  string `json:"step_id,omitempty"`
+	Message string `json:"message"`
+}
+
+// DatasetCreatedPayload is the payload of dataset created events
+type DatasetCreatedPayload struct {
+	Dataset Dataset `json:"dataset"`
+}
+
+// ErrorPayload is the payload of error events
+type ErrorPayload struct {
+	StepID  string `json:"step_id,omitempty"`
+	Error   Error  `json:"error"`
+	Message string `json:"message"`
+}
+
+// ResultPayload is the payload of result events, the last event of an invocation
+type ResultPayload struct {
+	Result Result `json:"result"`
+}
+
+// LogLevel is the severity of a log event
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+)
+
+// LogPayload is the payload of log events
+type LogPayload struct {
+	Level   LogLevel       `json:"level"`
+	Message string         `json:"message"`
+	StepID  string         `json:"step_id,omitempty"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// invocationEventPayloads creates the typed payload for each event type that has one
+var invocationEventPayloads = map[InvocationEventType]func() any{
+	InvocationEventTypeMessageCreated:          func() any { return &MessageCreatedPayload{} },
+	InvocationEventTypeMessageFinished:         func() any { return &MessageFinishedPayload{} },
+	InvocationEventTypeMessageContentDelta:     func() any { return &ContentDeltaPayload{} },
+	InvocationEventTypeCreateMessageContent:    func() any { return &CreateContentPayload{} },
+	InvocationEventTypeMessageContentResult:    func() any { return &ContentResultPayload{} },
+	InvocationEventTypeStepOutputCreated:       func() any { return &StepOutputCreatedPayload{} },
+	InvocationEventTypeStepOutputFinished:      func() any { return &StepOutputFinishedPayload{} },
+	InvocationEventTypeStepOutputContentDelta:  func() any { return &ContentDeltaPayload{} },
+	InvocationEventTypeCreateStepOutputContent: func() any { return &CreateContentPayload{} },
+	InvocationEventTypeStepOutputContentResult: func() any { return &ContentResultPayload{} },
+	InvocationEventTypeStepStarted:             func() any { return &StepStartedPayload{} },
+	InvocationEventTypeStepResult:              func() any { return &StepResultPayload{} },
+	InvocationEventTypeRequiresInfo:            func() any { return &RequiresInfoPayload{} },
+	InvocationEventTypeError:                   func() any { return &ErrorPayload{} },
+	InvocationEventTypeStatus:                  func() any { return &StatusPayload{} },
+	InvocationEventTypeResult:                  func() any { return &ResultPayload{} },
+	InvocationEventTypeDatasetCreated:          func() any { return &DatasetCreatedPayload{} },
+	InvocationEventTypeLog:                     func() any { return &LogPayload{} },
+}
+
+// UnmarshalJSON decodes the payload into the typed payload for the event's type
+func (e *InvocationEvent) UnmarshalJSON(data []byte) error {
+	type invocationEvent InvocationEvent
+	var raw struct {
+		invocationEvent
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = InvocationEvent(raw.invocationEvent)
+
+	if isNullJSON(raw.Payload) {
+		return nil
+	}
+	newPayload, ok := invocationEventPayloads[e.Type]
+	if !ok {
+		e.Payload = raw.Payload
+		return nil
+	}
+	payload := newPayload()
+	if err := json.Unmarshal(raw.Payload, payload); err != nil {
+		return fmt.Errorf("invalid %s event payload: %w", e.Type, err)
+	}
+	e.Payload = payload
+	return nil
+}
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvocationEventJSON(t *testing.T) {
+	var event InvocationEvent
+	require.NoError(t, json.Unmarshal([]byte(`{"type": "step result", "sequence": 4, "payload": {
+		"step_id": "s1", "action_type": "codeexec.execute", "result": {"status": "success", "output": {"rows": 3}}
+	}}`), &event))
+	payload, ok := event.Payload.(*StepResultPayload)
+	require.True(t, ok, "got %T", event.Payload)
+	assert.Equal(t, StatusSuccess, payload.Result.Status)
+	assert.Equal(t, map[string]any{"rows": 3.0}, *payload.Result.Output)
+
+	t.Run("event types without a payload type keep the raw JSON", func(t *testing.T) {
+		var event InvocationEvent
+		require.NoError(t, json.Unmarshal([]byte(`{"type": "bot started", "payload": {"bot_id": "b1"}}`), &event))
+		assert.JSONEq(t, `{"bot_id": "b1"}`, string(event.Payload.(json.RawMessage)))
+
+		require.NoError(t, json.Unmarshal([]byte(`{"type": "status"}`), &event))
+		assert.Nil(t, event.Payload)
+	})
+
+	t.Run("invalid payloads", func(t *testing.T) {
+		err := json.Unmarshal([]byte(`{"type": "log", "payload": {"level": 5}}`), &event)
+		assert.ErrorContains(t, err, "invalid log event payload")
+	})
+}
@@ -0,0 +1,422 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// JSON Schemas
+// ============
+
+// Schema is a typed JSON schema, covering the subset of draft 2020-12 that LLM
+// providers use for tool inputs, plus the OpenAPI nullable keyword. It's the
+// type of Tool.RawInputSchema; JSONSchema is the simpler form of InputSchema,
+// and the two convert with JSONSchema.ToSchema and Schema.ToJSONSchema. The
+// schema package sanitises schemas for each provider.
+//
+// A schema can also be a boolean schema (true accepts anything, false nothing),
+// which is how additionalProperties: false is written. Keywords this type
+// doesn't model are kept in Extra, so parsing and encoding a schema loses
+// nothing.
+type Schema struct {
+	// Bool is set for boolean schemas, in which case every other field is ignored
+	Bool *bool `json:"-"`
+
+	Ref  string             `json:"$ref,omitempty"`
+	Defs map[string]*Schema `json:"$defs,omitempty"`
+
+	// Type holds one type, or several for unions like ["string", "null"]
+	Type        []string `json:"type,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Enum        []any    `json:"enum,omitempty"`
+	Const       any      `json:"const,omitempty"`
+	Default     any      `json:"default,omitempty"`
+	Examples    []any    `json:"examples,omitempty"`
+	// Nullable is the OpenAPI 3.0 way of allowing null, used by Gemini
+	Nullable bool `json:"nullable,omitempty"`
+
+	// Strings
+	Format    string `json:"format,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+
+	// Numbers
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+	MultipleOf       *float64 `json:"multipleOf,omitempty"`
+
+	// Arrays
+	Items       *Schema `json:"items,omitempty"`
+	MinItems    *int    `json:"minItems,omitempty"`
+	MaxItems    *int    `json:"maxItems,omitempty"`
+	UniqueItems bool    `json:"uniqueItems,omitempty"`
+
+	// Objects
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	MinProperties        *int               `json:"minProperties,omitempty"`
+	MaxProperties        *int               `json:"maxProperties,omitempty"`
+
+	// Composition
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+	OneOf []*Schema `json:"oneOf,omitempty"`
+	AllOf []*Schema `json:"allOf,omitempty"`
+	Not   *Schema   `json:"not,omitempty"`
+
+	// Extra holds keywords this type doesn't model
+	Extra map[string]any `json:"-"`
+}
+
+// BoolSchema returns a boolean schema
+func BoolSchema(b bool) *Schema {
+	return &Schema{Bool: &b}
+}
+
+// IsBool reports whether the schema is the boolean schema b
+func (s *Schema) IsBool(b bool) bool {
+	return s != nil && s.Bool != nil && *s.Bool == b
+}
+
+// HasType reports whether the schema allows the type
+func (s *Schema) HasType(t string) bool {
+	return s != nil && slices.Contains(s.Type, t)
+}
+
+// IsNullable reports whether the schema allows null, with a "null" type, the
+// nullable keyword or a null branch of anyOf/oneOf
+func (s *Schema) IsNullable() bool {
+	if s == nil {
+		return false
+	}
+	if s.Nullable || s.HasType(string(JSONSchemaTypeNull)) {
+		return true
+	}
+	for _, branch := range slices.Concat(s.AnyOf, s.OneOf) {
+		if branch.IsNullable() {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaFields is the schema with its own JSON methods removed
+type schemaFields Schema
+
+// knownKeywords are the JSON names of the modelled keywords
+var knownKeywords = func() map[string]bool {
+	known := map[string]bool{}
+	t := reflect.TypeOf(Schema{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "-" {
+			known[name] = true
+		}
+	}
+	return known
+}()
+
+// MarshalJSON writes a single type as a string and merges in the extra keywords
+func (s Schema) MarshalJSON() ([]byte, error) {
+	if s.Bool != nil {
+		return json.Marshal(*s.Bool)
+	}
+
+	// Empty properties and required lists are written when they're set, as some
+	// providers require them on every object
+	fields := struct {
+		schemaFields
+		Type       any                 `json:"type,omitempty"`
+		Properties *map[string]*Schema `json:"properties,omitempty"`
+		Required   *[]string           `json:"required,omitempty"`
+	}{schemaFields: schemaFields(s)}
+	if s.Properties != nil {
+		fields.Properties = &s.Properties
+	}
+	if s.Required != nil {
+		fields.Required = &s.Required
+	}
+	switch len(s.Type) {
+	case 0:
+	case 1:
+		fields.Type = s.Type[0]
+	default:
+		fields.Type = s.Type
+	}
+	data, err := json.Marshal(fields)
+	if err != nil || len(s.Extra) == 0 {
+		return data, err
+	}
+
+	var merged map[string]any
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for key, value := range s.Extra {
+		if !knownKeywords[key] {
+			merged[key] = value
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON reads boolean schemas, a type given as a string or a list and
+// keeps unknown keywords in Extra
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.Equal(trimmed, []byte("true")) || bytes.Equal(trimmed, []byte("false")) {
+		*s = *BoolSchema(trimmed[0] == 't')
+		return nil
+	}
+
+	var fields struct {
+		schemaFields
+		Type json.RawMessage `json:"type,omitempty"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*s = Schema(fields.schemaFields)
+
+	if len(fields.Type) > 0 {
+		var single string
+		if err := json.Unmarshal(fields.Type, &single); err == nil {
+			s.Type = []string{single}
+		} else if err := json.Unmarshal(fields.Type, &s.Type); err != nil {
+			return fmt.Errorf("invalid schema type %s: %w", fields.Type, err)
+		}
+	}
+
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key, value := range all {
+		if knownKeywords[key] {
+			continue
+		}
+		if s.Extra == nil {
+			s.Extra = map[string]any{}
+		}
+		s.Extra[key] = value
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the schema tree. Values such as enums, defaults
+// and extra keywords are shared, as schemas don't modify them.
+func (s *Schema) Clone() *Schema {
+	if s == nil {
+		return nil
+	}
+	clone := *s
+	if s.Bool != nil {
+		b := *s.Bool
+		clone.Bool = &b
+	}
+	clone.Type = slices.Clone(s.Type)
+	clone.Enum = slices.Clone(s.Enum)
+	clone.Examples = slices.Clone(s.Examples)
+	clone.Required = slices.Clone(s.Required)
+	clone.Extra = maps.Clone(s.Extra)
+	clone.Defs = cloneSchemaMap(s.Defs)
+	clone.Properties = cloneSchemaMap(s.Properties)
+	clone.Items = s.Items.Clone()
+	clone.AdditionalProperties = s.AdditionalProperties.Clone()
+	clone.Not = s.Not.Clone()
+	clone.AnyOf = cloneSchemaList(s.AnyOf)
+	clone.OneOf = cloneSchemaList(s.OneOf)
+	clone.AllOf = cloneSchemaList(s.AllOf)
+	return &clone
+}
+
+func cloneSchemaMap(m map[string]*Schema) map[string]*Schema {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]*Schema, len(m))
+	for key, value := range m {
+		clone[key] = value.Clone()
+	}
+	return clone
+}
+
+func cloneSchemaList(list []*Schema) []*Schema {
+	if list == nil {
+		return nil
+	}
+	clone := make([]*Schema, len(list))
+	for i, value := range list {
+		clone[i] = value.Clone()
+	}
+	return clone
+}
+
+// Walk calls fn for the schema and every schema nested in it, parents first
+func (s *Schema) Walk(fn func(*Schema)) {
+	if s == nil {
+		return
+	}
+	fn(s)
+	for _, key := range sortedKeys(s.Defs) {
+		s.Defs[key].Walk(fn)
+	}
+	for _, key := range sortedKeys(s.Properties) {
+		s.Properties[key].Walk(fn)
+	}
+	s.Items.Walk(fn)
+	s.AdditionalProperties.Walk(fn)
+	s.Not.Walk(fn)
+	for _, list := range [][]*Schema{s.AnyOf, s.OneOf, s.AllOf} {
+		for _, branch := range list {
+			branch.Walk(fn)
+		}
+	}
+}
+
+// ResolveRef returns the definition a local reference like #/$defs/Address
+// points to in the schema's $defs, or nil if there isn't one
+func (s *Schema) ResolveRef(ref string) *Schema {
+	if s == nil {
+		return nil
+	}
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			return s.Defs[name]
+		}
+	}
+	return nil
+}
+
+// SchemaFromMap parses a schema from its decoded JSON
+func SchemaFromMap(m map[string]any) (*Schema, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &s, nil
+}
+
+// ToMap returns the schema as decoded JSON
+func (s *Schema) ToMap() (map[string]any, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("schema isn't an object: %w", err)
+	}
+	return m, nil
+}
+
+// ToSchema converts the simple tool schema to a Schema
+func (js JSONSchema) ToSchema() *Schema {
+	s := &Schema{Required: slices.Clone(js.Required)}
+	if js.Type != "" {
+		s.Type = []string{string(js.Type)}
+	}
+	if js.Properties != nil {
+		s.Properties = make(map[string]*Schema, len(js.Properties))
+		for key, property := range js.Properties {
+			s.Properties[key] = property.ToSchema()
+		}
+	}
+	if js.Items != nil {
+		s.Items = js.Items.ToSchema()
+	}
+	return s
+}
+
+// ToSchema converts a property of the simple tool schema to a Schema
+func (p JSONSchemaProperty) ToSchema() *Schema {
+	s := &Schema{Description: p.Description}
+	if p.Type != "" {
+		s.Type = []string{string(p.Type)}
+	}
+	for _, value := range p.Enum {
+		s.Enum = append(s.Enum, value)
+	}
+	if p.Items != nil {
+		s.Items = p.Items.ToSchema()
+	}
+	return s
+}
+
+// ToJSONSchema converts the schema to the simple tool schema, for consumers
+// that only read InputSchema. Only types, descriptions, string enums, items and
+// required properties are kept; the first non-null type stands in for unions.
+func (s *Schema) ToJSONSchema() JSONSchema {
+	js := JSONSchema{Type: JSONSchemaTypeObject, Properties: map[string]JSONSchemaProperty{}, Required: []string{}}
+	if s == nil || s.Bool != nil {
+		return js
+	}
+	if t := s.simpleType(); t != "" {
+		js.Type = t
+	}
+	for _, key := range sortedKeys(s.Properties) {
+		js.Properties[key] = s.Properties[key].toJSONSchemaProperty()
+	}
+	js.Required = append(js.Required, s.Required...)
+	if s.Items != nil {
+		items := s.Items.toJSONSchemaProperty()
+		js.Items = &items
+	}
+	return js
+}
+
+func (s *Schema) toJSONSchemaProperty() JSONSchemaProperty {
+	if s == nil || s.Bool != nil {
+		return JSONSchemaProperty{}
+	}
+	p := JSONSchemaProperty{Type: s.simpleType(), Description: s.Description}
+	for _, value := range s.Enum {
+		if str, ok := value.(string); ok {
+			p.Enum = append(p.Enum, str)
+		}
+	}
+	if s.Items != nil {
+		items := s.Items.toJSONSchemaProperty()
+		p.Items = &items
+	}
+	return p
+}
+
+// simpleType returns the schema's first non-null type
+func (s *Schema) simpleType() JSONSchemaType {
+	for _, t := range s.Type {
+		if t != string(JSONSchemaTypeNull) {
+			return JSONSchemaType(t)
+		}
+	}
+	return ""
+}
+
+// Schema returns the tool's input schema: RawInputSchema if it's set, and
+// InputSchema converted to a Schema otherwise
+func (t Tool) Schema() *Schema {
+	if t.RawInputSchema != nil {
+		return t.RawInputSchema
+	}
+	return t.InputSchema.ToSchema()
+}
+
+// SetSchema sets the tool's input schema, as RawInputSchema and as its simple
+// form in InputSchema
+func (t *Tool) SetSchema(s *Schema) {
+	t.RawInputSchema = s
+	t.InputSchema = s.ToJSONSchema()
+}
@@ -0,0 +1,488 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON Schema Validation
+// ======================
+
+// SchemaValidateOptions configures Schema validation
+type SchemaValidateOptions struct {
+	// Coerce fixes common LLM mistakes before checking a value: numbers and
+	// booleans sent as strings, a single value where an array is expected, and
+	// objects or arrays sent as JSON-encoded strings
+	Coerce bool
+}
+
+// Validate checks a JSON value against the schema and returns every violation.
+// Formats are annotations and aren't checked.
+func (s *Schema) Validate(value any) Violations {
+	_, violations := s.ValidateWithOptions(value, SchemaValidateOptions{})
+	return violations
+}
+
+// ValidateWithOptions checks a JSON value against the schema, returning the value
+// after any coercion along with the violations left. The value passed in is
+// never modified.
+func (s *Schema) ValidateWithOptions(value any, opts SchemaValidateOptions) (any, Violations) {
+	value, err := normalizeJSON(value)
+	if err != nil {
+		return value, Violations{NewViolation("", "a JSON value", value)}
+	}
+	v := &schemaValidator{root: s, coerce: opts.Coerce, resolving: map[string]bool{}}
+	value = v.check(s, value, "")
+	return value, v.violations
+}
+
+type schemaValidator struct {
+	root       *Schema
+	coerce     bool
+	violations Violations
+	// resolving holds the references being followed at each path, so circular
+	// references like a -> b -> a are reported instead of recursing forever
+	resolving map[string]bool
+}
+
+func (v *schemaValidator) add(path, expected string, got any) {
+	v.violations = append(v.violations, NewViolation(path, expected, got))
+}
+
+// sub validates a value against a schema without recording the violations, for
+// composition keywords
+func (v *schemaValidator) sub(s *Schema, value any, path string) (any, Violations) {
+	sub := &schemaValidator{root: v.root, coerce: v.coerce, resolving: v.resolving}
+	value = sub.check(s, value, path)
+	return value, sub.violations
+}
+
+// check validates the value, returning it after any coercion
+func (v *schemaValidator) check(s *Schema, value any, path string) any {
+	if s == nil || s.IsBool(true) {
+		return value
+	}
+	if s.IsBool(false) {
+		v.add(path, "no value", value)
+		return value
+	}
+
+	if s.Ref != "" {
+		if resolved := v.root.ResolveRef(s.Ref); resolved != nil {
+			// A reference followed again at the same path never reaches a value
+			key := path + "\x00" + s.Ref
+			if v.resolving[key] {
+				v.violations = append(v.violations, Violation{
+					Path:     path,
+					Expected: "a schema without circular references",
+					Got:      fmt.Sprintf("%s refers back to itself", s.Ref),
+				})
+				return value
+			}
+			v.resolving[key] = true
+			value = v.check(resolved, value, path)
+			delete(v.resolving, key)
+		}
+	}
+	if v.coerce {
+		value = coerce(s, value)
+	}
+	if value == nil && s.Nullable {
+		return value
+	}
+
+	if len(s.Type) > 0 && !matchesAnyType(value, s.Type) {
+		v.add(path, strings.Join(s.Type, " or "), value)
+		return value
+	}
+	if len(s.Enum) > 0 && !containsJSON(s.Enum, value) {
+		v.add(path, "one of "+describeValues(s.Enum), value)
+	}
+	if s.Const != nil && !equalJSON(s.Const, value) {
+		v.add(path, describeValues([]any{s.Const}), value)
+	}
+
+	switch tv := value.(type) {
+	case string:
+		v.checkString(s, tv, path)
+	case float64:
+		v.checkNumber(s, tv, path)
+	case []any:
+		value = v.checkArray(s, tv, path)
+	case map[string]any:
+		value = v.checkObject(s, tv, path)
+	}
+
+	return v.checkComposition(s, value, path)
+}
+
+func (v *schemaValidator) checkString(s *Schema, str string, path string) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		v.add(path, fmt.Sprintf("a string of at least %d characters", *s.MinLength), str)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.add(path, fmt.Sprintf("a string of at most %d characters", *s.MaxLength), str)
+	}
+	if s.Pattern != "" {
+		// Patterns RE2 can't compile are skipped rather than failing every value
+		if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(str) {
+			v.add(path, fmt.Sprintf("a string matching %q", s.Pattern), str)
+		}
+	}
+}
+
+func (v *schemaValidator) checkNumber(s *Schema, n float64, path string) {
+	if s.Minimum != nil && n < *s.Minimum {
+		v.add(path, fmt.Sprintf("a number >= %v", *s.Minimum), n)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		v.add(path, fmt.Sprintf("a number <= %v", *s.Maximum), n)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		v.add(path, fmt.Sprintf("a number > %v", *s.ExclusiveMinimum), n)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		v.add(path, fmt.Sprintf("a number < %v", *s.ExclusiveMaximum), n)
+	}
+	if s.MultipleOf != nil && *s.MultipleOf != 0 {
+		if quotient := n / *s.MultipleOf; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.add(path, fmt.Sprintf("a multiple of %v", *s.MultipleOf), n)
+		}
+	}
+}
+
+func (v *schemaValidator) checkArray(s *Schema, items []any, path string) []any {
+	if s.MinItems != nil && len(items) < *s.MinItems {
+		v.add(path, fmt.Sprintf("an array of at least %d items", *s.MinItems), items)
+	}
+	if s.MaxItems != nil && len(items) > *s.MaxItems {
+		v.add(path, fmt.Sprintf("an array of at most %d items", *s.MaxItems), items)
+	}
+	if s.UniqueItems {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if equalJSON(items[i], items[j]) {
+					v.add(indexPath(path, j), fmt.Sprintf("a unique item (same as item %d)", i), items[j])
+				}
+			}
+		}
+	}
+
+	if s.Items == nil {
+		return items
+	}
+	checked := items
+	if v.coerce {
+		checked = make([]any, len(items))
+	}
+	for i, item := range items {
+		item = v.check(s.Items, item, indexPath(path, i))
+		if v.coerce {
+			checked[i] = item
+		}
+	}
+	return checked
+}
+
+func (v *schemaValidator) checkObject(s *Schema, object map[string]any, path string) map[string]any {
+	for _, key := range s.Required {
+		if _, ok := object[key]; !ok {
+			v.violations = append(v.violations, Violation{
+				Path:     propertyPath(path, key),
+				Expected: "a required property",
+				Got:      "missing",
+			})
+		}
+	}
+	if s.MinProperties != nil && len(object) < *s.MinProperties {
+		v.add(path, fmt.Sprintf("an object with at least %d properties", *s.MinProperties), object)
+	}
+	if s.MaxProperties != nil && len(object) > *s.MaxProperties {
+		v.add(path, fmt.Sprintf("an object with at most %d properties", *s.MaxProperties), object)
+	}
+
+	checked := object
+	if v.coerce {
+		checked = make(map[string]any, len(object))
+	}
+	for _, key := range sortedKeys(object) {
+		value := object[key]
+		property, known := s.Properties[key]
+		switch {
+		case known:
+			value = v.check(property, value, propertyPath(path, key))
+		case s.AdditionalProperties.IsBool(false):
+			v.violations = append(v.violations, Violation{
+				Path:     propertyPath(path, key),
+				Expected: "no additional properties",
+				Got:      "unexpected property",
+			})
+		case s.AdditionalProperties != nil:
+			value = v.check(s.AdditionalProperties, value, propertyPath(path, key))
+		}
+		if v.coerce {
+			checked[key] = value
+		}
+	}
+	return checked
+}
+
+func (v *schemaValidator) checkComposition(s *Schema, value any, path string) any {
+	for _, branch := range s.AllOf {
+		value = v.check(branch, value, path)
+	}
+
+	if len(s.AnyOf) > 0 {
+		value = v.checkAnyOf(s.AnyOf, value, path)
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		var matched any
+		for _, branch := range s.OneOf {
+			if coerced, violations := v.sub(branch, value, path); len(violations) == 0 {
+				matches++
+				matched = coerced
+			}
+		}
+		switch matches {
+		case 0:
+			v.checkAnyOf(s.OneOf, value, path)
+		case 1:
+			value = matched
+		default:
+			v.add(path, fmt.Sprintf("a value matching exactly one oneOf schema, not %d", matches), value)
+		}
+	}
+
+	if s.Not != nil {
+		if _, violations := v.sub(s.Not, value, path); len(violations) == 0 {
+			v.add(path, "a value not matching the \"not\" schema", value)
+		}
+	}
+	return value
+}
+
+// checkAnyOf passes if any branch matches. Otherwise it reports the problems
+// with the closest branch whose type matched, or a single violation listing the
+// branch types.
+func (v *schemaValidator) checkAnyOf(branches []*Schema, value any, path string) any {
+	var closest Violations
+	for _, branch := range branches {
+		coerced, violations := v.sub(branch, value, path)
+		if len(violations) == 0 {
+			return coerced
+		}
+		typeMismatch := false
+		for _, violation := range violations {
+			if violation.Path == path {
+				typeMismatch = true
+			}
+		}
+		if !typeMismatch && (closest == nil || len(violations) < len(closest)) {
+			closest = violations
+		}
+	}
+	if closest != nil {
+		v.violations = append(v.violations, closest...)
+		return value
+	}
+
+	var expected []string
+	for _, branch := range branches {
+		expected = append(expected, describeSchema(branch))
+	}
+	v.add(path, "one of: "+strings.Join(expected, "; "), value)
+	return value
+}
+
+// coerce fixes common LLM mistakes in a value that doesn't have the schema's type
+func coerce(s *Schema, value any) any {
+	types := s.Type
+	if len(types) == 0 {
+		switch {
+		case s.Properties != nil:
+			types = []string{string(JSONSchemaTypeObject)}
+		case s.Items != nil:
+			types = []string{string(JSONSchemaTypeArray)}
+		default:
+			return value
+		}
+	}
+	if matchesAnyType(value, types) {
+		return value
+	}
+
+	if str, ok := value.(string); ok {
+		trimmed := strings.TrimSpace(str)
+		if slices.Contains(types, string(JSONSchemaTypeNumber)) || slices.Contains(types, string(JSONSchemaTypeInteger)) {
+			if n, err := strconv.ParseFloat(trimmed, 64); err == nil && matchesAnyType(n, types) {
+				return n
+			}
+		}
+		if slices.Contains(types, string(JSONSchemaTypeBoolean)) {
+			if b, err := strconv.ParseBool(strings.ToLower(trimmed)); err == nil {
+				return b
+			}
+		}
+		if slices.Contains(types, string(JSONSchemaTypeNull)) && trimmed == "null" {
+			return nil
+		}
+		if slices.Contains(types, string(JSONSchemaTypeObject)) || slices.Contains(types, string(JSONSchemaTypeArray)) {
+			var decoded any
+			if err := json.Unmarshal([]byte(trimmed), &decoded); err == nil && matchesAnyType(decoded, types) {
+				return decoded
+			}
+		}
+	}
+
+	if slices.Contains(types, string(JSONSchemaTypeArray)) && value != nil {
+		if _, isArray := value.([]any); !isArray && (s.Items == nil || matchesItems(s.Items, value)) {
+			return []any{value}
+		}
+	}
+	return value
+}
+
+// matchesItems reports whether a single value could be an item of the array
+func matchesItems(items *Schema, value any) bool {
+	return len(items.Type) == 0 || matchesAnyType(value, items.Type) || matchesAnyType(coerce(items, value), items.Type)
+}
+
+func matchesAnyType(value any, types []string) bool {
+	for _, t := range types {
+		if matchesType(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(value any, t string) bool {
+	switch t {
+	case string(JSONSchemaTypeNull):
+		return value == nil
+	case string(JSONSchemaTypeBoolean):
+		_, ok := value.(bool)
+		return ok
+	case string(JSONSchemaTypeString):
+		_, ok := value.(string)
+		return ok
+	case string(JSONSchemaTypeNumber):
+		_, ok := value.(float64)
+		return ok
+	case string(JSONSchemaTypeInteger):
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	case string(JSONSchemaTypeArray):
+		_, ok := value.([]any)
+		return ok
+	case string(JSONSchemaTypeObject):
+		_, ok := value.(map[string]any)
+		return ok
+	default:
+		return true
+	}
+}
+
+// normalizeJSON converts a value to the types encoding/json decodes to, so Go
+// values like []string or int can be validated
+func normalizeJSON(value any) (any, error) {
+	if isJSONValue(value) {
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value, err
+	}
+	var normalized any
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
+
+func isJSONValue(value any) bool {
+	switch v := value.(type) {
+	case nil, bool, string, float64:
+		return true
+	case []any:
+		for _, item := range v {
+			if !isJSONValue(item) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		for _, item := range v {
+			if !isJSONValue(item) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func equalJSON(a, b any) bool {
+	na, errA := normalizeJSON(a)
+	nb, errB := normalizeJSON(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(na, nb)
+}
+
+func containsJSON(values []any, value any) bool {
+	for _, candidate := range values {
+		if equalJSON(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func describeValues(values []any) string {
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			encoded[i] = fmt.Sprint(value)
+			continue
+		}
+		encoded[i] = string(data)
+	}
+	return strings.Join(encoded, ", ")
+}
+
+// describeSchema names what a schema accepts, for anyOf violations
+func describeSchema(s *Schema) string {
+	switch {
+	case s == nil || s.IsBool(true):
+		return "any value"
+	case s.IsBool(false):
+		return "no value"
+	case len(s.Enum) > 0:
+		return describeValues(s.Enum)
+	case s.Const != nil:
+		return describeValues([]any{s.Const})
+	case len(s.Type) > 0:
+		return strings.Join(s.Type, " or ")
+	case s.Ref != "":
+		return s.Ref
+	default:
+		return "a schema"
+	}
+}
+
+// propertyPath returns the path of an object property
+func propertyPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
@@ -0,0 +1,213 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Model Registry
+// ==============
+
+// Provider is the company serving a model
+type Provider string
+
+const (
+	ProviderAnthropic Provider = "anthropic"
+	ProviderOpenAI    Provider = "openai"
+	ProviderGoogle    Provider = "google"
+)
+
+// ErrUnknownModel is returned by ParseModel for names that aren't in the registry
+var ErrUnknownModel = errors.New("unknown model")
+
+// ModelInfo describes what a model supports and its limits
+type ModelInfo struct {
+	Model    Model    `json:"model"`
+	Provider Provider `json:"provider"`
+	// ContextWindow is the maximum number of input and output tokens
+	ContextWindow   int `json:"context_window"`
+	MaxOutputTokens int `json:"max_output_tokens"`
+	// Reasoning is true for models that think before answering and accept a reasoning effort or budget
+	Reasoning   bool `json:"reasoning"`
+	ToolCalling bool `json:"tool_calling"`
+	// StrictTools is true for models that can constrain tool inputs to their schemas
+	StrictTools bool `json:"strict_tools"`
+	Vision      bool `json:"vision"`
+	Deprecated  bool `json:"deprecated"`
+	// ReplacedBy is the model to move to from a deprecated one
+	ReplacedBy Model `json:"replaced_by,omitempty"`
+}
+
+// modelRegistry holds every known model, in the order of the Model constants
+var modelRegistry = []ModelInfo{
+	{Model: ModelClaude4Sonnet, Provider: ProviderAnthropic, ContextWindow: 200_000, MaxOutputTokens: 64_000, Reasoning: true, ToolCalling: true, Vision: true, Deprecated: true, ReplacedBy: ModelClaude4Dot6Sonnet},
+	{Model: ModelClaude4Dot5Sonnet, Provider: ProviderAnthropic, ContextWindow: 200_000, MaxOutputTokens: 64_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true, Deprecated: true, ReplacedBy: ModelClaude4Dot6Sonnet},
+	{Model: ModelClaude4Dot6Sonnet, Provider: ProviderAnthropic, ContextWindow: 200_000, MaxOutputTokens: 64_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelClaude4Dot5Haiku, Provider: ProviderAnthropic, ContextWindow: 200_000, MaxOutputTokens: 64_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+
+	{Model: ModelGPT4Dot1, Provider: ProviderOpenAI, ContextWindow: 1_047_576, MaxOutputTokens: 32_768, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT4Dot1Mini, Provider: ProviderOpenAI, ContextWindow: 1_047_576, MaxOutputTokens: 32_768, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT4Dot1Nano, Provider: ProviderOpenAI, ContextWindow: 1_047_576, MaxOutputTokens: 32_768, ToolCalling: true, StrictTools: true, Vision: true},
+
+	{Model: ModelGPT5, Provider: ProviderOpenAI, ContextWindow: 400_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT5Mini, Provider: ProviderOpenAI, ContextWindow: 400_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT5Nano, Provider: ProviderOpenAI, ContextWindow: 400_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+
+	{Model: ModelGPT5Dot2, Provider: ProviderOpenAI, ContextWindow: 400_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true, Deprecated: true, ReplacedBy: ModelGPT5Dot4},
+	{Model: ModelGPT5Dot2Pro, Provider: ProviderOpenAI, ContextWindow: 400_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true, Deprecated: true, ReplacedBy: ModelGPT5Dot4Pro},
+
+	{Model: ModelGPT5Dot5, Provider: ProviderOpenAI, ContextWindow: 1_000_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT5Dot4, Provider: ProviderOpenAI, ContextWindow: 1_000_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT5Dot4Pro, Provider: ProviderOpenAI, ContextWindow: 1_000_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+	{Model: ModelGPT5Dot4Mini, Provider: ProviderOpenAI, ContextWindow: 1_000_000, MaxOutputTokens: 128_000, Reasoning: true, ToolCalling: true, StrictTools: true, Vision: true},
+
+	{Model: ModelGemini2Dot5Pro, Provider: ProviderGoogle, ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Reasoning: true, ToolCalling: true, Vision: true, Deprecated: true, ReplacedBy: ModelGemini3Dot1Pro},
+	{Model: ModelGemini2Dot5Flash, Provider: ProviderGoogle, ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Reasoning: true, ToolCalling: true, Vision: true, Deprecated: true, ReplacedBy: ModelGemini3Flash},
+	{Model: ModelGemini2Dot5FlashLite, Provider: ProviderGoogle, ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Reasoning: true, ToolCalling: true, Vision: true},
+
+	{Model: ModelGemini3Dot1Pro, Provider: ProviderGoogle, ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Reasoning: true, ToolCalling: true, Vision: true},
+	{Model: ModelGemini3Flash, Provider: ProviderGoogle, ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Reasoning: true, ToolCalling: true, Vision: true},
+}
+
+// modelAliases maps other names people use for models to the registry's names
+var modelAliases = map[string]Model{
+	"sonnet":            ModelClaude4Dot6Sonnet,
+	"haiku":             ModelClaude4Dot5Haiku,
+	"claude-sonnet":     ModelClaude4Dot6Sonnet,
+	"claude-haiku":      ModelClaude4Dot5Haiku,
+	"claude-4-sonnet":   ModelClaude4Sonnet,
+	"claude-sonnet-4-0": ModelClaude4Sonnet,
+	"claude-4-5-sonnet": ModelClaude4Dot5Sonnet,
+	"claude-4-6-sonnet": ModelClaude4Dot6Sonnet,
+	"claude-4-5-haiku":  ModelClaude4Dot5Haiku,
+	"gemini-pro":        ModelGemini3Dot1Pro,
+	"gemini-flash":      ModelGemini3Flash,
+}
+
+var (
+	// snapshotSuffixRegex matches dated snapshots (-20250514, -2025-04-14) and
+	// release channels (-latest, -preview, -preview-05-20, -exp)
+	snapshotSuffixRegex = regexp.MustCompile(`-(?:\d{8}|\d{4}-\d{2}-\d{2}|latest|(?:preview|exp)(?:-\d{2}-\d{2})?)$`)
+	// claudeVersionDotRegex matches dotted Claude versions like sonnet-4.5
+	claudeVersionDotRegex = regexp.MustCompile(`^(claude-.*\d)\.(\d)`)
+)
+
+// ParseModel returns the model for a name, accepting the registry's names,
+// aliases (e.g. "sonnet"), dated snapshots (e.g. "claude-sonnet-4-5-20250929" or
+// "gpt-4.1-2025-04-14"), preview releases and provider prefixes (e.g.
+// "openai/gpt-5" or "models/gemini-2.5-pro"). Names are case-insensitive.
+func ParseModel(name string) (Model, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	if i := strings.LastIndex(normalized, "/"); i >= 0 {
+		normalized = normalized[i+1:]
+	}
+	normalized = claudeVersionDotRegex.ReplaceAllString(normalized, "$1-$2")
+
+	for {
+		if model, ok := lookupModelName(normalized); ok {
+			return model, nil
+		}
+		trimmed := snapshotSuffixRegex.ReplaceAllString(normalized, "")
+		if trimmed == normalized {
+			return "", fmt.Errorf("%w %q", ErrUnknownModel, name)
+		}
+		normalized = trimmed
+	}
+}
+
+func lookupModelName(name string) (Model, bool) {
+	if alias, ok := modelAliases[name]; ok {
+		return alias, true
+	}
+	for _, info := range modelRegistry {
+		if string(info.Model) == name {
+			return info.Model, true
+		}
+	}
+	return "", false
+}
+
+// Capabilities returns what the model supports, and false for unknown models
+func (m Model) Capabilities() (ModelInfo, bool) {
+	for _, info := range modelRegistry {
+		if info.Model == m {
+			return info, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// Provider returns the model's provider, or "" for unknown models
+func (m Model) Provider() Provider {
+	info, _ := m.Capabilities()
+	return info.Provider
+}
+
+// IsValid reports whether the model is in the registry
+func (m Model) IsValid() bool {
+	_, ok := m.Capabilities()
+	return ok
+}
+
+// Models returns every model in the registry
+func Models() []ModelInfo {
+	return slices.Clone(modelRegistry)
+}
+
+// ModelsByProvider returns the provider's models, including deprecated ones
+func ModelsByProvider(provider Provider) []Model {
+	var models []Model
+	for _, info := range modelRegistry {
+		if info.Provider == provider {
+			models = append(models, info.Model)
+		}
+	}
+	return models
+}
+
+// CheckStepParameters returns the problems with using the model with a step's
+// parameters: a max_tokens above the model's output limit, reasoning settings
+// for a model that doesn't reason, tools for a model without tool calling and
+// strict tools for a model that can't enforce them. Templated values are skipped.
+func (info ModelInfo) CheckStepParameters(parameters map[string]any) []string {
+	var problems []string
+	if maxTokens, ok := intParameter(parameters["max_tokens"]); ok && maxTokens > info.MaxOutputTokens {
+		problems = append(problems, fmt.Sprintf("max_tokens %d is above the %d output tokens %s supports", maxTokens, info.MaxOutputTokens, info.Model))
+	}
+	if !info.Reasoning {
+		for _, key := range []string{"reasoning", "reasoning_effort", "thinking", "thinking_budget"} {
+			if _, ok := parameters[key]; ok {
+				problems = append(problems, fmt.Sprintf("%s is set but %s doesn't support reasoning", key, info.Model))
+			}
+		}
+	}
+
+	tools, _ := parameters["tools"].([]any)
+	if len(tools) > 0 && !info.ToolCalling {
+		problems = append(problems, fmt.Sprintf("tools are set but %s doesn't support tool calling", info.Model))
+	}
+	if !info.StrictTools {
+		for i, tool := range tools {
+			if toolMap, ok := tool.(map[string]any); ok && toolMap["strict"] == true {
+				problems = append(problems, fmt.Sprintf("tools[%d] is strict but %s doesn't support strict tool schemas", i, info.Model))
+			}
+		}
+	}
+	return problems
+}
+
+func intParameter(value any) (int, bool) {
+	switch tv := value.(type) {
+	case int:
+		return tv, true
+	case int64:
+		return int(tv), true
+	case float64:
+		return int(tv), true
+	default:
+		return 0, false
+	}
+}
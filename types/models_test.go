@@ -0,0 +1,118 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModel(t *testing.T) {
+	for name, want := range map[string]Model{
+		"claude-sonnet-4-5":              ModelClaude4Dot5Sonnet,
+		"Claude-Sonnet-4-5":              ModelClaude4Dot5Sonnet,
+		"claude-sonnet-4-5-20250929":     ModelClaude4Dot5Sonnet,
+		"claude-sonnet-4.5":              ModelClaude4Dot5Sonnet,
+		"anthropic/claude-haiku-4-5":     ModelClaude4Dot5Haiku,
+		"claude-sonnet-4-20250514":       ModelClaude4Sonnet,
+		"claude-sonnet-4-0":              ModelClaude4Sonnet,
+		"sonnet":                         ModelClaude4Dot6Sonnet,
+		"gpt-4.1-2025-04-14":             ModelGPT4Dot1,
+		"gpt-4.1-mini":                   ModelGPT4Dot1Mini,
+		"openai/gpt-5-2025-08-07":        ModelGPT5,
+		"gpt-5.4-pro":                    ModelGPT5Dot4Pro,
+		"models/gemini-2.5-pro":          ModelGemini2Dot5Pro,
+		"gemini-2.5-flash-preview-05-20": ModelGemini2Dot5Flash,
+		"gemini-3-flash-preview":         ModelGemini3Flash,
+		" gemini-3.1-pro-preview ":       ModelGemini3Dot1Pro,
+	} {
+		model, err := ParseModel(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, model, name)
+	}
+
+	for _, name := range []string{"", "gpt-6", "claude-opus-3", "gpt-5-turbo", "20250514"} {
+		_, err := ParseModel(name)
+		assert.ErrorIs(t, err, ErrUnknownModel, name)
+	}
+}
+
+func TestModelRegistry(t *testing.T) {
+	seen := map[Model]bool{}
+	for _, info := range Models() {
+		assert.False(t, seen[info.Model], "%s is registered twice", info.Model)
+		seen[info.Model] = true
+		assert.NotEmpty(t, info.Provider, info.Model)
+		assert.Greater(t, info.ContextWindow, info.MaxOutputTokens, info.Model)
+		if info.Deprecated {
+			replacement, ok := info.ReplacedBy.Capabilities()
+			require.True(t, ok, "%s is replaced by an unknown model", info.Model)
+			assert.False(t, replacement.Deprecated, "%s is replaced by a deprecated model", info.Model)
+			assert.Equal(t, info.Provider, replacement.Provider, info.Model)
+		}
+		if info.ReplacedBy != "" {
+			assert.True(t, info.Deprecated, info.Model)
+		}
+	}
+	for _, model := range modelAliases {
+		assert.True(t, model.IsValid(), model)
+	}
+
+	assert.Equal(t, []Model{ModelClaude4Sonnet, ModelClaude4Dot5Sonnet, ModelClaude4Dot6Sonnet, ModelClaude4Dot5Haiku}, ModelsByProvider(ProviderAnthropic))
+	assert.Len(t, ModelsByProvider(ProviderGoogle), 5)
+	assert.Empty(t, ModelsByProvider("mistral"))
+
+	info, ok := ModelGPT5Dot2.Capabilities()
+	require.True(t, ok)
+	assert.Equal(t, 400_000, info.ContextWindow)
+	assert.True(t, info.Reasoning)
+	assert.Equal(t, ProviderOpenAI, ModelGPT5Dot2.Provider())
+
+	_, ok = Model("gpt-6").Capabilities()
+	assert.False(t, ok)
+
+	info, ok = ModelClaude4Dot5Sonnet.Capabilities()
+	require.True(t, ok)
+	assert.True(t, info.Deprecated)
+	assert.Equal(t, ModelClaude4Dot6Sonnet, info.ReplacedBy)
+
+	info, ok = ModelGemini2Dot5Flash.Capabilities()
+	require.True(t, ok)
+	assert.Equal(t, ModelGemini3Flash, info.ReplacedBy)
+
+	info, ok = ModelGPT5Dot5.Capabilities()
+	require.True(t, ok)
+	assert.False(t, info.Deprecated)
+	assert.Empty(t, info.ReplacedBy)
+}
+
+func TestStepValidateModel(t *testing.T) {
+	step := func(parameters map[string]any) Step {
+		return Step{ActionType: "llm.message", Parameters: parameters}
+	}
+
+	assert.NoError(t, step(map[string]any{"model": "claude-sonnet-4-5-20250929", "max_tokens": 8000, "reasoning_effort": "high"}).Validate())
+	assert.NoError(t, step(map[string]any{"model": "{{ model }}", "max_tokens": 1_000_000}).Validate())
+
+	err := step(map[string]any{"model": "gpt-6"}).Validate()
+	assert.EqualError(t, err, `parameters.model: unknown model "gpt-6"`)
+
+	// Only LLM actions pass the model to a provider
+	other := Step{ActionType: "codeexec.execute", Parameters: map[string]any{"model": "my-regression", "max_tokens": 1_000_000}}
+	assert.NoError(t, other.Validate())
+
+	err = step(map[string]any{
+		"model":            "gpt-4.1",
+		"max_tokens":       50_000.0,
+		"reasoning_effort": "high",
+	}).Validate()
+	assert.EqualError(t, err,
+		"parameters: max_tokens 50000 is above the 32768 output tokens gpt-4.1 supports\n"+
+			"parameters: reasoning_effort is set but gpt-4.1 doesn't support reasoning")
+
+	err = step(map[string]any{
+		"model": "gemini-2.5-pro",
+		"tools": []any{map[string]any{"name": "search", "strict": true}},
+	}).Validate()
+	assert.EqualError(t, err, "parameters: tools[0] is strict but gemini-2.5-pro doesn't support strict tool schemas")
+}
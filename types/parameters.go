@@ -20,6 +20,18 @@ var BasicTemplateFunctions = []string{
 	"regexReplace",
 	"noop",
 	"list",
+	"upper",
+	"lower",
+	"title",
+	"trim",
+	"replace",
+	"join",
+	"first",
+	"last",
+	"default",
+	"compare",
+	"toInt",
+	"toFloat",
 }
 
 // DataTemplateFunctions are functions that require .Data and .MissingKeys parameters
@@ -29,6 +41,8 @@ var DataTemplateFunctions = []string{
 	"getOrOriginal",
 	"sliceEnd",
 	"sliceEndKeepFirstUserMessage",
+	"sliceByTokenBudget",
+	"sliceByTokenBudgetWithPlaceholder",
 	"slice",
 	"extractSlice",
 	"dedupeBy",
@@ -45,6 +59,8 @@ var DataTemplateFunctions = []string{
 	"incrementCounterBy",
 	"coalesce",
 	"filter",
+	"include",
+	"pyFormat",
 }
 
 // AllTemplateFunctions combines basic and data template functions
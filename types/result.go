@@ -0,0 +1,175 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors
+// ======
+
+// Error implements error, so the Error constants are sentinel errors that can
+// be returned, wrapped and matched with errors.Is
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Retryable reports whether running again might succeed: timeouts and internal
+// errors are retryable, while bad requests and missing actions or info aren't
+func (e Error) Retryable() bool {
+	switch e {
+	case ErrorTimeout, ErrorInternalError:
+		return true
+	}
+	return false
+}
+
+// HTTPStatus returns the HTTP status code API layers respond with for the error
+func (e Error) HTTPStatus() int {
+	switch e {
+	case ErrorActionNotFound:
+		return http.StatusNotFound
+	case ErrorInfoNeeded:
+		return http.StatusUnprocessableEntity
+	case ErrorTerminated:
+		return http.StatusConflict
+	case ErrorBadRequest:
+		return http.StatusBadRequest
+	case ErrorTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// errorKinds are the Error values, for matching errors with an Is method
+var errorKinds = []Error{ErrorActionNotFound, ErrorInternalError, ErrorInfoNeeded, ErrorTerminated, ErrorBadRequest, ErrorTimeout}
+
+// ErrorOf classifies err as an Error. Errors that wrap or match an Error return
+// it, context deadlines are timeouts, cancellations are terminations, and
+// anything else is an internal error. A nil err has no Error and returns "".
+func ErrorOf(err error) Error {
+	if err == nil {
+		return ""
+	}
+	if kind, ok := classifyError(err); ok {
+		return kind
+	}
+	return ErrorInternalError
+}
+
+// classifyError returns the Error err wraps or matches, or false if err isn't
+// one we recognise
+func classifyError(err error) (Error, bool) {
+	var e Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return kind, true
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout, true
+	case errors.Is(err, context.Canceled):
+		return ErrorTerminated, true
+	}
+	return "", false
+}
+
+// IsRetryable reports whether the operation that returned err might succeed if
+// run again. Only errors classified as a retryable Error are: unrecognised
+// errors aren't, even though ErrorOf reports them as internal errors.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	kind, ok := classifyError(err)
+	return ok && kind.Retryable()
+}
+
+// HTTPStatus returns the HTTP status code for err, or 200 if it's nil
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return ErrorOf(err).HTTPStatus()
+}
+
+// ResultError is the error of a Result that didn't succeed. It matches its
+// Kind with errors.Is.
+type ResultError struct {
+	Status  Status
+	Kind    Error
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return string(e.Kind)
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
+func (e *ResultError) Unwrap() error {
+	return e.Kind
+}
+
+// Results
+// =======
+
+// Success returns a successful result with the output
+func Success(output map[string]any) Result {
+	return Result{Status: StatusSuccess, Output: &output}
+}
+
+// Failed returns an error result for err, classified by ErrorOf. Use
+// template.RequiresInfo for errors that need info from the user. A nil err is
+// a bug in the caller, so it's reported as an internal error saying so.
+func Failed(err error) Result {
+	if err == nil {
+		err = errFailedWithoutError
+	}
+	kind := ErrorOf(err)
+	message := err.Error()
+	return Result{Status: StatusError, Error: &kind, Message: &message}
+}
+
+// errFailedWithoutError is the error of Failed(nil)
+var errFailedWithoutError = errors.New("failed without an error")
+
+// Err returns a *ResultError for error and requires info results, and nil for
+// the rest. Error results without an Error are internal errors.
+func (r Result) Err() error {
+	var kind Error
+	switch r.Status {
+	case StatusError:
+		kind = ErrorInternalError
+	case StatusRequiresInfo:
+		kind = ErrorInfoNeeded
+	default:
+		return nil
+	}
+	if r.Error != nil {
+		kind = *r.Error
+	}
+	err := &ResultError{Status: r.Status, Kind: kind}
+	if r.Message != nil {
+		err.Message = *r.Message
+	}
+	return err
+}
+
+// Retryable reports whether the result is an error that might not happen again
+func (r Result) Retryable() bool {
+	return IsRetryable(r.Err())
+}
+
+// HTTPStatus returns the HTTP status code for the result: 200 unless it's an
+// error or requires info
+func (r Result) HTTPStatus() int {
+	return HTTPStatus(r.Err())
+}
@@ -0,0 +1,130 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Validate checks an action's output against the result schema: required
+// fields must be present and not null, declared properties must have their type
+// (recursing into Items and Properties) and enum values, and fields the schema
+// doesn't declare are reported, so schema drift shows up as violations.
+func (rs ResultSchema) Validate(output map[string]any) Violations {
+	normalized, err := normalizeJSONValue(output)
+	if err != nil {
+		return Violations{NewViolation("", "a JSON object", output)}
+	}
+	output, _ = normalized.(map[string]any)
+
+	var violations Violations
+	for _, field := range rs.RequiredFields {
+		if value, ok := output[field]; !ok || value == nil {
+			got := "missing"
+			if ok {
+				got = DescribeValue(value)
+			}
+			violations = append(violations, Violation{Path: field, Expected: "a required field", Got: got})
+		}
+	}
+
+	// A schema that declares no fields doesn't restrict them
+	declaresFields := len(rs.Properties) > 0 || len(rs.RequiredFields) > 0 || len(rs.OptionalFields) > 0
+	for _, field := range sortedKeys(output) {
+		value := output[field]
+		property, declared := rs.Properties[field]
+		switch {
+		case declared:
+			if value != nil {
+				violations = append(violations, property.validate(field, value)...)
+			}
+		case declaresFields && !slices.Contains(rs.RequiredFields, field) && !slices.Contains(rs.OptionalFields, field):
+			violations = append(violations, Violation{Path: field, Expected: "a declared field", Got: "undeclared field"})
+		}
+	}
+	return violations
+}
+
+// ValidateExamples checks each of the schema's examples against the schema, for
+// tests of action definitions. Paths start with the example, e.g. examples[0].id.
+func (rs ResultSchema) ValidateExamples() Violations {
+	var violations Violations
+	for i, example := range rs.Examples {
+		for _, violation := range rs.Validate(example) {
+			violation.Path = joinPath(fmt.Sprintf("examples[%d]", i), violation.Path)
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+func (ps PropertySchema) validate(path string, value any) Violations {
+	if ps.Type != "" && !matchesSchemaType(value, ps.Type) {
+		return Violations{NewViolation(path, string(ps.Type), value)}
+	}
+
+	var violations Violations
+	if len(ps.Enum) > 0 {
+		if str, ok := value.(string); !ok || !slices.Contains(ps.Enum, str) {
+			violations = append(violations, NewViolation(path, "one of "+quoteAll(ps.Enum), value))
+		}
+	}
+
+	switch tv := value.(type) {
+	case []any:
+		if ps.Items != nil {
+			for i, item := range tv {
+				if item != nil {
+					violations = append(violations, ps.Items.validate(indexPath(path, i), item)...)
+				}
+			}
+		}
+	case map[string]any:
+		for _, key := range sortedKeys(ps.Properties) {
+			if item, ok := tv[key]; ok && item != nil {
+				violations = append(violations, ps.Properties[key].validate(joinPath(path, key), item)...)
+			}
+		}
+	}
+	return violations
+}
+
+func matchesSchemaType(value any, schemaType JSONSchemaType) bool {
+	switch schemaType {
+	case JSONSchemaTypeString:
+		_, ok := value.(string)
+		return ok
+	case JSONSchemaTypeNumber:
+		_, ok := value.(float64)
+		return ok
+	case JSONSchemaTypeInteger:
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case JSONSchemaTypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case JSONSchemaTypeObject:
+		_, ok := value.(map[string]any)
+		return ok
+	case JSONSchemaTypeArray:
+		_, ok := value.([]any)
+		return ok
+	default:
+		return true
+	}
+}
+
+// normalizeJSONValue converts a value to the types encoding/json decodes to, so
+// outputs built from Go values (ints, []string, structs) can be checked
+func normalizeJSONValue(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
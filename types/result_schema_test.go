@@ -0,0 +1,90 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var searchResultSchema = ResultSchema{
+	Description:    "Search results",
+	RequiredFields: []string{"results", "total"},
+	OptionalFields: []string{"next_cursor"},
+	Properties: map[string]PropertySchema{
+		"total":       {Type: JSONSchemaTypeInteger},
+		"next_cursor": {Type: JSONSchemaTypeString},
+		"status":      {Type: JSONSchemaTypeString, Enum: []string{"complete", "partial"}},
+		"results": {
+			Type: JSONSchemaTypeArray,
+			Items: &PropertySchema{
+				Type: JSONSchemaTypeObject,
+				Properties: map[string]PropertySchema{
+					"title": {Type: JSONSchemaTypeString},
+					"score": {Type: JSONSchemaTypeNumber},
+					"tags":  {Type: JSONSchemaTypeArray, Items: &PropertySchema{Type: JSONSchemaTypeString}},
+				},
+			},
+		},
+	},
+}
+
+func TestResultSchemaValidate(t *testing.T) {
+	t.Run("valid output", func(t *testing.T) {
+		assert.Empty(t, searchResultSchema.Validate(map[string]any{
+			"results": []map[string]any{
+				{"title": "Erdo", "score": 0.9, "tags": []string{"ai"}},
+				{"title": "Other", "score": 1, "extra": true},
+			},
+			"total":       2,
+			"next_cursor": nil,
+			"status":      "partial",
+		}))
+	})
+
+	t.Run("every violation is reported", func(t *testing.T) {
+		violations := searchResultSchema.Validate(map[string]any{
+			"results": []any{
+				map[string]any{"title": 5, "tags": []any{"a", false}},
+				"not an object",
+			},
+			"total":  nil,
+			"status": "failed",
+			"debug":  "undeclared",
+		})
+		assert.Equal(t, Violations{
+			{Path: "total", Expected: "a required field", Got: "null"},
+			{Path: "debug", Expected: "a declared field", Got: "undeclared field"},
+			{Path: "results[0].tags[1]", Expected: "string", Got: "boolean false"},
+			{Path: "results[0].title", Expected: "string", Got: "number 5"},
+			{Path: "results[1]", Expected: "object", Got: `string "not an object"`},
+			{Path: "status", Expected: `one of "complete", "partial"`, Got: `string "failed"`},
+		}, violations)
+	})
+
+	t.Run("missing fields and integers", func(t *testing.T) {
+		violations := searchResultSchema.Validate(map[string]any{"total": 2.5})
+		assert.Equal(t, Violations{
+			{Path: "results", Expected: "a required field", Got: "missing"},
+			{Path: "total", Expected: "integer", Got: "number 2.5"},
+		}, violations)
+	})
+
+	t.Run("schemas without fields allow anything", func(t *testing.T) {
+		assert.Empty(t, ResultSchema{Description: "Anything"}.Validate(map[string]any{"a": 1}))
+	})
+}
+
+func TestResultSchemaValidateExamples(t *testing.T) {
+	schema := searchResultSchema
+	schema.Examples = []map[string]any{
+		{"results": []any{}, "total": 0},
+		{"results": []any{map[string]any{"title": "Erdo"}}},
+	}
+
+	assert.Equal(t, Violations{
+		{Path: "examples[1].total", Expected: "a required field", Got: "missing"},
+	}, schema.ValidateExamples())
+
+	schema.Examples = schema.Examples[:1]
+	assert.Empty(t, schema.ValidateExamples())
+}
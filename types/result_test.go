@@ -0,0 +1,78 @@
+package types
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Error
+	}{
+		{ErrorTimeout, ErrorTimeout},
+		{fmt.Errorf("running step: %w", ErrorActionNotFound), ErrorActionNotFound},
+		{&ResultError{Status: StatusError, Kind: ErrorBadRequest}, ErrorBadRequest},
+		{fmt.Errorf("calling model: %w", context.DeadlineExceeded), ErrorTimeout},
+		{context.Canceled, ErrorTerminated},
+		{errors.New("boom"), ErrorInternalError},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ErrorOf(tt.err), tt.err.Error())
+	}
+	assert.Equal(t, Error(""), ErrorOf(nil))
+}
+
+func TestErrorClassification(t *testing.T) {
+	assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", ErrorTimeout)))
+	assert.True(t, IsRetryable(fmt.Errorf("calling model: %w", ErrorInternalError)))
+	assert.False(t, IsRetryable(errors.New("boom")), "unrecognised errors aren't retryable")
+	assert.False(t, IsRetryable(ErrorBadRequest))
+	assert.False(t, IsRetryable(nil))
+
+	assert.Equal(t, http.StatusOK, HTTPStatus(nil))
+	assert.Equal(t, http.StatusNotFound, HTTPStatus(ErrorActionNotFound))
+	assert.Equal(t, http.StatusGatewayTimeout, HTTPStatus(context.DeadlineExceeded))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(errors.New("boom")))
+}
+
+func TestResultErr(t *testing.T) {
+	assert.NoError(t, Success(map[string]any{"answer": 42}).Err())
+	assert.NoError(t, Result{Status: StatusSkipped}.Err())
+
+	failed := Failed(fmt.Errorf("fetching data: %w", ErrorTimeout))
+	err := failed.Err()
+	assert.ErrorIs(t, err, ErrorTimeout)
+	assert.EqualError(t, err, "timeout: fetching data: timeout")
+	assert.True(t, failed.Retryable())
+	assert.Equal(t, http.StatusGatewayTimeout, failed.HTTPStatus())
+
+	var resultErr *ResultError
+	require.ErrorAs(t, err, &resultErr)
+	assert.Equal(t, StatusError, resultErr.Status)
+
+	// Error results from before the error was set are internal errors
+	assert.ErrorIs(t, Result{Status: StatusError}.Err(), ErrorInternalError)
+	assert.ErrorIs(t, Result{Status: StatusRequiresInfo}.Err(), ErrorInfoNeeded)
+
+	failedWithoutError := Failed(nil)
+	assert.Equal(t, StatusError, failedWithoutError.Status)
+	assert.EqualError(t, failedWithoutError.Err(), "internal error: failed without an error")
+}
+
+func TestResultJSONUnchanged(t *testing.T) {
+	encoded, err := json.Marshal(Failed(ErrorBadRequest))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"error","parameters":null,"output":null,"message":"bad request","error":"bad request","formatted_result":null}`, string(encoded))
+
+	var result Result
+	require.NoError(t, json.Unmarshal(encoded, &result))
+	assert.Equal(t, Failed(ErrorBadRequest), result)
+}
@@ -0,0 +1,135 @@
+package types
+
+import (
+	"slices"
+	"strings"
+)
+
+// Tool Schemas
+// ============
+
+// parameterSchemaTypes maps parameter types to JSON schema types. JSON
+// parameters can hold any value, but tool schemas need a type, so they're
+// objects.
+var parameterSchemaTypes = map[ParameterType]JSONSchemaType{
+	ParameterTypeString:  JSONSchemaTypeString,
+	ParameterTypeInteger: JSONSchemaTypeInteger,
+	ParameterTypeFloat:   JSONSchemaTypeNumber,
+	ParameterTypeBoolean: JSONSchemaTypeBoolean,
+	ParameterTypeJson:    JSONSchemaTypeObject,
+}
+
+// SchemaFromParameters builds the object schema for a list of parameters, with
+// a property per parameter key. Parameters of unknown types are strings.
+func SchemaFromParameters(params []ParameterDefinition) JSONSchema {
+	schema := JSONSchema{
+		Type:       JSONSchemaTypeObject,
+		Properties: make(map[string]JSONSchemaProperty, len(params)),
+		Required:   []string{},
+	}
+	for _, param := range params {
+		schemaType, ok := parameterSchemaTypes[param.Type]
+		if !ok {
+			schemaType = JSONSchemaTypeString
+		}
+		property := JSONSchemaProperty{Type: schemaType}
+		if param.Description != nil {
+			property.Description = *param.Description
+		}
+		schema.Properties[param.Key] = property
+		if param.IsRequired {
+			schema.Required = append(schema.Required, param.Key)
+		}
+	}
+	return schema
+}
+
+// ToParameters returns a parameter definition per property of the schema,
+// sorted by key. Arrays and objects become JSON parameters.
+func (s JSONSchema) ToParameters() []ParameterDefinition {
+	keys := sortedKeys(s.Properties)
+	params := make([]ParameterDefinition, 0, len(keys))
+	for _, key := range keys {
+		property := s.Properties[key]
+		param := ParameterDefinition{
+			Name:       key,
+			Key:        key,
+			Type:       schemaParameterType(property.Type),
+			IsRequired: slices.Contains(s.Required, key),
+		}
+		if property.Description != "" {
+			description := property.Description
+			param.Description = &description
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+func schemaParameterType(schemaType JSONSchemaType) ParameterType {
+	switch schemaType {
+	case JSONSchemaTypeInteger:
+		return ParameterTypeInteger
+	case JSONSchemaTypeNumber:
+		return ParameterTypeFloat
+	case JSONSchemaTypeBoolean:
+		return ParameterTypeBoolean
+	case JSONSchemaTypeObject, JSONSchemaTypeArray:
+		return ParameterTypeJson
+	default:
+		return ParameterTypeString
+	}
+}
+
+// ToTool exposes the action as an LLM tool, with an input schema generated from
+// its parameters. Each parameter is passed to the action from the tool input
+// with a template like {{query}}, or {{query?}} if it's optional. The action's
+// name is used as the action type; see ExportActionsResponse.Tools for tools
+// with service-qualified action types.
+func (a ActionDefinition) ToTool() Tool {
+	parameters := make(map[string]any, len(a.Parameters))
+	for _, param := range a.Parameters {
+		if param.IsRequired {
+			parameters[param.Key] = "{{" + param.Key + "}}"
+		} else {
+			parameters[param.Key] = "{{" + param.Key + "?}}"
+		}
+	}
+	return Tool{
+		Name:        toolName(a.Name),
+		Description: a.Description,
+		InputSchema: SchemaFromParameters(a.Parameters),
+		ActionType:  a.Name,
+		Parameters:  parameters,
+	}
+}
+
+// Tools returns a tool for every action of every service, sorted by action type
+func (r ExportActionsResponse) Tools() []Tool {
+	var tools []Tool
+	for _, serviceName := range sortedKeys(r.Services) {
+		for _, action := range r.Services[serviceName].Actions {
+			actionType := action.Name
+			if !strings.HasPrefix(actionType, serviceName+".") {
+				actionType = serviceName + "." + actionType
+			}
+			tool := action.ToTool()
+			tool.Name = toolName(actionType)
+			tool.ActionType = actionType
+			tools = append(tools, tool)
+		}
+	}
+	slices.SortStableFunc(tools, func(a, b Tool) int { return strings.Compare(a.ActionType, b.ActionType) })
+	return tools
+}
+
+// toolName makes a name valid for LLM providers, which only allow letters,
+// digits, underscores and hyphens
+func toolName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,198 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+// Token Budgets
+// =============
+
+// Tokenizer counts the tokens in a piece of text
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc adapts a function to the Tokenizer interface
+type TokenizerFunc func(text string) int
+
+func (f TokenizerFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+// CharsPerToken is the average number of characters in a token of English text
+// for the models we use, which EstimateTokens is based on
+const CharsPerToken = 4
+
+// MessageTokenOverhead is the number of tokens a message costs on top of its
+// content, for the role and the provider's message framing
+const MessageTokenOverhead = 4
+
+// EstimateTokens estimates the tokens in text from its length. It's fast and
+// within ~20% of real tokenizers for prose, but undercounts code and non-Latin text.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + CharsPerToken - 1) / CharsPerToken
+}
+
+// DefaultTokenizer is the tokenizer used when TokenBudgetOptions.Tokenizer is nil
+var DefaultTokenizer Tokenizer = TokenizerFunc(EstimateTokens)
+
+// TokenBudgetOptions configures SliceByTokenBudget
+type TokenBudgetOptions struct {
+	// Tokenizer counts the tokens in message content, DefaultTokenizer if nil
+	Tokenizer Tokenizer
+	// Placeholder, if set, builds a message that stands in for the dropped
+	// messages, e.g. a note that they were omitted or a summary of them. It
+	// counts towards the budget.
+	Placeholder func(dropped []Message) Message
+}
+
+// OmittedMessagesPlaceholder is a TokenBudgetOptions.Placeholder that notes how
+// many messages were dropped
+func OmittedMessagesPlaceholder(dropped []Message) Message {
+	return Message{
+		Role:    "user",
+		Content: fmt.Sprintf("[%d earlier messages were omitted to fit the context window]", len(dropped)),
+	}
+}
+
+// MessageTokens returns the tokens a message costs: its text, tool calls, tool
+// results and thinking, and MessageTokenOverhead. Images and files aren't counted.
+func MessageTokens(message Message, tokenizer Tokenizer) int {
+	if tokenizer == nil {
+		tokenizer = DefaultTokenizer
+	}
+	tokens := tokenizer.CountTokens(message.Text()) + MessageTokenOverhead
+	for _, part := range message.Parts {
+		if part.Type != ContentPartTypeText {
+			tokens += partTokens(part, tokenizer)
+		}
+	}
+	return tokens
+}
+
+// partTokens returns the tokens in a content part, with tool call inputs
+// counted as the JSON sent to the provider
+func partTokens(part ContentPart, tokenizer Tokenizer) int {
+	switch part.Type {
+	case ContentPartTypeText:
+		return tokenizer.CountTokens(part.Text)
+	case ContentPartTypeToolUse:
+		tokens := tokenizer.CountTokens(part.ToolName)
+		if len(part.Input) > 0 {
+			input, err := json.Marshal(part.Input)
+			if err != nil {
+				input = []byte(fmt.Sprint(part.Input))
+			}
+			tokens += tokenizer.CountTokens(string(input))
+		}
+		return tokens
+	case ContentPartTypeToolResult:
+		tokens := 0
+		for _, content := range part.Content {
+			tokens += partTokens(content, tokenizer)
+		}
+		return tokens
+	case ContentPartTypeThinking:
+		if part.Text == "" {
+			return tokenizer.CountTokens(part.Data)
+		}
+		return tokenizer.CountTokens(part.Text)
+	}
+	return 0
+}
+
+// SliceByTokenBudget returns the most recent messages that fit in budget tokens.
+// Like sliceEndKeepFirstUserMessage, when the kept messages don't start with a
+// user message, the latest user message before them is kept too, so the history
+// still starts with what the user asked. The last message is always kept, even
+// if it doesn't fit on its own.
+func SliceByTokenBudget(messages []Message, budget int, opts TokenBudgetOptions) []Message {
+	var placeholder func([]Message) (Message, bool)
+	if opts.Placeholder != nil {
+		placeholder = func(dropped []Message) (Message, bool) {
+			return opts.Placeholder(dropped), true
+		}
+	}
+	return SliceByTokenBudgetFunc(messages, budget,
+		func(m Message) int { return MessageTokens(m, opts.Tokenizer) },
+		func(m Message) bool { return m.Role == "user" },
+		placeholder,
+	)
+}
+
+// SliceByTokenBudgetFunc is SliceByTokenBudget for any message type, with
+// functions to count a message's tokens, tell whether it's from the user and
+// optionally build a placeholder for the dropped messages.
+func SliceByTokenBudgetFunc[T any](messages []T, budget int, countTokens func(T) int, isUser func(T) bool, placeholder func(dropped []T) (T, bool)) []T {
+	if len(messages) == 0 {
+		return messages
+	}
+
+	costs := make([]int, len(messages))
+	for i, message := range messages {
+		costs[i] = countTokens(message)
+	}
+
+	// Find the oldest message that can be kept when nothing else is added
+	last := len(messages) - 1
+	start, total := last, costs[last]
+	for start > 0 && total+costs[start-1] <= budget {
+		start--
+		total += costs[start]
+	}
+	if start == 0 {
+		return slices.Clone(messages)
+	}
+
+	// Then drop more until the user message and placeholder fit as well
+	for ; start <= last; start++ {
+		if window, ok := assembleWindow(messages, costs, start, budget, countTokens, isUser, placeholder); ok {
+			return window
+		}
+	}
+	return []T{messages[last]}
+}
+
+// assembleWindow builds the window of messages[start:] with the latest user
+// message before it and the placeholder, and reports whether it fits the budget
+func assembleWindow[T any](messages []T, costs []int, start, budget int, countTokens func(T) int, isUser func(T) bool, placeholder func([]T) (T, bool)) ([]T, bool) {
+	total := 0
+	for _, cost := range costs[start:] {
+		total += cost
+	}
+
+	userIndex := -1
+	if !isUser(messages[start]) {
+		for i := start - 1; i >= 0; i-- {
+			if isUser(messages[i]) {
+				userIndex = i
+				break
+			}
+		}
+	}
+
+	window := make([]T, 0, len(messages)-start+2)
+	if userIndex >= 0 {
+		window = append(window, messages[userIndex])
+		total += costs[userIndex]
+	}
+	if placeholder != nil {
+		dropped := make([]T, 0, start)
+		for i := range start {
+			if i != userIndex {
+				dropped = append(dropped, messages[i])
+			}
+		}
+		if len(dropped) > 0 {
+			if message, ok := placeholder(dropped); ok {
+				window = append(window, message)
+				total += countTokens(message)
+			}
+		}
+	}
+	window = append(window, messages[start:]...)
+	return window, total <= budget
+}
@@ -0,0 +1,85 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, EstimateTokens(""))
+	assert.Equal(t, 1, EstimateTokens("abc"))
+	assert.Equal(t, 2, EstimateTokens("hello"))
+	assert.Equal(t, 1, EstimateTokens("héllo"[:3]), "counts characters, not bytes")
+	assert.Equal(t, 14, MessageTokens(Message{Content: strings.Repeat("x", 40)}, nil))
+}
+
+func TestMessageTokens(t *testing.T) {
+	t.Run("counts tool calls and thinking", func(t *testing.T) {
+		// "search" is 2 tokens and {"query":"weather"} is 5
+		call := NewMessage(MessageRoleAssistant, ToolUsePart("call_1", "search", map[string]any{"query": "weather"}))
+		assert.Equal(t, 11, MessageTokens(call, nil))
+
+		thinking := NewMessage(MessageRoleAssistant, ThinkingPart(strings.Repeat("x", 40), "sig"), TextPart("done"))
+		assert.Equal(t, 15, MessageTokens(thinking, nil))
+	})
+
+	t.Run("counts large tool results", func(t *testing.T) {
+		result := NewMessage(MessageRoleTool, ToolResultPart("call_1", false, TextPart(strings.Repeat("x", 40000))))
+		assert.Equal(t, 10004, MessageTokens(result, nil))
+
+		history := []Message{
+			{Role: MessageRoleUser, Content: "what's the weather?"},
+			result,
+			{Role: MessageRoleAssistant, Content: "it's sunny"},
+		}
+		assert.Equal(t, []Message{history[0], history[2]}, SliceByTokenBudget(history, 100, TokenBudgetOptions{}))
+	})
+}
+
+func TestSliceByTokenBudget(t *testing.T) {
+	// Each message costs 10 tokens with a 24 character content
+	msg := func(role, label string) Message {
+		return Message{Role: role, Content: label + strings.Repeat(".", 24-len(label))}
+	}
+	history := []Message{
+		msg("user", "u1"),
+		msg("assistant", "a1"),
+		msg("user", "u2"),
+		msg("assistant", "a2"),
+		msg("assistant", "a3"),
+		msg("assistant", "a4"),
+	}
+
+	t.Run("everything fits", func(t *testing.T) {
+		assert.Equal(t, history, SliceByTokenBudget(history, 60, TokenBudgetOptions{}))
+		assert.Empty(t, SliceByTokenBudget(nil, 10, TokenBudgetOptions{}))
+	})
+
+	t.Run("keeps the user message before the window", func(t *testing.T) {
+		assert.Equal(t, []Message{history[2], history[4], history[5]}, SliceByTokenBudget(history, 35, TokenBudgetOptions{}))
+		assert.Equal(t, history[2:], SliceByTokenBudget(history, 40, TokenBudgetOptions{}))
+	})
+
+	t.Run("the last message is always kept", func(t *testing.T) {
+		assert.Equal(t, []Message{history[5]}, SliceByTokenBudget(history, 5, TokenBudgetOptions{}))
+		assert.Equal(t, []Message{history[2], history[5]}, SliceByTokenBudget(history, 20, TokenBudgetOptions{}))
+	})
+
+	t.Run("placeholder counts towards the budget", func(t *testing.T) {
+		opts := TokenBudgetOptions{Placeholder: OmittedMessagesPlaceholder}
+		assert.Equal(t, []Message{
+			history[2],
+			{Role: "user", Content: "[4 earlier messages were omitted to fit the context window]"},
+			history[5],
+		}, SliceByTokenBudget(history, 40, opts))
+	})
+
+	t.Run("custom tokenizer", func(t *testing.T) {
+		words := TokenizerFunc(func(text string) int { return len(strings.Fields(text)) })
+		short := []Message{{Role: "user", Content: "one two"}, {Role: "assistant", Content: "three"}}
+		assert.Equal(t, short, SliceByTokenBudget(short, 11, TokenBudgetOptions{Tokenizer: words}))
+		assert.Equal(t, short[1:], SliceByTokenBudget(short[1:], 9, TokenBudgetOptions{Tokenizer: words}))
+	})
+}
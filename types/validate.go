@@ -0,0 +1,438 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Validation
+// ==========
+
+// ValidationError is a problem with a single field, identified by its JSON path
+type ValidationError struct {
+	// Path is the JSON path of the field, e.g. steps[0].step.output_content_type
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors holds every problem found by a Validate method. It unwraps to
+// the individual *ValidationError values, so errors.As works on it.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// TemplateValidator checks the syntax of the templates in a value hydrated with
+// the policy, skipping the values it leaves raw or drops. Problems are returned
+// with paths relative to the value; an error means the policy itself is invalid.
+type TemplateValidator func(value any, policy HydrationPolicy, syntax TemplateSyntax) ([]*ValidationError, error)
+
+var (
+	templateValidatorMu sync.RWMutex
+	templateValidator   TemplateValidator
+)
+
+// RegisterTemplateValidator sets the validator used to check the templates in
+// step parameters and bot partials. The template package registers one when it
+// is imported; without one, template syntax isn't checked.
+func RegisterTemplateValidator(fn TemplateValidator) {
+	templateValidatorMu.Lock()
+	defer templateValidatorMu.Unlock()
+	templateValidator = fn
+}
+
+// TemplateChecksEnabled reports whether Validate methods check template syntax,
+// i.e. whether a template validator is registered. Without one, a bot with
+// invalid templates still validates.
+func TemplateChecksEnabled() bool {
+	return getTemplateValidator() != nil
+}
+
+func getTemplateValidator() TemplateValidator {
+	templateValidatorMu.RLock()
+	defer templateValidatorMu.RUnlock()
+	return templateValidator
+}
+
+var (
+	validOutputContentTypes = []OutputContentType{
+		OutputContentTypeText, OutputContentTypeJSON, OutputContentTypeHTML,
+		OutputContentTypeToolInvocation, OutputContentTypeToolResult,
+	}
+	validOutputVisibilities = []OutputVisibility{OutputVisibilityVisible, OutputVisibilityHidden}
+	validExecutionModes     = []ExecutionModeType{ExecutionModeTypeAll, ExecutionModeTypeIterateOver, ExecutionModeTypeAllBackground}
+	validHandlerTypes       = []HandlerType{HandlerTypeIntermediate, HandlerTypeFinal}
+	validOutputBehaviors    = []OutputBehaviorType{OutputBehaviorTypeStepOnly, OutputBehaviorTypeMerge, OutputBehaviorTypeOverwrite}
+	validHydrationBehaviour = []ParameterHydrationBehaviour{
+		ParameterHydrationBehaviourHydrate, ParameterHydrationBehaviourRaw, ParameterHydrationBehaviourNone,
+		ParameterHydrationBehaviourJinja, ParameterHydrationBehaviourOnce,
+	}
+	validTemplateSyntaxes = []TemplateSyntax{TemplateSyntaxErdo, TemplateSyntaxJinja}
+	validHistoryRoles     = []string{"user", "assistant"}
+)
+
+// validator collects validation errors
+type validator struct {
+	errs   ValidationErrors
+	syntax TemplateSyntax
+	// checkTemplate checks template syntax; nil if no validator is registered
+	checkTemplate TemplateValidator
+}
+
+func newValidator(syntax TemplateSyntax) *validator {
+	return &validator{syntax: syntax, checkTemplate: getTemplateValidator()}
+}
+
+func (v *validator) add(path string, format string, args ...any) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// oneOf checks an optional enum field
+func oneOf[T ~string](v *validator, path string, value T, valid []T) {
+	if value != "" && !slices.Contains(valid, value) {
+		v.add(path, "invalid value %q, must be one of %s", value, quoteAll(valid))
+	}
+}
+
+func quoteAll[T ~string](values []T) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func joinPath(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	if child == "" {
+		return parent
+	}
+	if strings.HasPrefix(child, "[") {
+		return parent + child
+	}
+	return parent + "." + child
+}
+
+func indexPath(parent string, i int) string {
+	return fmt.Sprintf("%s[%d]", parent, i)
+}
+
+// Validate checks the bot's fields, including the syntax of its partials
+func (b Bot) Validate() error {
+	v := newValidator(b.TemplateSyntax)
+	v.bot("", b)
+	return v.err()
+}
+
+func (v *validator) bot(path string, b Bot) {
+	if strings.TrimSpace(b.Name) == "" {
+		v.add(joinPath(path, "name"), "is required")
+	}
+	oneOf(v, joinPath(path, "template_syntax"), b.TemplateSyntax, validTemplateSyntaxes)
+	for _, name := range sortedKeys(b.Partials) {
+		v.templates(joinPath(path, "partials."+name), "", b.Partials[name], HydrationPolicy{})
+	}
+}
+
+// Validate checks the step's fields. Parameters are checked as erdo templates;
+// validate the bot's UpsertBotRequest to check them with the bot's template syntax.
+func (s Step) Validate() error {
+	v := newValidator(TemplateSyntaxErdo)
+	v.step("", s)
+	return v.err()
+}
+
+func (v *validator) step(path string, s Step) {
+	if strings.TrimSpace(s.ActionType) == "" {
+		v.add(joinPath(path, "action_type"), "is required")
+	}
+	if s.Key != nil && strings.TrimSpace(*s.Key) == "" {
+		v.add(joinPath(path, "key"), "must not be empty")
+	}
+	oneOf(v, joinPath(path, "output_content_type"), OutputContentType(s.OutputContentType), validOutputContentTypes)
+	oneOf(v, joinPath(path, "user_output_visibility"), OutputVisibility(s.UserOutputVisibility), validOutputVisibilities)
+	oneOf(v, joinPath(path, "bot_output_visibility"), OutputVisibility(s.BotOutputVisibility), validOutputVisibilities)
+	if s.HistoryRole != nil {
+		oneOf(v, joinPath(path, "history_role"), *s.HistoryRole, validHistoryRoles)
+	}
+	v.executionMode(joinPath(path, "execution_mode"), s.ExecutionMode)
+
+	for _, field := range sortedKeys(s.OutputBehaviour) {
+		oneOf(v, joinPath(path, "output_behaviour."+field), s.OutputBehaviour[field], validOutputBehaviors)
+	}
+
+	policyPath := joinPath(path, "hydration_policy")
+	errsBefore := len(v.errs)
+	if s.ParameterHydrationBehaviour != nil {
+		oneOf(v, joinPath(path, "parameter_hydration_behaviour"), *s.ParameterHydrationBehaviour, validHydrationBehaviour)
+	}
+	if s.HydrationPolicy != nil {
+		oneOf(v, joinPath(policyPath, "default"), s.HydrationPolicy.Default, validHydrationBehaviour)
+		for i, rule := range s.HydrationPolicy.Rules {
+			rulePath := indexPath(joinPath(policyPath, "rules"), i)
+			if strings.TrimSpace(rule.Path) == "" {
+				v.add(joinPath(rulePath, "path"), "is required")
+			}
+			oneOf(v, joinPath(rulePath, "behaviour"), rule.Behaviour, validHydrationBehaviour)
+		}
+	}
+
+	// Parameters the policy leaves raw or drops can hold anything. The templates
+	// can only be checked once the policy's behaviours are known to be valid.
+	if len(v.errs) == errsBefore {
+		v.templates(joinPath(path, "parameters"), policyPath, s.Parameters, s.EffectiveHydrationPolicy())
+	}
+	if strings.HasPrefix(s.ActionType, llmActionPrefix) {
+		v.model(joinPath(path, "parameters"), s.Parameters)
+	}
+}
+
+// llmActionPrefix starts the action types that call a model with their
+// parameters. Other actions can have a model parameter that means something else.
+const llmActionPrefix = "llm."
+
+// model checks that a step's model is known and supports the rest of its parameters
+func (v *validator) model(path string, parameters map[string]any) {
+	name, ok := parameters["model"].(string)
+	if !ok || strings.Contains(name, "{{") || strings.Contains(name, "{%") {
+		return
+	}
+	model, err := ParseModel(name)
+	if err != nil {
+		v.add(joinPath(path, "model"), "%v", err)
+		return
+	}
+	info, _ := model.Capabilities()
+	for _, problem := range info.CheckStepParameters(parameters) {
+		v.add(path, "%s", problem)
+	}
+}
+
+func (v *validator) executionMode(path string, em ExecutionMode) {
+	oneOf(v, joinPath(path, "mode"), em.Mode, validExecutionModes)
+	if em.Mode == ExecutionModeTypeIterateOver && em.Data == nil {
+		v.add(joinPath(path, "data"), "is required when mode is %q", ExecutionModeTypeIterateOver)
+	}
+	if em.IfCondition != nil {
+		v.condition(joinPath(path, "if_condition"), *em.IfCondition, false)
+	}
+}
+
+// Validate checks the condition tree. Composite conditions (and, or, not)
+// must have nested conditions and no leaf; any other condition is a leaf.
+func (cd ConditionDefinition) Validate() error {
+	v := newValidator(TemplateSyntaxErdo)
+	v.condition("", cd, false)
+	return v.err()
+}
+
+func (v *validator) condition(path string, cd ConditionDefinition, allowEmpty bool) {
+	if allowEmpty && cd.Type == "" && len(cd.Conditions) == 0 && len(cd.Leaf) == 0 {
+		return
+	}
+	if strings.TrimSpace(cd.Type) == "" {
+		v.add(joinPath(path, "type"), "is required")
+		return
+	}
+
+	switch strings.ToLower(cd.Type) {
+	case "and", "or":
+		if len(cd.Conditions) == 0 {
+			v.add(joinPath(path, "conditions"), "%q needs at least one condition", cd.Type)
+		}
+	case "not":
+		if len(cd.Conditions) != 1 {
+			v.add(joinPath(path, "conditions"), "%q needs exactly one condition, got %d", cd.Type, len(cd.Conditions))
+		}
+	default:
+		if len(cd.Conditions) > 0 {
+			v.add(joinPath(path, "conditions"), "leaf condition %q can't have nested conditions", cd.Type)
+		}
+		return
+	}
+
+	if len(cd.Leaf) > 0 {
+		v.add(joinPath(path, "leaf"), "composite condition %q can't have a leaf", cd.Type)
+	}
+	for i, child := range cd.Conditions {
+		v.condition(indexPath(joinPath(path, "conditions"), i), child, false)
+	}
+}
+
+// Validate checks the step, its result handlers and the steps nested in them,
+// and that step keys are unique within the tree. DependsOn can refer to steps
+// outside the tree, so it's checked by UpsertBotRequest.Validate.
+func (s StepWithHandlers) Validate() error {
+	v := newValidator(TemplateSyntaxErdo)
+	v.stepWithHandlers("", s)
+	v.stepKeys([]stepRef{{path: "", step: s}}, false)
+	return v.err()
+}
+
+func (v *validator) stepWithHandlers(path string, s StepWithHandlers) {
+	v.step(joinPath(path, "step"), s.Step)
+	for i, handler := range s.ResultHandlers {
+		v.resultHandler(indexPath(joinPath(path, "result_handlers"), i), handler)
+	}
+}
+
+// Validate checks the handler's fields, its condition and its steps
+func (rh ResultHandler) Validate() error {
+	v := newValidator(TemplateSyntaxErdo)
+	v.resultHandler("", rh)
+	return v.err()
+}
+
+func (v *validator) resultHandler(path string, rh ResultHandler) {
+	if rh.Type == "" {
+		v.add(joinPath(path, "type"), "is required")
+	} else {
+		oneOf(v, joinPath(path, "type"), HandlerType(rh.Type), validHandlerTypes)
+	}
+	oneOf(v, joinPath(path, "output_content_type"), OutputContentType(rh.OutputContentType), validOutputContentTypes)
+	v.condition(joinPath(path, "if_conditions"), rh.IfConditions, true)
+	for i, step := range rh.Steps {
+		v.stepWithHandlers(indexPath(joinPath(path, "steps"), i), step)
+	}
+}
+
+// Validate checks the bot and every step with the bot's template syntax, that
+// step keys are unique and that DependsOn only refers to known step keys
+func (r UpsertBotRequest) Validate() error {
+	v := newValidator(r.Bot.TemplateSyntax)
+	v.bot("bot", r.Bot)
+
+	refs := make([]stepRef, 0, len(r.Steps))
+	for i, apiStep := range r.Steps {
+		path := indexPath("steps", i)
+		var step StepWithHandlers
+		if err := step.FromAPI(apiStep); err != nil {
+			v.add(path, "%v", err)
+			continue
+		}
+		v.stepWithHandlers(path, step)
+		refs = append(refs, stepRef{path: path, step: step})
+	}
+	v.stepKeys(refs, true)
+
+	for i, def := range r.ParameterDefinitions {
+		path := indexPath("parameter_definitions", i)
+		if strings.TrimSpace(def.Key) == "" {
+			v.add(joinPath(path, "key"), "is required")
+		}
+	}
+	return v.err()
+}
+
+type stepRef struct {
+	path string
+	step StepWithHandlers
+}
+
+// stepKeys checks that step keys are unique across the trees and, if
+// checkDependencies is set, that every DependsOn entry is a known step key
+func (v *validator) stepKeys(roots []stepRef, checkDependencies bool) {
+	var all []stepRef
+	var collect func(ref stepRef)
+	collect = func(ref stepRef) {
+		all = append(all, ref)
+		for i, handler := range ref.step.ResultHandlers {
+			handlerPath := indexPath(joinPath(ref.path, "result_handlers"), i)
+			for j, step := range handler.Steps {
+				collect(stepRef{path: indexPath(joinPath(handlerPath, "steps"), j), step: step})
+			}
+		}
+	}
+	for _, root := range roots {
+		collect(root)
+	}
+
+	keys := map[string]string{}
+	for _, ref := range all {
+		if ref.step.Step.Key == nil || *ref.step.Step.Key == "" {
+			continue
+		}
+		key := *ref.step.Step.Key
+		if first, exists := keys[key]; exists {
+			v.add(joinPath(ref.path, "step.key"), "duplicate step key %q, also used by %s", key, first)
+			continue
+		}
+		keys[key] = joinPath(ref.path, "step")
+	}
+
+	if !checkDependencies {
+		return
+	}
+	for _, ref := range all {
+		if ref.step.Step.DependsOn == nil {
+			continue
+		}
+		for i, dep := range *ref.step.Step.DependsOn {
+			depPath := indexPath(joinPath(ref.path, "step.depends_on"), i)
+			switch {
+			case ref.step.Step.Key != nil && dep == *ref.step.Step.Key:
+				v.add(depPath, "step can't depend on itself")
+			case keys[dep] == "":
+				v.add(depPath, "unknown step key %q", dep)
+			}
+		}
+	}
+}
+
+// templates checks the syntax of the templates in a value hydrated with the
+// policy, reporting an invalid policy at policyPath. Nothing is checked if no
+// template validator is registered; see TemplateChecksEnabled.
+func (v *validator) templates(path string, policyPath string, value any, policy HydrationPolicy) {
+	if v.checkTemplate == nil {
+		return
+	}
+
+	problems, err := v.checkTemplate(value, policy, v.syntax)
+	if err != nil {
+		v.add(policyPath, "%v", err)
+		return
+	}
+	for _, problem := range problems {
+		v.add(joinPath(path, problem.Path), "%s", problem.Message)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,18 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWithoutTemplateValidator(t *testing.T) {
+	// The template package isn't imported here, so no validator is registered
+	assert.False(t, TemplateChecksEnabled())
+
+	step := Step{ActionType: "llm.message", Parameters: map[string]any{
+		"model":  "gpt-4.1",
+		"prompt": "{{nosuchfunc x}}",
+	}}
+	assert.NoError(t, step.Validate(), "template syntax isn't checked")
+}
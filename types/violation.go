@@ -0,0 +1,92 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Violations
+// ==========
+
+// Violation is a way a value doesn't match its schema, e.g. a tool call input or
+// an action result, described so it can be sent back to an LLM to correct
+type Violation struct {
+	// Path is the JSON path of the value, e.g. filters.tags[0]; empty for the whole value
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+}
+
+// NewViolation returns a violation at the path, describing the value that was found
+func NewViolation(path, expected string, got any) Violation {
+	return Violation{Path: path, Expected: expected, Got: DescribeValue(got)}
+}
+
+func (v Violation) Error() string {
+	if v.Path == "" {
+		return fmt.Sprintf("expected %s, got %s", v.Expected, v.Got)
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", v.Path, v.Expected, v.Got)
+}
+
+// Violations is every way a value doesn't match its schema
+type Violations []Violation
+
+func (v Violations) Error() string {
+	messages := make([]string, len(v))
+	for i, violation := range v {
+		messages[i] = violation.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// CorrectionMessage describes the violations for an LLM, asking it to fix them.
+// The subject says what was invalid, e.g. `the arguments for tool "search"`.
+func (v Violations) CorrectionMessage(subject string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s didn't match the expected schema:\n", capitalize(subject))
+	for _, violation := range v {
+		path := violation.Path
+		if path == "" {
+			path = "(root)"
+		}
+		fmt.Fprintf(&b, "- %s: expected %s, got %s\n", path, violation.Expected, violation.Got)
+	}
+	b.WriteString("Fix these problems and try again.")
+	return b.String()
+}
+
+// maxDescribedValue is how much of a value DescribeValue includes
+const maxDescribedValue = 60
+
+// DescribeValue describes a JSON value by its type and, for short values, the
+// value itself, e.g. `string "five"`, `number 3.5` or `object`
+func DescribeValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return fmt.Sprintf("boolean %t", v)
+	case string:
+		if len(v) > maxDescribedValue {
+			return fmt.Sprintf("string %q...", v[:maxDescribedValue])
+		}
+		return fmt.Sprintf("string %q", v)
+	case map[string]any:
+		return "object"
+	case []any:
+		return fmt.Sprintf("array of %d items", len(v))
+	case float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return fmt.Sprintf("number %v", v)
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -59,24 +59,59 @@ func ToJSONWithOptions(v any, checkSafety bool) (*json.RawMessage, error) {
 	return &raw, nil
 }
 
-func JSON(v any) json.RawMessage {
+// JSONError is returned when a value can't be serialized to JSON
+type JSONError struct {
+	Type string
+	Err  error
+}
+
+func (e *JSONError) Error() string {
+	return fmt.Sprintf("JSON serialization of %s failed: %v", e.Type, e.Err)
+}
+
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// TryJSON serializes a value to JSON, returning a *JSONError on failure
+func TryJSON(v any) (json.RawMessage, error) {
 	raw, err := ToJSON(v)
 	if err != nil {
-		panic(fmt.Sprintf("JSON serialization failed: %v", err))
+		return nil, &JSONError{Type: fmt.Sprintf("%T", v), Err: err}
 	}
-	return *raw
+	return *raw, nil
 }
 
-// SafeJSON serializes to JSON with circular reference checking and validation
-// This is slower but safer - use when you're unsure about the data structure
-func SafeJSON(v any) json.RawMessage {
+// TrySafeJSON serializes to JSON with circular reference checking and validation,
+// returning a *JSONError on failure
+func TrySafeJSON(v any) (json.RawMessage, error) {
 	raw, err := ToJSONWithOptions(v, true)
 	if err != nil {
-		panic(fmt.Sprintf("JSON serialization failed: %v", err))
+		return nil, &JSONError{Type: fmt.Sprintf("%T", v), Err: err}
 	}
-	return *raw
+	return *raw, nil
 }
 
+// JSON serializes a value to JSON and panics if it can't be serialized.
+// Use TryJSON to handle the error.
+func JSON(v any) json.RawMessage {
+	raw, err := TryJSON(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// SafeJSON serializes to JSON with circular reference checking and validation
+// This is slower but safer - use when you're unsure about the data structure.
+// It panics if the value can't be serialized; use TrySafeJSON to handle the error.
+func SafeJSON(v any) json.RawMessage {
+	raw, err := TrySafeJSON(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
 
 // ToAnySlice converts any slice type to []any.
 // Handles []any, []SomeStruct, []interface{}, etc.